@@ -0,0 +1,90 @@
+// Command migrate inspects and applies store schema migrations.
+//
+//	migrate status        list migrations and whether they are applied
+//	migrate up            apply pending migrations
+//	migrate up -dry-run   list pending migrations without applying them
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+func main() {
+	godotenv.Load()
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	db, err := store.Open(getEnv("DB_DRIVER", store.DriverSQLite), getEnv("DB_DSN", getEnv("DB_PATH", "./data/study_room.db")))
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	switch os.Args[1] {
+	case "status":
+		status, err := db.MigrationStatus()
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%04d_%-32s %s\n", s.Version, s.Name, state)
+		}
+
+	case "up":
+		fs := flag.NewFlagSet("up", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "list pending migrations without applying them")
+		fs.Parse(os.Args[2:])
+
+		if *dryRun {
+			pending, err := db.PendingMigrations()
+			if err != nil {
+				log.Fatalf("Failed to read migration status: %v", err)
+			}
+			if len(pending) == 0 {
+				fmt.Println("Database is up to date")
+			}
+			for _, m := range pending {
+				fmt.Printf("would apply %04d_%s\n", m.Version, m.Name)
+			}
+			return
+		}
+
+		applied, err := db.Migrate()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate status | migrate up [-dry-run]")
+	os.Exit(2)
+}
+
+func getEnv(key, fallback string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return fallback
+}
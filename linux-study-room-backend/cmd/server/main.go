@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/linuxstudyroom/backend/internal/handler"
 	"github.com/linuxstudyroom/backend/internal/service"
@@ -18,82 +22,69 @@ func main() {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	// Initialize SQLite
-	db, err := store.InitDB(getEnv("DB_PATH", "./data/study_room.db"))
+	// Initialize database (sqlite by default, postgres via DB_DRIVER/DB_DSN)
+	db, err := store.Init(getEnv("DB_DRIVER", store.DriverSQLite), getEnv("DB_DSN", getEnv("DB_PATH", "./data/study_room.db")))
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
-	// Initialize Docker service
-	dockerSvc, err := service.NewDockerService()
+	// Images users can launch; the built-in catalog unless IMAGE_CATALOG names a file
+	catalog, err := service.LoadCatalog(os.Getenv("IMAGE_CATALOG"))
 	if err != nil {
-		log.Fatalf("Failed to connect to Docker: %v", err)
+		log.Fatalf("Failed to load image catalog: %v", err)
 	}
+	log.Printf("📚 Image catalog: %v", catalog.IDs())
 
-	// Initialize Cleanup Manager (handles container cleanup after user disconnects)
-	// TODO: 暂时禁用，后续可能启用
-	// cleanupMgr := service.NewCleanupManager(dockerSvc, db)
-	// log.Println("✅ Cleanup manager initialized (20 min timeout)")
-
-	// Clean up stale containers from previous sessions
-	// cleanupMgr.CleanupStaleContainers()
-
-	// Initialize Gin
-	r := gin.Default()
-
-	// CORS configuration - Allow all origins for open source deployment
-	r.Use(cors.New(cors.Config{
-		AllowAllOrigins:  true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		AllowCredentials: false,
-	}))
-
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok", "service": "linux-study-room"})
-	})
-
-	// API routes
-	api := r.Group("/api")
-	{
-		// Container management
-		containerHandler := handler.NewContainerHandler(dockerSvc, db)
-		api.POST("/container/check", containerHandler.Check)
-		api.POST("/container/launch", containerHandler.Launch)
-		api.POST("/container/:id/restart", containerHandler.Restart)
-		api.POST("/container/:id/reset", containerHandler.Reset)
-		api.GET("/container/:id/status", containerHandler.Status)
-
-		// Leaderboard
-		leaderboardHandler := handler.NewLeaderboardHandler(db)
-		api.GET("/leaderboard", leaderboardHandler.GetLeaderboard)
+	// Resource limits per trust level; the built-in table unless QUOTA_POLICY names a file
+	quotas, err := service.LoadQuotaPolicy(os.Getenv("QUOTA_POLICY"), catalog)
+	if err != nil {
+		log.Fatalf("Failed to load quota policy: %v", err)
+	}
 
-		// OAuth2 Authentication
-		authHandler := handler.NewAuthHandler()
-		api.GET("/auth/linuxdo", authHandler.Login)
-		api.GET("/auth/linuxdo/callback", authHandler.Callback)
-		api.GET("/auth/me", authHandler.Me)
+	// Initialize container runtime (docker by default, fake runs local shells without a daemon)
+	var runtime service.ContainerRuntime
+	switch mode := getEnv("CONTAINER_RUNTIME", "docker"); mode {
+	case "docker":
+		dockerSvc, err := service.NewDockerService(catalog)
+		if err != nil {
+			log.Fatalf("Failed to connect to Docker: %v", err)
+		}
+		runtime = dockerSvc
+	case "fake":
+		log.Println("⚠️ CONTAINER_RUNTIME=fake: terminals are local shells on this host, for development only")
+		runtime = service.NewFakeRuntime(os.Getenv("FAKE_RUNTIME_SHELL"))
+	default:
+		log.Fatalf("Unknown CONTAINER_RUNTIME %q (want docker or fake)", mode)
 	}
 
-	// WebSocket routes
-	ws := r.Group("/ws")
-	{
-		// TODO: 暂时禁用cleanupMgr，传nil
-		terminalHandler := handler.NewTerminalHandler(dockerSvc, nil, db)
-		ws.GET("/terminal", terminalHandler.Handle)
-		ws.GET("/terminal/helper", terminalHandler.HandleHelper) // Helper terminal
+	// Terminal recordings are asciicast files on disk, one directory per user
+	recordings := service.NewRecordingStore(getEnv("RECORDINGS_DIR", "./data/recordings"))
 
-		lobbyHandler := handler.NewLobbyHandler(db)
-		ws.GET("/lobby", lobbyHandler.Handle)
-	}
+	// The router's background workers run until SIGINT or SIGTERM. Among them
+	// the cleanup manager stops idle containers and removes unused ones per
+	// the quota tiers. Containers from previous runs are kept: homes persist
+	// and the lifecycle policy ages them out.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	r := handler.NewRouter(ctx, runtime, db, recordings, catalog, quotas)
 
 	// Start server
 	port := getEnv("PORT", "8080")
-	log.Printf("🚀 Linux Study Room Backend starting on :%s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("🚀 Linux Study Room Backend starting on :%s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("👋 Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ Shutdown: %v", err)
 	}
 }
 
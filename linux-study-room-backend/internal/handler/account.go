@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// AccountHandler deletes users along with everything they own
+type AccountHandler struct {
+	runtime    service.ContainerRuntime
+	db         store.Store
+	recordings *service.RecordingStore
+}
+
+// NewAccountHandler creates a new account handler
+func NewAccountHandler(runtime service.ContainerRuntime, db store.Store, recordings *service.RecordingStore) *AccountHandler {
+	return &AccountHandler{runtime: runtime, db: db, recordings: recordings}
+}
+
+// Delete removes the caller's container, checkpoints, home volume, recordings
+// and user record. Signing in again starts a fresh account.
+func (h *AccountHandler) Delete(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userID := principal.UserID
+	ctx := context.Background()
+
+	if err := removeUserContainers(ctx, h.runtime, h.db, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove container: " + err.Error()})
+		return
+	}
+	if err := h.purge(ctx, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.DeleteUser(userID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete user: " + err.Error()})
+		return
+	}
+
+	log.Printf("👋 Account deleted: %s (ID: %d)", principal.Username, userID)
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// purge deletes the user's checkpoint images, home volume and recordings
+func (h *AccountHandler) purge(ctx context.Context, userID int64) error {
+	checkpoints, err := h.runtime.ListCheckpoints(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, cp := range checkpoints {
+		if err := h.runtime.RemoveCheckpoint(ctx, userID, cp.Name); err != nil && !errors.Is(err, service.ErrCheckpointNotFound) {
+			return err
+		}
+	}
+	if err := h.runtime.WipeHome(ctx, userID); err != nil {
+		return err
+	}
+	return h.recordings.DeleteAll(userID)
+}
@@ -1,16 +1,21 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
 )
 
 const (
@@ -37,19 +42,108 @@ type AuthHandler struct {
 	callbackURL  string
 	jwtSecret    []byte
 	frontendURL  string
+	db           store.Store
+	runtime      service.ContainerRuntime
+	admins       map[string]bool // Lowercased LinuxDo usernames from ADMIN_USERS
+	quotas       *service.QuotaPolicy
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler() *AuthHandler {
+func NewAuthHandler(runtime service.ContainerRuntime, db store.Store, quotas *service.QuotaPolicy) *AuthHandler {
 	return &AuthHandler{
 		clientID:     os.Getenv("LINUXDO_CLIENT_ID"),
 		clientSecret: os.Getenv("LINUXDO_CLIENT_SECRET"),
 		callbackURL:  os.Getenv("LINUXDO_CALLBACK_URL"),
 		jwtSecret:    []byte(os.Getenv("JWT_SECRET")),
 		frontendURL:  getEnvOrDefault("FRONTEND_URL", "http://localhost:5173"),
+		db:           db,
+		runtime:      runtime,
+		admins:       adminsFromEnv(),
+		quotas:       quotas,
 	}
 }
 
+// adminsFromEnv reads the comma separated LinuxDo usernames in ADMIN_USERS
+func adminsFromEnv() map[string]bool {
+	admins := make(map[string]bool)
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins[strings.ToLower(name)] = true
+		}
+	}
+	return admins
+}
+
+// syncUser upserts the user record keyed by LinuxDo ID, hands over any
+// containers still owned by the old username hash and returns users.id
+func (h *AuthHandler) syncUser(linuxdoID int64, username, avatar string, trustLevel int) (int64, error) {
+	user := &store.User{
+		LinuxDoID:  strconv.FormatInt(linuxdoID, 10),
+		Username:   username,
+		Avatar:     avatar,
+		TrustLevel: trustLevel,
+	}
+	if err := h.db.UpsertUser(user); err != nil {
+		return 0, err
+	}
+
+	if err := h.claimLegacyContainers(user.ID, username); err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// claimLegacyContainers hands over the containers recorded under the
+// username hash. Hashes collide, so a container is only claimed if it was
+// created for this username; the others are left for their real owner.
+func (h *AuthHandler) claimLegacyContainers(userID int64, username string) error {
+	legacy, err := h.db.ListLegacyContainers(store.LegacyUserID(username))
+	if err != nil || len(legacy) == 0 {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claimed := 0
+	for _, c := range legacy {
+		owner := ""
+		if h.runtime != nil && c.DockerID != "" {
+			if owner, err = h.runtime.ContainerUsername(ctx, c.DockerID); err != nil {
+				log.Printf("⚠️ Legacy container %s can't be inspected, left unclaimed: %v", c.DockerID, err)
+				continue
+			}
+		}
+		if owner == "" || (owner != username && owner != service.LinuxUsername(username)) {
+			log.Printf("⚠️ Legacy container %s (USER=%q) shares %s's username hash, left unclaimed", c.DockerID, owner, username)
+			continue
+		}
+		ok, err := h.db.ClaimLegacyContainer(c.ID, userID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			claimed++
+		}
+	}
+	if claimed > 0 {
+		log.Printf("📦 Migrated %d legacy container record(s) to user %s (ID: %d)", claimed, username, userID)
+	}
+	return nil
+}
+
+// resolveUserID returns users.id for a principal, creating the record for
+// tokens minted before users were persisted
+func (h *AuthHandler) resolveUserID(p *Principal) (int64, error) {
+	user, err := h.db.GetUserByLinuxDoID(strconv.FormatInt(p.ID, 10))
+	if err == nil {
+		return user.ID, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return 0, err
+	}
+	return h.syncUser(p.ID, p.Username, p.Avatar, p.TrustLevel)
+}
+
 func getEnvOrDefault(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -138,6 +232,13 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 
 	log.Printf("✅ LinuxDo user logged in: %s (ID: %d, TL: %d)", user.Username, user.ID, user.TrustLevel)
 
+	// Persist the user record
+	if _, err := h.syncUser(int64(user.ID), user.Username, user.AvatarTemplate, user.TrustLevel); err != nil {
+		log.Printf("User upsert failed: %v", err)
+		c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"?error=user_save_failed")
+		return
+	}
+
 	// Generate JWT token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":          user.ID,
@@ -159,36 +260,22 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, h.frontendURL+"?token="+tokenString)
 }
 
-// Me returns current user info from JWT token
+// Me returns current user info from the authenticated principal
 func (h *AuthHandler) Me(c *gin.Context) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+	principal := GetPrincipal(c)
+	if principal == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
 		return
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return h.jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		return
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid claims"})
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{
-		"id":          claims["id"],
-		"username":    claims["username"],
-		"name":        claims["name"],
-		"avatar":      claims["avatar"],
-		"trust_level": claims["trust_level"],
+		"id":          principal.ID,
+		"user_id":     principal.UserID,
+		"username":    principal.Username,
+		"name":        principal.Name,
+		"avatar":      principal.Avatar,
+		"trust_level": principal.TrustLevel,
+		"admin":       principal.Admin,
+		"quota":       h.quotas.For(principal.TrustLevel),
 	})
 }
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+)
+
+// authorizeContainer checks that the authenticated user may use containerID.
+// Helpers are accepted only when allowHelper is set. On failure it writes the
+// error response and returns false.
+func authorizeContainer(c *gin.Context, authz *service.ContainerAuthorizer, containerID string, allowHelper bool) (*service.ContainerGrant, bool) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return nil, false
+	}
+	if containerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "container ID required"})
+		return nil, false
+	}
+
+	grant, err := authz.Authorize(context.Background(), containerID, principal.UserID, principal.Username, allowHelper)
+	switch {
+	case err == nil:
+		return grant, true
+	case errors.Is(err, service.ErrContainerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "container not found"})
+	default:
+		log.Printf("🚫 %s denied access to container %s: %v", principal.Username, containerID, err)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	}
+	return nil, false
+}
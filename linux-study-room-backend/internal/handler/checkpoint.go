@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// CreateCheckpointRequest names a new checkpoint; empty picks a timestamp
+type CreateCheckpointRequest struct {
+	Name string `json:"name"`
+}
+
+// checkpointError maps a runtime checkpoint error to a response
+func checkpointError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrCheckpointNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "checkpoint not found"})
+	case errors.Is(err, service.ErrCheckpointExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "checkpoint already exists"})
+	default:
+		log.Printf("⚠️ Checkpoint error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ListCheckpoints returns the caller's checkpoints with their size and date
+func (h *ContainerHandler) ListCheckpoints(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	list, err := h.dockerSvc.ListCheckpoints(context.Background(), principal.UserID)
+	if err != nil {
+		checkpointError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"checkpoints": list,
+		"limit":       h.quotas.For(principal.TrustLevel).Checkpoints,
+	})
+}
+
+// CreateCheckpoint commits the container's filesystem as a named checkpoint
+func (h *ContainerHandler) CreateCheckpoint(c *gin.Context) {
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), false)
+	if !ok {
+		return
+	}
+	principal := GetPrincipal(c)
+
+	var req CreateCheckpointRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Name == "" {
+		req.Name = time.Now().Format("20060102-150405")
+	}
+	if !service.ValidCheckpointName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-32 letters, digits, '_', '.' or '-'"})
+		return
+	}
+
+	ctx := context.Background()
+	existing, err := h.dockerSvc.ListCheckpoints(ctx, principal.UserID)
+	if err != nil {
+		checkpointError(c, err)
+		return
+	}
+	if limit := h.quotas.For(principal.TrustLevel).Checkpoints; len(existing) >= limit {
+		c.JSON(http.StatusForbidden, gin.H{"error": "checkpoint limit reached, delete one first", "limit": limit})
+		return
+	}
+
+	// Saving more while the home is past its quota isn't allowed
+	if err := service.CheckHomeQuota(ctx, h.dockerSvc, principal.UserID, h.quotas.For(principal.TrustLevel), 0); err != nil {
+		if errors.Is(err, service.ErrHomeQuota) {
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": "home directory is over its disk quota, free some space first"})
+			return
+		}
+		checkpointError(c, err)
+		return
+	}
+
+	cp, err := h.dockerSvc.CommitCheckpoint(ctx, grant.DockerID, principal.UserID, req.Name, grant.Record.OSType)
+	if err != nil {
+		checkpointError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, cp)
+}
+
+// RestoreCheckpoint replaces the caller's container with a new one created
+// from the checkpoint. The home volume is kept as it is.
+func (h *ContainerHandler) RestoreCheckpoint(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userID := principal.UserID
+	name := c.Param("name")
+	ctx := context.Background()
+
+	list, err := h.dockerSvc.ListCheckpoints(ctx, userID)
+	if err != nil {
+		checkpointError(c, err)
+		return
+	}
+	var cp *service.Checkpoint
+	for i := range list {
+		if list[i].Name == name {
+			cp = &list[i]
+		}
+	}
+	if cp == nil {
+		checkpointError(c, service.ErrCheckpointNotFound)
+		return
+	}
+
+	// The checkpoint's image may have been taken off the caller's tier since
+	quota := h.quotas.For(principal.TrustLevel)
+	if spec, ok := h.catalog.Get(cp.OSType); ok && !h.quotas.CanLaunch(principal.TrustLevel, spec) {
+		c.JSON(http.StatusForbidden, gin.H{"error": spec.Name + " is not available at your trust level"})
+		return
+	}
+
+	if err := removeUserContainers(ctx, h.dockerSvc, h.db, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove: " + err.Error()})
+		return
+	}
+	if _, _, err := h.dockerSvc.LookupContainer(ctx, service.ContainerName(userID)); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "container name is in use by another user"})
+		return
+	}
+
+	account := h.accounts.For(principal)
+	dockerID, err := h.dockerSvc.CreateContainer(ctx, &service.ContainerConfig{
+		UserID:     userID,
+		OSType:     cp.OSType,
+		Username:   principal.Username,
+		Checkpoint: cp.Name,
+		Shell:      loginShell(h.db, h.catalog, userID, cp.OSType),
+		Account:    account,
+		Quota:      quota,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	imageVersion, _ := h.dockerSvc.ContainerImageVersion(ctx, dockerID)
+	h.db.CreateContainer(&store.Container{
+		UserID:       userID,
+		DockerID:     dockerID,
+		OSType:       cp.OSType,
+		Status:       "running",
+		ImageVersion: imageVersion,
+	})
+
+	log.Printf("⏪ Restored checkpoint %s for user %s: %s", cp.Name, principal.Username, dockerID[:12])
+	c.JSON(http.StatusOK, gin.H{
+		"container_id": dockerID,
+		"status":       "running",
+		"os_type":      cp.OSType,
+		"username":     principal.Username,
+		"checkpoint":   cp.Name,
+		"user":         accountName(account),
+	})
+}
+
+// DeleteCheckpoint removes one of the caller's checkpoints
+func (h *ContainerHandler) DeleteCheckpoint(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	name := c.Param("name")
+	if !service.ValidCheckpointName(name) {
+		checkpointError(c, service.ErrCheckpointNotFound)
+		return
+	}
+	if err := h.dockerSvc.RemoveCheckpoint(context.Background(), principal.UserID, name); err != nil {
+		checkpointError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
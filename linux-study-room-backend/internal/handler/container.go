@@ -2,7 +2,7 @@ package handler
 
 import (
 	"context"
-	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -13,19 +13,34 @@ import (
 
 // ContainerHandler handles container API requests
 type ContainerHandler struct {
-	dockerSvc *service.DockerService
-	db        *sql.DB
+	dockerSvc service.ContainerRuntime
+	db        store.Store
+	authz     *service.ContainerAuthorizer
+	catalog   *service.Catalog
+	quotas    *service.QuotaPolicy
+	scheduler *service.Scheduler
+	pool      *service.WarmPool
+	accounts  accountPolicy
 }
 
 // NewContainerHandler creates a new container handler
-func NewContainerHandler(dockerSvc *service.DockerService, db *sql.DB) *ContainerHandler {
-	return &ContainerHandler{dockerSvc: dockerSvc, db: db}
+func NewContainerHandler(dockerSvc service.ContainerRuntime, db store.Store, catalog *service.Catalog, quotas *service.QuotaPolicy, scheduler *service.Scheduler, pool *service.WarmPool) *ContainerHandler {
+	return &ContainerHandler{
+		dockerSvc: dockerSvc,
+		db:        db,
+		authz:     service.NewContainerAuthorizer(dockerSvc, db),
+		catalog:   catalog,
+		quotas:    quotas,
+		scheduler: scheduler,
+		pool:      pool,
+		accounts:  accountPolicyFromEnv(),
+	}
 }
 
+
 // LaunchRequest represents container launch request
 type LaunchRequest struct {
-	OSType   string `json:"os_type" binding:"required,oneof=alpine debian ubuntu arch"`
-	Username string `json:"username"`
+	OSType string `json:"os_type" binding:"required"` // Image ID from the catalog
 }
 
 // Launch creates and starts a new container, or reuses existing one
@@ -36,38 +51,50 @@ func (h *ContainerHandler) Launch(c *gin.Context) {
 		return
 	}
 
-	// Generate unique user ID from username (or use timestamp if no username)
-	username := req.Username
-	if username == "" {
-		username = "User_" + c.ClientIP()
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	username := principal.Username
+	userID := principal.UserID
+
+	spec, ok := h.catalog.Get(req.OSType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown image: " + req.OSType, "images": h.catalog.IDs()})
+		return
 	}
-	
-	// Create a stable unique ID based on username hash (no timestamp for consistency)
-	var userID int64
-	for _, b := range username {
-		userID = userID*31 + int64(b)
+	if !spec.AllowedFor(principal.TrustLevel) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s requires trust level %d", spec.Name, spec.MinTrustLevel)})
+		return
 	}
-	if userID < 0 {
-		userID = -userID
+	quota := h.quotas.For(principal.TrustLevel)
+	if !quota.AllowsImage(spec.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s is not available to the %s tier", spec.Name, quota.Name), "images": quota.Images})
+		return
 	}
-	userID = userID % 1000000
 
 	ctx := context.Background()
 
 	// Check if user already has a container
-	existingContainer, err := store.GetContainerByUserID(h.db, userID)
+	existingContainer, err := h.db.GetContainerByUserID(userID)
 	if err == nil && existingContainer != nil && existingContainer.DockerID != "" {
 		// User has existing container, try to reuse it
 		status, err := h.dockerSvc.GetContainerStatus(ctx, existingContainer.DockerID)
 		if err == nil {
 			// Container exists in Docker
 			if status == "exited" || status == "stopped" {
+				// Starting it again takes a slot like a new launch
+				if !h.admit(c, principal, quota) {
+					return
+				}
+				defer h.scheduler.Done(userID)
 				// Start the stopped container
 				if err := h.dockerSvc.StartContainer(ctx, existingContainer.DockerID); err != nil {
 					log.Printf("Failed to start existing container: %v", err)
 				} else {
 					log.Printf("✅ Reusing existing container for user %s: %s", username, existingContainer.DockerID[:12])
-					store.UpdateContainerStatus(h.db, existingContainer.ID, "running", existingContainer.DockerID)
+					h.db.UpdateContainerStatus(existingContainer.ID, "running", existingContainer.DockerID)
 					c.JSON(http.StatusOK, gin.H{
 						"container_id": existingContainer.DockerID,
 						"status":       "running",
@@ -94,51 +121,92 @@ func (h *ContainerHandler) Launch(c *gin.Context) {
 		log.Printf("⚠️ Existing container not found in Docker, creating new one for user %s", username)
 	}
 
-	// Create new container
-	dockerID, err := h.dockerSvc.CreateContainer(ctx, &service.ContainerConfig{
+	// Containers created before real user IDs were named after a username hash,
+	// so the name may belong to someone else. Never replace another user's container.
+	if id, _, err := h.dockerSvc.LookupContainer(ctx, service.ContainerName(userID)); err == nil {
+		if owner, err := h.db.GetContainerByDockerID(id); err == nil && owner.UserID != userID {
+			log.Printf("⚠️ Container name %s is held by another user's container", service.ContainerName(userID))
+			c.JSON(http.StatusConflict, gin.H{"error": "container name is in use by another user"})
+			return
+		}
+	}
+
+	// Wait for capacity when the host is full
+	if !h.admit(c, principal, quota) {
+		return
+	}
+	defer h.scheduler.Done(userID)
+
+	// Create new container, or claim a warm one from the pool
+	account := h.accounts.For(principal)
+	dockerID, pooled, err := h.pool.Launch(ctx, &service.ContainerConfig{
 		UserID:   userID,
 		OSType:   req.OSType,
 		Username: username,
+		Shell:    loginShell(h.db, h.catalog, userID, req.OSType),
+		Account:  account,
+		Quota:    quota,
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Remember which build of the image the container runs
+	imageVersion, err := h.dockerSvc.ContainerImageVersion(ctx, dockerID)
+	if err != nil {
+		log.Printf("⚠️ Failed to read image version of %s: %v", dockerID[:12], err)
+	}
+
 	// Save or update database
 	if existingContainer != nil {
 		// Update existing record
-		store.UpdateContainerStatus(h.db, existingContainer.ID, "running", dockerID)
+		h.db.UpdateContainerStatus(existingContainer.ID, "running", dockerID)
+		h.db.UpdateContainerImage(existingContainer.ID, req.OSType, imageVersion)
 	} else {
 		// Create new record
 		container := &store.Container{
-			UserID:   userID,
-			DockerID: dockerID,
-			OSType:   req.OSType,
-			Status:   "running",
+			UserID:       userID,
+			DockerID:     dockerID,
+			OSType:       req.OSType,
+			Status:       "running",
+			ImageVersion: imageVersion,
 		}
-		store.CreateContainer(h.db, container)
+		h.db.CreateContainer(container)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"container_id": dockerID,
-		"status":       "running",
-		"os_type":      req.OSType,
-		"username":     username,
-		"reused":       false,
+		"container_id":  dockerID,
+		"status":        "running",
+		"os_type":       req.OSType,
+		"username":      username,
+		"reused":        false,
+		"pooled":        pooled,
+		"image_version": imageVersion,
+		"user":          accountName(account),
+		"quota":         quota,
 	})
 }
 
 // Restart restarts a container
 func (h *ContainerHandler) Restart(c *gin.Context) {
-	containerID := c.Param("id")
-	if containerID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "container ID required"})
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), false)
+	if !ok {
 		return
 	}
+	containerID := grant.DockerID
+	principal := GetPrincipal(c)
 
 	ctx := context.Background()
 
+	// A running container keeps its own slot; starting a stopped one takes a slot like a launch
+	if status, err := h.dockerSvc.GetContainerStatus(ctx, containerID); err == nil && status != "running" {
+		if !h.admit(c, principal, h.quotas.For(principal.TrustLevel)) {
+			return
+		}
+		defer h.scheduler.Done(principal.UserID)
+	}
+
 	// Stop container
 	if err := h.dockerSvc.StopContainer(ctx, containerID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stop: " + err.Error()})
@@ -154,13 +222,13 @@ func (h *ContainerHandler) Restart(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "running", "message": "container restarted"})
 }
 
-// Reset destroys and recreates a container
+// Reset destroys a container; the user's home volume is kept for the next launch
 func (h *ContainerHandler) Reset(c *gin.Context) {
-	containerID := c.Param("id")
-	if containerID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "container ID required"})
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), false)
+	if !ok {
 		return
 	}
+	containerID := grant.DockerID
 
 	ctx := context.Background()
 
@@ -169,17 +237,18 @@ func (h *ContainerHandler) Reset(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove: " + err.Error()})
 		return
 	}
+	h.db.UpdateContainerStatus(grant.Record.ID, "removed", containerID)
 
-	c.JSON(http.StatusOK, gin.H{"status": "destroyed", "message": "container destroyed, use /launch to create new one"})
+	c.JSON(http.StatusOK, gin.H{"status": "destroyed", "message": "container destroyed, home kept, use /launch to create new one"})
 }
 
 // Status returns container status
 func (h *ContainerHandler) Status(c *gin.Context) {
-	containerID := c.Param("id")
-	if containerID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "container ID required"})
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), true)
+	if !ok {
 		return
 	}
+	containerID := grant.DockerID
 
 	status, err := h.dockerSvc.GetContainerStatus(context.Background(), containerID)
 	if err != nil {
@@ -191,35 +260,19 @@ func (h *ContainerHandler) Status(c *gin.Context) {
 }
 
 
-// CheckRequest represents container check request
-type CheckRequest struct {
-	Username string `json:"username" binding:"required"`
-}
-
-// Check checks if user has an existing container and returns its info
+// Check checks if the authenticated user has an existing container and returns its info
 func (h *ContainerHandler) Check(c *gin.Context) {
-	var req CheckRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
 		return
 	}
-
-	username := req.Username
-
-	// Create stable user ID from username
-	var userID int64
-	for _, b := range username {
-		userID = userID*31 + int64(b)
-	}
-	if userID < 0 {
-		userID = -userID
-	}
-	userID = userID % 1000000
+	userID := principal.UserID
 
 	ctx := context.Background()
 
 	// Check if user has a container in database
-	existingContainer, err := store.GetContainerByUserID(h.db, userID)
+	existingContainer, err := h.db.GetContainerByUserID(userID)
 	if err != nil || existingContainer == nil || existingContainer.DockerID == "" {
 		c.JSON(http.StatusOK, gin.H{
 			"has_container": false,
@@ -237,10 +290,12 @@ func (h *ContainerHandler) Check(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"has_container": true,
-		"container_id":  existingContainer.DockerID,
-		"os_type":       existingContainer.OSType,
-		"status":        status,
-	})
+	// image_version, latest_version and upgrade_available let the UI offer
+	// "upgrade on next reset" after an image rebuild
+	resp := upgradeInfo(ctx, h.dockerSvc, h.catalog, existingContainer.OSType, existingContainer.ImageVersion)
+	resp["has_container"] = true
+	resp["container_id"] = existingContainer.DockerID
+	resp["os_type"] = existingContainer.OSType
+	resp["status"] = status
+	c.JSON(http.StatusOK, resp)
 }
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/linuxstudyroom/backend/internal/service"
+)
+
+// defaultSudoMinTrustLevel is the trust level that gets sudo unless SUDO_MIN_TRUST_LEVEL is set
+const defaultSudoMinTrustLevel = 2
+
+// accountPolicy decides which account a user's new containers run as
+type accountPolicy struct {
+	unprivileged bool // Create an account named after the LinuxDo user instead of using root
+	sudoMinTrust int  // Lowest trust level allowed passwordless sudo
+}
+
+// accountPolicyFromEnv reads CONTAINER_USER ("root" or "user") and SUDO_MIN_TRUST_LEVEL
+func accountPolicyFromEnv() accountPolicy {
+	p := accountPolicy{sudoMinTrust: defaultSudoMinTrustLevel}
+	switch v := os.Getenv("CONTAINER_USER"); v {
+	case "", "root":
+	case "user":
+		p.unprivileged = true
+	default:
+		log.Printf("⚠️ Invalid CONTAINER_USER %q, using root", v)
+	}
+	if v := os.Getenv("SUDO_MIN_TRUST_LEVEL"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || level < 0 {
+			log.Printf("⚠️ Invalid SUDO_MIN_TRUST_LEVEL %q, using %d", v, defaultSudoMinTrustLevel)
+		} else {
+			p.sudoMinTrust = level
+		}
+	}
+	return p
+}
+
+// For returns the account to create for the principal, nil for root
+func (p accountPolicy) For(principal *Principal) *service.Account {
+	if !p.unprivileged {
+		return nil
+	}
+	return service.NewAccount(service.LinuxUsername(principal.Username), principal.TrustLevel >= p.sudoMinTrust)
+}
+
+// accountName is the login name of an account; nil is root
+func accountName(account *service.Account) string {
+	if account.IsRoot() {
+		return service.RootAccount.Username
+	}
+	return account.Username
+}
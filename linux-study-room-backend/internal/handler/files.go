@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// defaultUploadMaxMB caps one upload request unless UPLOAD_MAX_MB is set
+const defaultUploadMaxMB = 50
+
+// uploadLimitFromEnv reads UPLOAD_MAX_MB in bytes
+func uploadLimitFromEnv() int64 {
+	v := os.Getenv("UPLOAD_MAX_MB")
+	if v == "" {
+		return defaultUploadMaxMB << 20
+	}
+	mb, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || mb <= 0 {
+		log.Printf("⚠️ Invalid UPLOAD_MAX_MB %q, using %d", v, defaultUploadMaxMB)
+		return defaultUploadMaxMB << 20
+	}
+	return mb << 20
+}
+
+// FileHandler moves files in and out of a user's home directory
+type FileHandler struct {
+	runtime   service.ContainerRuntime
+	authz     *service.ContainerAuthorizer
+	quotas    *service.QuotaPolicy
+	maxUpload int64
+}
+
+// NewFileHandler creates a new file handler
+func NewFileHandler(runtime service.ContainerRuntime, db store.Store, quotas *service.QuotaPolicy) *FileHandler {
+	return &FileHandler{
+		runtime:   runtime,
+		authz:     service.NewContainerAuthorizer(runtime, db),
+		quotas:    quotas,
+		maxUpload: uploadLimitFromEnv(),
+	}
+}
+
+// fileError maps a file operation error to a response
+func fileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrFileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such file or directory"})
+	case errors.Is(err, service.ErrOutsideHome):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path must be inside the home directory"})
+	case errors.Is(err, service.ErrHomeQuota):
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+	default:
+		log.Printf("⚠️ File operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// resolve authorizes the container and resolves ?path= inside the home
+// directory. Symlinks are refused at every level, since the Docker archive
+// API would follow them out of the home. On failure it writes the response.
+func (h *FileHandler) resolve(c *gin.Context) (string, string, *service.FileEntry, bool) {
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), false)
+	if !ok {
+		return "", "", nil, false
+	}
+	ctx := context.Background()
+	account, err := h.runtime.ContainerAccount(ctx, grant.DockerID)
+	if err != nil {
+		fileError(c, err)
+		return "", "", nil, false
+	}
+	p, err := service.HomePathIn(account.Home, c.Query("path"))
+	if err != nil {
+		fileError(c, err)
+		return "", "", nil, false
+	}
+
+	var entry *service.FileEntry
+	// Stat the home itself, then each component below it
+	components := []string{""}
+	if rest := strings.Trim(strings.TrimPrefix(p, account.Home), "/"); rest != "" {
+		components = append(components, strings.Split(rest, "/")...)
+	}
+	current := account.Home
+	for _, part := range components {
+		current = path.Join(current, part)
+		entry, err = h.runtime.StatPath(ctx, grant.DockerID, current)
+		if err != nil {
+			fileError(c, err)
+			return "", "", nil, false
+		}
+		if entry.Type == "symlink" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "symbolic links are not followed: " + current})
+			return "", "", nil, false
+		}
+	}
+	return grant.DockerID, p, entry, true
+}
+
+// List returns the entries of a directory, directories first
+func (h *FileHandler) List(c *gin.Context) {
+	containerID, dir, entry, ok := h.resolve(c)
+	if !ok {
+		return
+	}
+	if entry.Type != "dir" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a directory, use /files/download"})
+		return
+	}
+
+	entries, err := h.runtime.ListDir(context.Background(), containerID, dir)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if (entries[i].Type == "dir") != (entries[j].Type == "dir") {
+			return entries[i].Type == "dir"
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	c.JSON(http.StatusOK, gin.H{"path": dir, "entries": entries})
+}
+
+// Download sends a file as-is, or a file or directory as ?format=tar|zip.
+// Directories default to tar.
+func (h *FileHandler) Download(c *gin.Context) {
+	containerID, p, entry, ok := h.resolve(c)
+	if !ok {
+		return
+	}
+	format := c.Query("format")
+	if format == "" {
+		format = "raw"
+		if entry.Type == "dir" {
+			format = "tar"
+		}
+	}
+	if format != "raw" && format != "tar" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be raw, tar or zip"})
+		return
+	}
+	if format == "raw" && entry.Type != "file" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only regular files can be downloaded raw, use format=tar or zip"})
+		return
+	}
+
+	archive, err := h.runtime.CopyFromContainer(context.Background(), containerID, p)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	defer archive.Close()
+
+	name := path.Base(p)
+	switch format {
+	case "raw":
+		tr := tar.NewReader(archive)
+		hdr, err := tr.Next()
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+		c.Header("Content-Disposition", attachment(name))
+		c.DataFromReader(http.StatusOK, hdr.Size, "application/octet-stream", tr, nil)
+	case "tar":
+		c.Header("Content-Disposition", attachment(name+".tar"))
+		c.DataFromReader(http.StatusOK, -1, "application/x-tar", archive, nil)
+	case "zip":
+		c.Header("Content-Disposition", attachment(name+".zip"))
+		c.Header("Content-Type", "application/zip")
+		c.Status(http.StatusOK)
+		if err := service.TarToZip(c.Writer, archive); err != nil {
+			log.Printf("⚠️ Zip download of %s failed: %v", p, err)
+		}
+	}
+}
+
+// attachment returns a Content-Disposition header for a download
+func attachment(name string) string {
+	return fmt.Sprintf(`attachment; filename=%q`, name)
+}
+
+// Upload stores the multipart "file" fields in the ?path= directory,
+// replacing files with the same name. The whole request is size-capped.
+func (h *FileHandler) Upload(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUpload)
+	containerID, dir, entry, ok := h.resolve(c)
+	if !ok {
+		return
+	}
+	if entry.Type != "dir" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "upload target must be a directory"})
+		return
+	}
+
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload too large", "limit": h.maxUpload})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer c.Request.MultipartForm.RemoveAll()
+	files := c.Request.MultipartForm.File["file"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `no "file" fields in the form`})
+		return
+	}
+	names := make([]string, 0, len(files))
+	var total int64
+	for _, fh := range files {
+		if !service.ValidFileName(fh.Filename) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid file name %q", fh.Filename)})
+			return
+		}
+		names = append(names, fh.Filename)
+		total += fh.Size
+	}
+	principal := GetPrincipal(c)
+	if h.quotas != nil {
+		tier := h.quotas.For(principal.TrustLevel)
+		if err := service.CheckHomeQuota(context.Background(), h.runtime, principal.UserID, tier, total); err != nil {
+			fileError(c, err)
+			return
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(writeUploadTar(pw, files)) }()
+	if err := h.runtime.CopyToContainer(context.Background(), containerID, dir, pr); err != nil {
+		pr.CloseWithError(err)
+		fileError(c, err)
+		return
+	}
+
+	log.Printf("📤 Uploaded %d file(s) to %s in %s", len(names), dir, containerID[:12])
+	c.JSON(http.StatusCreated, gin.H{"path": dir, "uploaded": names})
+}
+
+// writeUploadTar writes the uploaded files as a flat tar archive
+func writeUploadTar(w io.Writer, files []*multipart.FileHeader) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	for _, fh := range files {
+		src, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     fh.Filename,
+			Mode:     0o644,
+			Size:     fh.Size,
+			ModTime:  now,
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			_, err = io.Copy(tw, src)
+		}
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// Home reports the size of the caller's persistent home against the disk
+// quota of their trust level tier
+func (h *ContainerHandler) Home(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	size, err := h.dockerSvc.HomeUsage(context.Background(), principal.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read home usage: " + err.Error()})
+		return
+	}
+	// The home is mounted where the current container's account expects it
+	homePath := service.ContainerHome
+	if account := h.accounts.For(principal); account != nil {
+		homePath = account.Home
+	}
+	if record, err := h.db.GetContainerByUserID(principal.UserID); err == nil && record.Status != "removed" && record.DockerID != "" {
+		if account, err := h.dockerSvc.ContainerAccount(context.Background(), record.DockerID); err == nil {
+			homePath = account.Home
+		}
+	}
+	quota := h.quotas.For(principal.TrustLevel).DiskBytes()
+	c.JSON(http.StatusOK, gin.H{
+		"volume":     service.HomeVolumeName(principal.UserID),
+		"path":       homePath,
+		"size":       size,
+		"quota":      quota,
+		"over_quota": quota > 0 && size > quota,
+	})
+}
+
+// removeUserContainers removes the user's recorded container and marks it
+// removed, plus any container under the user's name the database lost track
+// of, unless that one belongs to someone else
+func removeUserContainers(ctx context.Context, rt service.ContainerRuntime, db store.Store, userID int64) error {
+	if record, err := db.GetContainerByUserID(userID); err == nil && record.DockerID != "" {
+		if _, err := rt.GetContainerStatus(ctx, record.DockerID); err == nil {
+			if err := rt.RemoveContainer(ctx, record.DockerID); err != nil {
+				return err
+			}
+		}
+		db.UpdateContainerStatus(record.ID, "removed", record.DockerID)
+	}
+	if id, _, err := rt.LookupContainer(ctx, service.ContainerName(userID)); err == nil {
+		if owner, err := db.GetContainerByDockerID(id); err != nil || owner.UserID == userID {
+			return rt.RemoveContainer(ctx, id)
+		}
+	}
+	return nil
+}
+
+// WipeHome removes the caller's container and deletes their persistent home
+func (h *ContainerHandler) WipeHome(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	userID := principal.UserID
+	ctx := context.Background()
+
+	// The volume can't be removed while a container still mounts it
+	if err := removeUserContainers(ctx, h.dockerSvc, h.db, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove: " + err.Error()})
+		return
+	}
+
+	if err := h.dockerSvc.WipeHome(ctx, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to wipe home: " + err.Error()})
+		return
+	}
+	log.Printf("🧹 Home wiped for user %s", principal.Username)
+	c.JSON(http.StatusOK, gin.H{"status": "wiped", "message": "home deleted, use /launch to start fresh"})
+}
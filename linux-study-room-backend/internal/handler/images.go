@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+)
+
+// ImageHandler serves the image catalog and rebuilds its images
+type ImageHandler struct {
+	catalog *service.Catalog
+	runtime service.ContainerRuntime
+	quotas  *service.QuotaPolicy
+
+	mu        sync.Mutex
+	building  bool
+	lastBuild map[string]service.BuildResult // Image ID -> most recent build
+}
+
+// NewImageHandler creates a new image handler
+func NewImageHandler(catalog *service.Catalog, runtime service.ContainerRuntime, quotas *service.QuotaPolicy) *ImageHandler {
+	return &ImageHandler{
+		catalog:   catalog,
+		runtime:   runtime,
+		quotas:    quotas,
+		lastBuild: make(map[string]service.BuildResult),
+	}
+}
+
+// ImageInfo is a catalog entry as shown to a user
+type ImageInfo struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	Shell         string   `json:"shell"`
+	Shells        []string `json:"shells"` // Login shells installed
+	MinTrustLevel int      `json:"min_trust_level"`
+	Allowed       bool     `json:"allowed"`           // Whether the caller's trust level and tier allow it
+	Version       string   `json:"version,omitempty"` // Current build; empty until built
+}
+
+// List returns the catalog in order, marking the images the caller may launch
+func (h *ImageHandler) List(c *gin.Context) {
+	principal := GetPrincipal(c)
+	trustLevel := 0
+	if principal != nil {
+		trustLevel = principal.TrustLevel
+	}
+
+	ctx := context.Background()
+	images := make([]ImageInfo, 0, len(h.catalog.Images))
+	for i := range h.catalog.Images {
+		spec := &h.catalog.Images[i]
+		version, _ := h.runtime.ImageVersion(ctx, spec)
+		images = append(images, ImageInfo{
+			ID:            spec.ID,
+			Name:          spec.Name,
+			Description:   spec.Description,
+			Shell:         spec.Shell,
+			Shells:        spec.Shells,
+			MinTrustLevel: spec.MinTrustLevel,
+			Allowed:       h.quotas.CanLaunch(trustLevel, spec),
+			Version:       version,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"images": images})
+}
+
+// RebuildRequest selects images to rebuild; empty means the whole catalog.
+// Force defaults to true: skip the layer cache and pull fresh base images.
+type RebuildRequest struct {
+	Images []string `json:"images"`
+	Force  *bool    `json:"force"`
+}
+
+// Rebuild builds catalog images and streams progress as newline-delimited
+// JSON BuildEvents, ending with {"status":"done","results":[...]}. Only one
+// rebuild runs at a time. Running containers keep their image; they pick up
+// the new version on their next reset.
+func (h *ImageHandler) Rebuild(c *gin.Context) {
+	var req RebuildRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	force := req.Force == nil || *req.Force
+
+	specs := h.catalog.Images
+	if len(req.Images) > 0 {
+		specs = make([]service.ImageSpec, 0, len(req.Images))
+		for _, id := range req.Images {
+			spec, ok := h.catalog.Get(id)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown image: " + id, "images": h.catalog.IDs()})
+				return
+			}
+			specs = append(specs, *spec)
+		}
+	}
+
+	h.mu.Lock()
+	if h.building {
+		h.mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "a rebuild is already running"})
+		return
+	}
+	h.building = true
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.building = false
+		h.mu.Unlock()
+	}()
+
+	log.Printf("🔨 Image rebuild started by %s (force=%v)", GetPrincipal(c).Username, force)
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	send := func(v any) {
+		// A client that went away doesn't stop the build
+		if enc.Encode(v) == nil {
+			c.Writer.Flush()
+		}
+	}
+
+	// The build outlives the request so a dropped connection can't leave a half-built tag
+	results := h.runtime.BuildImages(context.Background(), specs, force, func(ev service.BuildEvent) {
+		send(ev)
+	})
+
+	h.mu.Lock()
+	for _, r := range results {
+		h.lastBuild[r.Image] = r
+	}
+	h.mu.Unlock()
+	send(gin.H{"status": "done", "results": summarize(results)})
+}
+
+// BuildSummary is a BuildResult without its log
+type BuildSummary struct {
+	Image   string `json:"image"`
+	Status  string `json:"status"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// summarize drops the logs, which were already streamed
+func summarize(results []service.BuildResult) []BuildSummary {
+	out := make([]BuildSummary, len(results))
+	for i, r := range results {
+		out[i] = BuildSummary{Image: r.Image, Status: r.Status, Version: r.Version, Error: r.Error}
+	}
+	return out
+}
+
+// Builds returns the most recent build of each image with its full log
+func (h *ImageHandler) Builds(c *gin.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	builds := make([]service.BuildResult, 0, len(h.lastBuild))
+	for _, spec := range h.catalog.Images {
+		if r, ok := h.lastBuild[spec.ID]; ok {
+			builds = append(builds, r)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"building": h.building, "builds": builds})
+}
+
+// upgradeInfo compares the image version a container runs with the latest
+// build of its catalog image
+func upgradeInfo(ctx context.Context, rt service.ContainerRuntime, catalog *service.Catalog, osType, running string) gin.H {
+	info := gin.H{"image_version": running, "upgrade_available": false}
+	spec, ok := catalog.Get(osType)
+	if !ok {
+		return info
+	}
+	latest, err := rt.ImageVersion(ctx, spec)
+	if err != nil {
+		return info
+	}
+	info["latest_version"] = latest
+	info["upgrade_available"] = running != "" && running != latest
+	return info
+}
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"database/sql"
 	"fmt"
 	"net/http"
 
@@ -11,11 +10,11 @@ import (
 
 // LeaderboardHandler handles leaderboard API requests
 type LeaderboardHandler struct {
-	db *sql.DB
+	db store.Store
 }
 
 // NewLeaderboardHandler creates a new leaderboard handler
-func NewLeaderboardHandler(db *sql.DB) *LeaderboardHandler {
+func NewLeaderboardHandler(db store.Store) *LeaderboardHandler {
 	return &LeaderboardHandler{db: db}
 }
 
@@ -41,7 +40,7 @@ func formatDuration(seconds int64) string {
 
 // GetLeaderboard returns the top 10 users by online time
 func (h *LeaderboardHandler) GetLeaderboard(c *gin.Context) {
-	users, err := store.GetOnlineLeaderboard(h.db, 10)
+	users, err := h.db.GetOnlineLeaderboard(10)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get leaderboard"})
 		return
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/linuxstudyroom/backend/internal/service"
+)
+
+// removalWarningFromEnv reads CLEANUP_WARN_BEFORE (e.g. "24h"), how long
+// before an unused container is removed its owner is warned in the lobby
+func removalWarningFromEnv() time.Duration {
+	v := os.Getenv("CLEANUP_WARN_BEFORE")
+	if v == "" {
+		return service.DefaultRemovalWarning
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("⚠️ Invalid CLEANUP_WARN_BEFORE %q, using %s", v, service.DefaultRemovalWarning)
+		return service.DefaultRemovalWarning
+	}
+	return d
+}
@@ -1,9 +1,9 @@
 package handler
 
 import (
-	"database/sql"
 	"encoding/json"
 	"log"
+	"net/http"
 	"sort"
 	"sync"
 	"time"
@@ -17,13 +17,17 @@ import (
 // Invite cooldown duration
 const inviteCooldownSeconds = 30
 
+// lobbyWriteTimeout bounds each write so a stalled client can't hold up broadcasts
+const lobbyWriteTimeout = 10 * time.Second
+
 // LobbyHandler handles lobby/chat WebSocket connections
 type LobbyHandler struct {
 	clients         map[*websocket.Conn]*LobbyClient
 	mu              sync.RWMutex
-	db              *sql.DB
+	db              store.Store
 	inviteCooldowns map[string]time.Time // Track invite cooldowns per user
 	cooldownMu      sync.RWMutex
+	removalWarnings map[string]LobbyMessage // Username -> pending container removal warning, guarded by mu
 }
 
 // LobbyClient represents a connected client
@@ -32,11 +36,13 @@ type LobbyClient struct {
 	Name     string // Display name (nickname)
 	Avatar   string
 	OS       string
+
+	writeMu sync.Mutex // A websocket conn allows only one writer at a time
 }
 
 // LobbyMessage represents lobby WebSocket message
 type LobbyMessage struct {
-	Type              string        `json:"type"` // "users", "chat", "join", "leave", "snapshots", "like", "pin", "unpin", "history", "invite", "invite_accept", "invite_reject", "invite_sent", "invite_rejected_notify", "control_revoke", "helper_leave", "owner_cancel"
+	Type              string        `json:"type"` // "users", "chat", "join", "leave", "snapshots", "like", "pin", "unpin", "history", "invite", "invite_accept", "invite_reject", "invite_sent", "invite_rejected_notify", "control_revoke", "helper_leave", "owner_cancel", "removal_warning", "status"
 	Count             int           `json:"count,omitempty"`
 	Sessions          []SessionInfo `json:"sessions,omitempty"`
 	User              string        `json:"user,omitempty"`
@@ -70,14 +76,18 @@ type SessionInfo struct {
 	RawSnapshot string   `json:"rawSnapshot,omitempty"`
 	PinCount    int      `json:"pinCount"`
 	Helpers     []string `json:"helpers,omitempty"` // List of usernames helping this session
+
+	AllowSpectators bool `json:"allowSpectators"` // Can be watched live via /ws/terminal/watch
+	Spectators      int  `json:"spectators"`
 }
 
 // NewLobbyHandler creates a new lobby handler
-func NewLobbyHandler(db *sql.DB) *LobbyHandler {
+func NewLobbyHandler(db store.Store) *LobbyHandler {
 	h := &LobbyHandler{
 		clients:         make(map[*websocket.Conn]*LobbyClient),
 		db:              db,
 		inviteCooldowns: make(map[string]time.Time),
+		removalWarnings: make(map[string]LobbyMessage),
 	}
 	
 	// Start snapshot broadcaster
@@ -109,6 +119,9 @@ func (h *LobbyHandler) broadcastSnapshots() {
 				RawSnapshot: s.RawSnapshot,
 				PinCount:    s.PinCount,
 				Helpers:     s.Helpers,
+
+				AllowSpectators: s.AllowSpectators,
+				Spectators:      s.Spectators,
 			})
 		}
 		
@@ -137,6 +150,12 @@ func (h *LobbyHandler) broadcastSnapshots() {
 
 // Handle handles lobby WebSocket connection
 func (h *LobbyHandler) Handle(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
@@ -144,22 +163,10 @@ func (h *LobbyHandler) Handle(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	username := c.Query("username")
-	if username == "" {
-		username = "Guest_" + c.ClientIP()
-	}
-	
-	// Get display name from query, fallback to username
-	name := c.Query("name")
-	if name == "" {
-		name = username
-	}
-	
-	// Get avatar from query, fallback to dicebear
-	avatar := c.Query("avatar")
-	if avatar == "" {
-		avatar = "https://api.dicebear.com/7.x/avataaars/svg?seed=" + username
-	}
+	// Identity comes from the verified token only
+	username := principal.Username
+	name := principal.DisplayName()
+	avatar := principal.AvatarURL()
 
 	client := &LobbyClient{
 		Username: username,
@@ -176,10 +183,18 @@ func (h *LobbyHandler) Handle(c *gin.Context) {
 	log.Printf("👋 Lobby: %s joined (%d online)", username, len(h.clients))
 
 	// Send initial session list
-	h.sendSessionList(conn)
+	h.sendSessionList(conn, client)
 	
 	// Send chat history
-	h.sendChatHistory(conn)
+	h.sendChatHistory(conn, client)
+
+	// Repeat a pending container removal warning
+	h.mu.RLock()
+	warning, warned := h.removalWarnings[username]
+	h.mu.RUnlock()
+	if warned {
+		h.send(conn, client, warning)
+	}
 
 	// Ping ticker to keep connection alive (no ReadDeadline - user may be idle)
 	pingTicker := time.NewTicker(30 * time.Second)
@@ -193,7 +208,7 @@ func (h *LobbyHandler) Handle(c *gin.Context) {
 			case <-done:
 				return
 			case <-pingTicker.C:
-				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(lobbyWriteTimeout)); err != nil {
 					return
 				}
 			}
@@ -217,7 +232,7 @@ func (h *LobbyHandler) Handle(c *gin.Context) {
 		case "chat":
 			// Save to database (use username for storage, name for display)
 			if h.db != nil {
-				store.SaveChatMessage(h.db, client.Name, client.Avatar, msg.Content, "text")
+				h.db.SaveChatMessage(client.Name, client.Avatar, msg.Content, "text")
 			}
 			// Broadcast chat message with display name
 			chatMsg := LobbyMessage{
@@ -305,7 +320,7 @@ func (h *LobbyHandler) Handle(c *gin.Context) {
 							CooldownRemaining: remaining,
 							Timestamp:         time.Now().Unix(),
 						}
-						conn.WriteJSON(errMsg)
+						h.send(conn, client, errMsg)
 						log.Printf("❌ Invite cooldown: %s has %d seconds remaining", username, remaining)
 						continue
 					}
@@ -337,7 +352,7 @@ func (h *LobbyHandler) Handle(c *gin.Context) {
 					Content:           "邀请已发送，等待 " + msg.InviteTo + " 回应",
 					Timestamp:         time.Now().Unix(),
 				}
-				conn.WriteJSON(sentMsg)
+				h.send(conn, client, sentMsg)
 				
 				// Broadcast invite to all (target will filter)
 				inviteMsg := LobbyMessage{
@@ -495,25 +510,87 @@ func (h *LobbyHandler) Handle(c *gin.Context) {
 	log.Printf("👋 Lobby: %s left (%d online)", username, len(h.clients))
 }
 
-// broadcast sends message to all clients
-func (h *LobbyHandler) broadcast(msg LobbyMessage) {
+// send writes one message to a client, serialized with its other writers
+func (h *LobbyHandler) send(conn *websocket.Conn, client *LobbyClient, msg LobbyMessage) error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(lobbyWriteTimeout))
+	return conn.WriteJSON(msg)
+}
+
+// recipients snapshots the clients matching keep, so writes happen outside mu
+func (h *LobbyHandler) recipients(keep func(*LobbyClient) bool) map[*websocket.Conn]*LobbyClient {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
+	out := make(map[*websocket.Conn]*LobbyClient, len(h.clients))
+	for conn, client := range h.clients {
+		if keep == nil || keep(client) {
+			out[conn] = client
+		}
+	}
+	return out
+}
+
+// broadcast sends message to all clients
+func (h *LobbyHandler) broadcast(msg LobbyMessage) {
+	clients := h.recipients(nil)
 
 	// Log invite broadcasts for debugging
 	if msg.Type == "invite" {
-		log.Printf("📨 Broadcasting invite from %s to %s, sending to %d clients", msg.InviteFrom, msg.InviteTo, len(h.clients))
+		log.Printf("📨 Broadcasting invite from %s to %s, sending to %d clients", msg.InviteFrom, msg.InviteTo, len(clients))
 	}
 
-	for conn, client := range h.clients {
-		if err := conn.WriteJSON(msg); err != nil {
+	for conn, client := range clients {
+		if err := h.send(conn, client, msg); err != nil {
 			log.Printf("Broadcast error to %s: %v", client.Username, err)
 		}
 	}
 }
 
+// WarnRemoval tells a user their unused container will be removed at
+// removeAt. The warning is repeated whenever they join the lobby until a
+// zero removeAt withdraws it.
+func (h *LobbyHandler) WarnRemoval(username, containerID string, removeAt time.Time) {
+	if removeAt.IsZero() {
+		h.mu.Lock()
+		delete(h.removalWarnings, username)
+		h.mu.Unlock()
+		return
+	}
+	msg := LobbyMessage{
+		Type:              "removal_warning",
+		TargetUsername:    username,
+		TargetContainerID: containerID,
+		Content:           "你的容器长时间未使用，将于 " + removeAt.Local().Format("2006-01-02 15:04") + " 被删除（主目录会保留），在此之前打开终端即可保留",
+		Timestamp:         removeAt.Unix(), // Removal time
+	}
+	h.mu.Lock()
+	h.removalWarnings[username] = msg
+	h.mu.Unlock()
+
+	// Only the owner's connections get it
+	owners := h.recipients(func(client *LobbyClient) bool { return client.Username == username })
+	for conn, client := range owners {
+		if err := h.send(conn, client, msg); err != nil {
+			log.Printf("Removal warning to %s failed: %v", username, err)
+		}
+	}
+}
+
+// ContainerStatus tells everyone in the lobby that a container stopped and
+// why ("oom", "died", "stopped" or "removed"), so its session disappears
+func (h *LobbyHandler) ContainerStatus(username, containerID, reason string) {
+	h.broadcast(LobbyMessage{
+		Type:              "status",
+		TargetUsername:    username,
+		TargetContainerID: containerID,
+		Content:           reason,
+		Timestamp:         time.Now().Unix(),
+	})
+}
+
 // sendSessionList sends current session list to a specific client
-func (h *LobbyHandler) sendSessionList(conn *websocket.Conn) {
+func (h *LobbyHandler) sendSessionList(conn *websocket.Conn, client *LobbyClient) {
 	sessions := service.Sessions.GetAllSessions()
 	sessionInfos := make([]SessionInfo, 0, len(sessions))
 	
@@ -527,6 +604,9 @@ func (h *LobbyHandler) sendSessionList(conn *websocket.Conn) {
 			Snapshot:    s.Snapshot,
 			RawSnapshot: s.RawSnapshot,
 			PinCount:    s.PinCount,
+
+			AllowSpectators: s.AllowSpectators,
+			Spectators:      s.Spectators,
 		})
 	}
 	
@@ -546,16 +626,16 @@ func (h *LobbyHandler) sendSessionList(conn *websocket.Conn) {
 		Sessions: sessionInfos,
 	}
 	
-	conn.WriteJSON(msg)
+	h.send(conn, client, msg)
 }
 
 // sendChatHistory sends recent chat messages to a new client
-func (h *LobbyHandler) sendChatHistory(conn *websocket.Conn) {
+func (h *LobbyHandler) sendChatHistory(conn *websocket.Conn, client *LobbyClient) {
 	if h.db == nil {
 		return
 	}
 	
-	messages, err := store.GetRecentMessages(h.db, 500)
+	messages, err := h.db.GetRecentMessages(500)
 	if err != nil {
 		log.Printf("Failed to get chat history: %v", err)
 		return
@@ -579,5 +659,5 @@ func (h *LobbyHandler) sendChatHistory(conn *websocket.Conn) {
 		Messages: history,
 	}
 	
-	conn.WriteJSON(msg)
+	h.send(conn, client, msg)
 }
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// principalKey is the gin context key holding the authenticated *Principal
+	principalKey = "principal"
+	// authCookieName is the cookie checked when no Authorization header is
+	// sent; ignored on WebSocket upgrades
+	authCookieName = "lsr_token"
+	// authSubprotocol is the WebSocket subprotocol that carries the JWT.
+	// Browsers can't set headers on WebSocket requests, so clients send
+	// `Sec-WebSocket-Protocol: bearer, <jwt>` and the server echoes "bearer".
+	authSubprotocol = "bearer"
+)
+
+// Principal is the authenticated user extracted from a verified JWT
+type Principal struct {
+	ID         int64  `json:"id"`      // LinuxDo user ID
+	UserID     int64  `json:"user_id"` // users.id in our database
+	Username   string `json:"username"`
+	Name       string `json:"name"`
+	Avatar     string `json:"avatar"`
+	TrustLevel int    `json:"trust_level"`
+	Admin      bool   `json:"admin"` // Listed in ADMIN_USERS; never taken from the token
+}
+
+// DisplayName returns the nickname, falling back to the username
+func (p *Principal) DisplayName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Username
+}
+
+// AvatarURL builds an absolute avatar URL from the LinuxDo avatar template
+func (p *Principal) AvatarURL() string {
+	avatar := p.Avatar
+	if avatar == "" {
+		return "https://api.dicebear.com/7.x/avataaars/svg?seed=" + p.Username
+	}
+	if strings.Contains(avatar, "{size}") {
+		avatar = strings.ReplaceAll(avatar, "{size}", "120")
+	}
+	if strings.HasPrefix(avatar, "/") {
+		avatar = "https://linux.do" + avatar
+	}
+	return avatar
+}
+
+// RequireAuth returns a middleware that rejects requests without a valid JWT
+// and stores the resulting Principal in the gin context
+func (h *AuthHandler) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := tokenFromRequest(c.Request)
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
+			return
+		}
+
+		principal, err := h.parseToken(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		if h.db != nil {
+			userID, err := h.resolveUserID(principal)
+			if err != nil {
+				log.Printf("Failed to resolve user %s: %v", principal.Username, err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+				return
+			}
+			principal.UserID = userID
+		}
+
+		principal.Admin = h.admins[strings.ToLower(principal.Username)]
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects authenticated users who aren't in ADMIN_USERS.
+// Use it after RequireAuth.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p := GetPrincipal(c); p == nil || !p.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin only"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetPrincipal returns the authenticated user set by RequireAuth
+func GetPrincipal(c *gin.Context) *Principal {
+	if v, ok := c.Get(principalKey); ok {
+		if p, ok := v.(*Principal); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// parseToken verifies a JWT signed by Callback and converts its claims
+func (h *AuthHandler) parseToken(tokenString string) (*Principal, error) {
+	// An empty HMAC key would accept tokens signed by anyone
+	if len(h.jwtSecret) == 0 {
+		return nil, fmt.Errorf("JWT_SECRET not configured")
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return h.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid claims")
+	}
+	return principalFromClaims(claims)
+}
+
+// principalFromClaims maps the claims minted in Callback to a Principal
+func principalFromClaims(claims jwt.MapClaims) (*Principal, error) {
+	// JSON numbers decode as float64
+	id, ok := claims["id"].(float64)
+	if !ok || id <= 0 {
+		return nil, fmt.Errorf("missing id claim")
+	}
+	username, _ := claims["username"].(string)
+	if username == "" {
+		return nil, fmt.Errorf("missing username claim")
+	}
+	name, _ := claims["name"].(string)
+	avatar, _ := claims["avatar"].(string)
+	trustLevel, _ := claims["trust_level"].(float64)
+
+	return &Principal{
+		ID:         int64(id),
+		Username:   username,
+		Name:       name,
+		Avatar:     avatar,
+		TrustLevel: int(trustLevel),
+	}, nil
+}
+
+// tokenFromRequest extracts the JWT from the Authorization header,
+// the WebSocket subprotocol list or the auth cookie, in that order.
+// WebSocket upgrades never use the cookie: browsers send it along with
+// cross-site handshakes, which would let any page open the user's terminal.
+func tokenFromRequest(r *http.Request) string {
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+
+	// Sec-WebSocket-Protocol: bearer, <jwt>
+	var protocols []string
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+	for i, p := range protocols {
+		if p == authSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	if cookie, err := r.Cookie(authCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+func signTestToken(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"id":          42,
+		"username":    "alice",
+		"name":        "Alice",
+		"avatar":      "/user_avatar/linux.do/alice/{size}/1.png",
+		"trust_level": 2,
+		"exp":         time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestRequireAuth_TokenSources(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &AuthHandler{jwtSecret: []byte("test-secret")}
+	token := signTestToken(t, h.jwtSecret, validClaims())
+
+	cases := map[string]func(r *http.Request){
+		"header":      func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) },
+		"subprotocol": func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "bearer, "+token) },
+		"cookie":      func(r *http.Request) { r.AddCookie(&http.Cookie{Name: authCookieName, Value: token}) },
+	}
+
+	for name, setup := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got *Principal
+			r := gin.New()
+			r.GET("/", h.RequireAuth(), func(c *gin.Context) {
+				got = GetPrincipal(c)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			setup(req)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", w.Code)
+			}
+			if got == nil || got.ID != 42 || got.Username != "alice" || got.TrustLevel != 2 {
+				t.Fatalf("unexpected principal: %+v", got)
+			}
+			if got.AvatarURL() != "https://linux.do/user_avatar/linux.do/alice/120/1.png" {
+				t.Errorf("unexpected avatar URL: %s", got.AvatarURL())
+			}
+		})
+	}
+}
+
+func TestRequireAuth_Rejects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &AuthHandler{jwtSecret: []byte("test-secret")}
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noID := validClaims()
+	delete(noID, "id")
+
+	cases := map[string]string{
+		"missing":      "",
+		"wrong secret": signTestToken(t, []byte("other"), validClaims()),
+		"expired":      signTestToken(t, h.jwtSecret, expired),
+		"no id":        signTestToken(t, h.jwtSecret, noID),
+		"garbage":      "not-a-jwt",
+	}
+
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", h.RequireAuth(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/?username=alice", nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("expected 401, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireAuth_IgnoresCookieOnWebSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &AuthHandler{jwtSecret: []byte("test-secret")}
+	token := signTestToken(t, h.jwtSecret, validClaims())
+
+	r := gin.New()
+	r.GET("/", h.RequireAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// A cross-site page can make the browser send the cookie with a handshake
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Origin", "https://evil.example")
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestSyncUser_LegacyHashCollision(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := store.Init(store.DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	defer db.Close()
+	rt := service.NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+
+	// Two pre-users containers under the same hash, as if "mallory" collided with "alice"
+	ctx := context.Background()
+	hash := store.LegacyUserID("alice")
+	aliceID, _ := rt.CreateContainer(ctx, &service.ContainerConfig{UserID: hash, Username: "alice", OSType: "alpine"})
+	malloryID, _ := rt.CreateContainer(ctx, &service.ContainerConfig{UserID: 1, Username: "mallory", OSType: "alpine"})
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec("INSERT INTO containers (user_id, legacy_user_id, docker_id, os_type) VALUES (0, ?, ?, 'alpine'), (0, ?, ?, 'alpine')",
+		hash, malloryID, hash, aliceID); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &AuthHandler{db: db, runtime: rt}
+	userID, err := h.syncUser(42, "alice", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := db.GetContainerByUserID(userID); err != nil || c.DockerID != aliceID {
+		t.Fatalf("alice's container = %+v, %v", c, err)
+	}
+	if list, _ := db.ListLegacyContainers(hash); len(list) != 1 || list[0].DockerID != malloryID {
+		t.Fatalf("mallory's container was claimed: %+v", list)
+	}
+}
@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PoolStats reports each image's warm pool: its size, ready containers, and
+// how many first launches it served (hits) or had to create a container for
+// (misses), plus the launches of returning users it can't serve
+func (h *ContainerHandler) PoolStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"pools": h.pool.Stats()})
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+)
+
+// defaultEvictIdle is how long a container nobody is attached to may hold a
+// slot others are queued for, unless QUEUE_EVICT_IDLE is set
+const defaultEvictIdle = 10 * time.Minute
+
+// schedulerConfigFromEnv reads the host capacity (HOST_MAX_CONTAINERS,
+// HOST_MAX_MEMORY_MB, HOST_MAX_CPUS; unset or 0 is unlimited), QUEUE_PRIORITY
+// ("fifo" or "trust") and QUEUE_EVICT_IDLE (e.g. "10m"; "0" disables eviction)
+func schedulerConfigFromEnv() service.SchedulerConfig {
+	cfg := service.SchedulerConfig{EvictIdle: defaultEvictIdle}
+	if v := os.Getenv("HOST_MAX_CONTAINERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.Capacity.Containers = n
+		} else {
+			log.Printf("⚠️ Invalid HOST_MAX_CONTAINERS %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("HOST_MAX_MEMORY_MB"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			cfg.Capacity.MemoryMB = n
+		} else {
+			log.Printf("⚠️ Invalid HOST_MAX_MEMORY_MB %q, ignoring", v)
+		}
+	}
+	if v := os.Getenv("HOST_MAX_CPUS"); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil && n >= 0 {
+			cfg.Capacity.CPUs = n
+		} else {
+			log.Printf("⚠️ Invalid HOST_MAX_CPUS %q, ignoring", v)
+		}
+	}
+	switch v := os.Getenv("QUEUE_PRIORITY"); v {
+	case "", "fifo":
+	case "trust":
+		cfg.Priority = true
+	default:
+		log.Printf("⚠️ Invalid QUEUE_PRIORITY %q, using fifo", v)
+	}
+	if v := os.Getenv("QUEUE_EVICT_IDLE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.EvictIdle = d
+		} else {
+			log.Printf("⚠️ Invalid QUEUE_EVICT_IDLE %q, using %s", v, defaultEvictIdle)
+		}
+	}
+	return cfg
+}
+
+// admit reserves a launch slot for the caller, or answers 202 with their
+// place in the queue. After it returns true the caller must call
+// h.scheduler.Done once the container is started.
+func (h *ContainerHandler) admit(c *gin.Context, principal *Principal, quota *service.QuotaTier) bool {
+	st, ok := h.scheduler.Acquire(context.Background(), principal.UserID, principal.TrustLevel, quota)
+	if !ok {
+		c.JSON(http.StatusAccepted, gin.H{"queued": true, "queue": st})
+	}
+	return ok
+}
+
+// QueueStatus returns the caller's place in the launch queue and the host's load
+func (h *ContainerHandler) QueueStatus(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	resp := gin.H{"queue": h.scheduler.Status(principal.UserID), "capacity": h.scheduler.Capacity()}
+	if usage, err := h.scheduler.Usage(context.Background()); err == nil {
+		resp["usage"] = usage
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// LeaveQueue takes the caller out of the launch queue
+func (h *ContainerHandler) LeaveQueue(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	h.scheduler.Leave(principal.UserID)
+	c.JSON(http.StatusOK, gin.H{"queue": h.scheduler.Status(principal.UserID)})
+}
+
+// QueueMessage is sent on /ws/queue whenever the caller's place changes
+type QueueMessage struct {
+	Type string `json:"type"` // Always "queue"
+	service.QueueStatus
+}
+
+// WatchQueue streams the caller's queue status over a WebSocket. Once the
+// state is "ready" the client should call /api/container/launch again. A
+// connected client keeps its place however long the wait.
+func (h *ContainerHandler) WatchQueue(c *gin.Context) {
+	principal := GetPrincipal(c)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	updates, stop := h.scheduler.Watch(principal.UserID)
+	defer stop()
+
+	// The client only ever closes; reading notices that
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case st := <-updates:
+			if err := conn.WriteJSON(QueueMessage{Type: "queue", QueueStatus: st}); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
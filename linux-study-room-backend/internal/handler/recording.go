@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/linuxstudyroom/backend/internal/service"
+)
+
+// RecordingHandler serves a user's asciicast terminal recordings
+type RecordingHandler struct {
+	recordings *service.RecordingStore
+}
+
+// NewRecordingHandler creates a new recording handler
+func NewRecordingHandler(recordings *service.RecordingStore) *RecordingHandler {
+	return &RecordingHandler{recordings: recordings}
+}
+
+// defaultPlaybackIdle caps pauses during playback unless idle_limit is given
+const defaultPlaybackIdle = 2 * time.Second
+
+// recordingError maps a store error to a response
+func recordingError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrRecordingNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "recording not found"})
+		return
+	}
+	log.Printf("⚠️ Recording error: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// List returns the caller's recordings
+func (h *RecordingHandler) List(c *gin.Context) {
+	list, err := h.recordings.List(GetPrincipal(c).UserID)
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recordings": list})
+}
+
+// Download sends a recording as an asciicast v2 file playable with asciinema
+func (h *RecordingHandler) Download(c *gin.Context) {
+	id := c.Param("id")
+	f, err := h.recordings.Open(GetPrincipal(c).UserID, id)
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cast"`, id))
+	c.DataFromReader(http.StatusOK, info.Size(), "application/x-asciicast", f, nil)
+}
+
+// Delete removes one of the caller's recordings
+func (h *RecordingHandler) Delete(c *gin.Context) {
+	if err := h.recordings.Delete(GetPrincipal(c).UserID, c.Param("id")); err != nil {
+		recordingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// Play streams a recording over WebSocket in the terminal message format:
+// a "resize" with the recorded size, then "output" (and "input"/"resize")
+// messages with their original timing divided by ?speed= (default 1, 0.25-16).
+// Pauses are shortened to ?idle_limit= seconds (default 2, 0 keeps them).
+// A "status" message with "ended" follows the last event.
+func (h *RecordingHandler) Play(c *gin.Context) {
+	speed := 1.0
+	if v := c.Query("speed"); v != "" {
+		s, err := strconv.ParseFloat(v, 64)
+		if err != nil || s < 0.25 || s > 16 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "speed must be between 0.25 and 16"})
+			return
+		}
+		speed = s
+	}
+	idle := defaultPlaybackIdle
+	if v := c.Query("idle_limit"); v != "" {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid idle_limit"})
+			return
+		}
+		idle = time.Duration(secs * float64(time.Second))
+	}
+
+	f, err := h.recordings.Open(GetPrincipal(c).UserID, c.Param("id"))
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+	defer f.Close()
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Stop playing when the viewer goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err = service.PlayCast(ctx, f, speed, idle,
+		func(header service.CastHeader) error {
+			return conn.WriteJSON(TerminalMessage{Type: "resize", Cols: header.Width, Rows: header.Height})
+		},
+		func(ev service.CastEvent) error {
+			switch ev.Code {
+			case "o":
+				return conn.WriteJSON(TerminalMessage{Type: "output", Data: ev.Data})
+			case "i":
+				return conn.WriteJSON(TerminalMessage{Type: "input", Data: ev.Data})
+			case "r":
+				var cols, rows uint
+				if _, err := fmt.Sscanf(strings.ToLower(ev.Data), "%dx%d", &cols, &rows); err == nil {
+					return conn.WriteJSON(TerminalMessage{Type: "resize", Cols: cols, Rows: rows})
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("⚠️ Playback failed: %v", err)
+			conn.WriteJSON(TerminalMessage{Type: "status", Data: "error: " + err.Error()})
+		}
+		return
+	}
+	conn.WriteJSON(TerminalMessage{Type: "status", Data: "ended"})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// NewRouter wires every route onto a new gin engine. The background workers
+// it starts (lifecycle policy, event watcher, launch queue, warm pool) stop
+// when ctx is done.
+func NewRouter(ctx context.Context, runtime service.ContainerRuntime, db store.Store, recordings *service.RecordingStore, catalog *service.Catalog, quotas *service.QuotaPolicy) *gin.Engine {
+	r := gin.Default()
+
+	// CORS configuration - Allow all origins for open source deployment
+	r.Use(cors.New(cors.Config{
+		AllowAllOrigins:  true,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowCredentials: false,
+	}))
+
+	// Health check
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok", "service": "linux-study-room"})
+	})
+
+	// OAuth2 Authentication
+	authHandler := NewAuthHandler(runtime, db, quotas)
+	requireAuth := authHandler.RequireAuth()
+
+	// API routes
+	api := r.Group("/api")
+	{
+		// Public routes
+		api.GET("/auth/linuxdo", authHandler.Login)
+		api.GET("/auth/linuxdo/callback", authHandler.Callback)
+
+		// Leaderboard
+		leaderboardHandler := NewLeaderboardHandler(db)
+		api.GET("/leaderboard", leaderboardHandler.GetLeaderboard)
+	}
+
+	// Stops idle containers and removes unused ones per trust level, warning in the lobby first
+	lobbyHandler := NewLobbyHandler(db)
+	cleanupMgr := service.NewLifecycleManager(runtime, db, quotas, lobbyHandler, removalWarningFromEnv())
+	cleanupMgr.Start(ctx)
+	terminalHandler := NewTerminalHandler(runtime, cleanupMgr, db, recordings, catalog)
+	// Keeps records and sessions in step with containers that die or are removed outside the service
+	service.NewEventWatcher(runtime, db, lobbyHandler).Start(ctx)
+	recordingHandler := NewRecordingHandler(recordings)
+	// Launches wait in a queue when the host is at capacity
+	scheduler := service.NewScheduler(runtime, schedulerConfigFromEnv())
+	scheduler.Start(ctx)
+	// Started containers of images with a pool_size, claimed by launches. They
+	// get the lowest tier's limits, as first launches are mostly new users'.
+	pool := service.NewWarmPool(runtime, catalog, quotas.For(0), scheduler)
+	pool.Start(ctx)
+	containerHandler := NewContainerHandler(runtime, db, catalog, quotas, scheduler, pool)
+
+	// Authenticated API routes - identity always comes from the JWT
+	authed := api.Group("", requireAuth)
+	{
+		authed.GET("/auth/me", authHandler.Me)
+		imageHandler := NewImageHandler(catalog, runtime, quotas)
+		authed.GET("/images", imageHandler.List)
+		authed.DELETE("/auth/me", NewAccountHandler(runtime, db, recordings).Delete)
+		shellHandler := NewShellHandler(db, catalog)
+		authed.GET("/auth/me/shell", shellHandler.Get)
+		authed.PUT("/auth/me/shell", shellHandler.Set)
+
+		// Container management
+		authed.POST("/container/check", containerHandler.Check)
+		authed.POST("/container/launch", containerHandler.Launch)
+		authed.GET("/container/queue", containerHandler.QueueStatus)
+		authed.DELETE("/container/queue", containerHandler.LeaveQueue)
+		authed.GET("/container/home", containerHandler.Home)
+		authed.DELETE("/container/home", containerHandler.WipeHome)
+		authed.POST("/container/:id/restart", containerHandler.Restart)
+		authed.POST("/container/:id/reset", containerHandler.Reset)
+		authed.GET("/container/:id/status", containerHandler.Status)
+
+		// Checkpoints of the container filesystem (docker commit)
+		authed.GET("/checkpoints", containerHandler.ListCheckpoints)
+		authed.POST("/container/:id/checkpoints", containerHandler.CreateCheckpoint)
+		authed.POST("/checkpoints/:name/restore", containerHandler.RestoreCheckpoint)
+		authed.DELETE("/checkpoints/:name", containerHandler.DeleteCheckpoint)
+
+		// Files in the user's home directory
+		fileHandler := NewFileHandler(runtime, db, quotas)
+		authed.GET("/container/:id/files", fileHandler.List)
+		authed.GET("/container/:id/files/download", fileHandler.Download)
+		authed.POST("/container/:id/files", fileHandler.Upload)
+
+		// Terminal tabs within a container
+		authed.GET("/container/:id/terminals", terminalHandler.ListTerminals)
+		authed.POST("/container/:id/terminals", terminalHandler.CreateTerminal)
+		authed.DELETE("/container/:id/terminals/:terminal", terminalHandler.CloseTerminal)
+
+		// Terminal recordings (asciicast v2)
+		authed.GET("/recordings", recordingHandler.List)
+		authed.GET("/recordings/:id", recordingHandler.Download)
+		authed.DELETE("/recordings/:id", recordingHandler.Delete)
+
+		// Administration, for LinuxDo users listed in ADMIN_USERS
+		admin := authed.Group("/admin", RequireAdmin())
+		admin.POST("/images/rebuild", imageHandler.Rebuild)
+		admin.GET("/images/builds", imageHandler.Builds)
+		admin.GET("/pool", containerHandler.PoolStats)
+	}
+
+	// WebSocket routes
+	ws := r.Group("/ws", requireAuth)
+	{
+		ws.GET("/terminal", terminalHandler.Handle)
+		ws.GET("/terminal/helper", terminalHandler.HandleHelper) // Helper terminal
+		ws.GET("/terminal/watch", terminalHandler.HandleWatch)   // Read-only spectators
+		ws.GET("/recordings/:id/play", recordingHandler.Play)
+		ws.GET("/queue", containerHandler.WatchQueue) // Launch queue position
+		ws.GET("/lobby", lobbyHandler.Handle)
+	}
+
+	return r
+}
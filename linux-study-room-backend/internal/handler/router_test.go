@@ -0,0 +1,1159 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// newTestServer runs the full router on the fake runtime and a temp SQLite DB
+func newTestServer(t *testing.T) (*httptest.Server, *service.FakeRuntime) {
+	t.Helper()
+	catalog, err := service.LoadCatalog("")
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	return newTestServerWithCatalog(t, catalog)
+}
+
+func newTestServerWithCatalog(t *testing.T, catalog *service.Catalog) (*httptest.Server, *service.FakeRuntime) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	db, err := store.Init(store.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	rt := service.NewFakeRuntime("/bin/sh")
+	t.Cleanup(func() { rt.Close() })
+	quotas, err := service.LoadQuotaPolicy("", catalog)
+	if err != nil {
+		t.Fatalf("quotas: %v", err)
+	}
+	srv := httptest.NewServer(NewRouter(t.Context(), rt, db, service.NewRecordingStore(t.TempDir()), catalog, quotas))
+	t.Cleanup(srv.Close)
+	return srv, rt
+}
+
+func TestRouter_LaunchAndTerminal(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+
+	// Launch a container over REST
+	body, _ := json.Marshal(LaunchRequest{OSType: "alpine"})
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/container/launch", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("launch: expected 200, got %d", resp.StatusCode)
+	}
+	var launched struct {
+		ContainerID string `json:"container_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&launched); err != nil || launched.ContainerID == "" {
+		t.Fatalf("launch response: %+v err=%v", launched, err)
+	}
+
+	// Attach the terminal with the token in the subprotocol, like the browser does
+	conn := dialTerminal(t, srv, "/ws/terminal", launched.ContainerID, token)
+	defer conn.Close()
+
+	if err := conn.WriteJSON(TerminalMessage{Type: "input", Data: "echo router-$((40+2))\n"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readOutputUntil(t, conn, "router-42")
+
+	// An invited helper joins the same shell rather than a fresh one
+	service.Sessions.SetPendingInvite(launched.ContainerID, "bob")
+	service.Sessions.AddHelper(launched.ContainerID, "bob")
+	bobClaims := validClaims()
+	bobClaims["id"] = 43
+	bobClaims["username"] = "bob"
+	helper := dialTerminal(t, srv, "/ws/terminal/helper", launched.ContainerID, signTestToken(t, []byte("test-secret"), bobClaims))
+	defer helper.Close()
+
+	if err := conn.WriteJSON(TerminalMessage{Type: "input", Data: "SHARED=owner-var; echo set-$((0+1))\n"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readOutputUntil(t, helper, "set-1")
+	if err := helper.WriteJSON(TerminalMessage{Type: "input", Data: "echo helper-sees-$SHARED\n"}); err != nil {
+		t.Fatalf("helper write: %v", err)
+	}
+	readOutputUntil(t, helper, "helper-sees-owner-var")
+	readOutputUntil(t, conn, "helper-sees-owner-var")
+
+	// Any signed-in user may watch read-only while spectators are allowed
+	carolClaims := validClaims()
+	carolClaims["id"] = 44
+	carolClaims["username"] = "carol"
+	carolToken := signTestToken(t, []byte("test-secret"), carolClaims)
+	watcher := dialTerminal(t, srv, "/ws/terminal/watch", launched.ContainerID, carolToken)
+	defer watcher.Close()
+
+	watcher.WriteJSON(TerminalMessage{Type: "input", Data: "echo spectator-typed\n"})
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "echo owner-$((5+5))\n"})
+	readOutputUntil(t, watcher, "owner-10")
+
+	// Once the owner turns spectating off, new spectators are refused
+	conn.WriteJSON(TerminalMessage{Type: "spectators", Data: "off"})
+	readMessageUntil(t, conn, func(m TerminalMessage) bool { return m.Type == "spectators" && m.Data == "off" })
+	dialer := websocket.Dialer{Subprotocols: []string{authSubprotocol, carolToken}}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/terminal/watch?container_id=" + launched.ContainerID
+	if _, resp, err := dialer.Dial(wsURL, nil); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 with spectators disabled, got %v", err)
+	}
+	if service.Sessions.GetSession(launched.ContainerID).AllowSpectators {
+		t.Fatal("session still allows spectators")
+	}
+}
+
+// readMessageUntil reads terminal messages until one matches
+func readMessageUntil(t *testing.T, conn *websocket.Conn, match func(TerminalMessage) bool) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg TerminalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if match(msg) {
+			return
+		}
+	}
+}
+
+func dialTerminal(t *testing.T, srv *httptest.Server, path, containerID, token string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{authSubprotocol, token}}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + path + "?container_id=" + containerID
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", path, err)
+	}
+	return conn
+}
+
+// readOutputUntil reads terminal messages until the output contains want
+func readOutputUntil(t *testing.T, conn *websocket.Conn, want string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var output strings.Builder
+	for !strings.Contains(output.String(), want) {
+		var msg TerminalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v (output so far %q)", err, output.String())
+		}
+		if msg.Type == "output" {
+			output.WriteString(msg.Data)
+		}
+	}
+}
+
+func TestRouter_TerminalRequiresOwner(t *testing.T) {
+	srv, rt := newTestServer(t)
+
+	// A container nobody has a record for must not be attachable
+	id, err := rt.CreateContainer(t.Context(), &service.ContainerConfig{UserID: 999, OSType: "alpine", Username: "mallory"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	dialer := websocket.Dialer{Subprotocols: []string{authSubprotocol, token}}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/terminal?container_id=" + id
+	_, resp, err := dialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("expected handshake to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %v", resp)
+	}
+}
+
+// launchContainer launches an alpine container over REST and returns its ID
+func launchContainer(t *testing.T, srv *httptest.Server, token string) string {
+	t.Helper()
+	body, _ := json.Marshal(LaunchRequest{OSType: "alpine"})
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/container/launch", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	defer resp.Body.Close()
+	var launched struct {
+		ContainerID string `json:"container_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&launched); err != nil || launched.ContainerID == "" {
+		t.Fatalf("launch response %d: %+v err=%v", resp.StatusCode, launched, err)
+	}
+	return launched.ContainerID
+}
+
+func TestRouter_TerminalResume(t *testing.T) {
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+
+	conn := dialTerminal(t, srv, "/ws/terminal", containerID, token)
+	var resumeToken string
+	readMessageUntil(t, conn, func(m TerminalMessage) bool {
+		resumeToken = m.Data
+		return m.Type == "session"
+	})
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "KEPT=same-shell; echo ready-$((1+1))\n"})
+	var lastSeq uint64
+	readMessageUntil(t, conn, func(m TerminalMessage) bool {
+		lastSeq = m.Seq
+		return strings.Contains(m.Data, "ready-2")
+	})
+	conn.Close()
+
+	// Reconnecting with the token reattaches to the same shell
+	resumed := dialTerminal(t, srv, "/ws/terminal", containerID+"&resume_token="+resumeToken+"&last_seq="+strconv.FormatUint(lastSeq, 10), token)
+	defer resumed.Close()
+	readMessageUntil(t, resumed, func(m TerminalMessage) bool {
+		return m.Type == "session" && m.Data == resumeToken
+	})
+	resumed.WriteJSON(TerminalMessage{Type: "input", Data: "echo got-$KEPT\n"})
+	readOutputUntil(t, resumed, "got-same-shell")
+
+	if status, _ := rt.GetContainerStatus(context.Background(), containerID); status != "running" {
+		t.Fatalf("container should keep running across the reconnect, got %q", status)
+	}
+}
+
+func TestRouter_LastTerminalKeepsContainer(t *testing.T) {
+	t.Setenv("TERMINAL_RESUME_GRACE", "0")
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+
+	conn := dialTerminal(t, srv, "/ws/terminal", containerID, token)
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "echo up-$((2+2))\n"})
+	readOutputUntil(t, conn, "up-4")
+	conn.Close()
+
+	// The session ends with the last terminal; stopping is left to idle_minutes
+	deadline := time.Now().Add(5 * time.Second)
+	for service.Sessions.GetSession(containerID) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("session outlived its last terminal")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, _ := rt.GetContainerStatus(context.Background(), containerID); status != "running" {
+		t.Fatalf("container is %q after the last terminal closed, want running", status)
+	}
+}
+
+// apiRequest sends an authenticated JSON request, decodes the response into out and returns the status
+func apiRequest(t *testing.T, srv *httptest.Server, token, method, path string, body, out any) int {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, reader)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func TestRouter_TerminalTabs(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+
+	do := func(method, path string, body any, out any) int {
+		t.Helper()
+		return apiRequest(t, srv, token, method, path, body, out)
+	}
+	base := "/api/container/" + containerID + "/terminals"
+
+	main := dialTerminal(t, srv, "/ws/terminal", containerID, token)
+	defer main.Close()
+	main.WriteJSON(TerminalMessage{Type: "input", Data: "TAB=main; echo main-$((1+1))\n"})
+	readOutputUntil(t, main, "main-2")
+
+	var created TerminalInfo
+	if code := do(http.MethodPost, base, CreateTerminalRequest{Name: "build"}, &created); code != http.StatusCreated {
+		t.Fatalf("create terminal: %d", code)
+	}
+	if created.ID == "" || created.Name != "build" || created.ResumeToken == "" {
+		t.Fatalf("unexpected terminal %+v", created)
+	}
+
+	// The new tab is a separate shell in the same container
+	tab := dialTerminal(t, srv, "/ws/terminal", containerID+"&terminal="+created.ID+"&resume_token="+created.ResumeToken, token)
+	defer tab.Close()
+	tab.WriteJSON(TerminalMessage{Type: "resize", Cols: 70, Rows: 20})
+	tab.WriteJSON(TerminalMessage{Type: "input", Data: "echo tab-[$TAB]\n"})
+	readOutputUntil(t, tab, "tab-[]")
+
+	var list struct {
+		Terminals []TerminalInfo `json:"terminals"`
+	}
+	do(http.MethodGet, base, nil, &list)
+	if len(list.Terminals) != 2 || list.Terminals[0].ID != service.MainTerminal || list.Terminals[1].Cols != 70 {
+		t.Fatalf("unexpected terminal list %+v", list.Terminals)
+	}
+
+	// Closing a tab leaves the session and the other shell alone
+	if code := do(http.MethodDelete, base+"/"+created.ID, nil, nil); code != http.StatusOK {
+		t.Fatalf("close terminal: %d", code)
+	}
+	main.WriteJSON(TerminalMessage{Type: "input", Data: "echo still-$TAB\n"})
+	readOutputUntil(t, main, "still-main")
+	do(http.MethodGet, base, nil, &list)
+	if len(list.Terminals) != 1 {
+		t.Fatalf("expected one terminal left, got %+v", list.Terminals)
+	}
+
+	// Unknown terminals are reported as missing
+	if code := do(http.MethodDelete, base+"/nope", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("close unknown terminal: %d", code)
+	}
+}
+
+func TestRouter_RecordAndPlayback(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+
+	conn := dialTerminal(t, srv, "/ws/terminal", containerID+"&record=1", token)
+	defer conn.Close()
+	var recordingID string
+	readMessageUntil(t, conn, func(m TerminalMessage) bool {
+		recordingID = m.Data
+		return m.Type == "record"
+	})
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "echo taped-$((6*7))\n"})
+	readOutputUntil(t, conn, "taped-42")
+	conn.WriteJSON(TerminalMessage{Type: "record", Data: "off"})
+	readMessageUntil(t, conn, func(m TerminalMessage) bool { return m.Type == "record" && m.Data == "" })
+
+	get := func(method, path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	var list struct {
+		Recordings []service.Recording `json:"recordings"`
+	}
+	json.NewDecoder(get(http.MethodGet, "/api/recordings").Body).Decode(&list)
+	if len(list.Recordings) != 1 || list.Recordings[0].ID != recordingID {
+		t.Fatalf("unexpected recordings %+v", list.Recordings)
+	}
+
+	resp := get(http.MethodGet, "/api/recordings/"+recordingID)
+	cast, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(cast), `{"version":2`) || !strings.Contains(string(cast), "taped-42") {
+		t.Fatalf("download %d: %q", resp.StatusCode, cast)
+	}
+
+	// Playback replays the output in the terminal message format
+	player := dialTerminal(t, srv, "/ws/recordings/"+recordingID+"/play", "&speed=16", token)
+	defer player.Close()
+	readOutputUntil(t, player, "taped-42")
+	readMessageUntil(t, player, func(m TerminalMessage) bool { return m.Type == "status" && m.Data == "ended" })
+
+	// Other users can't see it
+	otherClaims := validClaims()
+	otherClaims["id"] = 45
+	otherClaims["username"] = "dave"
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/recordings/"+recordingID, nil)
+	req.Header.Set("Authorization", "Bearer "+signTestToken(t, []byte("test-secret"), otherClaims))
+	if other, err := http.DefaultClient.Do(req); err != nil || other.StatusCode != http.StatusNotFound {
+		t.Fatalf("other user download: %v %v", other, err)
+	} else {
+		other.Body.Close()
+	}
+
+	if resp := get(http.MethodDelete, "/api/recordings/"+recordingID); resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+	if resp := get(http.MethodGet, "/api/recordings/"+recordingID); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("download after delete: %d", resp.StatusCode)
+	}
+}
+
+func TestRouter_TerminalBinaryProtocol(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+
+	dialer := websocket.Dialer{
+		Subprotocols:      []string{terminalProtocolV2, authSubprotocol, token},
+		EnableCompression: true,
+	}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/terminal?container_id=" + containerID
+	conn, resp, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != terminalProtocolV2 {
+		t.Fatalf("negotiated %q", conn.Subprotocol())
+	}
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("compression not negotiated: %q", ext)
+	}
+
+	// Status messages travel as JSON behind their opcode
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		kind, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if kind != websocket.BinaryMessage {
+			t.Fatalf("expected binary frames, got %d", kind)
+		}
+		var msg TerminalMessage
+		if frame[0] == opStatus && json.Unmarshal(frame[1:], &msg) == nil && msg.Type == "session" {
+			break
+		}
+	}
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte{opResize, 0, 100, 0, 30})
+	conn.WriteMessage(websocket.BinaryMessage, append([]byte{opInput}, "printf '\\344\\270\\255\\346\\226\\207-%s\\n' ok\n"...))
+	conn.WriteMessage(websocket.BinaryMessage, []byte{opPing, 'h', 'i'})
+
+	var output strings.Builder
+	var lastSeq uint64
+	gotPong := false
+	for !gotPong || !strings.Contains(output.String(), "中文-ok") {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v (output %q)", err, output.String())
+		}
+		switch frame[0] {
+		case opOutput:
+			seq := binary.BigEndian.Uint64(frame[1:9])
+			if seq <= lastSeq {
+				t.Fatalf("seq went from %d to %d", lastSeq, seq)
+			}
+			lastSeq = seq
+			output.Write(frame[9:])
+		case opPing:
+			gotPong = string(frame[1:]) == "hi"
+		}
+	}
+}
+
+func TestRouter_HomeSurvivesReset(t *testing.T) {
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+
+	// The shell starts in the persistent home
+	conn := dialTerminal(t, srv, "/ws/terminal", containerID, token)
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "echo kept > \"$HOME/notes.txt\"; echo wr''ote\n"})
+	readOutputUntil(t, conn, "wrote")
+	conn.Close()
+
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/container/"+containerID+"/reset", nil, nil); code != http.StatusOK {
+		t.Fatalf("reset: %d", code)
+	}
+	var launched struct {
+		ContainerID string `json:"container_id"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/container/launch", LaunchRequest{OSType: "debian"}, &launched); code != http.StatusOK || launched.ContainerID == containerID {
+		t.Fatalf("relaunch: %d %+v", code, launched)
+	}
+
+	var me struct {
+		UserID int64 `json:"user_id"`
+	}
+	apiRequest(t, srv, token, http.MethodGet, "/api/auth/me", nil, &me)
+	home, err := rt.HomeDir(me.UserID)
+	if err != nil {
+		t.Fatalf("home dir: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(home, "notes.txt")); err != nil || string(data) != "kept\n" {
+		t.Fatalf("home not kept across reset and OS switch: %q %v", data, err)
+	}
+
+	var usage struct {
+		Volume    string `json:"volume"`
+		Size      int64  `json:"size"`
+		Quota     int64  `json:"quota"`
+		OverQuota bool   `json:"over_quota"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodGet, "/api/container/home", nil, &usage); code != http.StatusOK {
+		t.Fatalf("home usage: %d", code)
+	}
+	if usage.Volume != service.HomeVolumeName(me.UserID) || usage.Size != 5 || usage.Quota != 1024<<20 || usage.OverQuota {
+		t.Fatalf("usage = %+v", usage)
+	}
+
+	// Wiping removes the container along with the files
+	if code := apiRequest(t, srv, token, http.MethodDelete, "/api/container/home", nil, nil); code != http.StatusOK {
+		t.Fatalf("wipe: %d", code)
+	}
+	if _, err := rt.GetContainerStatus(context.Background(), launched.ContainerID); err == nil {
+		t.Fatal("container still exists after wiping home")
+	}
+	if _, err := os.Stat(filepath.Join(home, "notes.txt")); !os.IsNotExist(err) {
+		t.Fatalf("file survived wipe: %v", err)
+	}
+}
+
+func TestRouter_Checkpoints(t *testing.T) {
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+	var me struct {
+		UserID int64 `json:"user_id"`
+	}
+	apiRequest(t, srv, token, http.MethodGet, "/api/auth/me", nil, &me)
+
+	create := func(name string) int {
+		t.Helper()
+		return apiRequest(t, srv, token, http.MethodPost, "/api/container/"+containerID+"/checkpoints", CreateCheckpointRequest{Name: name}, nil)
+	}
+	if code := create("clean"); code != http.StatusCreated {
+		t.Fatalf("create: %d", code)
+	}
+	if code := create("clean"); code != http.StatusConflict {
+		t.Fatalf("duplicate: %d", code)
+	}
+	if code := create("../x"); code != http.StatusBadRequest {
+		t.Fatalf("bad name: %d", code)
+	}
+	// Trust level 2 may keep three
+	create("second")
+	create("third")
+	if code := create("fourth"); code != http.StatusForbidden {
+		t.Fatalf("over limit: %d", code)
+	}
+
+	var list struct {
+		Checkpoints []service.Checkpoint `json:"checkpoints"`
+		Limit       int                  `json:"limit"`
+	}
+	apiRequest(t, srv, token, http.MethodGet, "/api/checkpoints", nil, &list)
+	if len(list.Checkpoints) != 3 || list.Limit != 3 || list.Checkpoints[0].OSType != "alpine" || list.Checkpoints[0].Size == 0 {
+		t.Fatalf("list = %+v", list)
+	}
+
+	var restored struct {
+		ContainerID string `json:"container_id"`
+		Checkpoint  string `json:"checkpoint"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/checkpoints/clean/restore", nil, &restored); code != http.StatusOK {
+		t.Fatalf("restore: %d", code)
+	}
+	if restored.ContainerID == "" || restored.ContainerID == containerID || restored.Checkpoint != "clean" {
+		t.Fatalf("restored = %+v", restored)
+	}
+	if _, err := rt.GetContainerStatus(context.Background(), containerID); err == nil {
+		t.Fatal("old container survived the restore")
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/checkpoints/missing/restore", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("restore missing: %d", code)
+	}
+
+	if code := apiRequest(t, srv, token, http.MethodDelete, "/api/checkpoints/second", nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: %d", code)
+	}
+	if code := apiRequest(t, srv, token, http.MethodDelete, "/api/checkpoints/second", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("second delete: %d", code)
+	}
+
+	// Deleting the account takes the remaining checkpoints with it
+	if code := apiRequest(t, srv, token, http.MethodDelete, "/api/auth/me", nil, nil); code != http.StatusOK {
+		t.Fatalf("delete account: %d", code)
+	}
+	if left, _ := rt.ListCheckpoints(context.Background(), me.UserID); len(left) != 0 {
+		t.Fatalf("checkpoints left after account deletion: %+v", left)
+	}
+	if _, err := rt.GetContainerStatus(context.Background(), restored.ContainerID); err == nil {
+		t.Fatal("container survived account deletion")
+	}
+}
+
+func TestRouter_Files(t *testing.T) {
+	t.Setenv("UPLOAD_MAX_MB", "1")
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+	base := srv.URL + "/api/container/" + containerID + "/files"
+
+	upload := func(query string, files map[string][]byte) int {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for name, data := range files {
+			fw, _ := mw.CreateFormFile("file", name)
+			fw.Write(data)
+		}
+		mw.Close()
+		req, _ := http.NewRequest(http.MethodPost, base+query, &body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	get := func(url string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, data
+	}
+
+	if code := upload("", map[string][]byte{"hello.txt": []byte("hello\n"), "b.bin": {1, 2, 3}}); code != http.StatusCreated {
+		t.Fatalf("upload: %d", code)
+	}
+	if code := upload("", map[string][]byte{"big.bin": make([]byte, 2<<20)}); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized upload: %d", code)
+	}
+
+	var listing struct {
+		Path    string              `json:"path"`
+		Entries []service.FileEntry `json:"entries"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodGet, "/api/container/"+containerID+"/files", nil, &listing); code != http.StatusOK {
+		t.Fatalf("list: %d", code)
+	}
+	if listing.Path != service.ContainerHome || len(listing.Entries) != 2 || listing.Entries[1].Name != "hello.txt" || listing.Entries[1].Size != 6 {
+		t.Fatalf("listing = %+v", listing)
+	}
+
+	resp, data := get(base + "/download?path=hello.txt")
+	if resp.StatusCode != http.StatusOK || string(data) != "hello\n" || !strings.Contains(resp.Header.Get("Content-Disposition"), "hello.txt") {
+		t.Fatalf("raw download: %d %q", resp.StatusCode, data)
+	}
+	resp, data = get(base + "/download?path=/root&format=zip")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("zip download: %d", resp.StatusCode)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["root/hello.txt"] || !names["root/b.bin"] {
+		t.Fatalf("zip entries %v", names)
+	}
+
+	// Paths are confined to the home directory and symlinks aren't followed
+	if resp, _ := get(base + "?path=../etc"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("traversal: %d", resp.StatusCode)
+	}
+	var me struct {
+		UserID int64 `json:"user_id"`
+	}
+	apiRequest(t, srv, token, http.MethodGet, "/api/auth/me", nil, &me)
+	home, _ := rt.HomeDir(me.UserID)
+	if err := os.Symlink("/etc", filepath.Join(home, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get(base + "/download?path=etc/passwd"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("symlink: %d", resp.StatusCode)
+	}
+	if resp, _ := get(base + "?path=missing"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing: %d", resp.StatusCode)
+	}
+
+	// A home past its tier's disk_mb takes no more uploads or checkpoints
+	if err := os.Truncate(filepath.Join(home, "b.bin"), 1<<30+1); err != nil {
+		t.Fatal(err)
+	}
+	if code := upload("", map[string][]byte{"more.txt": []byte("more\n")}); code != http.StatusInsufficientStorage {
+		t.Fatalf("upload over quota: %d", code)
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/container/"+containerID+"/checkpoints", nil, nil); code != http.StatusInsufficientStorage {
+		t.Fatalf("checkpoint over quota: %d", code)
+	}
+	os.Remove(filepath.Join(home, "b.bin"))
+	if code := upload("", map[string][]byte{"more.txt": []byte("more\n")}); code != http.StatusCreated {
+		t.Fatalf("upload after freeing space: %d", code)
+	}
+
+	// Someone else's container is off limits
+	other := validClaims()
+	other["id"] = 43
+	other["username"] = "bob"
+	if resp, err := http.DefaultClient.Do(func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, base, nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(t, []byte("test-secret"), other))
+		return req
+	}()); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("other user: %v %v", resp, err)
+	}
+}
+
+func TestRouter_ImageCatalog(t *testing.T) {
+	catalog, err := service.ParseCatalog([]byte(`{"images": [
+		{"id": "alpine", "name": "Alpine", "base": "alpine:3.19", "package_manager": "apk", "packages": ["fish"], "shell": "fish"},
+		{"id": "kali", "name": "Kali", "base": "kalilinux/kali-rolling", "shell": "bash", "min_trust_level": 3}
+	]}`), "")
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	srv, _ := newTestServerWithCatalog(t, catalog)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+
+	var list struct {
+		Images []ImageInfo `json:"images"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodGet, "/api/images", nil, &list); code != http.StatusOK {
+		t.Fatalf("list: %d", code)
+	}
+	if len(list.Images) != 2 || list.Images[0].ID != "alpine" || !list.Images[0].Allowed || list.Images[1].Allowed || list.Images[1].Shell != "bash" {
+		t.Fatalf("images = %+v", list.Images)
+	}
+
+	// Launch only accepts catalog images the user's trust level allows
+	for image, want := range map[string]int{"debian": http.StatusBadRequest, "kali": http.StatusForbidden, "alpine": http.StatusOK} {
+		if code := apiRequest(t, srv, token, http.MethodPost, "/api/container/launch", LaunchRequest{OSType: image}, nil); code != want {
+			t.Errorf("launch %s: %d, want %d", image, code, want)
+		}
+	}
+}
+
+func TestRouter_ImageRebuild(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "Alice")
+	srv, _ := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	bobClaims := validClaims()
+	bobClaims["id"] = 43
+	bobClaims["username"] = "bob"
+	bobToken := signTestToken(t, []byte("test-secret"), bobClaims)
+
+	if code := apiRequest(t, srv, bobToken, http.MethodPost, "/api/admin/images/rebuild", nil, nil); code != http.StatusForbidden {
+		t.Fatalf("non-admin rebuild: %d", code)
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/admin/images/rebuild", RebuildRequest{Images: []string{"gentoo"}}, nil); code != http.StatusBadRequest {
+		t.Fatalf("unknown image: %d", code)
+	}
+
+	// rebuild streams one JSON event per line and returns alpine's new version
+	rebuild := func() string {
+		t.Helper()
+		body, _ := json.Marshal(RebuildRequest{Images: []string{"alpine"}})
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/admin/images/rebuild", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("rebuild: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("rebuild: %d", resp.StatusCode)
+		}
+		var statuses []string
+		var done struct {
+			Results []BuildSummary `json:"results"`
+		}
+		dec := json.NewDecoder(resp.Body)
+		for dec.More() {
+			var line json.RawMessage
+			if err := dec.Decode(&line); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			var ev service.BuildEvent
+			json.Unmarshal(line, &ev)
+			statuses = append(statuses, ev.Status)
+			if ev.Status == "done" {
+				json.Unmarshal(line, &done)
+			}
+		}
+		joined := strings.Join(statuses, ",")
+		if !strings.HasPrefix(joined, "started,running") || !strings.HasSuffix(joined, "built,done") {
+			t.Fatalf("events = %s", joined)
+		}
+		if len(done.Results) != 1 || done.Results[0].Status != service.BuildDone || done.Results[0].Version == "" {
+			t.Fatalf("results = %+v", done.Results)
+		}
+		return done.Results[0].Version
+	}
+
+	first := rebuild()
+	var list struct {
+		Images []ImageInfo `json:"images"`
+	}
+	apiRequest(t, srv, token, http.MethodGet, "/api/images", nil, &list)
+	if list.Images[0].Version != first || list.Images[1].Version != "" {
+		t.Fatalf("images = %+v", list.Images)
+	}
+
+	// A new container records the version it runs
+	launchContainer(t, srv, token)
+	var check map[string]any
+	apiRequest(t, srv, token, http.MethodPost, "/api/container/check", nil, &check)
+	if check["image_version"] != first || check["upgrade_available"] != false {
+		t.Fatalf("check after launch = %v", check)
+	}
+
+	// After a rebuild the running container is offered the upgrade
+	second := rebuild()
+	if second == first {
+		t.Fatalf("forced rebuild kept version %s", first)
+	}
+	apiRequest(t, srv, token, http.MethodPost, "/api/container/check", nil, &check)
+	if check["image_version"] != first || check["latest_version"] != second || check["upgrade_available"] != true {
+		t.Fatalf("check after rebuild = %v", check)
+	}
+
+	var builds struct {
+		Builds []service.BuildResult `json:"builds"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodGet, "/api/admin/images/builds", nil, &builds); code != http.StatusOK {
+		t.Fatalf("builds: %d", code)
+	}
+	if len(builds.Builds) != 1 || builds.Builds[0].Version != second || !strings.Contains(builds.Builds[0].Log, "FROM alpine") {
+		t.Fatalf("builds = %+v", builds.Builds)
+	}
+}
+
+func TestRouter_LoginShell(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+
+	var pref struct {
+		Shell     string   `json:"shell"`
+		Shells    []string `json:"shells"`
+		Effective string   `json:"effective"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodGet, "/api/auth/me/shell", nil, &pref); code != http.StatusOK || pref.Shell != "" || len(pref.Shells) != 4 {
+		t.Fatalf("get: %d %+v", code, pref)
+	}
+	if code := apiRequest(t, srv, token, http.MethodPut, "/api/auth/me/shell", SetShellRequest{Shell: "tcsh"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("tcsh: %d", code)
+	}
+	if code := apiRequest(t, srv, token, http.MethodPut, "/api/auth/me/shell", SetShellRequest{Shell: "bash"}, &pref); code != http.StatusOK || pref.Shell != "bash" {
+		t.Fatalf("set: %d %+v", code, pref)
+	}
+
+	// New terminals run the preferred shell; the fake runtime reports it in $SHELL
+	containerID := launchContainer(t, srv, token)
+	apiRequest(t, srv, token, http.MethodGet, "/api/auth/me/shell", nil, &pref)
+	if pref.Effective != "bash" {
+		t.Fatalf("effective shell = %q", pref.Effective)
+	}
+	conn := dialTerminal(t, srv, "/ws/terminal", containerID, token)
+	defer conn.Close()
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "echo login-$SHELL\n"})
+	readOutputUntil(t, conn, "login-bash")
+
+	// Clearing the preference goes back to the image default
+	if code := apiRequest(t, srv, token, http.MethodPut, "/api/auth/me/shell", SetShellRequest{}, &pref); code != http.StatusOK || pref.Effective != "fish" {
+		t.Fatalf("clear: %d %+v", code, pref)
+	}
+}
+
+func TestRouter_ContainerUser(t *testing.T) {
+	t.Setenv("CONTAINER_USER", "user")
+	t.Setenv("SUDO_MIN_TRUST_LEVEL", "3")
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+
+	var launched struct {
+		ContainerID string `json:"container_id"`
+		User        string `json:"user"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/container/launch", LaunchRequest{OSType: "alpine"}, &launched); code != http.StatusOK || launched.User != "alice" {
+		t.Fatalf("launch: %d %+v", code, launched)
+	}
+	account, err := rt.ContainerAccount(t.Context(), launched.ContainerID)
+	if err != nil || account.Home != "/home/alice" || account.Sudo {
+		t.Fatalf("account = %+v, %v; want /home/alice without sudo at trust level 2", account, err)
+	}
+
+	// Sessions run as the account and files resolve in its home
+	conn := dialTerminal(t, srv, "/ws/terminal", launched.ContainerID, token)
+	defer conn.Close()
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "echo whoami-$USER\n"})
+	readOutputUntil(t, conn, "whoami-alice")
+
+	var listing struct {
+		Path string `json:"path"`
+	}
+	base := "/api/container/" + launched.ContainerID + "/files"
+	if code := apiRequest(t, srv, token, http.MethodGet, base, nil, &listing); code != http.StatusOK || listing.Path != "/home/alice" {
+		t.Fatalf("list: %d %+v", code, listing)
+	}
+	if code := apiRequest(t, srv, token, http.MethodGet, base+"?path=/root", nil, nil); code != http.StatusBadRequest {
+		t.Fatalf("/root should be outside the home: %d", code)
+	}
+	var home struct {
+		Path string `json:"path"`
+	}
+	if apiRequest(t, srv, token, http.MethodGet, "/api/container/home", nil, &home); home.Path != "/home/alice" {
+		t.Fatalf("home path = %q", home.Path)
+	}
+}
+
+func TestRouter_QuotaTier(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+
+	var me struct {
+		Quota service.QuotaTier `json:"quota"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodGet, "/api/auth/me", nil, &me); code != http.StatusOK {
+		t.Fatalf("me: %d", code)
+	}
+	if me.Quota.TrustLevel != 2 || me.Quota.MemoryMB != 512 || me.Quota.Checkpoints != 3 || me.Quota.SessionMinutes != 240 {
+		t.Fatalf("quota = %+v", me.Quota)
+	}
+
+	var launched struct {
+		Quota service.QuotaTier `json:"quota"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/container/launch", LaunchRequest{OSType: "alpine"}, &launched); code != http.StatusOK || launched.Quota.PidsLimit != 512 {
+		t.Fatalf("launch: %d %+v", code, launched)
+	}
+
+	// A newcomer only gets the smaller tier
+	claims := validClaims()
+	claims["id"], claims["username"], claims["trust_level"] = 43, "bob", 0
+	newcomer := signTestToken(t, []byte("test-secret"), claims)
+	if apiRequest(t, srv, newcomer, http.MethodGet, "/api/auth/me", nil, &me); me.Quota.TrustLevel != 0 || me.Quota.MemoryMB != 256 {
+		t.Fatalf("newcomer quota = %+v", me.Quota)
+	}
+}
+
+func TestRouter_LaunchQueue(t *testing.T) {
+	t.Setenv("HOST_MAX_CONTAINERS", "1")
+	t.Setenv("QUEUE_EVICT_IDLE", "0")
+	srv, _ := newTestServer(t)
+	alice := signTestToken(t, []byte("test-secret"), validClaims())
+	claims := validClaims()
+	claims["id"], claims["username"] = 43, "bob"
+	bob := signTestToken(t, []byte("test-secret"), claims)
+
+	aliceContainer := launchContainer(t, srv, alice)
+	var queued struct {
+		Queued bool                `json:"queued"`
+		Queue  service.QueueStatus `json:"queue"`
+	}
+	if code := apiRequest(t, srv, bob, http.MethodPost, "/api/container/launch", LaunchRequest{OSType: "alpine"}, &queued); code != http.StatusAccepted || !queued.Queued || queued.Queue.Position != 1 {
+		t.Fatalf("launch on a full host: %d %+v", code, queued)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{authSubprotocol, bob}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/queue", nil)
+	if err != nil {
+		t.Fatalf("dial queue: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var msg QueueMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.State != service.QueueWaiting || msg.Position != 1 {
+		t.Fatalf("first queue message: %+v %v", msg, err)
+	}
+
+	// Alice resetting her container lets bob in
+	if code := apiRequest(t, srv, alice, http.MethodPost, "/api/container/"+aliceContainer+"/reset", nil, nil); code != http.StatusOK {
+		t.Fatalf("reset: %d", code)
+	}
+	for msg.State != service.QueueReady {
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for ready: %v", err)
+		}
+	}
+	launchContainer(t, srv, bob)
+
+	var status struct {
+		Queue    service.QueueStatus `json:"queue"`
+		Capacity service.Capacity    `json:"capacity"`
+		Usage    service.Usage       `json:"usage"`
+	}
+	if apiRequest(t, srv, bob, http.MethodGet, "/api/container/queue", nil, &status); status.Queue.State != service.QueueNone || status.Capacity.Containers != 1 || status.Usage.Containers != 1 {
+		t.Fatalf("queue status = %+v", status)
+	}
+}
+
+func TestRouter_RestartWaitsForCapacity(t *testing.T) {
+	t.Setenv("HOST_MAX_CONTAINERS", "1")
+	t.Setenv("QUEUE_EVICT_IDLE", "0")
+	srv, rt := newTestServer(t)
+	alice := signTestToken(t, []byte("test-secret"), validClaims())
+	claims := validClaims()
+	claims["id"], claims["username"] = 43, "bob"
+	bob := signTestToken(t, []byte("test-secret"), claims)
+
+	// Alice's container was stopped for idling and bob took the only slot
+	aliceContainer := launchContainer(t, srv, alice)
+	if err := rt.StopContainer(context.Background(), aliceContainer); err != nil {
+		t.Fatal(err)
+	}
+	bobContainer := launchContainer(t, srv, bob)
+
+	var queued struct {
+		Queued bool `json:"queued"`
+	}
+	if code := apiRequest(t, srv, alice, http.MethodPost, "/api/container/"+aliceContainer+"/restart", nil, &queued); code != http.StatusAccepted || !queued.Queued {
+		t.Fatalf("restart of a stopped container on a full host: %d %+v", code, queued)
+	}
+	if status, _ := rt.GetContainerStatus(context.Background(), aliceContainer); status == "running" {
+		t.Fatal("restart started a container past capacity")
+	}
+
+	// A running container restarts in its own slot
+	if code := apiRequest(t, srv, bob, http.MethodPost, "/api/container/"+bobContainer+"/restart", nil, nil); code != http.StatusOK {
+		t.Fatalf("restart of a running container: %d", code)
+	}
+}
+
+func TestRouter_WarmPool(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "Alice")
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+
+	// The built-in catalog keeps one arch container warm
+	var stats struct {
+		Pools []service.PoolStats `json:"pools"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if code := apiRequest(t, srv, token, http.MethodGet, "/api/admin/pool", nil, &stats); code != http.StatusOK {
+			t.Fatalf("pool stats: %d", code)
+		}
+		if len(stats.Pools) == 1 && stats.Pools[0].Ready == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool never filled: %+v", stats.Pools)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var launched struct {
+		ContainerID string `json:"container_id"`
+		Pooled      bool   `json:"pooled"`
+	}
+	if code := apiRequest(t, srv, token, http.MethodPost, "/api/container/launch", LaunchRequest{OSType: "arch"}, &launched); code != http.StatusOK || !launched.Pooled {
+		t.Fatalf("launch: %d %+v", code, launched)
+	}
+	if _, name, err := rt.LookupContainer(context.Background(), launched.ContainerID); err != nil || !strings.HasPrefix(name, "/"+service.UserContainerPrefix) {
+		t.Fatalf("claimed container: %s %v", name, err)
+	}
+	apiRequest(t, srv, token, http.MethodGet, "/api/admin/pool", nil, &stats)
+	if stats.Pools[0].Hits != 1 || stats.Pools[0].Misses != 0 {
+		t.Fatalf("stats = %+v", stats.Pools)
+	}
+}
+
+func TestRouter_ContainerDiedStatus(t *testing.T) {
+	srv, rt := newTestServer(t)
+	token := signTestToken(t, []byte("test-secret"), validClaims())
+	containerID := launchContainer(t, srv, token)
+
+	conn := dialTerminal(t, srv, "/ws/terminal", containerID, token)
+	defer conn.Close()
+	readMessageUntil(t, conn, func(m TerminalMessage) bool { return m.Type == "session" })
+
+	// The terminal learns why its container went away
+	if err := rt.Crash(containerID, true); err != nil {
+		t.Fatal(err)
+	}
+	readMessageUntil(t, conn, func(m TerminalMessage) bool { return m.Type == "status" && m.Data == service.StatusOOM })
+}
+
+func TestLobby_ConcurrentWrites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lobby := NewLobbyHandler(nil)
+	r := gin.New()
+	r.GET("/ws/lobby", func(c *gin.Context) {
+		c.Set(principalKey, &Principal{ID: 42, UserID: 1, Username: "alice"})
+		lobby.Handle(c)
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/lobby", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Broadcasts and owner warnings race on the same connection
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			lobby.ContainerStatus("bob", "c1", "stopped")
+		}()
+		go func() {
+			defer wg.Done()
+			lobby.WarnRemoval("alice", "c2", time.Now().Add(time.Hour))
+		}()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	counts := map[string]int{}
+	for counts["status"] < n || counts["removal_warning"] < n {
+		var msg LobbyMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v (got %v)", err, counts)
+		}
+		counts[msg.Type]++
+	}
+	wg.Wait()
+}
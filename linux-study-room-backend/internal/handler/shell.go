@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// ShellHandler reads and changes a user's login shell preference
+type ShellHandler struct {
+	db      store.Store
+	catalog *service.Catalog
+}
+
+// NewShellHandler creates a new shell handler
+func NewShellHandler(db store.Store, catalog *service.Catalog) *ShellHandler {
+	return &ShellHandler{db: db, catalog: catalog}
+}
+
+// SetShellRequest is the body of PUT /api/auth/me/shell; an empty shell
+// goes back to each image's default
+type SetShellRequest struct {
+	Shell string `json:"shell"`
+}
+
+// loginShell returns the shell to run for the user in a container of the
+// image: their preference when the image has it installed, else the image
+// default. It returns "" for images no longer in the catalog, which makes
+// the runtime use the shell the container was created with.
+func loginShell(db store.Store, catalog *service.Catalog, userID int64, imageID string) string {
+	spec, ok := catalog.Get(imageID)
+	if !ok {
+		return ""
+	}
+	preferred, err := db.GetUserShell(userID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("⚠️ Failed to load shell preference of user %d: %v", userID, err)
+	}
+	return spec.ShellFor(preferred)
+}
+
+// currentImage returns the catalog image of the user's container, if any
+func (h *ShellHandler) currentImage(userID int64) *service.ImageSpec {
+	record, err := h.db.GetContainerByUserID(userID)
+	if err != nil || record.Status == "removed" {
+		return nil
+	}
+	spec, _ := h.catalog.Get(record.OSType)
+	return spec
+}
+
+// shellResponse describes the preference and what it means for the user's image
+func (h *ShellHandler) shellResponse(userID int64, preferred string) gin.H {
+	resp := gin.H{"shell": preferred, "shells": service.LoginShells}
+	if spec := h.currentImage(userID); spec != nil {
+		resp["image"] = spec.ID
+		resp["installed"] = spec.Shells
+		resp["effective"] = spec.ShellFor(preferred)
+	}
+	return resp
+}
+
+// Get returns the caller's shell preference and the shell their image will run
+func (h *ShellHandler) Get(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	shell, err := h.db.GetUserShell(principal.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, h.shellResponse(principal.UserID, shell))
+}
+
+// Set changes the caller's shell preference. The shell must be installed in
+// the image of their current container, or in some catalog image if they
+// have none. It applies to terminals opened from now on.
+func (h *ShellHandler) Set(c *gin.Context) {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	var req SetShellRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Shell != "" {
+		if !service.ValidLoginShell(req.Shell) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "shell must be one of " + strings.Join(service.LoginShells, ", ")})
+			return
+		}
+		if spec := h.currentImage(principal.UserID); spec != nil {
+			if !spec.HasShell(req.Shell) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": req.Shell + " is not installed in " + spec.Name, "installed": spec.Shells})
+				return
+			}
+		} else if !h.anyImageHas(req.Shell) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": req.Shell + " is not installed in any image"})
+			return
+		}
+	}
+
+	if err := h.db.SetUserShell(principal.UserID, req.Shell); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("🐚 Shell of %s set to %q", principal.Username, req.Shell)
+	c.JSON(http.StatusOK, h.shellResponse(principal.UserID, req.Shell))
+}
+
+// anyImageHas reports whether some catalog image installs the shell
+func (h *ShellHandler) anyImageHas(shell string) bool {
+	for i := range h.catalog.Images {
+		if h.catalog.Images[i].HasShell(shell) {
+			return true
+		}
+	}
+	return false
+}
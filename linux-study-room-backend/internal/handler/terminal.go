@@ -2,12 +2,12 @@ package handler
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
-	"io"
+	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,201 +22,252 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins in dev
 	},
+	// Echo the auth subprotocol so browsers accept the handshake
+	Subprotocols: []string{authSubprotocol},
 }
 
 // TerminalHandler handles WebSocket terminal connections
 type TerminalHandler struct {
-	dockerSvc  *service.DockerService
+	dockerSvc  service.ContainerRuntime
 	cleanupMgr *service.CleanupManager
-	db         *sql.DB
+	db         store.Store
+	authz      *service.ContainerAuthorizer
+	recordings *service.RecordingStore
+	catalog    *service.Catalog
+
+	resumeGrace time.Duration // How long a disconnected owner's shell is kept for resuming
 }
 
+// defaultResumeGrace is used when TERMINAL_RESUME_GRACE is unset
+const defaultResumeGrace = 2 * time.Minute
+
 // NewTerminalHandler creates a new terminal handler
-func NewTerminalHandler(dockerSvc *service.DockerService, cleanupMgr *service.CleanupManager, db *sql.DB) *TerminalHandler {
-	return &TerminalHandler{dockerSvc: dockerSvc, cleanupMgr: cleanupMgr, db: db}
+func NewTerminalHandler(dockerSvc service.ContainerRuntime, cleanupMgr *service.CleanupManager, db store.Store, recordings *service.RecordingStore, catalog *service.Catalog) *TerminalHandler {
+	return &TerminalHandler{
+		dockerSvc:  dockerSvc,
+		cleanupMgr: cleanupMgr,
+		db:         db,
+		authz:      service.NewContainerAuthorizer(dockerSvc, db),
+		recordings: recordings,
+		catalog:    catalog,
+
+		resumeGrace: resumeGraceFromEnv(),
+	}
+}
+
+// resumeGraceFromEnv reads TERMINAL_RESUME_GRACE (e.g. "2m"); "0" disables resuming
+func resumeGraceFromEnv() time.Duration {
+	v := os.Getenv("TERMINAL_RESUME_GRACE")
+	if v == "" {
+		return defaultResumeGrace
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		if secs, convErr := strconv.Atoi(v); convErr == nil {
+			return time.Duration(secs) * time.Second
+		}
+		log.Printf("⚠️ Invalid TERMINAL_RESUME_GRACE %q, using %s", v, defaultResumeGrace)
+		return defaultResumeGrace
+	}
+	return d
+}
+
+// stopStatusWait is how long a client whose terminal closed waits to learn
+// why the container stopped
+const stopStatusWait = time.Second
+
+// sendStopStatus tells a client whose terminal closed why its container
+// stopped ("oom", "died", "stopped", "removed"), if that is learned soon
+func sendStopStatus(conn *terminalConn, containerID string, since time.Time) {
+	if status, ok := service.Hubs.WaitStatus(containerID, since, stopStatusWait); ok {
+		conn.Send(TerminalMessage{Type: "status", Data: status})
+	}
 }
 
 // TerminalMessage represents WebSocket message
 type TerminalMessage struct {
-	Type string `json:"type"` // "input", "resize", "output", "status"
-	Data string `json:"data,omitempty"`
-	Cols uint   `json:"cols,omitempty"`
-	Rows uint   `json:"rows,omitempty"`
+	Type  string `json:"type"` // "input", "resize", "output", "status", "spectators", "session", "record"
+	Data  string `json:"data,omitempty"`
+	Cols  uint   `json:"cols,omitempty"`
+	Rows  uint   `json:"rows,omitempty"`
+	Count int    `json:"count,omitempty"` // Spectator count for "spectators"
+	Seq   uint64 `json:"seq,omitempty"`   // Output sequence number, sent back as last_seq to resume
+}
+
+// spectatorsMessage tells the owner the spectator setting ("on"/"off") and count
+func spectatorsMessage(allow bool, count int) TerminalMessage {
+	data := "off"
+	if allow {
+		data = "on"
+	}
+	return TerminalMessage{Type: "spectators", Data: data, Count: count}
 }
 
 // Handle handles WebSocket terminal connection
 func (h *TerminalHandler) Handle(c *gin.Context) {
-	containerID := c.Query("container_id")
-	if containerID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "container_id required"})
+	// Only the owner may attach; helpers use HandleHelper
+	grant, ok := authorizeContainer(c, h.authz, c.Query("container_id"), false)
+	if !ok {
 		return
 	}
+	containerID := grant.DockerID
+
+	principal := GetPrincipal(c)
+	username := principal.Username
 
-	username := c.Query("username")
-	if username == "" {
-		username = "User"
-	}
 	os := c.Query("os")
 	if os == "" {
 		os = "linux"
 	}
 
-	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	// Extra terminals must be created over REST before connecting
+	terminalID := c.DefaultQuery("terminal", service.MainTerminal)
+	if terminalID != service.MainTerminal && !service.Sessions.HasTerminal(containerID, terminalID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "terminal not found"})
+		return
+	}
+
+	// Upgrade to WebSocket (JSON v1 or binary v2, see terminal_conn.go)
+	conn, err := upgradeTerminal(c)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
 	defer conn.Close()
 
-	log.Printf("🔌 Terminal WebSocket connected for container: %s", containerID[:12])
+	log.Printf("🔌 Terminal WebSocket connected for container: %s (binary=%v)", containerID[:12], conn.binary)
 
 	// Notify cleanup manager of connection
 	if h.cleanupMgr != nil {
 		h.cleanupMgr.OnConnect(containerID)
+		defer h.cleanupMgr.OnDisconnect(containerID)
 	}
 
-	// Register session
-	// Get avatar from query, fallback to dicebear
-	avatar := c.Query("avatar")
-	if avatar == "" {
-		avatar = "https://api.dicebear.com/7.x/avataaars/svg?seed=" + username
-	}
-	name := c.Query("name")
-	if name == "" {
-		name = username
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connected := time.Now()
+
+	// Reattach to a lingering shell if the client presents its resume token
+	var hub *service.PTYHub
+	var client *service.HubClient
+	if token := c.Query("resume_token"); token != "" {
+		if existing := service.Hubs.Get(containerID, terminalID); existing != nil {
+			lastSeq, _ := strconv.ParseUint(c.Query("last_seq"), 10, 64)
+			if client, ok = existing.Resume(token, username, lastSeq); ok {
+				hub = existing
+				log.Printf("♻️ Terminal session resumed for container: %s terminal %s", containerID[:12], terminalID)
+			}
+		}
 	}
 
-	service.Sessions.Register(containerID, &service.Session{
-		Username:    username,
-		Name:        name,
-		ContainerID: containerID,
-		OS:          os,
-		Avatar:      avatar,
-		Snapshot:    "",
-	})
-	
-	// Record connection for online time tracking
-	if h.db != nil {
-		store.RecordConnect(h.db, username, avatar)
+	if hub == nil {
+		// Open the shared PTY hub (works for both new and restarted containers)
+		shell := loginShell(h.db, h.catalog, principal.UserID, grant.Record.OSType)
+		hub, err = h.openTerminal(principal, containerID, os, terminalID, "", shell)
+		if err != nil {
+			log.Printf("Failed to exec in container: %v", err)
+			conn.Send(TerminalMessage{Type: "status", Data: "error: " + err.Error()})
+			return
+		}
+		client = hub.Attach(username, service.HubOwner)
 	}
-	
-	defer func() {
-		// Record disconnect for online time tracking
-		if h.db != nil {
-			store.RecordDisconnect(h.db, username)
+	// Keep the shell for a while so a dropped connection can resume it
+	defer hub.Linger(h.resumeGrace)
+	defer client.Detach()
+
+	conn.Send(TerminalMessage{Type: "session", Data: hub.ResumeToken()})
+
+	// Opt-in asciicast recording of this connection's terminal
+	var recorder atomic.Pointer[service.Recorder]
+	stopRecording := func() {
+		if rec := recorder.Swap(nil); rec != nil {
+			rec.Close()
+			conn.Send(TerminalMessage{Type: "record", Data: ""})
 		}
-		// Stop container when user disconnects (container persists, just stopped)
-		log.Printf("⏹️ Stopping container on disconnect: %s", containerID[:12])
-		if err := h.dockerSvc.StopContainer(context.Background(), containerID); err != nil {
-			log.Printf("⚠️ Failed to stop container: %v", err)
+	}
+	startRecording := func(recordInput bool) {
+		if h.recordings == nil || recorder.Load() != nil {
+			return
 		}
-		// Unregister session
-		service.Sessions.Unregister(containerID)
-	}()
-
-	// Create exec session in container (works for both new and restarted containers)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	hijack, execID, err := h.dockerSvc.ExecContainer(ctx, containerID)
-	if err != nil {
-		log.Printf("Failed to exec in container: %v", err)
-		conn.WriteJSON(TerminalMessage{Type: "status", Data: "error: " + err.Error()})
-		return
+		cols, rows := hub.Size()
+		if cols == 0 || rows == 0 {
+			cols, rows = 80, 24
+		}
+		rec, err := h.recordings.Create(principal.UserID, service.CastHeader{
+			Width:  cols,
+			Height: rows,
+			Title:  fmt.Sprintf("%s %s %s", username, terminalID, time.Now().Format("2006-01-02 15:04")),
+			Env:    map[string]string{"TERM": "xterm-256color"},
+		}, recordInput)
+		if err != nil {
+			log.Printf("⚠️ Failed to start recording: %v", err)
+			conn.Send(TerminalMessage{Type: "status", Data: "error: " + err.Error()})
+			return
+		}
+		// Start from what is on screen now
+		ansi, _ := hub.Screen()
+		rec.Output([]byte(ansi))
+		recorder.Store(rec)
+		conn.Send(TerminalMessage{Type: "record", Data: rec.ID})
+	}
+	defer stopRecording()
+	if c.Query("record") == "1" {
+		startRecording(c.Query("record_input") == "1")
 	}
-	defer hijack.Close()
-
-	// execID is used for resize operations
-	log.Printf("📺 Exec session created: %s", execID[:12])
 
 	// Ping ticker to keep connection alive (no ReadDeadline - user may be idle)
 	pingTicker := time.NewTicker(30 * time.Second)
 	defer pingTicker.Stop()
 
 	go func() {
-		for range pingTicker.C {
-			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				cancel()
+		for {
+			select {
+			case <-ctx.Done():
 				return
+			case <-pingTicker.C:
+				if err := conn.Ping(); err != nil {
+					cancel()
+					return
+				}
 			}
 		}
 	}()
 
-	// Buffers for snapshot
-	var cleanSnapshotBuffer strings.Builder
-	var rawSnapshotBuffer strings.Builder
-
-	// Goroutine: Container stdout -> WebSocket
+	// Goroutine: Shared PTY output -> WebSocket
 	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := hijack.Reader.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Container read error: %v", err)
+		gotStatus := false
+		for ev, ok := client.Next(); ok; ev, ok = client.Next() {
+			msg := TerminalMessage{Type: "output", Data: string(ev.Data), Seq: ev.Seq}
+			switch ev.Kind {
+			case service.HubEventSpectators:
+				msg = spectatorsMessage(ev.AllowSpectators, ev.Spectators)
+			case service.HubEventStatus:
+				msg = TerminalMessage{Type: "status", Data: ev.Status}
+				gotStatus = true
+			default:
+				if rec := recorder.Load(); rec != nil {
+					rec.Output(ev.Data)
 				}
-				cancel()
-				return
 			}
-			if n > 0 {
-				output := string(buf[:n])
-				
-				// Store raw output for xterm.js rendering (increased capacity for more history)
-				rawSnapshotBuffer.WriteString(output)
-				if rawSnapshotBuffer.Len() > 16000 {
-					s := rawSnapshotBuffer.String()
-					rawSnapshotBuffer.Reset()
-					rawSnapshotBuffer.WriteString(s[len(s)-12000:])
-				}
-				
-				// Store cleaned output for fallback text display
-				cleanOutput := service.StripANSI(output)
-				if len(cleanOutput) > 0 {
-					cleanSnapshotBuffer.WriteString(cleanOutput)
-					if cleanSnapshotBuffer.Len() > 400 {
-						s := cleanSnapshotBuffer.String()
-						cleanSnapshotBuffer.Reset()
-						cleanSnapshotBuffer.WriteString(s[len(s)-300:])
-					}
-				}
-				
-				// Update session with both snapshots
-				service.Sessions.UpdateSnapshot(containerID, rawSnapshotBuffer.String(), cleanSnapshotBuffer.String())
-
-				msg := TerminalMessage{Type: "output", Data: output}
-				if err := conn.WriteJSON(msg); err != nil {
-					log.Printf("WebSocket write error: %v", err)
-					cancel()
-					return
-				}
+			if err := conn.Send(msg); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				break
+			}
+		}
+		select {
+		case <-hub.Done():
+			if !gotStatus {
+				sendStopStatus(conn, containerID, connected)
 			}
+		default:
 		}
+		cancel()
+		conn.Close()
 	}()
 
-	// Idle timeout checker disabled - container stops on disconnect
-	// go func() {
-	// 	ticker := time.NewTicker(1 * time.Minute)
-	// 	defer ticker.Stop()
-	// 	for {
-	// 		select {
-	// 		case <-ctx.Done():
-	// 			return
-	// 		case <-ticker.C:
-	// 			if time.Since(lastActivity) > idleTimeout {
-	// 				log.Printf("⏰ Container %s idle timeout, stopping...", containerID[:12])
-	// 				h.dockerSvc.StopContainer(context.Background(), containerID)
-	// 				conn.WriteJSON(TerminalMessage{Type: "status", Data: "stopped"})
-	// 				cancel()
-	// 				return
-	// 			}
-	// 		}
-	// 	}
-	// }()
-
 	// Main loop: WebSocket -> Container stdin
 	for {
-		_, msgBytes, err := conn.ReadMessage()
+		msg, err := conn.Receive()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -224,23 +275,36 @@ func (h *TerminalHandler) Handle(c *gin.Context) {
 			break
 		}
 
-		var msg TerminalMessage
-		if err := json.Unmarshal(msgBytes, &msg); err != nil {
-			// Treat as raw input
-			msg = TerminalMessage{Type: "input", Data: string(msgBytes)}
-		}
-
-		// lastActivity = time.Now() // disabled - no idle timeout
-
 		switch msg.Type {
 		case "input":
-			if _, err := hijack.Conn.Write([]byte(msg.Data)); err != nil {
+			if _, err := client.Write([]byte(msg.Data)); err != nil {
 				log.Printf("Container write error: %v", err)
 				break
 			}
+			// Keystrokes keep the container from being stopped as idle
+			if h.cleanupMgr != nil {
+				h.cleanupMgr.OnInput(containerID)
+			}
+			if rec := recorder.Load(); rec != nil {
+				rec.Input([]byte(msg.Data))
+			}
 		case "resize":
-			if err := h.dockerSvc.ResizeExecTTY(ctx, execID, msg.Cols, msg.Rows); err != nil {
-				log.Printf("Resize error: %v", err)
+			oldCols, oldRows := hub.Size()
+			client.Resize(msg.Cols, msg.Rows)
+			if rec := recorder.Load(); rec != nil {
+				if cols, rows := hub.Size(); cols != oldCols || rows != oldRows {
+					rec.Resize(cols, rows)
+				}
+			}
+		case "spectators":
+			// Owner turns read-only spectating on or off for all terminals
+			service.Hubs.SetAllowSpectators(containerID, msg.Data != "off")
+		case "record":
+			// "on" records output, "input" also records keystrokes, "off" stops
+			if msg.Data == "off" {
+				stopRecording()
+			} else {
+				startRecording(msg.Data == "input")
 			}
 		}
 	}
@@ -249,28 +313,22 @@ func (h *TerminalHandler) Handle(c *gin.Context) {
 }
 
 // HandleHelper handles WebSocket connection for helpers
-// Helpers can send input to the container but don't own it
+// Helpers join the owner's shared PTY and can type into it but don't own it
 func (h *TerminalHandler) HandleHelper(c *gin.Context) {
-	containerID := c.Query("container_id")
-	if containerID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "container_id required"})
-		return
-	}
-
-	helperUsername := c.Query("username")
-	if helperUsername == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
+	grant, ok := authorizeContainer(c, h.authz, c.Query("container_id"), true)
+	if !ok {
 		return
 	}
-
 	// Check if this user is a helper for this container
-	if !service.Sessions.IsHelper(containerID, helperUsername) {
+	if grant.Access != service.AccessHelper {
 		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized as helper"})
 		return
 	}
+	containerID := grant.DockerID
+	helperUsername := GetPrincipal(c).Username
 
 	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := upgradeTerminal(c)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
@@ -279,61 +337,56 @@ func (h *TerminalHandler) HandleHelper(c *gin.Context) {
 
 	log.Printf("👥 Helper %s connected to container: %s", helperUsername, containerID[:12])
 
-	// Create exec session for helper (they can run commands)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	hijack, execID, err := h.dockerSvc.ExecContainer(ctx, containerID)
-	if err != nil {
-		log.Printf("Failed to exec for helper: %v", err)
-		conn.WriteJSON(TerminalMessage{Type: "status", Data: "error: " + err.Error()})
+	// Join the owner's shared PTY instead of starting a separate shell
+	hub := service.Hubs.Get(containerID, c.DefaultQuery("terminal", service.MainTerminal))
+	if hub == nil {
+		conn.Send(TerminalMessage{Type: "status", Data: "error: owner is not connected"})
+		return
+	}
+	client := hub.Attach(helperUsername, service.HubHelper)
+	defer client.Detach()
+	joined := time.Now()
+	// Revoked between the check above and attaching
+	if !service.Sessions.IsHelper(containerID, helperUsername) {
+		conn.Send(TerminalMessage{Type: "status", Data: "revoked"})
 		return
 	}
-	defer hijack.Close()
-
-	log.Printf("📺 Helper exec session created: %s", execID[:12])
 
-	// Buffers for snapshot (helper's output also updates main session)
-	var rawSnapshotBuffer strings.Builder
+	log.Printf("📺 Helper joined shared exec session: %s", hub.ExecID()[:12])
 
-	// Goroutine: Container stdout -> WebSocket (helper sees output)
+	// Goroutine: Shared PTY output -> WebSocket (helper sees the owner's screen)
 	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := hijack.Reader.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Container read error (helper): %v", err)
-				}
-				cancel()
-				return
+		gotStatus := false
+		for ev, ok := client.Next(); ok; ev, ok = client.Next() {
+			msg := TerminalMessage{Type: "output", Data: string(ev.Data), Seq: ev.Seq}
+			if ev.Kind == service.HubEventStatus {
+				msg = TerminalMessage{Type: "status", Data: ev.Status}
+				gotStatus = true
+			} else if ev.Kind != service.HubEventOutput {
+				continue
 			}
-			if n > 0 {
-				output := string(buf[:n])
-				
-				// Also update the main session's snapshot for LiveWall sync
-				rawSnapshotBuffer.WriteString(output)
-				if rawSnapshotBuffer.Len() > 16000 {
-					s := rawSnapshotBuffer.String()
-					rawSnapshotBuffer.Reset()
-					rawSnapshotBuffer.WriteString(s[len(s)-12000:])
-				}
-				cleanOutput := service.StripANSI(output)
-				service.Sessions.UpdateSnapshot(containerID, rawSnapshotBuffer.String(), cleanOutput)
-				
-				msg := TerminalMessage{Type: "output", Data: output}
-				if err := conn.WriteJSON(msg); err != nil {
-					log.Printf("WebSocket write error (helper): %v", err)
-					cancel()
-					return
-				}
+			if err := conn.Send(msg); err != nil {
+				log.Printf("WebSocket write error (helper): %v", err)
+				break
+			}
+		}
+		select {
+		case <-hub.Done():
+			if !gotStatus {
+				sendStopStatus(conn, containerID, joined)
+			}
+			conn.Send(TerminalMessage{Type: "status", Data: "closed"})
+		default:
+			if !service.Sessions.IsHelper(containerID, helperUsername) {
+				conn.Send(TerminalMessage{Type: "status", Data: "revoked"})
 			}
 		}
+		conn.Close()
 	}()
 
 	// Main loop: WebSocket -> Container stdin (helper sends input)
 	for {
-		_, msgBytes, err := conn.ReadMessage()
+		msg, err := conn.Receive()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error (helper): %v", err)
@@ -343,27 +396,95 @@ func (h *TerminalHandler) HandleHelper(c *gin.Context) {
 
 		// Check if still a helper (could be revoked)
 		if !service.Sessions.IsHelper(containerID, helperUsername) {
-			conn.WriteJSON(TerminalMessage{Type: "status", Data: "revoked"})
+			conn.Send(TerminalMessage{Type: "status", Data: "revoked"})
 			break
 		}
 
-		var msg TerminalMessage
-		if err := json.Unmarshal(msgBytes, &msg); err != nil {
-			msg = TerminalMessage{Type: "input", Data: string(msgBytes)}
-		}
-
 		switch msg.Type {
 		case "input":
-			if _, err := hijack.Conn.Write([]byte(msg.Data)); err != nil {
+			if _, err := client.Write([]byte(msg.Data)); err != nil {
 				log.Printf("Container write error (helper): %v", err)
 				break
 			}
-		case "resize":
-			if err := h.dockerSvc.ResizeExecTTY(ctx, execID, msg.Cols, msg.Rows); err != nil {
-				log.Printf("Resize error (helper): %v", err)
+			if h.cleanupMgr != nil {
+				h.cleanupMgr.OnInput(containerID)
 			}
+		case "resize":
+			client.Resize(msg.Cols, msg.Rows)
 		}
 	}
 
 	log.Printf("👥 Helper %s disconnected from container: %s", helperUsername, containerID[:12])
 }
+
+// HandleWatch streams a session's live output to a read-only spectator.
+// Any signed-in user may watch while the owner allows spectators; input is never accepted.
+func (h *TerminalHandler) HandleWatch(c *gin.Context) {
+	containerID := c.Query("container_id")
+	hub := service.Hubs.Get(containerID, c.DefaultQuery("terminal", service.MainTerminal))
+	if service.Sessions.GetSession(containerID) == nil || hub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if allow, _ := hub.Spectators(); !allow {
+		c.JSON(http.StatusForbidden, gin.H{"error": "spectators are disabled for this session"})
+		return
+	}
+	viewer := GetPrincipal(c).Username
+
+	// Upgrade to WebSocket
+	conn, err := upgradeTerminal(c)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	client, err := hub.AttachSpectator(viewer)
+	if err != nil {
+		conn.Send(TerminalMessage{Type: "status", Data: "error: " + err.Error()})
+		return
+	}
+	defer client.Detach()
+	joined := time.Now()
+
+	log.Printf("👀 %s is watching container: %s", viewer, containerID[:12])
+
+	// Goroutine: Shared PTY output -> WebSocket
+	go func() {
+		gotStatus := false
+		for ev, ok := client.Next(); ok; ev, ok = client.Next() {
+			msg := TerminalMessage{Type: "output", Data: string(ev.Data)}
+			if ev.Kind == service.HubEventStatus {
+				msg = TerminalMessage{Type: "status", Data: ev.Status}
+				gotStatus = true
+			} else if ev.Kind != service.HubEventOutput {
+				continue
+			}
+			if err := conn.Send(msg); err != nil {
+				break
+			}
+		}
+		select {
+		case <-hub.Done():
+			if !gotStatus {
+				sendStopStatus(conn, containerID, joined)
+			}
+			conn.Send(TerminalMessage{Type: "status", Data: "closed"})
+		default:
+			if allow, _ := hub.Spectators(); !allow {
+				conn.Send(TerminalMessage{Type: "status", Data: "spectators_disabled"})
+			}
+		}
+		conn.Close()
+	}()
+
+	// Drain the connection to notice when the spectator leaves; anything sent is ignored
+	for {
+		if _, err := conn.Receive(); err != nil {
+			break
+		}
+	}
+
+	log.Printf("👀 %s stopped watching container: %s", viewer, containerID[:12])
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// terminalProtocolV2 is the subprotocol for binary terminal frames. Clients
+// offer it ahead of the auth subprotocol, e.g.
+// `Sec-WebSocket-Protocol: lsr.terminal.v2, bearer, <jwt>`; clients that
+// don't get the JSON protocol (v1).
+const terminalProtocolV2 = "lsr.terminal.v2"
+
+// v2 frames are binary messages whose first byte is one of these opcodes
+const (
+	opOutput byte = 0x01 // server→client: 8-byte big-endian seq, then raw output
+	opInput  byte = 0x02 // client→server: raw keystrokes
+	opResize byte = 0x03 // both ways: big-endian uint16 cols, uint16 rows
+	opStatus byte = 0x04 // both ways: a JSON TerminalMessage for everything else
+	opPing   byte = 0x05 // client→server, echoed back with the same payload
+)
+
+// terminalUpgrader prefers the v2 protocol and compresses frames when the client supports it
+var terminalUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 32 * 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true // Allow all origins in dev
+	},
+	Subprotocols:      []string{terminalProtocolV2, authSubprotocol},
+	EnableCompression: true,
+}
+
+// terminalConn speaks either terminal protocol over a WebSocket. Send is
+// safe for concurrent use; Receive must only be called from one goroutine.
+type terminalConn struct {
+	ws     *websocket.Conn
+	binary bool
+	mu     sync.Mutex
+}
+
+// upgradeTerminal upgrades a terminal request, negotiating the protocol
+func upgradeTerminal(c *gin.Context) (*terminalConn, error) {
+	ws, err := terminalUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &terminalConn{ws: ws, binary: ws.Subprotocol() == terminalProtocolV2}, nil
+}
+
+// Send writes a message in the negotiated protocol
+func (t *terminalConn) Send(msg TerminalMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.binary {
+		return t.ws.WriteJSON(msg)
+	}
+
+	var frame []byte
+	switch msg.Type {
+	case "output":
+		frame = make([]byte, 9, 9+len(msg.Data))
+		frame[0] = opOutput
+		binary.BigEndian.PutUint64(frame[1:], msg.Seq)
+		frame = append(frame, msg.Data...)
+	case "resize":
+		frame = []byte{opResize, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(frame[1:], uint16(msg.Cols))
+		binary.BigEndian.PutUint16(frame[3:], uint16(msg.Rows))
+	default:
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		frame = append([]byte{opStatus}, body...)
+	}
+	return t.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// Receive reads the next client message. v2 pings are answered here and
+// never returned. In v1 anything that isn't JSON is treated as raw input.
+func (t *terminalConn) Receive() (TerminalMessage, error) {
+	for {
+		kind, data, err := t.ws.ReadMessage()
+		if err != nil {
+			return TerminalMessage{}, err
+		}
+
+		var msg TerminalMessage
+		if !t.binary || kind == websocket.TextMessage {
+			if err := json.Unmarshal(data, &msg); err != nil {
+				msg = TerminalMessage{Type: "input", Data: string(data)}
+			}
+			return msg, nil
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+		payload := data[1:]
+		switch data[0] {
+		case opInput:
+			return TerminalMessage{Type: "input", Data: string(payload)}, nil
+		case opResize:
+			if len(payload) < 4 {
+				continue
+			}
+			return TerminalMessage{
+				Type: "resize",
+				Cols: uint(binary.BigEndian.Uint16(payload)),
+				Rows: uint(binary.BigEndian.Uint16(payload[2:])),
+			}, nil
+		case opStatus:
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				continue
+			}
+			return msg, nil
+		case opPing:
+			t.mu.Lock()
+			err := t.ws.WriteMessage(websocket.BinaryMessage, data)
+			t.mu.Unlock()
+			if err != nil {
+				return TerminalMessage{}, err
+			}
+		}
+	}
+}
+
+// Ping sends a WebSocket-level keepalive
+func (t *terminalConn) Ping() error {
+	return t.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+}
+
+// Close closes the underlying connection
+func (t *terminalConn) Close() error {
+	return t.ws.Close()
+}
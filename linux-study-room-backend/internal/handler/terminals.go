@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linuxstudyroom/backend/internal/service"
+)
+
+// maxTerminals limits how many terminals one container may have open
+const maxTerminals = 8
+
+// TerminalInfo describes one terminal of a container for the REST API
+type TerminalInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Cols        uint      `json:"cols"`
+	Rows        uint      `json:"rows"`
+	Spectators  int       `json:"spectators"`
+	CreatedAt   time.Time `json:"created_at"`
+	ResumeToken string    `json:"resume_token,omitempty"` // Pass to /ws/terminal to attach
+}
+
+// CreateTerminalRequest is the body of POST /api/container/:id/terminals
+type CreateTerminalRequest struct {
+	Name string `json:"name"`
+}
+
+// openTerminal starts a shell for a terminal of the container and registers it
+// with the session, creating the session for the container's first terminal.
+// The session ends once its last terminal shuts down; the container keeps
+// running until the lifecycle policy stops it for the owner's idle_minutes.
+func (h *TerminalHandler) openTerminal(principal *Principal, containerID, os, terminalID, name, shell string) (*service.PTYHub, error) {
+	// The exec outlives the request while the terminal lingers, so it isn't
+	// tied to the request context
+	hub, err := service.Hubs.Open(context.Background(), h.dockerSvc, containerID, terminalID, shell)
+	if err != nil {
+		return nil, err
+	}
+
+	// Register session with the identity from the verified token
+	username := principal.Username
+	avatar := principal.AvatarURL()
+	created := service.Sessions.OpenTerminal(containerID, &service.Session{
+		Username:        username,
+		Name:            principal.DisplayName(),
+		ContainerID:     containerID,
+		OS:              os,
+		Avatar:          avatar,
+		AllowSpectators: true,
+	}, &service.Terminal{ID: terminalID, Name: name})
+
+	if created {
+		// Record connection for online time tracking
+		if h.db != nil {
+			h.db.RecordConnect(username, avatar)
+		}
+	} else if s := service.Sessions.GetSession(containerID); s != nil && !s.AllowSpectators {
+		// New terminals follow the owner's spectator setting
+		hub.SetAllowSpectators(false)
+	}
+
+	// Runs once the shell exits or the owner doesn't come back in time
+	hub.OnShutdown(func() {
+		if !service.Sessions.CloseTerminal(containerID, terminalID) {
+			return
+		}
+		// Record disconnect for online time tracking
+		if h.db != nil {
+			h.db.RecordDisconnect(username)
+		}
+	})
+	return hub, nil
+}
+
+// terminalInfo builds the REST view of a terminal
+func terminalInfo(containerID string, t service.Terminal) TerminalInfo {
+	info := TerminalInfo{
+		ID:         t.ID,
+		Name:       t.Name,
+		Cols:       t.Cols,
+		Rows:       t.Rows,
+		Spectators: t.Spectators,
+		CreatedAt:  t.CreatedAt,
+	}
+	if hub := service.Hubs.Get(containerID, t.ID); hub != nil {
+		info.ResumeToken = hub.ResumeToken()
+	}
+	return info
+}
+
+// ListTerminals returns the open terminals of a container
+func (h *TerminalHandler) ListTerminals(c *gin.Context) {
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), false)
+	if !ok {
+		return
+	}
+	containerID := grant.DockerID
+
+	terminals := []TerminalInfo{}
+	for _, t := range service.Sessions.GetTerminals(containerID) {
+		terminals = append(terminals, terminalInfo(containerID, t))
+	}
+	c.JSON(http.StatusOK, gin.H{"terminals": terminals})
+}
+
+// CreateTerminal starts a new named shell in the container. The client
+// attaches with /ws/terminal?terminal=<id>&resume_token=<token> before the
+// resume grace period runs out.
+func (h *TerminalHandler) CreateTerminal(c *gin.Context) {
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), false)
+	if !ok {
+		return
+	}
+	containerID := grant.DockerID
+
+	var req CreateTerminalRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be at most 32 characters"})
+		return
+	}
+
+	existing := service.Sessions.GetTerminals(containerID)
+	if len(existing) >= maxTerminals {
+		c.JSON(http.StatusConflict, gin.H{"error": "too many terminals open"})
+		return
+	}
+	if req.Name == "" {
+		req.Name = "shell " + strconv.Itoa(len(existing)+1)
+	}
+
+	terminalID, err := service.NewTerminalID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	principal := GetPrincipal(c)
+	shell := loginShell(h.db, h.catalog, principal.UserID, grant.Record.OSType)
+	hub, err := h.openTerminal(principal, containerID, grant.Record.OSType, terminalID, req.Name, shell)
+	if err != nil {
+		log.Printf("Failed to exec in container: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start terminal: " + err.Error()})
+		return
+	}
+	// Nobody is attached yet; close the shell if the client never connects
+	grace := h.resumeGrace
+	if grace <= 0 {
+		grace = defaultResumeGrace
+	}
+	hub.Linger(grace)
+
+	for _, t := range service.Sessions.GetTerminals(containerID) {
+		if t.ID == terminalID {
+			c.JSON(http.StatusCreated, terminalInfo(containerID, t))
+			return
+		}
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "terminal closed"})
+}
+
+// CloseTerminal ends one terminal's shell; closing the last one stops the container
+func (h *TerminalHandler) CloseTerminal(c *gin.Context) {
+	grant, ok := authorizeContainer(c, h.authz, c.Param("id"), false)
+	if !ok {
+		return
+	}
+	containerID := grant.DockerID
+
+	hub := service.Hubs.Get(containerID, c.Param("terminal"))
+	if hub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "terminal not found"})
+		return
+	}
+	hub.Close()
+	c.JSON(http.StatusOK, gin.H{"status": "closed"})
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// AccountUID is the uid (and gid) of the unprivileged account in a container.
+// Containers are started as this uid before the account exists.
+const AccountUID = 1000
+
+// Labels recording a container's account
+const (
+	labelAccount = "lsr.account" // Unprivileged username; absent for root containers
+	labelSudo    = "lsr.sudo"    // "1" if the account may use sudo
+)
+
+// Account is the login exec sessions run as inside a container
+type Account struct {
+	Username string `json:"username"`
+	Home     string `json:"home"`
+	Sudo     bool   `json:"sudo"` // Passwordless sudo; always true for root
+}
+
+// RootAccount is the account of containers created without an unprivileged user
+var RootAccount = Account{Username: "root", Home: ContainerHome, Sudo: true}
+
+// NewAccount returns an unprivileged account with a home under /home
+func NewAccount(username string, sudo bool) *Account {
+	return &Account{Username: username, Home: path.Join("/home", username), Sudo: sudo}
+}
+
+// IsRoot reports whether the account is root
+func (a *Account) IsRoot() bool {
+	return a == nil || a.Username == "root"
+}
+
+// reservedUsernames are system accounts common in the catalog's base images
+var reservedUsernames = map[string]bool{
+	"root": true, "daemon": true, "bin": true, "sys": true, "sync": true, "games": true,
+	"man": true, "lp": true, "mail": true, "news": true, "uucp": true, "proxy": true,
+	"www-data": true, "backup": true, "list": true, "irc": true, "gnats": true,
+	"nobody": true, "_apt": true, "operator": true, "adm": true, "halt": true,
+	"shutdown": true, "ftp": true, "sshd": true, "ntp": true, "http": true,
+	"messagebus": true, "systemd-network": true, "systemd-resolve": true,
+	"systemd-timesync": true, "dbus": true, "guest": true, "ubuntu": true,
+}
+
+// LinuxUsername turns a LinuxDo username into a valid Linux login name:
+// lowercase letters, digits, '_' and '-', starting with a letter or '_',
+// at most 32 characters, and not a system account
+func LinuxUsername(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		case r == '.':
+			b.WriteRune('_')
+		}
+	}
+	u := b.String()
+	if u == "" {
+		return "student"
+	}
+	if c := u[0]; c == '-' || (c >= '0' && c <= '9') || reservedUsernames[u] {
+		u = "u_" + u
+	}
+	if len(u) > 32 {
+		u = u[:32]
+	}
+	return u
+}
+
+// accountSetupScript creates the account for uid $2 named $1 with home $3
+// and login shell $4, then grants or revokes sudo per $5. It is idempotent,
+// so it also refreshes accounts in containers restored from a checkpoint.
+// useradd covers debian, ubuntu and arch; busybox adduser covers alpine.
+const accountSetupScript = `set -e
+name="$1"; uid="$2"; home="$3"; sudo="$5"
+shell="$(command -v "$4" || echo /bin/sh)"
+other="$(awk -F: -v u="$uid" '$3 == u { print $1 }' /etc/passwd)"
+if [ -n "$other" ] && [ "$other" != "$name" ]; then
+	userdel "$other" 2>/dev/null || deluser "$other"
+fi
+if ! grep -q "^$name:" /etc/passwd; then
+	if command -v useradd >/dev/null; then
+		groupadd -g "$uid" "$name" 2>/dev/null || true
+		useradd -u "$uid" -g "$uid" -d "$home" -M -s "$shell" "$name"
+	else
+		addgroup -g "$uid" "$name" 2>/dev/null || true
+		adduser -D -H -u "$uid" -G "$name" -h "$home" -s "$shell" "$name"
+	fi
+fi
+mkdir -p "$home"
+chown -R "$uid:$uid" "$home"
+rm -f /etc/sudoers.d/lsr
+if [ "$sudo" = 1 ]; then
+	mkdir -p /etc/sudoers.d
+	echo "$name ALL=(ALL) NOPASSWD: ALL" > /etc/sudoers.d/lsr
+	chmod 440 /etc/sudoers.d/lsr
+fi`
+
+// setupAccount runs accountSetupScript as root in a started container
+func (d *DockerService) setupAccount(ctx context.Context, containerID string, account *Account, shell string) error {
+	sudo := "0"
+	if account.Sudo {
+		sudo = "1"
+	}
+	_, err := d.execAs(ctx, containerID, "0:0", []string{
+		"sh", "-c", accountSetupScript, "sh",
+		account.Username, strconv.Itoa(AccountUID), account.Home, shell, sudo,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user %s: %w", account.Username, err)
+	}
+	log.Printf("👤 Account %s created in %s (sudo=%v)", account.Username, containerID[:12], account.Sudo)
+	return nil
+}
+
+// accountFromLabels reads the account recorded on a container
+func accountFromLabels(labels map[string]string) *Account {
+	name := labels[labelAccount]
+	if name == "" {
+		root := RootAccount
+		return &root
+	}
+	return NewAccount(name, labels[labelSudo] == "1")
+}
+
+// ContainerAccount returns the account exec sessions of a container run as
+func (d *DockerService) ContainerAccount(ctx context.Context, containerID string) (*Account, error) {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	if info.Config == nil {
+		return accountFromLabels(nil), nil
+	}
+	return accountFromLabels(info.Config.Labels), nil
+}
+
+// ContainerUsername reads the USER variable a container was created with
+func (d *DockerService) ContainerUsername(ctx context.Context, containerID string) (string, error) {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if info.Config != nil {
+		for _, kv := range info.Config.Env {
+			if name, ok := strings.CutPrefix(kv, "USER="); ok {
+				return name, nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,34 @@
+package service
+
+import "testing"
+
+func TestLinuxUsername(t *testing.T) {
+	cases := map[string]string{
+		"alice":                                "alice",
+		"Alice.Smith":                          "alice_smith",
+		"bob-2":                                "bob-2",
+		"42answer":                             "u_42answer",
+		"-dash":                                "u_-dash",
+		"root":                                 "u_root",
+		"nobody":                               "u_nobody",
+		"张三":                                   "student",
+		"x张y":                                  "xy",
+		"averyveryveryveryverylongusername123": "averyveryveryveryverylongusernam",
+	}
+	for in, want := range cases {
+		if got := LinuxUsername(in); got != want {
+			t.Errorf("LinuxUsername(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHomePathIn(t *testing.T) {
+	if got, err := HomePathIn("/home/alice", "notes"); err != nil || got != "/home/alice/notes" {
+		t.Errorf("relative path = %q, %v", got, err)
+	}
+	for _, in := range []string{"/root", "/home/alicex", "../bob"} {
+		if _, err := HomePathIn("/home/alice", in); err != ErrOutsideHome {
+			t.Errorf("HomePathIn(%q) should be refused, got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// UserContainerPrefix is the name prefix of every container managed by this service
+const UserContainerPrefix = "lsr-user-"
+
+// Authorization errors returned by ContainerAuthorizer
+var (
+	ErrContainerNotFound = errors.New("container not found")
+	ErrNotUserContainer  = errors.New("not a user container")
+	ErrNotContainerOwner = errors.New("not authorized for this container")
+)
+
+// ContainerAccess describes how a user is allowed to use a container
+type ContainerAccess int
+
+const (
+	// AccessOwner means the container belongs to the user
+	AccessOwner ContainerAccess = iota + 1
+	// AccessHelper means the user is an active helper on the owner's session
+	AccessHelper
+)
+
+// ContainerLookup resolves a container reference (ID, short ID or name)
+type ContainerLookup interface {
+	LookupContainer(ctx context.Context, containerID string) (id, name string, err error)
+}
+
+// ContainerGrant is the result of a successful authorization check
+type ContainerGrant struct {
+	DockerID string           // Full Docker ID, use this instead of the caller's reference
+	Record   *store.Container // Row in the containers table
+	Access   ContainerAccess
+}
+
+// ContainerAuthorizer checks container ownership against the containers table
+type ContainerAuthorizer struct {
+	lookup ContainerLookup
+	db     store.Store
+}
+
+// NewContainerAuthorizer creates a new container authorizer
+func NewContainerAuthorizer(lookup ContainerLookup, db store.Store) *ContainerAuthorizer {
+	return &ContainerAuthorizer{lookup: lookup, db: db}
+}
+
+// Authorize checks that userID owns containerID, or that username is an active
+// helper on it when allowHelper is set. Containers not named lsr-user-* are
+// always refused.
+func (a *ContainerAuthorizer) Authorize(ctx context.Context, containerID string, userID int64, username string, allowHelper bool) (*ContainerGrant, error) {
+	id, name, err := a.lookup.LookupContainer(ctx, containerID)
+	if err != nil {
+		return nil, ErrContainerNotFound
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(name, "/"), UserContainerPrefix) {
+		return nil, ErrNotUserContainer
+	}
+
+	record, err := a.db.GetContainerByDockerID(id)
+	if err != nil {
+		// A user container we have no record of belongs to nobody we can verify
+		return nil, ErrNotContainerOwner
+	}
+
+	if record.UserID == userID {
+		return &ContainerGrant{DockerID: id, Record: record, Access: AccessOwner}, nil
+	}
+	if allowHelper && Sessions.IsHelper(id, username) {
+		return &ContainerGrant{DockerID: id, Record: record, Access: AccessHelper}, nil
+	}
+	return nil, ErrNotContainerOwner
+}
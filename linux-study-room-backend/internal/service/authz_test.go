@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// fakeLookup resolves containers from a fixed ID -> name table
+type fakeLookup map[string]string
+
+func (f fakeLookup) LookupContainer(ctx context.Context, containerID string) (string, string, error) {
+	name, ok := f[containerID]
+	if !ok {
+		return "", "", errors.New("no such container")
+	}
+	return containerID, name, nil
+}
+
+func TestContainerAuthorizer_Authorize(t *testing.T) {
+	db, err := store.Init(store.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+
+	const ownerID, otherID = 101, 202
+	const (
+		ownedID    = "0123456789abcdef-owned"
+		orphanID   = "0123456789abcdef-orphan"
+		databaseID = "0123456789abcdef-database"
+	)
+	if err := db.CreateContainer(&store.Container{UserID: ownerID, DockerID: ownedID, OSType: "alpine", Status: "running"}); err != nil {
+		t.Fatalf("CreateContainer: %v", err)
+	}
+
+	lookup := fakeLookup{
+		ownedID:    "/lsr-user-101",
+		orphanID:   "/lsr-user-999",
+		databaseID: "/postgres",
+	}
+	authz := NewContainerAuthorizer(lookup, db)
+	ctx := context.Background()
+
+	Sessions.Register(ownedID, &Session{Username: "owner", ContainerID: ownedID})
+	defer Sessions.Unregister(ownedID)
+
+	if grant, err := authz.Authorize(ctx, ownedID, ownerID, "owner", false); err != nil || grant.Access != AccessOwner {
+		t.Fatalf("owner should be granted, got %v, %v", grant, err)
+	}
+	if _, err := authz.Authorize(ctx, ownedID, otherID, "stranger", true); !errors.Is(err, ErrNotContainerOwner) {
+		t.Errorf("stranger should be refused, got %v", err)
+	}
+	if _, err := authz.Authorize(ctx, databaseID, ownerID, "owner", true); !errors.Is(err, ErrNotUserContainer) {
+		t.Errorf("non lsr-user container should be refused, got %v", err)
+	}
+	if _, err := authz.Authorize(ctx, orphanID, 999, "ghost", true); !errors.Is(err, ErrNotContainerOwner) {
+		t.Errorf("unrecorded container should be refused, got %v", err)
+	}
+	if _, err := authz.Authorize(ctx, "missing", ownerID, "owner", true); !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("missing container should be not found, got %v", err)
+	}
+
+	// Helpers need an accepted invite and are only admitted when allowed
+	if Sessions.AddHelper(ownedID, "helper") {
+		t.Fatal("helper added without a pending invite")
+	}
+	Sessions.SetPendingInvite(ownedID, "helper")
+	if !Sessions.AddHelper(ownedID, "helper") {
+		t.Fatal("invited helper not added")
+	}
+	if grant, err := authz.Authorize(ctx, ownedID, otherID, "helper", true); err != nil || grant.Access != AccessHelper {
+		t.Errorf("helper should be granted, got %v, %v", grant, err)
+	}
+	if _, err := authz.Authorize(ctx, ownedID, otherID, "helper", false); !errors.Is(err, ErrNotContainerOwner) {
+		t.Errorf("helper should be refused owner-only access, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// ErrImageNotBuilt is returned when a catalog image has no built version yet
+var ErrImageNotBuilt = errors.New("image not built")
+
+// maxBuildLog caps the build output kept per image; the tail is kept
+const maxBuildLog = 256 << 10
+
+// Build statuses reported in BuildEvent and BuildResult
+const (
+	BuildStarted = "started"
+	BuildRunning = "running" // Output line in BuildEvent.Stream
+	BuildCached  = "cached"  // The tag already existed and force was off
+	BuildDone    = "built"
+	BuildFailed  = "failed"
+)
+
+// BuildEvent is one step of build progress
+type BuildEvent struct {
+	Image   string `json:"image"`
+	Status  string `json:"status"`
+	Stream  string `json:"stream,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BuildResult is the outcome of building one catalog image
+type BuildResult struct {
+	Image      string    `json:"image"`
+	Tag        string    `json:"tag"`
+	Status     string    `json:"status"`
+	Version    string    `json:"version,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Log        string    `json:"log"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// ImageVersionOf turns a Docker image ID into the short version shown to users
+func ImageVersionOf(imageID string) string {
+	v := strings.TrimPrefix(imageID, "sha256:")
+	if len(v) > 12 {
+		v = v[:12]
+	}
+	return v
+}
+
+// buildLog collects build output, keeping only the last maxBuildLog bytes
+type buildLog struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (l *buildLog) WriteString(s string) {
+	l.buf.WriteString(s)
+	if l.buf.Len() > 2*maxBuildLog {
+		tail := append([]byte(nil), l.buf.Bytes()[l.buf.Len()-maxBuildLog:]...)
+		l.buf.Reset()
+		l.buf.Write(tail)
+		l.truncated = true
+	}
+}
+
+func (l *buildLog) String() string {
+	s := l.buf.String()
+	if len(s) > maxBuildLog {
+		s = s[len(s)-maxBuildLog:]
+		l.truncated = true
+	}
+	if l.truncated {
+		return "[earlier output truncated]\n" + s
+	}
+	return s
+}
+
+// BuildImages builds the given catalog images one after another. Without
+// force, images whose content-hash tag exists are skipped; with force, the
+// build ignores the layer cache and pulls the base image again, which is how
+// packages get security updates. progress, if set, sees every output line.
+func (d *DockerService) BuildImages(ctx context.Context, specs []ImageSpec, force bool, progress func(BuildEvent)) []BuildResult {
+	if progress == nil {
+		progress = func(BuildEvent) {}
+	}
+	results := make([]BuildResult, 0, len(specs))
+	for i := range specs {
+		spec := &specs[i]
+		result := BuildResult{Image: spec.ID, Tag: spec.Tag(), StartedAt: time.Now()}
+
+		if !force {
+			if info, _, err := d.cli.ImageInspectWithRaw(ctx, spec.Tag()); err == nil {
+				result.Status, result.Version = BuildCached, ImageVersionOf(info.ID)
+				result.FinishedAt = time.Now()
+				progress(BuildEvent{Image: spec.ID, Status: BuildCached, Version: result.Version})
+				results = append(results, result)
+				continue
+			}
+		}
+
+		log.Printf("🔨 Building image: %s (this may take a while...)", spec.Tag())
+		progress(BuildEvent{Image: spec.ID, Status: BuildStarted})
+		var output buildLog
+		version, err := d.buildImage(ctx, spec, force, func(line string) {
+			output.WriteString(line)
+			progress(BuildEvent{Image: spec.ID, Status: BuildRunning, Stream: line})
+		})
+		result.Log = output.String()
+		result.FinishedAt = time.Now()
+		if err != nil {
+			result.Status, result.Error = BuildFailed, err.Error()
+			log.Printf("❌ Image build failed: %s: %v", spec.Tag(), err)
+			progress(BuildEvent{Image: spec.ID, Status: BuildFailed, Error: result.Error})
+		} else {
+			result.Status, result.Version = BuildDone, version
+			log.Printf("✅ Image built: %s (version %s)", spec.Tag(), version)
+			progress(BuildEvent{Image: spec.ID, Status: BuildDone, Version: version})
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// buildImage runs one docker build, passing each output line to output, and
+// returns the version of the new image
+func (d *DockerService) buildImage(ctx context.Context, spec *ImageSpec, force bool, output func(string)) (string, error) {
+	// The build context is just the Dockerfile
+	dockerfile := []byte(spec.DockerfileContents())
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0o644, Size: int64(len(dockerfile))})
+	tw.Write(dockerfile)
+	tw.Close()
+
+	resp, err := d.cli.ImageBuild(ctx, buf, types.ImageBuildOptions{
+		Tags:        []string{spec.Tag()},
+		Dockerfile:  "Dockerfile",
+		Remove:      true,
+		ForceRemove: true,
+		NoCache:     force,
+		PullParent:  force,
+		Labels:      map[string]string{labelImage: spec.ID},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// The daemon reports failures inside the JSON stream, not as an HTTP error
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("reading build output: %w", err)
+		}
+		if msg.Error != nil || msg.ErrorMessage != "" {
+			text := msg.ErrorMessage
+			if msg.Error != nil {
+				text = msg.Error.Message
+			}
+			output(text + "\n")
+			return "", errors.New(text)
+		}
+		switch {
+		case msg.Stream != "":
+			output(msg.Stream)
+		case msg.Status != "" && msg.ProgressMessage == "":
+			// Pull status lines; progress bar updates are skipped
+			output(strings.TrimSpace(msg.ID+" "+msg.Status) + "\n")
+		}
+	}
+
+	info, _, err := d.cli.ImageInspectWithRaw(ctx, spec.Tag())
+	if err != nil {
+		return "", fmt.Errorf("inspect built image: %w", err)
+	}
+	return ImageVersionOf(info.ID), nil
+}
+
+// ImageVersion returns the version currently tagged for a catalog image
+func (d *DockerService) ImageVersion(ctx context.Context, spec *ImageSpec) (string, error) {
+	info, _, err := d.cli.ImageInspectWithRaw(ctx, spec.Tag())
+	if client.IsErrNotFound(err) {
+		return "", ErrImageNotBuilt
+	}
+	if err != nil {
+		return "", err
+	}
+	return ImageVersionOf(info.ID), nil
+}
+
+// ContainerImageVersion returns the catalog image version a container was created from
+func (d *DockerService) ContainerImageVersion(ctx context.Context, containerID string) (string, error) {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if info.Config == nil {
+		return "", nil
+	}
+	return info.Config.Labels[labelImageVersion], nil
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultCatalogJSON is the catalog used when IMAGE_CATALOG isn't set
+//
+//go:embed images.json
+var defaultCatalogJSON []byte
+
+// installCommands maps a package manager to the RUN line installing packages
+var installCommands = map[string]string{
+	"apk":    "apk add --no-cache %s",
+	"apt":    "apt-get update && apt-get install -y --no-install-recommends %s && apt-get clean && rm -rf /var/lib/apt/lists/*",
+	"pacman": "pacman -Syu --noconfirm %s && pacman -Scc --noconfirm",
+	"dnf":    "dnf install -y %s && dnf clean all",
+}
+
+// LoginShells are the shells users can pick with PUT /api/auth/me/shell
+var LoginShells = []string{"bash", "zsh", "fish", "sh"}
+
+// ValidLoginShell reports whether name is one of LoginShells
+func ValidLoginShell(name string) bool {
+	for _, s := range LoginShells {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// imageIDPattern keeps image IDs usable in Docker tags and URLs
+var imageIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ImageSpec is one launchable environment in the catalog. It is built either
+// from a Dockerfile or from a base image plus packages.
+type ImageSpec struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description,omitempty"`
+	Base           string   `json:"base,omitempty"`
+	Dockerfile     string   `json:"dockerfile,omitempty"` // Path, relative to the catalog file
+	PackageManager string   `json:"package_manager,omitempty"`
+	Packages       []string `json:"packages,omitempty"`
+	Shell          string   `json:"shell"`            // Default for users without a preference
+	Shells         []string `json:"shells,omitempty"` // Login shells installed, defaults to shell and sh
+	MinTrustLevel  int      `json:"min_trust_level"`
+	PoolSize       int      `json:"pool_size,omitempty"` // Warm containers kept started for instant launches
+
+	dockerfile string // Resolved Dockerfile contents
+}
+
+// Tag returns the Docker image tag built for the spec. The tag names the
+// Dockerfile's content hash, so editing the Dockerfile means a new build.
+func (s *ImageSpec) Tag() string {
+	return "lsr-" + s.ID + ":" + s.ContentHash()
+}
+
+// ContentHash is a short hash of the Dockerfile the image is built from
+func (s *ImageSpec) ContentHash() string {
+	sum := sha256.Sum256([]byte(s.dockerfile))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// DockerfileContents returns the Dockerfile the image is built from
+func (s *ImageSpec) DockerfileContents() string {
+	return s.dockerfile
+}
+
+// AllowedFor reports whether a user of the trust level may launch the image
+func (s *ImageSpec) AllowedFor(trustLevel int) bool {
+	return trustLevel >= s.MinTrustLevel
+}
+
+// HasShell reports whether a login shell is installed in the image
+func (s *ImageSpec) HasShell(name string) bool {
+	for _, sh := range s.Shells {
+		if sh == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ShellFor returns the preferred shell if the image has it, else the image default
+func (s *ImageSpec) ShellFor(preferred string) string {
+	if preferred != "" && s.HasShell(preferred) {
+		return preferred
+	}
+	return s.Shell
+}
+
+// Catalog is the ordered set of images users can launch
+type Catalog struct {
+	Images []ImageSpec `json:"images"`
+}
+
+// LoadCatalog reads a catalog file, or the built-in catalog when path is empty
+func LoadCatalog(path string) (*Catalog, error) {
+	data, dir := defaultCatalogJSON, ""
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read image catalog: %w", err)
+		}
+		dir = filepath.Dir(path)
+	}
+	return ParseCatalog(data, dir)
+}
+
+// ParseCatalog parses and validates a catalog. Dockerfile paths are resolved
+// against dir; an empty dir disallows them.
+func ParseCatalog(data []byte, dir string) (*Catalog, error) {
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse image catalog: %w", err)
+	}
+	if len(c.Images) == 0 {
+		return nil, fmt.Errorf("image catalog has no images")
+	}
+
+	seen := make(map[string]bool)
+	for i := range c.Images {
+		s := &c.Images[i]
+		if !imageIDPattern.MatchString(s.ID) {
+			return nil, fmt.Errorf("image %d: invalid id %q", i, s.ID)
+		}
+		if seen[s.ID] {
+			return nil, fmt.Errorf("image %s: duplicate id", s.ID)
+		}
+		seen[s.ID] = true
+		if s.Name == "" {
+			s.Name = s.ID
+		}
+		if !ValidLoginShell(s.Shell) {
+			return nil, fmt.Errorf("image %s: shell must be one of %s", s.ID, strings.Join(LoginShells, ", "))
+		}
+		if len(s.Shells) == 0 {
+			s.Shells = []string{s.Shell}
+			if s.Shell != "sh" {
+				s.Shells = append(s.Shells, "sh")
+			}
+		}
+		for _, sh := range s.Shells {
+			if !ValidLoginShell(sh) {
+				return nil, fmt.Errorf("image %s: unknown shell %q in shells", s.ID, sh)
+			}
+		}
+		if !s.HasShell(s.Shell) {
+			return nil, fmt.Errorf("image %s: shell %s is not listed in shells", s.ID, s.Shell)
+		}
+		if s.MinTrustLevel < 0 || s.MinTrustLevel > 4 {
+			return nil, fmt.Errorf("image %s: min_trust_level must be 0-4", s.ID)
+		}
+		if s.PoolSize < 0 {
+			return nil, fmt.Errorf("image %s: pool_size must not be negative", s.ID)
+		}
+
+		switch {
+		case s.Dockerfile != "" && s.Base != "":
+			return nil, fmt.Errorf("image %s: set either dockerfile or base, not both", s.ID)
+		case s.Dockerfile != "":
+			if dir == "" {
+				return nil, fmt.Errorf("image %s: dockerfile needs a catalog file to resolve against", s.ID)
+			}
+			p := s.Dockerfile
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(dir, p)
+			}
+			contents, err := os.ReadFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("image %s: %w", s.ID, err)
+			}
+			s.dockerfile = string(contents)
+		case s.Base != "":
+			dockerfile, err := generateDockerfile(s)
+			if err != nil {
+				return nil, fmt.Errorf("image %s: %w", s.ID, err)
+			}
+			s.dockerfile = dockerfile
+		default:
+			return nil, fmt.Errorf("image %s: dockerfile or base is required", s.ID)
+		}
+	}
+	return &c, nil
+}
+
+// generateDockerfile builds a Dockerfile from a base image and packages
+func generateDockerfile(s *ImageSpec) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", s.Base)
+	if len(s.Packages) > 0 {
+		install, ok := installCommands[s.PackageManager]
+		if !ok {
+			return "", fmt.Errorf("unknown package_manager %q", s.PackageManager)
+		}
+		for _, p := range s.Packages {
+			if strings.ContainsAny(p, " \t\n;&|`$\\\"'") {
+				return "", fmt.Errorf("invalid package name %q", p)
+			}
+		}
+		fmt.Fprintf(&b, "RUN "+install+"\n", strings.Join(s.Packages, " "))
+	}
+	shell, _ := json.Marshal([]string{s.Shell})
+	fmt.Fprintf(&b, "CMD %s\n", shell)
+	return b.String(), nil
+}
+
+// Get returns the image with the given ID
+func (c *Catalog) Get(id string) (*ImageSpec, bool) {
+	for i := range c.Images {
+		if c.Images[i].ID == id {
+			return &c.Images[i], true
+		}
+	}
+	return nil, false
+}
+
+// IDs lists the image IDs in catalog order
+func (c *Catalog) IDs() []string {
+	ids := make([]string, len(c.Images))
+	for i, s := range c.Images {
+		ids[i] = s.ID
+	}
+	return ids
+}
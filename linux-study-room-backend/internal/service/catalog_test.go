@@ -0,0 +1,79 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCatalog_Default(t *testing.T) {
+	c, err := LoadCatalog("")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := strings.Join(c.IDs(), ","); got != "alpine,debian,ubuntu,arch" {
+		t.Fatalf("ids = %s", got)
+	}
+	alpine, ok := c.Get("alpine")
+	if !ok || alpine.Tag() != "lsr-alpine:"+alpine.ContentHash() || alpine.Shell != "fish" {
+		t.Fatalf("alpine = %+v", alpine)
+	}
+	want := "FROM alpine:3.19\nRUN apk add --no-cache bash zsh fish sudo\nCMD [\"fish\"]\n"
+	if alpine.DockerfileContents() != want {
+		t.Fatalf("dockerfile = %q", alpine.DockerfileContents())
+	}
+	if alpine.ShellFor("bash") != "bash" || alpine.ShellFor("") != "fish" {
+		t.Fatalf("shells = %v", alpine.Shells)
+	}
+}
+
+func TestLoadCatalog_DockerfileAndValidation(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Dockerfile.gentoo"), []byte("FROM gentoo/stage3\n"), 0o644)
+	path := filepath.Join(dir, "images.json")
+	os.WriteFile(path, []byte(`{"images": [{"id": "gentoo", "dockerfile": "Dockerfile.gentoo", "shell": "bash", "min_trust_level": 2}]}`), 0o644)
+
+	c, err := LoadCatalog(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	gentoo, _ := c.Get("gentoo")
+	if gentoo.DockerfileContents() != "FROM gentoo/stage3\n" || gentoo.Name != "gentoo" || gentoo.AllowedFor(1) || !gentoo.AllowedFor(2) {
+		t.Fatalf("gentoo = %+v", gentoo)
+	}
+	// Without a shells list only the default shell and sh are offered
+	if !gentoo.HasShell("sh") || gentoo.ShellFor("zsh") != "bash" {
+		t.Fatalf("gentoo shells = %v", gentoo.Shells)
+	}
+
+	// Editing the Dockerfile moves the tag, so the next start rebuilds
+	tag := gentoo.Tag()
+	os.WriteFile(filepath.Join(dir, "Dockerfile.gentoo"), []byte("FROM gentoo/stage3\nRUN emerge --sync\n"), 0o644)
+	if c, err = LoadCatalog(path); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if gentoo, _ = c.Get("gentoo"); gentoo.Tag() == tag {
+		t.Fatalf("tag %s unchanged after editing the Dockerfile", tag)
+	}
+
+	bad := map[string]string{
+		"no images":       `{"images": []}`,
+		"bad id":          `{"images": [{"id": "Bad ID", "base": "x", "shell": "sh"}]}`,
+		"duplicate":       `{"images": [{"id": "a", "base": "x", "shell": "sh"}, {"id": "a", "base": "y", "shell": "sh"}]}`,
+		"no shell":        `{"images": [{"id": "a", "base": "x"}]}`,
+		"unknown shell":   `{"images": [{"id": "a", "base": "x", "shell": "tcsh"}]}`,
+		"shell missing":   `{"images": [{"id": "a", "base": "x", "shell": "bash", "shells": ["fish"]}]}`,
+		"no source":       `{"images": [{"id": "a", "shell": "sh"}]}`,
+		"both sources":    `{"images": [{"id": "a", "base": "x", "dockerfile": "D", "shell": "sh"}]}`,
+		"unknown manager": `{"images": [{"id": "a", "base": "x", "packages": ["vim"], "package_manager": "brew", "shell": "sh"}]}`,
+		"shell injection": `{"images": [{"id": "a", "base": "x", "packages": ["vim; rm -rf /"], "package_manager": "apk", "shell": "sh"}]}`,
+		"trust level":     `{"images": [{"id": "a", "base": "x", "shell": "sh", "min_trust_level": 5}]}`,
+		"dockerfile path": `{"images": [{"id": "a", "dockerfile": "D", "shell": "sh"}]}`,
+	}
+	for name, data := range bad {
+		if _, err := ParseCatalog([]byte(data), ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
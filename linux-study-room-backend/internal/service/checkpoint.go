@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// Checkpoint errors returned by every runtime
+var (
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+	ErrCheckpointExists   = errors.New("checkpoint already exists")
+)
+
+// CheckpointRepoPrefix names the per-user checkpoint image repositories
+const CheckpointRepoPrefix = "lsr-checkpoint-"
+
+// Labels identifying checkpoints and user containers
+const (
+	labelUserID     = "lsr.user_id"
+	labelCheckpoint = "lsr.checkpoint"
+	labelOSType     = "lsr.os_type"
+	labelImage      = "lsr.image" // Catalog image ID a container was created from
+	labelShell      = "lsr.shell" // Shell started for new terminals
+	// Version of the catalog image a container runs; checkpoints inherit it
+	labelImageVersion = "lsr.image_version"
+)
+
+// checkpointNamePattern is a Docker tag that is also safe in URLs
+var checkpointNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,31}$`)
+
+// Checkpoint is a saved copy of a user's container filesystem. The home
+// volume is not part of it; restoring keeps the current home.
+type Checkpoint struct {
+	Name      string    `json:"name"`
+	OSType    string    `json:"os_type"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	// ImageVersion is the catalog image version the checkpointed container ran
+	ImageVersion string `json:"image_version,omitempty"`
+}
+
+// CheckpointImage returns the image reference of a user's checkpoint
+func CheckpointImage(userID int64, name string) string {
+	return fmt.Sprintf("%s%d:%s", CheckpointRepoPrefix, userID, name)
+}
+
+// ValidCheckpointName reports whether name can be used as a checkpoint name
+func ValidCheckpointName(name string) bool {
+	return checkpointNamePattern.MatchString(name)
+}
+
+// CommitCheckpoint saves the container's filesystem as a checkpoint image of the user
+func (d *DockerService) CommitCheckpoint(ctx context.Context, containerID string, userID int64, name, osType string) (*Checkpoint, error) {
+	ref := CheckpointImage(userID, name)
+	if _, _, err := d.cli.ImageInspectWithRaw(ctx, ref); err == nil {
+		return nil, ErrCheckpointExists
+	}
+
+	_, err := d.cli.ContainerCommit(ctx, containerID, container.CommitOptions{
+		Reference: ref,
+		Comment:   "Linux Study Room checkpoint",
+		Pause:     true,
+		Changes: []string{
+			fmt.Sprintf("LABEL %s=%d", labelUserID, userID),
+			fmt.Sprintf("LABEL %s=%s", labelCheckpoint, name),
+			fmt.Sprintf("LABEL %s=%s", labelOSType, osType),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit checkpoint: %w", err)
+	}
+	info, _, err := d.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	created, _ := time.Parse(time.RFC3339Nano, info.Created)
+	version := ""
+	if info.Config != nil {
+		version = info.Config.Labels[labelImageVersion]
+	}
+
+	log.Printf("💾 Checkpoint saved: %s", ref)
+	return &Checkpoint{
+		Name:         name,
+		OSType:       osType,
+		Size:         info.Size,
+		CreatedAt:    created,
+		ImageVersion: version,
+	}, nil
+}
+
+// ListCheckpoints returns the user's checkpoints, newest first
+func (d *DockerService) ListCheckpoints(ctx context.Context, userID int64) ([]Checkpoint, error) {
+	images, err := d.cli.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelUserID+"="+strconv.FormatInt(userID, 10))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := []Checkpoint{}
+	for _, img := range images {
+		name := img.Labels[labelCheckpoint]
+		if name == "" || !hasTag(img.RepoTags, CheckpointImage(userID, name)) {
+			continue
+		}
+		result = append(result, Checkpoint{
+			Name:      name,
+			OSType:    img.Labels[labelOSType],
+			Size:      img.Size,
+			CreatedAt: time.Unix(img.Created, 0),
+			// The committed container's labels carry over to the image
+			ImageVersion: img.Labels[labelImageVersion],
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+// hasTag reports whether tags contains ref
+func hasTag(tags []string, ref string) bool {
+	for _, t := range tags {
+		if t == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveCheckpoint deletes a checkpoint image. A container restored from it
+// keeps running; its layers stay as a dangling image until that container is
+// removed and images are pruned.
+func (d *DockerService) RemoveCheckpoint(ctx context.Context, userID int64, name string) error {
+	ref := CheckpointImage(userID, name)
+	_, err := d.cli.ImageRemove(ctx, ref, types.ImageRemoveOptions{Force: true, PruneChildren: true})
+	if client.IsErrNotFound(err) {
+		return ErrCheckpointNotFound
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("🗑️ Checkpoint removed: %s", ref)
+	return nil
+}
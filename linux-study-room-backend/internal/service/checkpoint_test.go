@@ -0,0 +1,20 @@
+package service
+
+import "testing"
+
+func TestCheckpointNames(t *testing.T) {
+	for _, name := range []string{"clean", "before-rm_rf", "v1.2", "20261017-071849"} {
+		if !ValidCheckpointName(name) {
+			t.Errorf("%q should be valid", name)
+		}
+	}
+	for _, name := range []string{"", "../x", "a/b", ".hidden", "-flag", "has space", "this-name-is-far-too-long-for-a-tag"} {
+		if ValidCheckpointName(name) {
+			t.Errorf("%q should be rejected", name)
+		}
+	}
+
+	if got := CheckpointImage(7, "clean"); got != "lsr-checkpoint-7:clean" {
+		t.Fatalf("image = %q", got)
+	}
+}
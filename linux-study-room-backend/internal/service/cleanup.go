@@ -2,12 +2,11 @@ package service
 
 import (
 	"context"
-	"database/sql"
 	"log"
 	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
+	"github.com/linuxstudyroom/backend/internal/store"
 )
 
 // ContainerOperations defines the interface for container operations (for testing)
@@ -21,17 +20,18 @@ type CleanupManager struct {
 	mu              sync.RWMutex
 	timers          map[string]*time.Timer // containerID -> cleanup timer
 	connectionCount map[string]int         // containerID -> active connection count
-	dockerSvc       *DockerService
+	dockerSvc       ContainerRuntime
 	containerOps    ContainerOperations // for testing with mock
-	db              *sql.DB
-	cleanupDelay    time.Duration
+	db              store.Store
+	cleanupDelay    time.Duration          // 0 disables the removal timer
+	lc              *lifecycle             // Idle and expiry policy, see lifecycle.go
 	// Metrics for testing
 	StopAttempts   int
 	RemoveAttempts int
 }
 
 // NewCleanupManager creates a new cleanup manager
-func NewCleanupManager(dockerSvc *DockerService, db *sql.DB) *CleanupManager {
+func NewCleanupManager(dockerSvc ContainerRuntime, db store.Store) *CleanupManager {
 	return &CleanupManager{
 		timers:          make(map[string]*time.Timer),
 		connectionCount: make(map[string]int),
@@ -42,14 +42,14 @@ func NewCleanupManager(dockerSvc *DockerService, db *sql.DB) *CleanupManager {
 }
 
 // NewCleanupManagerWithDelay creates a cleanup manager with custom delay (for testing)
-func NewCleanupManagerWithDelay(dockerSvc *DockerService, db *sql.DB, delay time.Duration) *CleanupManager {
+func NewCleanupManagerWithDelay(dockerSvc ContainerRuntime, db store.Store, delay time.Duration) *CleanupManager {
 	cm := NewCleanupManager(dockerSvc, db)
 	cm.cleanupDelay = delay
 	return cm
 }
 
 // NewCleanupManagerWithOps creates a cleanup manager with custom container operations (for testing)
-func NewCleanupManagerWithOps(ops ContainerOperations, db *sql.DB, delay time.Duration) *CleanupManager {
+func NewCleanupManagerWithOps(ops ContainerOperations, db store.Store, delay time.Duration) *CleanupManager {
 	return &CleanupManager{
 		timers:          make(map[string]*time.Timer),
 		connectionCount: make(map[string]int),
@@ -70,6 +70,9 @@ func (cm *CleanupManager) OnConnect(containerID string) {
 
 	log.Printf("🔗 Container %s: connection added (total: %d)", containerID[:min(12, len(containerID))], count)
 
+	// Connecting counts as using the container
+	cm.markUsed(containerID)
+
 	// Cancel any pending cleanup timer
 	if timer, exists := cm.timers[containerID]; exists {
 		timer.Stop()
@@ -92,7 +95,7 @@ func (cm *CleanupManager) OnDisconnect(containerID string) {
 	log.Printf("🔌 Container %s: connection removed (remaining: %d)", containerID[:min(12, len(containerID))], count)
 
 	// Only start cleanup timer when all connections are closed
-	if count == 0 {
+	if count == 0 && cm.cleanupDelay > 0 {
 		cm.startCleanupTimer(containerID)
 	}
 }
@@ -179,11 +182,7 @@ func (cm *CleanupManager) executeCleanup(containerID string) {
 
 	// Update database status
 	if cm.db != nil {
-		_, err := cm.db.Exec(
-			"UPDATE containers SET status = 'removed', last_active = CURRENT_TIMESTAMP WHERE docker_id = ?",
-			containerID,
-		)
-		if err != nil {
+		if err := cm.db.UpdateContainerStatusByDockerID(containerID, "removed"); err != nil {
 			log.Printf("⚠️ Container %s: database update failed: %v", containerID[:min(12, len(containerID))], err)
 		}
 	}
@@ -233,7 +232,7 @@ func min(a, b int) int {
 // CleanupStaleContainers removes all lsr-user-* containers that are currently running
 // This should be called at startup to clean up containers from previous sessions
 func (cm *CleanupManager) CleanupStaleContainers() {
-	if cm.dockerSvc == nil || cm.dockerSvc.cli == nil {
+	if cm.dockerSvc == nil {
 		log.Println("⚠️ Docker service not available, skipping stale container cleanup")
 		return
 	}
@@ -242,10 +241,8 @@ func (cm *CleanupManager) CleanupStaleContainers() {
 
 	ctx := context.Background()
 
-	// List all containers (including stopped ones)
-	containers, err := cm.dockerSvc.cli.ContainerList(ctx, container.ListOptions{
-		All: true,
-	})
+	// List all lsr-user containers (including stopped ones)
+	containers, err := cm.dockerSvc.ListUserContainers(ctx)
 	if err != nil {
 		log.Printf("⚠️ Failed to list containers: %v", err)
 		return
@@ -253,30 +250,21 @@ func (cm *CleanupManager) CleanupStaleContainers() {
 
 	cleanedCount := 0
 	for _, c := range containers {
-		// Check if it's an lsr-user container
-		for _, name := range c.Names {
-			if len(name) > 0 && name[0] == '/' {
-				name = name[1:] // Remove leading slash
-			}
-			if len(name) >= 8 && name[:8] == "lsr-user" {
-				log.Printf("🧹 Cleaning stale container: %s (%s)", name, c.ID[:12])
-
-				// Stop if running
-				if c.State == "running" {
-					if err := cm.dockerSvc.StopContainer(ctx, c.ID); err != nil {
-						log.Printf("⚠️ Failed to stop %s: %v", name, err)
-					}
-				}
-
-				// Remove container
-				if err := cm.dockerSvc.RemoveContainer(ctx, c.ID); err != nil {
-					log.Printf("⚠️ Failed to remove %s: %v", name, err)
-				} else {
-					cleanedCount++
-				}
-				break
+		log.Printf("🧹 Cleaning stale container: %s (%s)", c.Name, c.ID[:12])
+
+		// Stop if running
+		if c.State == "running" {
+			if err := cm.dockerSvc.StopContainer(ctx, c.ID); err != nil {
+				log.Printf("⚠️ Failed to stop %s: %v", c.Name, err)
 			}
 		}
+
+		// Remove container
+		if err := cm.dockerSvc.RemoveContainer(ctx, c.ID); err != nil {
+			log.Printf("⚠️ Failed to remove %s: %v", c.Name, err)
+		} else {
+			cleanedCount++
+		}
 	}
 
 	if cleanedCount > 0 {
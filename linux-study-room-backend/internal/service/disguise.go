@@ -44,6 +44,12 @@ func GetDisguisePath(userID int64) string {
 	return filepath.Join(GetDisguiseBasePath(), fmt.Sprintf("user-%d", userID))
 }
 
+// poolDisguisePath returns the disguise directory of a warm pool container,
+// which keeps it after being claimed
+func poolDisguisePath(poolName string) string {
+	return filepath.Join(GetDisguiseBasePath(), poolName)
+}
+
 
 // GenerateCPUInfo generates fake /proc/cpuinfo content
 func GenerateCPUInfo(cfg *DisguiseConfig) string {
@@ -243,12 +249,16 @@ EOF
 // CreateDisguiseFiles creates fake proc files on host for a container
 // Returns the path to the disguise directory
 func CreateDisguiseFiles(userID int64, cfg *DisguiseConfig) (string, error) {
+	return writeDisguiseFiles(GetDisguisePath(userID), cfg)
+}
+
+// writeDisguiseFiles writes the fake proc files into disguisePath. Files are
+// rewritten in place, so containers already bind mounting them see the update.
+func writeDisguiseFiles(disguisePath string, cfg *DisguiseConfig) (string, error) {
 	if cfg == nil {
 		cfg = DefaultDisguiseConfig()
 	}
 
-	disguisePath := GetDisguisePath(userID)
-
 	// Create directory
 	if err := os.MkdirAll(disguisePath, 0755); err != nil {
 		return "", fmt.Errorf("failed to create disguise directory: %w", err)
@@ -300,8 +310,11 @@ func toDockerPath(windowsPath string) string {
 
 // GetBindMounts returns Docker bind mount configurations for disguise files
 func GetBindMounts(userID int64) []mount.Mount {
-	disguisePath := GetDisguisePath(userID)
-	
+	return disguiseMounts(GetDisguisePath(userID))
+}
+
+// disguiseMounts bind mounts the fake proc files in disguisePath
+func disguiseMounts(disguisePath string) []mount.Mount {
 	// Convert paths for Docker Desktop on Windows
 	cpuinfoSource := toDockerPath(filepath.Join(disguisePath, "cpuinfo"))
 	meminfoSource := toDockerPath(filepath.Join(disguisePath, "meminfo"))
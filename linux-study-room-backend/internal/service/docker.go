@@ -1,17 +1,17 @@
 package service
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
@@ -27,34 +27,60 @@ const (
 
 // DockerService wraps Docker API operations
 type DockerService struct {
-	cli *client.Client
+	cli     *client.Client
+	catalog *Catalog
+
+	// Set once the daemon refuses a disk size limit, which needs quota
+	// support (overlay2 or local volumes on xfs mounted with pquota)
+	noLayerLimit  atomic.Bool
+	noVolumeLimit atomic.Bool
 }
 
+var _ ContainerRuntime = (*DockerService)(nil)
+
 // ContainerConfig holds container creation options
 type ContainerConfig struct {
 	UserID   int64
-	OSType   string // "alpine" or "debian"
+	OSType   string // Image ID in the catalog, e.g. "alpine"
 	Username string
+	// Checkpoint, when set, restores the user's checkpoint instead of the OS image
+	Checkpoint string
+	// Shell is the user's preferred login shell; the image default is used
+	// when empty or not installed in the image
+	Shell string
+	// Account, when set, is created in the container and exec sessions run
+	// as it; nil runs everything as root
+	Account *Account
+	// Quota sets memory, CPU, process and disk limits; nil uses defaultQuota
+	Quota *QuotaTier
 }
 
-// Dockerfile templates for pre-built images
-var dockerfiles = map[string]string{
-	"lsr-alpine": `FROM alpine:3.19
-RUN apk add --no-cache fish
-CMD ["fish"]`,
-	"lsr-debian": `FROM debian:bookworm-slim
-RUN apt-get update && apt-get install -y --no-install-recommends fish && apt-get clean
-CMD ["fish"]`,
-	"lsr-ubuntu": `FROM ubuntu:24.04
-RUN apt-get update && apt-get install -y --no-install-recommends fish && apt-get clean
-CMD ["fish"]`,
-	"lsr-arch": `FROM archlinux:latest
-RUN pacman -Syu --noconfirm && pacman -S --noconfirm fish && pacman -Scc --noconfirm
-CMD ["fish"]`,
+// defaultQuota applies to containers created without a quota tier
+var defaultQuota = QuotaTier{MemoryMB: 256, CPUs: 0.5, PidsLimit: 256}
+
+// Labels recording the limits a container was created with
+const (
+	labelMemory = "lsr.memory_mb"
+	labelCPUs   = "lsr.cpus"
+	labelDisk   = "lsr.disk_mb"
+)
+
+// containerLimits reads a container's limits from its labels; containers
+// from before the labels existed had defaultQuota
+func containerLimits(labels map[string]string) (int64, float64) {
+	memoryMB, err := strconv.ParseInt(labels[labelMemory], 10, 64)
+	if err != nil {
+		memoryMB = defaultQuota.MemoryMB
+	}
+	cpus, err := strconv.ParseFloat(labels[labelCPUs], 64)
+	if err != nil {
+		cpus = defaultQuota.CPUs
+	}
+	return memoryMB, cpus
 }
 
-// NewDockerService creates a new Docker service
-func NewDockerService() (*DockerService, error) {
+// NewDockerService creates a new Docker service that builds and launches the catalog's images
+func NewDockerService(catalog *Catalog) (*DockerService, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
@@ -73,7 +99,7 @@ func NewDockerService() (*DockerService, error) {
 		log.Println("🐋 Detected Docker-in-Docker environment - containers will run as siblings")
 	}
 	
-	svc := &DockerService{cli: cli}
+	svc := &DockerService{cli: cli, catalog: catalog}
 	
 	// Auto-build images if they don't exist
 	if err := svc.buildImagesIfNeeded(context.Background()); err != nil {
@@ -88,45 +114,21 @@ func NewDockerService() (*DockerService, error) {
 	return svc, nil
 }
 
-// buildImagesIfNeeded checks and builds lsr-alpine and lsr-debian images
+// buildImagesIfNeeded builds every catalog image whose content-hash tag
+// doesn't exist yet, logging the output of failed builds
 func (d *DockerService) buildImagesIfNeeded(ctx context.Context) error {
-	for imageName, dockerfile := range dockerfiles {
-		// Check if image exists
-		_, _, err := d.cli.ImageInspectWithRaw(ctx, imageName)
-		if err == nil {
-			log.Printf("✅ Image already exists: %s", imageName)
-			continue
+	var failed []string
+	for _, r := range d.BuildImages(ctx, d.catalog.Images, false, nil) {
+		switch r.Status {
+		case BuildCached:
+			log.Printf("✅ Image already exists: %s", r.Tag)
+		case BuildFailed:
+			log.Printf("📜 Build log of %s:\n%s", r.Tag, r.Log)
+			failed = append(failed, r.Image)
 		}
-		
-		// Build image
-		log.Printf("🔨 Building image: %s (this may take a while...)", imageName)
-		
-		// Create tar archive with Dockerfile
-		buf := new(bytes.Buffer)
-		tw := tar.NewWriter(buf)
-		dockerfileBytes := []byte(dockerfile)
-		tw.WriteHeader(&tar.Header{
-			Name: "Dockerfile",
-			Size: int64(len(dockerfileBytes)),
-		})
-		tw.Write(dockerfileBytes)
-		tw.Close()
-		
-		// Build
-		resp, err := d.cli.ImageBuild(ctx, buf, types.ImageBuildOptions{
-			Tags:       []string{imageName},
-			Dockerfile: "Dockerfile",
-			Remove:     true,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to build %s: %w", imageName, err)
-		}
-		
-		// Wait for build to complete
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
-		
-		log.Printf("✅ Image built: %s", imageName)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to build %s", strings.Join(failed, ", "))
 	}
 	return nil
 }
@@ -164,40 +166,54 @@ func (d *DockerService) createIsolatedNetwork(ctx context.Context) error {
 	return nil
 }
 
+// ContainerName returns the Docker container name for a user
+func ContainerName(userID int64) string {
+	return fmt.Sprintf("%s%d", UserContainerPrefix, userID)
+}
+
 // CreateContainer creates a new user container
 func (d *DockerService) CreateContainer(ctx context.Context, cfg *ContainerConfig) (string, error) {
-	// Select image based on OS type - use pre-built images with fish
-	imageName := "lsr-alpine"
-	if cfg.OSType == "debian" {
-		imageName = "lsr-debian"
-	} else if cfg.OSType == "ubuntu" {
-		imageName = "lsr-ubuntu"
-	} else if cfg.OSType == "arch" {
-		imageName = "lsr-arch"
+	// Select the catalog image for the OS type
+	spec, ok := d.catalog.Get(cfg.OSType)
+	if !ok {
+		return "", fmt.Errorf("unknown image %q", cfg.OSType)
+	}
+	imageName := spec.Tag()
+
+	if cfg.Checkpoint != "" {
+		imageName = CheckpointImage(cfg.UserID, cfg.Checkpoint)
 	}
 
 	// Check if image already exists
-	_, _, err := d.cli.ImageInspectWithRaw(ctx, imageName)
+	imageInfo, _, err := d.cli.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		// Image doesn't exist, try to pull or build
-		log.Printf("⚠️ Image %s not found. Please build it first with: docker build -t %s .", imageName, imageName)
+		log.Printf("⚠️ Image %s not found. Rebuild it with POST /api/admin/images/rebuild", imageName)
 		return "", fmt.Errorf("image %s not found - please build it first", imageName)
 	} else {
 		log.Printf("✅ Using pre-built image: %s", imageName)
 	}
 
+	// Pin the image ID so a rebuild moving the tag can't change what we record.
+	// A checkpoint keeps the version of the container it was committed from.
+	imageVersion := ImageVersionOf(imageInfo.ID)
+	if cfg.Checkpoint != "" && imageInfo.Config != nil {
+		imageVersion = imageInfo.Config.Labels[labelImageVersion]
+	}
+
 	// Container name
-	containerName := fmt.Sprintf("lsr-user-%d", cfg.UserID)
+	containerName := ContainerName(cfg.UserID)
 
 	// Remove any existing container with the same name to avoid conflicts
 	// This handles cases where database lost track of a container but Docker still has it
 	if oldInfo, err := d.cli.ContainerInspect(ctx, containerName); err == nil {
 		log.Printf("⚠️ Found orphaned container %s, removing it...", containerName)
-		d.cli.ContainerRemove(ctx, oldInfo.ID, container.RemoveOptions{Force: true})
+		d.RemoveContainer(ctx, oldInfo.ID)
 	}
 
-	// Fish is pre-installed, just run it directly
-	shellCmd := []string{"fish"}
+	// The user's shell, if the image has it, keeps the container alive
+	shell := spec.ShellFor(cfg.Shell)
+	shellCmd := []string{shell}
 
 	// Create disguise files for system info spoofing (fun feature)
 	_, disguiseErr := CreateDisguiseFiles(cfg.UserID, nil)
@@ -213,38 +229,77 @@ func (d *DockerService) CreateContainer(ctx context.Context, cfg *ContainerConfi
 		log.Printf("🎭 System disguise enabled: 16-core CPU, 64GB RAM, RTX 4060")
 	}
 
+	// Mount the persistent home so files survive Reset and OS switches
+	account := cfg.Account
+	if account.IsRoot() {
+		account = nil
+	}
+	homeDir := ContainerHome
+	if account != nil {
+		homeDir = account.Home
+	}
+	quota := cfg.Quota
+	if quota == nil {
+		quota = &defaultQuota
+	}
+	home, err := d.ensureHomeVolume(ctx, cfg.UserID, homeDir, quota.DiskBytes())
+	if err != nil {
+		return "", err
+	}
+	mounts = append(mounts, home)
+
+	labels := map[string]string{
+		labelUserID:       fmt.Sprint(cfg.UserID),
+		labelImage:        spec.ID,
+		labelShell:        shell,
+		labelImageVersion: imageVersion,
+		labelMemory:       strconv.FormatInt(quota.MemoryMB, 10),
+		labelCPUs:         strconv.FormatFloat(quota.CPUs, 'f', -1, 64),
+		labelDisk:         strconv.FormatInt(quota.DiskMB, 10),
+	}
+	env := []string{
+		fmt.Sprintf("USER=%s", cfg.Username),
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+	}
+	// Everything but the account setup runs unprivileged. no-new-privileges
+	// would stop sudo from switching to root, so sudoers go without it.
+	// A checkpoint carries the user and labels of the container it came from,
+	// so root containers clear them explicitly
+	user, workDir := "0:0", ""
+	labels[labelAccount], labels[labelSudo], labels[labelPool] = "", "", ""
+	capAdd := []string{"CHOWN", "SETUID", "SETGID"}
+	securityOpt := []string{"no-new-privileges"}
+	if account != nil {
+		user = fmt.Sprintf("%d:%d", AccountUID, AccountUID)
+		workDir = account.Home
+		labels[labelAccount] = account.Username
+		env[0] = "USER=" + account.Username
+		env = append(env, "HOME="+account.Home)
+		if account.Sudo {
+			labels[labelSudo] = "1"
+			capAdd = append(capAdd, "AUDIT_WRITE")
+			securityOpt = nil
+		}
+	}
+
 	// Create container
-	resp, err := d.cli.ContainerCreate(ctx,
+	resp, err := d.createContainer(ctx,
 		&container.Config{
-			Image:        imageName,
+			Image:        imageInfo.ID,
 			Cmd:          shellCmd,
+			User:         user,
+			WorkingDir:   workDir,
+			Labels:       labels,
 			Tty:          true,
 			OpenStdin:    true,
 			AttachStdin:  true,
 			AttachStdout: true,
 			AttachStderr: true,
-			Env: []string{
-				fmt.Sprintf("USER=%s", cfg.Username),
-				"TERM=xterm-256color",
-				"COLORTERM=truecolor",
-			},
+			Env:          env,
 		},
-		&container.HostConfig{
-			NetworkMode: container.NetworkMode(IsolatedNetworkName),
-			Resources: container.Resources{
-				Memory:   256 * 1024 * 1024, // 256MB
-				NanoCPUs: 500000000,          // 0.5 CPU
-			},
-			Mounts: mounts,
-			// Security: Drop unnecessary capabilities
-			CapDrop: []string{"ALL"},
-			CapAdd:  []string{"CHOWN", "SETUID", "SETGID"},
-			// Security: Read-only root filesystem (optional, may break some commands)
-			// ReadonlyRootfs: true,
-			// Security: Prevent privilege escalation
-			SecurityOpt: []string{"no-new-privileges"},
-		},
-		nil, nil, containerName,
+		hostConfig(quota, mounts, capAdd, securityOpt),
+		containerName,
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to create container: %w", err)
@@ -255,10 +310,77 @@ func (d *DockerService) CreateContainer(ctx context.Context, cfg *ContainerConfi
 		return "", fmt.Errorf("failed to start container: %w", err)
 	}
 
-	log.Printf("✅ Container created and started: %s", resp.ID[:12])
+	if account != nil {
+		if err := d.setupAccount(ctx, resp.ID, account, shell); err != nil {
+			d.cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+			return "", err
+		}
+	}
+
+	log.Printf("✅ Container created and started: %s (%dMB, %.1f CPU, %d pids, %dMB disk)", resp.ID[:12], quota.MemoryMB, quota.CPUs, quota.PidsLimit, quota.DiskMB)
 	return resp.ID, nil
 }
 
+// hostConfig isolates a user container on the isolated network with the
+// quota's limits
+func hostConfig(quota *QuotaTier, mounts []mount.Mount, capAdd, securityOpt []string) *container.HostConfig {
+	return &container.HostConfig{
+		NetworkMode: container.NetworkMode(IsolatedNetworkName),
+		// Limits come from the user's trust level tier; swap is off
+		Resources: quotaResources(quota),
+		// Caps the writable layer; the home volume is sized separately
+		StorageOpt: diskStorageOpt(quota),
+		Mounts:     mounts,
+		// Security: Drop unnecessary capabilities
+		CapDrop: []string{"ALL"},
+		CapAdd:  capAdd,
+		// Security: Read-only root filesystem (optional, may break some commands)
+		// ReadonlyRootfs: true,
+		// Security: Prevent privilege escalation
+		SecurityOpt: securityOpt,
+	}
+}
+
+// quotaResources converts a quota tier to Docker resource limits
+func quotaResources(quota *QuotaTier) container.Resources {
+	pidsLimit := quota.PidsLimit
+	return container.Resources{
+		Memory:     quota.MemoryBytes(),
+		MemorySwap: quota.MemoryBytes(),
+		NanoCPUs:   quota.NanoCPUs(),
+		PidsLimit:  &pidsLimit,
+	}
+}
+
+// diskStorageOpt limits a container's writable layer to the quota's disk size
+func diskStorageOpt(quota *QuotaTier) map[string]string {
+	if quota.DiskMB == 0 {
+		return nil
+	}
+	return map[string]string{"size": strconv.FormatInt(quota.DiskBytes(), 10)}
+}
+
+// createContainer creates a container. If the storage driver refuses the
+// writable layer's size limit, the limit is dropped for this and later
+// containers and disk_mb is left to the home volume.
+func (d *DockerService) createContainer(ctx context.Context, config *container.Config, hc *container.HostConfig, name string) (container.CreateResponse, error) {
+	if d.noLayerLimit.Load() {
+		hc.StorageOpt = nil
+	}
+	resp, err := d.cli.ContainerCreate(ctx, config, hc, nil, nil, name)
+	if err == nil || hc.StorageOpt == nil {
+		return resp, err
+	}
+	hc.StorageOpt = nil
+	resp, retryErr := d.cli.ContainerCreate(ctx, config, hc, nil, nil, name)
+	if retryErr != nil {
+		return resp, err
+	}
+	d.noLayerLimit.Store(true)
+	log.Printf("⚠️ Storage driver can't limit container size, disk_mb only applies to home volumes: %v", err)
+	return resp, nil
+}
+
 // StopContainer stops a container
 func (d *DockerService) StopContainer(ctx context.Context, containerID string) error {
 	timeout := 10
@@ -288,7 +410,15 @@ func (d *DockerService) RemoveContainer(ctx context.Context, containerID string)
 		}
 	}
 	
-	return d.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
+	if err := d.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true}); err != nil {
+		return err
+	}
+
+	// Warm pool containers leave their own home volume and disguise files
+	if info.Config != nil && info.Config.Labels[labelPool] != "" {
+		d.releasePoolContainer(ctx, info)
+	}
+	return nil
 }
 
 // GetContainerStatus returns container status
@@ -300,6 +430,15 @@ func (d *DockerService) GetContainerStatus(ctx context.Context, containerID stri
 	return info.State.Status, nil
 }
 
+// LookupContainer resolves a container ID, short ID or name to its full ID and name
+func (d *DockerService) LookupContainer(ctx context.Context, containerID string) (string, string, error) {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", "", err
+	}
+	return info.ID, info.Name, nil
+}
+
 // AttachContainer attaches to container stdin/stdout
 func (d *DockerService) AttachContainer(ctx context.Context, containerID string) (types.HijackedResponse, error) {
 	return d.cli.ContainerAttach(ctx, containerID, container.AttachOptions{
@@ -319,22 +458,78 @@ func (d *DockerService) ResizeContainerTTY(ctx context.Context, containerID stri
 }
 
 
+// ListUserContainers lists all lsr-user-* containers, including stopped ones
+func (d *DockerService) ListUserContainers(ctx context.Context) ([]ContainerInfo, error) {
+	list, err := d.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("name", UserContainerPrefix)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ContainerInfo
+	for _, c := range list {
+		for _, name := range c.Names {
+			name = strings.TrimPrefix(name, "/")
+			// The name filter matches substrings, so check the prefix too
+			if strings.HasPrefix(name, UserContainerPrefix) {
+				memoryMB, cpus := containerLimits(c.Labels)
+				if c.Labels[labelPool] != "" {
+					// Claimed pool containers got their limits after creation
+					memoryMB, cpus = d.updatedLimits(ctx, c.ID, memoryMB, cpus)
+				}
+				result = append(result, ContainerInfo{ID: c.ID, Name: name, State: c.State, Image: c.Labels[labelImage], MemoryMB: memoryMB, CPUs: cpus})
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// hijackedStream adapts a Docker hijacked connection to ExecStream
+type hijackedStream struct {
+	resp types.HijackedResponse
+}
+
+func (h *hijackedStream) Read(p []byte) (int, error)  { return h.resp.Reader.Read(p) }
+func (h *hijackedStream) Write(p []byte) (int, error) { return h.resp.Conn.Write(p) }
+func (h *hijackedStream) Close() error {
+	h.resp.Close()
+	return nil
+}
+
 // ExecContainer creates an exec instance and attaches to it (for reconnecting to stopped containers)
-func (d *DockerService) ExecContainer(ctx context.Context, containerID string) (types.HijackedResponse, string, error) {
+func (d *DockerService) ExecContainer(ctx context.Context, containerID, shell string) (ExecStream, string, error) {
+	// Default to the shell the container was created with. Claimed pool
+	// containers keep their user's environment in a file.
+	var sessionEnv []string
+	if info, err := d.cli.ContainerInspect(ctx, containerID); err == nil && info.Config != nil {
+		if shell == "" {
+			shell = info.Config.Labels[labelShell]
+		}
+		if info.Config.Labels[labelPool] != "" {
+			sessionEnv = d.poolSessionEnv(ctx, containerID)
+		}
+	}
+	if shell == "" {
+		shell = "sh"
+	}
+
 	// Create exec instance
 	execResp, err := d.cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
-		Cmd:          []string{"fish"},
+		Cmd:          []string{shell},
 		AttachStdin:  true,
 		AttachStdout: true,
 		AttachStderr: true,
 		Tty:          true,
-		Env: []string{
+		Env: append([]string{
 			"TERM=xterm-256color",
 			"COLORTERM=truecolor",
-		},
+		}, sessionEnv...),
 	})
 	if err != nil {
-		return types.HijackedResponse{}, "", fmt.Errorf("failed to create exec: %w", err)
+		return nil, "", fmt.Errorf("failed to create exec: %w", err)
 	}
 
 	// Attach to exec
@@ -342,10 +537,10 @@ func (d *DockerService) ExecContainer(ctx context.Context, containerID string) (
 		Tty: true,
 	})
 	if err != nil {
-		return types.HijackedResponse{}, "", fmt.Errorf("failed to attach exec: %w", err)
+		return nil, "", fmt.Errorf("failed to attach exec: %w", err)
 	}
 
-	return attachResp, execResp.ID, nil
+	return &hijackedStream{resp: attachResp}, execResp.ID, nil
 }
 
 // ResizeExecTTY resizes exec terminal
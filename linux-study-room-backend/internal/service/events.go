@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// Reasons a container stopped, pushed to its clients
+const (
+	StatusOOM     = "oom"     // Killed by the OOM killer
+	StatusDied    = "died"    // Its process exited on its own
+	StatusStopped = "stopped" // Stopped on purpose
+	StatusRemoved = "removed" // Deleted, by this service or an admin
+)
+
+// Backoff between attempts to resubscribe to container events
+const (
+	eventRetryMin = time.Second
+	eventRetryMax = time.Minute
+)
+
+// StatusNotifier tells clients outside the terminals that a container stopped
+type StatusNotifier interface {
+	// ContainerStatus reports why a container stopped; username is its
+	// session's owner, empty if nobody was connected
+	ContainerStatus(username, containerID, reason string)
+}
+
+// EventWatcher follows the runtime's container events so the store and the
+// sessions notice containers that die, are OOM-killed or are removed behind
+// the service's back. Every (re)subscription starts with a full
+// reconciliation, so events missed while disconnected aren't lost.
+type EventWatcher struct {
+	runtime  ContainerRuntime
+	db       store.Store
+	notifier StatusNotifier
+
+	mu       sync.Mutex
+	stopping map[string]string // Container ID -> reason announced before its die
+}
+
+// NewEventWatcher creates a watcher; call Start to run it
+func NewEventWatcher(runtime ContainerRuntime, db store.Store, notifier StatusNotifier) *EventWatcher {
+	return &EventWatcher{
+		runtime:  runtime,
+		db:       db,
+		notifier: notifier,
+		stopping: make(map[string]string),
+	}
+}
+
+// Start watches events in the background until ctx is done
+func (w *EventWatcher) Start(ctx context.Context) {
+	go w.run(ctx)
+}
+
+// run subscribes, reconciles and handles events, resubscribing with backoff
+// whenever the stream fails
+func (w *EventWatcher) run(ctx context.Context) {
+	retry := eventRetryMin
+	for {
+		// Subscribe before reconciling so nothing falls in between
+		events, errs := w.runtime.ContainerEvents(ctx)
+		subscribed := time.Now()
+		w.Reconcile(ctx)
+
+		err := w.consume(ctx, events, errs)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(subscribed) > eventRetryMax {
+			retry = eventRetryMin
+		}
+		log.Printf("⚠️ Container event stream ended: %v, resubscribing in %s", err, retry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > eventRetryMax {
+			retry = eventRetryMax
+		}
+	}
+}
+
+// consume handles events until the stream reports an error
+func (w *EventWatcher) consume(ctx context.Context, events <-chan ContainerEvent, errs <-chan error) error {
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case ev := <-events:
+			w.handle(ctx, ev)
+		}
+	}
+}
+
+// handle applies one event
+func (w *EventWatcher) handle(ctx context.Context, ev ContainerEvent) {
+	switch ev.Action {
+	case EventOOM, EventKill:
+		// The die follows; an OOM explains it better than the kill that may come with it
+		reason := StatusStopped
+		if ev.Action == EventOOM {
+			reason = StatusOOM
+		}
+		w.mu.Lock()
+		if w.stopping[ev.ID] != StatusOOM {
+			w.stopping[ev.ID] = reason
+		}
+		w.mu.Unlock()
+	case EventStart:
+		w.setStatus(ev.ID, "running")
+	case EventDie:
+		w.mu.Lock()
+		reason, ok := w.stopping[ev.ID]
+		delete(w.stopping, ev.ID)
+		w.mu.Unlock()
+		if !ok {
+			reason = StatusDied
+		}
+		// Restarted meanwhile: the new terminals aren't affected
+		if status, err := w.runtime.GetContainerStatus(ctx, ev.ID); err == nil && status == "running" {
+			return
+		}
+		if reason != StatusStopped {
+			log.Printf("💥 Container %s %s (exit code %d)", ev.Name, reason, ev.ExitCode)
+		}
+		w.setStatus(ev.ID, "exited")
+		w.endSession(ev.ID, reason)
+	case EventDestroy:
+		w.mu.Lock()
+		delete(w.stopping, ev.ID)
+		w.mu.Unlock()
+		w.setStatus(ev.ID, "removed")
+		w.endSession(ev.ID, StatusRemoved)
+	}
+}
+
+// Reconcile compares the store and the sessions with the containers that
+// exist and fixes whatever changed without an event being seen
+func (w *EventWatcher) Reconcile(ctx context.Context) {
+	list, err := w.runtime.ListUserContainers(ctx)
+	if err != nil {
+		log.Printf("⚠️ Reconciliation can't list containers: %v", err)
+		return
+	}
+	states := make(map[string]string, len(list))
+	for _, c := range list {
+		states[c.ID] = c.State
+	}
+
+	fixed := 0
+	if records, err := w.db.ListContainerActivity(); err != nil {
+		log.Printf("⚠️ Reconciliation can't read containers: %v", err)
+	} else {
+		for _, rec := range records {
+			state, ok := states[rec.DockerID]
+			if !ok {
+				state = "removed"
+			}
+			if state != rec.Status {
+				w.setStatus(rec.DockerID, state)
+				fixed++
+			}
+		}
+	}
+
+	for _, s := range Sessions.GetAllSessions() {
+		switch state, ok := states[s.ContainerID]; {
+		case !ok:
+			w.endSession(s.ContainerID, StatusRemoved)
+		case state != "running":
+			w.endSession(s.ContainerID, StatusDied)
+		default:
+			continue
+		}
+		fixed++
+	}
+	if fixed > 0 {
+		log.Printf("🔄 Reconciled %d container records and sessions", fixed)
+	}
+}
+
+// setStatus records the status Docker reports
+func (w *EventWatcher) setStatus(containerID, status string) {
+	if err := w.db.UpdateContainerStatusByDockerID(containerID, status); err != nil {
+		log.Printf("⚠️ Container %s: failed to record status %s: %v", containerID[:min(12, len(containerID))], status, err)
+	}
+}
+
+// endSession tells the container's terminals and the notifier why it
+// stopped, then closes the terminals and drops the session
+func (w *EventWatcher) endSession(containerID, reason string) {
+	username := ""
+	if s := Sessions.GetSession(containerID); s != nil {
+		username = s.Username
+	}
+	Hubs.NotifyStatus(containerID, reason)
+	Hubs.CloseContainer(containerID)
+	Sessions.Unregister(containerID)
+	// Deliberate stops of containers nobody uses aren't news
+	if w.notifier != nil && (username != "" || reason != StatusStopped) {
+		w.notifier.ContainerStatus(username, containerID, reason)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ContainerEvents subscribes to the daemon's events of user containers.
+// Docker's name filter isn't a prefix match, so names are checked here.
+func (d *DockerService) ContainerEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	messages, errs := d.cli.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", EventStart),
+			filters.Arg("event", EventKill),
+			filters.Arg("event", EventOOM),
+			filters.Arg("event", EventDie),
+			filters.Arg("event", EventDestroy),
+		),
+	})
+
+	out := make(chan ContainerEvent)
+	outErr := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case err := <-errs:
+				outErr <- err
+				return
+			case msg := <-messages:
+				name := msg.Actor.Attributes["name"]
+				if !strings.HasPrefix(name, UserContainerPrefix) {
+					continue
+				}
+				ev := ContainerEvent{ID: msg.Actor.ID, Name: name, Action: string(msg.Action)}
+				ev.ExitCode, _ = strconv.Atoi(msg.Actor.Attributes["exitCode"])
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					outErr <- ctx.Err()
+					return
+				}
+			}
+		}
+	}()
+	return out, outErr
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// statusRecorder keeps every status passed to the notifier
+type statusRecorder struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (r *statusRecorder) ContainerStatus(username, containerID, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reasons = append(r.reasons, username+":"+reason)
+}
+
+func (r *statusRecorder) has(want string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, got := range r.reasons {
+		if got == want {
+			return true
+		}
+	}
+	return false
+}
+
+// eventually waits up to five seconds for cond
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventWatcher_OOMAndReconnect(t *testing.T) {
+	db, err := store.Init(store.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+	user := &store.User{LinuxDoID: "7", Username: "bob"}
+	if err := db.UpsertUser(user); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rt := NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+	id, _ := rt.CreateContainer(ctx, &ContainerConfig{UserID: user.ID, OSType: "alpine"})
+	if err := db.CreateContainer(&store.Container{UserID: user.ID, DockerID: id, OSType: "alpine", Status: "running"}); err != nil {
+		t.Fatal(err)
+	}
+	status := func() string {
+		c, err := db.GetContainerByDockerID(id)
+		if err != nil {
+			return ""
+		}
+		return c.Status
+	}
+	subscribed := func() bool {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		return len(rt.subscribers) == 1
+	}
+
+	opened := time.Now()
+	hub, err := Hubs.Open(ctx, rt, id, MainTerminal, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := hub.Attach("bob", HubOwner)
+	Sessions.Register(id, &Session{Username: "bob", ContainerID: id})
+	defer Sessions.Unregister(id)
+
+	notifier := &statusRecorder{}
+	NewEventWatcher(rt, db, notifier).Start(ctx)
+	eventually(t, "subscription", subscribed)
+
+	// An OOM kill reaches the terminal, the lobby, the store and the sessions
+	rt.Crash(id, true)
+	eventually(t, "oom status", func() bool { return notifier.has("bob:oom") })
+	// The shell usually ends before the reason is known; terminals ask for it after closing
+	for _, ok := client.Next(); ok; _, ok = client.Next() {
+	}
+	if reason, ok := Hubs.WaitStatus(id, opened, time.Second); !ok || reason != StatusOOM {
+		t.Errorf("terminal status = %q, %v", reason, ok)
+	}
+	if status() != "exited" || Sessions.GetSession(id) != nil {
+		t.Fatalf("after oom: status %s, session %+v", status(), Sessions.GetSession(id))
+	}
+
+	rt.StartContainer(ctx, id)
+	eventually(t, "running status", func() bool { return status() == "running" })
+
+	// A removal missed while the stream was down is found on resubscribing
+	Sessions.Register(id, &Session{Username: "bob", ContainerID: id})
+	rt.DropEvents()
+	rt.RemoveContainer(ctx, id)
+	eventually(t, "reconciliation", func() bool { return status() == "removed" })
+	if Sessions.GetSession(id) != nil || !notifier.has("bob:removed") {
+		t.Fatalf("session left after reconciliation: %+v", Sessions.GetSession(id))
+	}
+	eventually(t, "resubscription", subscribed)
+}
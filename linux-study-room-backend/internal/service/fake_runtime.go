@@ -0,0 +1,850 @@
+package service
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// FakeRuntime is an in-memory ContainerRuntime. "Containers" are just records,
+// and exec sessions are local shells on a PTY, so the whole server can run in
+// tests or on a machine without a Docker daemon. It provides no isolation.
+type FakeRuntime struct {
+	mu          sync.Mutex
+	shell       string
+	containers  map[string]*fakeContainer          // ID -> container
+	execs       map[string]*fakeExec               // exec ID -> session
+	homes       string                             // Temp directory holding one home per user, created on first use
+	checkpoints map[int64]map[string]Checkpoint    // user ID -> name -> checkpoint
+	images      map[string]string                  // Catalog image ID -> built version
+	builds      int                                // Counter making every fake build a new version
+	subscribers map[chan ContainerEvent]chan error // ContainerEvents streams -> their error channel
+}
+
+type fakeContainer struct {
+	id           string
+	name         string
+	status       string
+	cfg          ContainerConfig
+	imageVersion string
+}
+
+// account returns the account the container's sessions run as
+func (c *fakeContainer) account() *Account {
+	if c.cfg.Account.IsRoot() {
+		root := RootAccount
+		return &root
+	}
+	account := *c.cfg.Account
+	return &account
+}
+
+type fakeExec struct {
+	containerID string
+	cmd         *exec.Cmd
+	pty         *os.File
+	closeOnce   sync.Once
+}
+
+var _ ContainerRuntime = (*FakeRuntime)(nil)
+
+// NewFakeRuntime creates a fake runtime whose exec sessions run shell.
+// An empty shell uses $SHELL, falling back to /bin/sh.
+func NewFakeRuntime(shell string) *FakeRuntime {
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return &FakeRuntime{
+		shell:       shell,
+		containers:  make(map[string]*fakeContainer),
+		execs:       make(map[string]*fakeExec),
+		checkpoints: make(map[int64]map[string]Checkpoint),
+		images:      make(map[string]string),
+	}
+}
+
+// randomID returns a Docker-style 64 character hex ID
+func randomID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// find resolves an ID, unique ID prefix or name. Caller holds f.mu.
+func (f *FakeRuntime) find(ref string) (*fakeContainer, error) {
+	ref = strings.TrimPrefix(ref, "/")
+	if c, ok := f.containers[ref]; ok {
+		return c, nil
+	}
+	var match *fakeContainer
+	for id, c := range f.containers {
+		if c.name == ref || (len(ref) >= 12 && strings.HasPrefix(id, ref)) {
+			if match != nil {
+				return nil, fmt.Errorf("ambiguous container reference: %s", ref)
+			}
+			match = c
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no such container: %s", ref)
+	}
+	return match, nil
+}
+
+// CreateContainer records a new running container, replacing any with the same name
+func (f *FakeRuntime) CreateContainer(ctx context.Context, cfg *ContainerConfig) (string, error) {
+	name := ContainerName(cfg.UserID)
+
+	f.mu.Lock()
+	// Images never need building here; unbuilt ones just have no version
+	version := f.images[cfg.OSType]
+	if cfg.Checkpoint != "" {
+		cp, ok := f.checkpoints[cfg.UserID][cfg.Checkpoint]
+		if !ok {
+			f.mu.Unlock()
+			return "", fmt.Errorf("image %s not found", CheckpointImage(cfg.UserID, cfg.Checkpoint))
+		}
+		version = cp.ImageVersion
+	}
+	if old, err := f.find(name); err == nil {
+		f.removeLocked(old)
+	}
+	c := &fakeContainer{id: randomID(), name: name, status: "running", cfg: *cfg, imageVersion: version}
+	f.containers[c.id] = c
+	f.emitLocked(ContainerEvent{ID: c.id, Name: c.name, Action: EventStart})
+	f.mu.Unlock()
+
+	log.Printf("✅ Fake container created: %s (%s)", name, c.id[:12])
+	return c.id, nil
+}
+
+// StartContainer marks a container as running
+func (f *FakeRuntime) StartContainer(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return err
+	}
+	if c.status != "running" {
+		c.status = "running"
+		f.emitLocked(ContainerEvent{ID: c.id, Name: c.name, Action: EventStart})
+	}
+	return nil
+}
+
+// StopContainer kills the container's shells and marks it exited
+func (f *FakeRuntime) StopContainer(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return err
+	}
+	f.stopLocked(c, 143, EventKill)
+	return nil
+}
+
+// Crash stops a container as if its process died on its own, killed by the
+// OOM killer when oom is set
+func (f *FakeRuntime) Crash(containerID string, oom bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return err
+	}
+	if oom {
+		f.stopLocked(c, 137, EventOOM)
+	} else {
+		f.stopLocked(c, 1)
+	}
+	return nil
+}
+
+// stopLocked kills the container's shells and marks it exited, sending the
+// before events and then a die with the exit code. Caller holds f.mu.
+func (f *FakeRuntime) stopLocked(c *fakeContainer, exitCode int, before ...string) {
+	f.killExecs(c.id)
+	if c.status != "running" {
+		return
+	}
+	c.status = "exited"
+	for _, action := range before {
+		f.emitLocked(ContainerEvent{ID: c.id, Name: c.name, Action: action})
+	}
+	f.emitLocked(ContainerEvent{ID: c.id, Name: c.name, Action: EventDie, ExitCode: exitCode})
+}
+
+// removeLocked stops and forgets a container. Caller holds f.mu.
+func (f *FakeRuntime) removeLocked(c *fakeContainer) {
+	f.stopLocked(c, 137, EventKill)
+	delete(f.containers, c.id)
+	f.emitLocked(ContainerEvent{ID: c.id, Name: c.name, Action: EventDestroy})
+}
+
+// RemoveContainer kills the container's shells and forgets it
+func (f *FakeRuntime) RemoveContainer(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return err
+	}
+	f.removeLocked(c)
+	return nil
+}
+
+// GetContainerStatus returns the container's status
+func (f *FakeRuntime) GetContainerStatus(ctx context.Context, containerID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return "", err
+	}
+	return c.status, nil
+}
+
+// LookupContainer resolves a reference to the full ID and Docker-style name
+func (f *FakeRuntime) LookupContainer(ctx context.Context, containerID string) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return "", "", err
+	}
+	return c.id, "/" + c.name, nil
+}
+
+// ListUserContainers lists all fake containers
+func (f *FakeRuntime) ListUserContainers(ctx context.Context) ([]ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make([]ContainerInfo, 0, len(f.containers))
+	for _, c := range f.containers {
+		if !isPoolContainerName(c.name) {
+			result = append(result, c.info())
+		}
+	}
+	return result, nil
+}
+
+// info summarizes the container for the List methods
+func (c *fakeContainer) info() ContainerInfo {
+	quota := c.cfg.Quota
+	if quota == nil {
+		quota = &defaultQuota
+	}
+	return ContainerInfo{ID: c.id, Name: c.name, State: c.status, Image: c.cfg.OSType, MemoryMB: quota.MemoryMB, CPUs: quota.CPUs}
+}
+
+// CreatePoolContainer records a running, unassigned container of the image
+func (f *FakeRuntime) CreatePoolContainer(ctx context.Context, osType string, quota *QuotaTier) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := randomID()
+	c := &fakeContainer{
+		id:           id,
+		name:         PoolContainerPrefix + osType + "-" + id[:8],
+		status:       "running",
+		cfg:          ContainerConfig{OSType: osType, Quota: quota},
+		imageVersion: f.images[osType],
+	}
+	f.containers[id] = c
+	return id, nil
+}
+
+// ClaimPoolContainer gives a pool container to cfg's user. Like Docker, it
+// only fits root launches of the same image by users with no home yet.
+func (f *FakeRuntime) ClaimPoolContainer(ctx context.Context, containerID string, cfg *ContainerConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return err
+	}
+	if !isPoolContainerName(c.name) {
+		return fmt.Errorf("%s is not an unclaimed pool container", c.name)
+	}
+	if cfg.OSType != c.cfg.OSType || cfg.Checkpoint != "" || !cfg.Account.IsRoot() {
+		return ErrPoolMismatch
+	}
+	if f.hasHomeLocked(cfg.UserID) {
+		return ErrPoolMismatch
+	}
+	name := ContainerName(cfg.UserID)
+	if old, err := f.find(name); err == nil {
+		f.removeLocked(old)
+	}
+	c.name = name
+	c.cfg = *cfg
+	return nil
+}
+
+// ListPoolContainers lists the unclaimed pool containers
+func (f *FakeRuntime) ListPoolContainers(ctx context.Context) ([]ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []ContainerInfo
+	for _, c := range f.containers {
+		if isPoolContainerName(c.name) {
+			result = append(result, c.info())
+		}
+	}
+	return result, nil
+}
+
+// ExecContainer starts a local shell on a PTY for a running container
+func (f *FakeRuntime) ExecContainer(ctx context.Context, containerID, shell string) (ExecStream, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.status != "running" {
+		return nil, "", fmt.Errorf("container %s is not running", c.name)
+	}
+
+	home, err := f.homeLocked(c.cfg.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The local shell always runs; SHELL names the login shell that was asked for
+	if shell == "" {
+		shell = c.cfg.Shell
+	}
+	user := c.cfg.Username
+	if account := c.account(); !account.IsRoot() {
+		user = account.Username
+	}
+	cmd := exec.Command(f.shell)
+	cmd.Dir = home
+	cmd.Env = append(os.Environ(),
+		"HOME="+home,
+		"USER="+user,
+		"SHELL="+shell,
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+	)
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start shell: %w", err)
+	}
+
+	execID := randomID()
+	e := &fakeExec{containerID: c.id, cmd: cmd, pty: ptmx}
+	f.execs[execID] = e
+
+	// Reap the shell and forget the session once it exits
+	go func() {
+		cmd.Wait()
+		f.mu.Lock()
+		delete(f.execs, execID)
+		f.mu.Unlock()
+		e.Close()
+	}()
+
+	return e, execID, nil
+}
+
+// ResizeExecTTY resizes an exec session's PTY
+func (f *FakeRuntime) ResizeExecTTY(ctx context.Context, execID string, cols, rows uint) error {
+	f.mu.Lock()
+	e, ok := f.execs[execID]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such exec: %s", execID)
+	}
+	return pty.Setsize(e.pty, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+// HomeDir returns the local directory standing in for the user's home volume
+func (f *FakeRuntime) HomeDir(userID int64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.homeLocked(userID)
+}
+
+// homeLocked creates the user's home directory if needed. Caller holds f.mu.
+func (f *FakeRuntime) homeLocked(userID int64) (string, error) {
+	if f.homes == "" {
+		dir, err := os.MkdirTemp("", "lsr-fake-homes-")
+		if err != nil {
+			return "", err
+		}
+		f.homes = dir
+	}
+	home := filepath.Join(f.homes, strconv.FormatInt(userID, 10))
+	if err := os.MkdirAll(home, 0o755); err != nil {
+		return "", err
+	}
+	return home, nil
+}
+
+// HasHome reports whether the user's home directory exists
+func (f *FakeRuntime) HasHome(ctx context.Context, userID int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hasHomeLocked(userID), nil
+}
+
+func (f *FakeRuntime) hasHomeLocked(userID int64) bool {
+	if f.homes == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(f.homes, strconv.FormatInt(userID, 10)))
+	return err == nil
+}
+
+// HomeUsage sums the sizes of the files in the user's home
+func (f *FakeRuntime) HomeUsage(ctx context.Context, userID int64) (int64, error) {
+	f.mu.Lock()
+	homes := f.homes
+	f.mu.Unlock()
+	if homes == "" {
+		return 0, nil
+	}
+	var size int64
+	err := filepath.WalkDir(filepath.Join(homes, strconv.FormatInt(userID, 10)), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// WipeHome deletes the user's home, refusing while one of their containers exists
+func (f *FakeRuntime) WipeHome(ctx context.Context, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if c.cfg.UserID == userID {
+			return fmt.Errorf("home of user %d is in use by container %s", userID, c.name)
+		}
+	}
+	if f.homes == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(f.homes, strconv.FormatInt(userID, 10)))
+}
+
+// CommitCheckpoint records a checkpoint of an existing container
+func (f *FakeRuntime) CommitCheckpoint(ctx context.Context, containerID string, userID int64, name, osType string) (*Checkpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.checkpoints[userID][name]; ok {
+		return nil, ErrCheckpointExists
+	}
+	if f.checkpoints[userID] == nil {
+		f.checkpoints[userID] = make(map[string]Checkpoint)
+	}
+	cp := Checkpoint{Name: name, OSType: osType, Size: 1 << 20, CreatedAt: time.Now(), ImageVersion: c.imageVersion}
+	f.checkpoints[userID][name] = cp
+	return &cp, nil
+}
+
+// ListCheckpoints returns the user's checkpoints, newest first
+func (f *FakeRuntime) ListCheckpoints(ctx context.Context, userID int64) ([]Checkpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := []Checkpoint{}
+	for _, cp := range f.checkpoints[userID] {
+		result = append(result, cp)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+// RemoveCheckpoint forgets a checkpoint
+func (f *FakeRuntime) RemoveCheckpoint(ctx context.Context, userID int64, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.checkpoints[userID][name]; !ok {
+		return ErrCheckpointNotFound
+	}
+	delete(f.checkpoints[userID], name)
+	return nil
+}
+
+// localPath maps a path under the home of a container's account to the fake
+// home. Paths elsewhere in the "container" don't exist.
+func (f *FakeRuntime) localPath(containerID, p string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return "", err
+	}
+	accountHome := c.account().Home
+	p, err = HomePathIn(accountHome, p)
+	if err != nil {
+		return "", ErrFileNotFound
+	}
+	home, err := f.homeLocked(c.cfg.UserID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, filepath.FromSlash(strings.TrimPrefix(p, accountHome))), nil
+}
+
+// ContainerAccount returns the account the container was created with
+func (f *FakeRuntime) ContainerAccount(ctx context.Context, containerID string) (*Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return nil, err
+	}
+	return c.account(), nil
+}
+
+// ContainerUsername returns the account's name, or the username it was created for
+func (f *FakeRuntime) ContainerUsername(ctx context.Context, containerID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return "", err
+	}
+	if !c.cfg.Account.IsRoot() {
+		return c.cfg.Account.Username, nil
+	}
+	return c.cfg.Username, nil
+}
+
+// fileEntry converts local file info to a FileEntry
+func fileEntry(info os.FileInfo) FileEntry {
+	return FileEntry{
+		Name:    info.Name(),
+		Type:    fileType(info.Mode()),
+		Size:    info.Size(),
+		Mode:    strconv.FormatUint(uint64(info.Mode().Perm()), 8),
+		ModTime: info.ModTime(),
+	}
+}
+
+// StatPath describes a file in the fake home
+func (f *FakeRuntime) StatPath(ctx context.Context, containerID, p string) (*FileEntry, error) {
+	local, err := f.localPath(containerID, p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(local)
+	if os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := fileEntry(info)
+	entry.Name = path.Base(p)
+	return &entry, nil
+}
+
+// ListDir lists a directory in the fake home
+func (f *FakeRuntime) ListDir(ctx context.Context, containerID, dir string) ([]FileEntry, error) {
+	local, err := f.localPath(containerID, dir)
+	if err != nil {
+		return nil, err
+	}
+	list, err := os.ReadDir(local)
+	if os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []FileEntry{}
+	for _, e := range list {
+		if info, err := e.Info(); err == nil {
+			entries = append(entries, fileEntry(info))
+		}
+	}
+	return entries, nil
+}
+
+// CopyFromContainer tars a file or directory of the fake home
+func (f *FakeRuntime) CopyFromContainer(ctx context.Context, containerID, p string) (io.ReadCloser, error) {
+	local, err := f.localPath(containerID, p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(local); os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		base := path.Base(p) // Entries are named as in the container, not the fake home
+		err := filepath.Walk(local, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				link, _ = os.Readlink(file)
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(local, file)
+			hdr.Name = path.Join(base, filepath.ToSlash(rel))
+			if info.IsDir() {
+				hdr.Name += "/"
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				src, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer src.Close()
+				_, err = io.Copy(tw, src)
+				return err
+			}
+			return nil
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// CopyToContainer extracts a tar archive into a directory of the fake home
+func (f *FakeRuntime) CopyToContainer(ctx context.Context, containerID, dir string, archive io.Reader) error {
+	local, err := f.localPath(containerID, dir)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(local); err != nil || !info.IsDir() {
+		return ErrFileNotFound
+	}
+
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		target := filepath.Join(local, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, tr)
+			dst.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Close kills every shell and deletes the fake homes
+func (f *FakeRuntime) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.execs {
+		e.Close()
+	}
+	if f.homes == "" {
+		return nil
+	}
+	err := os.RemoveAll(f.homes)
+	f.homes = ""
+	return err
+}
+
+// fakeEventBuffer is how many events a ContainerEvents stream may lag behind
+// before events are dropped
+const fakeEventBuffer = 64
+
+// ContainerEvents streams the events of user containers until ctx is done
+// or DropEvents is called
+func (f *FakeRuntime) ContainerEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	events := make(chan ContainerEvent, fakeEventBuffer)
+	errs := make(chan error, 1)
+	f.mu.Lock()
+	if f.subscribers == nil {
+		f.subscribers = make(map[chan ContainerEvent]chan error)
+	}
+	f.subscribers[events] = errs
+	f.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if errs, ok := f.subscribers[events]; ok {
+			errs <- ctx.Err()
+			delete(f.subscribers, events)
+		}
+	}()
+	return events, errs
+}
+
+// DropEvents ends every event stream with an error, like a lost connection to the daemon
+func (f *FakeRuntime) DropEvents() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for events, errs := range f.subscribers {
+		errs <- fmt.Errorf("event stream closed")
+		delete(f.subscribers, events)
+	}
+}
+
+// emitLocked sends an event of a user container to every stream. Caller holds f.mu.
+func (f *FakeRuntime) emitLocked(ev ContainerEvent) {
+	if !strings.HasPrefix(ev.Name, UserContainerPrefix) {
+		return
+	}
+	for events := range f.subscribers {
+		select {
+		case events <- ev:
+		default:
+			log.Printf("⚠️ Fake event stream is full, dropping %s of %s", ev.Action, ev.Name)
+		}
+	}
+}
+
+// killExecs terminates every shell of a container. Caller holds f.mu.
+func (f *FakeRuntime) killExecs(containerID string) {
+	for _, e := range f.execs {
+		if e.containerID == containerID {
+			e.Close()
+		}
+	}
+}
+
+func (e *fakeExec) Read(p []byte) (int, error)  { return e.pty.Read(p) }
+func (e *fakeExec) Write(p []byte) (int, error) { return e.pty.Write(p) }
+
+// Close kills the shell and closes the PTY
+func (e *fakeExec) Close() error {
+	e.closeOnce.Do(func() {
+		if e.cmd.Process != nil {
+			e.cmd.Process.Kill()
+		}
+		e.pty.Close()
+	})
+	return nil
+}
+
+// BuildImages pretends to build each image, reporting its Dockerfile lines as
+// output. Every build that isn't skipped produces a new version.
+func (f *FakeRuntime) BuildImages(ctx context.Context, specs []ImageSpec, force bool, progress func(BuildEvent)) []BuildResult {
+	if progress == nil {
+		progress = func(BuildEvent) {}
+	}
+	results := make([]BuildResult, 0, len(specs))
+	for i := range specs {
+		spec := &specs[i]
+		result := BuildResult{Image: spec.ID, Tag: spec.Tag(), StartedAt: time.Now()}
+
+		f.mu.Lock()
+		version, built := f.images[spec.ID]
+		f.mu.Unlock()
+		if built && !force {
+			result.Status, result.Version, result.FinishedAt = BuildCached, version, time.Now()
+			progress(BuildEvent{Image: spec.ID, Status: BuildCached, Version: version})
+			results = append(results, result)
+			continue
+		}
+
+		progress(BuildEvent{Image: spec.ID, Status: BuildStarted})
+		var output strings.Builder
+		lines := strings.Split(strings.TrimSpace(spec.DockerfileContents()), "\n")
+		for n, line := range lines {
+			step := fmt.Sprintf("Step %d/%d : %s\n", n+1, len(lines), line)
+			output.WriteString(step)
+			progress(BuildEvent{Image: spec.ID, Status: BuildRunning, Stream: step})
+		}
+
+		f.mu.Lock()
+		f.builds++
+		version = fmt.Sprintf("%s%08x", spec.ContentHash()[:4], f.builds)
+		f.images[spec.ID] = version
+		f.mu.Unlock()
+
+		result.Status, result.Version, result.Log, result.FinishedAt = BuildDone, version, output.String(), time.Now()
+		progress(BuildEvent{Image: spec.ID, Status: BuildDone, Version: version})
+		results = append(results, result)
+	}
+	return results
+}
+
+// ImageVersion returns the version of the last fake build of an image
+func (f *FakeRuntime) ImageVersion(ctx context.Context, spec *ImageSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.images[spec.ID]; ok {
+		return v, nil
+	}
+	return "", ErrImageNotBuilt
+}
+
+// ContainerImageVersion returns the image version recorded when the container was created
+func (f *FakeRuntime) ContainerImageVersion(ctx context.Context, containerID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.find(containerID)
+	if err != nil {
+		return "", err
+	}
+	return c.imageVersion, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeRuntime_ExecEcho(t *testing.T) {
+	rt := NewFakeRuntime("/bin/sh")
+	ctx := context.Background()
+
+	id, err := rt.CreateContainer(ctx, &ContainerConfig{UserID: 7, OSType: "alpine", Username: "alice"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, name, err := rt.LookupContainer(ctx, id[:12]); err != nil || name != "/"+ContainerName(7) {
+		t.Fatalf("lookup by short id: name=%q err=%v", name, err)
+	}
+
+	stream, execID, err := rt.ExecContainer(ctx, id, "")
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	defer stream.Close()
+	if err := rt.ResizeExecTTY(ctx, execID, 100, 30); err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+
+	if _, err := stream.Write([]byte("echo fake-$((40+2))\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	out := make(chan string)
+	go func() {
+		var sb strings.Builder
+		buf := make([]byte, 1024)
+		for {
+			n, err := stream.Read(buf)
+			sb.Write(buf[:n])
+			if strings.Contains(sb.String(), "fake-42") || err != nil {
+				out <- sb.String()
+				return
+			}
+		}
+	}()
+	select {
+	case got := <-out:
+		if !strings.Contains(got, "fake-42") {
+			t.Fatalf("shell output missing echo result: %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for shell output")
+	}
+
+	// Stopping the container ends its shells and refuses new ones
+	if err := rt.StopContainer(ctx, id); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if status, _ := rt.GetContainerStatus(ctx, id); status != "exited" {
+		t.Fatalf("expected exited, got %q", status)
+	}
+	if _, _, err := rt.ExecContainer(ctx, id, ""); err == nil {
+		t.Fatal("exec on stopped container should fail")
+	}
+
+	if err := rt.RemoveContainer(ctx, id); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := rt.GetContainerStatus(ctx, id); err == nil {
+		t.Fatal("removed container still has a status")
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Errors for file operations inside a container
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrOutsideHome  = errors.New("path is outside the home directory")
+)
+
+// FileEntry describes a file inside a container
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // "file", "dir", "symlink" or "other"
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"` // Octal permission bits, e.g. "644"
+	ModTime time.Time `json:"mod_time"`
+}
+
+// fileType classifies a file mode for FileEntry.Type
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode.IsDir():
+		return "dir"
+	case mode.IsRegular():
+		return "file"
+	default:
+		return "other"
+	}
+}
+
+// HomePath resolves p, relative to the home directory or absolute, to a
+// cleaned absolute path that must lie within ContainerHome
+func HomePath(p string) (string, error) {
+	return HomePathIn(ContainerHome, p)
+}
+
+// HomePathIn is HomePath for a container whose account's home is home
+func HomePathIn(home, p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", ErrOutsideHome
+	}
+	if !path.IsAbs(p) {
+		p = path.Join(home, p)
+	}
+	p = path.Clean(p)
+	if p != home && !strings.HasPrefix(p, home+"/") {
+		return "", ErrOutsideHome
+	}
+	return p, nil
+}
+
+// ValidFileName reports whether name is a plain file name without path parts
+func ValidFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= 255 &&
+		!strings.ContainsAny(name, "/\\\x00")
+}
+
+// TarToZip rewrites a tar stream as a zip archive
+func TarToZip(w io.Writer, r io.Reader) error {
+	tr := tar.NewReader(r)
+	zw := zip.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fh := &zip.FileHeader{Name: hdr.Name, Method: zip.Deflate, Modified: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fh.Name = strings.TrimSuffix(hdr.Name, "/") + "/"
+			fh.SetMode(os.ModeDir | os.FileMode(hdr.Mode).Perm())
+			if _, err := zw.CreateHeader(fh); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			fh.SetMode(os.FileMode(hdr.Mode).Perm())
+			fw, err := zw.CreateHeader(fh)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(fw, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			fh.SetMode(os.ModeSymlink | 0o777)
+			fh.Method = zip.Store
+			fw, err := zw.CreateHeader(fh)
+			if err != nil {
+				return err
+			}
+			io.WriteString(fw, hdr.Linkname)
+		}
+	}
+	return zw.Close()
+}
+
+// StatPath describes a path inside the container without following a final symlink
+func (d *DockerService) StatPath(ctx context.Context, containerID, p string) (*FileEntry, error) {
+	st, err := d.cli.ContainerStatPath(ctx, containerID, p)
+	if client.IsErrNotFound(err) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &FileEntry{
+		Name:    st.Name,
+		Type:    fileType(st.Mode),
+		Size:    st.Size,
+		Mode:    strconv.FormatUint(uint64(st.Mode.Perm()), 8),
+		ModTime: st.Mtime,
+	}, nil
+}
+
+// listDirScript prints "type|size|mtime|mode|name" for each entry of $1,
+// using only sh and stat so it works on busybox as well as coreutils
+const listDirScript = `cd -- "$1" || exit 2
+for f in .* *; do
+	case "$f" in .|..) continue;; esac
+	[ -e "$f" ] || [ -L "$f" ] || continue
+	stat -c '%F|%s|%Y|%a|%n' -- "$f"
+done`
+
+// ListDir lists a directory inside a running container
+func (d *DockerService) ListDir(ctx context.Context, containerID, dir string) ([]FileEntry, error) {
+	out, err := d.execOutput(ctx, containerID, []string{"sh", "-c", listDirScript, "sh", dir})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []FileEntry{}
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		fields := strings.SplitN(line, "|", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		mtime, _ := strconv.ParseInt(fields[2], 10, 64)
+		typ := "other"
+		switch {
+		case strings.Contains(fields[0], "regular"):
+			typ = "file"
+		case fields[0] == "directory":
+			typ = "dir"
+		case fields[0] == "symbolic link":
+			typ = "symlink"
+		}
+		entries = append(entries, FileEntry{
+			Name:    fields[4],
+			Type:    typ,
+			Size:    size,
+			Mode:    fields[3],
+			ModTime: time.Unix(mtime, 0),
+		})
+	}
+	return entries, nil
+}
+
+// execOutput runs a command without a TTY as the container's account and
+// returns its stdout
+func (d *DockerService) execOutput(ctx context.Context, containerID string, cmd []string) ([]byte, error) {
+	return d.execAs(ctx, containerID, "", cmd)
+}
+
+// execAs is execOutput as the given user; empty means the container's user
+func (d *DockerService) execAs(ctx context.Context, containerID, user string, cmd []string) ([]byte, error) {
+	created, err := d.cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		User:         user,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+	resp, err := d.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach exec: %w", err)
+	}
+	defer resp.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, resp.Reader); err != nil {
+		return nil, err
+	}
+	info, err := d.cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return nil, err
+	}
+	if info.ExitCode != 0 {
+		return nil, fmt.Errorf("%s exited with %d: %s", cmd[0], info.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// CopyFromContainer returns a tar archive of a file or directory in the container
+func (d *DockerService) CopyFromContainer(ctx context.Context, containerID, p string) (io.ReadCloser, error) {
+	rc, _, err := d.cli.CopyFromContainer(ctx, containerID, p)
+	if client.IsErrNotFound(err) {
+		return nil, ErrFileNotFound
+	}
+	return rc, err
+}
+
+// CopyToContainer extracts a tar archive into a directory of the container,
+// owned by the container's user
+func (d *DockerService) CopyToContainer(ctx context.Context, containerID, dir string, archive io.Reader) error {
+	err := d.cli.CopyToContainer(ctx, containerID, dir, archive, types.CopyToContainerOptions{CopyUIDGID: true})
+	if client.IsErrNotFound(err) {
+		return ErrFileNotFound
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestHomePath(t *testing.T) {
+	cases := map[string]string{
+		"":               "/root",
+		".":              "/root",
+		"notes/a.txt":    "/root/notes/a.txt",
+		"/root/x/../y":   "/root/y",
+		"./deep//path/":  "/root/deep/path",
+		"/root":          "/root",
+		"a/../../root/b": "/root/b",
+	}
+	for in, want := range cases {
+		if got, err := HomePath(in); err != nil || got != want {
+			t.Errorf("HomePath(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"..", "../etc", "/etc/passwd", "/rootkit", "a/../../etc", "x\x00y"} {
+		if _, err := HomePath(in); err != ErrOutsideHome {
+			t.Errorf("HomePath(%q) should be refused, got %v", in, err)
+		}
+	}
+}
+
+func TestTarToZip(t *testing.T) {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755})
+	tw.WriteHeader(&tar.Header{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.WriteHeader(&tar.Header{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "a.txt"})
+	tw.Close()
+
+	var zipBuf bytes.Buffer
+	if err := TarToZip(&zipBuf, &tarBuf); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	if len(zr.File) != 3 || zr.File[0].Name != "dir/" || !zr.File[0].Mode().IsDir() {
+		t.Fatalf("entries %+v", zr.File)
+	}
+	rc, _ := zr.File[1].Open()
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" || zr.File[1].Mode().Perm() != 0o644 {
+		t.Fatalf("file %q mode %v", data, zr.File[1].Mode())
+	}
+	if zr.File[2].Mode()&0o777 == 0 || zr.File[2].Mode().Type() == 0 {
+		t.Fatalf("symlink mode %v", zr.File[2].Mode())
+	}
+}
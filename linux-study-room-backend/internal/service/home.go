@@ -0,0 +1,210 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ContainerHome is where a user's persistent home is mounted inside the container
+const ContainerHome = "/root"
+
+// HomeVolumePrefix names the per-user home volumes, like UserContainerPrefix
+const HomeVolumePrefix = "lsr-home-"
+
+// ErrHomeQuota is returned when a write would take a home past its tier's disk quota
+var ErrHomeQuota = errors.New("home directory disk quota exceeded")
+
+// CheckHomeQuota returns ErrHomeQuota if the user's home plus adding bytes
+// would exceed the tier's disk_mb. Docker enforces the limit itself where the
+// storage supports it; this refuses the service's own writes with a clear
+// error first, and is the only check where it doesn't.
+func CheckHomeQuota(ctx context.Context, rt ContainerRuntime, userID int64, tier *QuotaTier, adding int64) error {
+	quota := tier.DiskBytes()
+	if quota == 0 {
+		return nil
+	}
+	size, err := rt.HomeUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if size+adding > quota {
+		return ErrHomeQuota
+	}
+	return nil
+}
+
+// HomeVolumeName returns the Docker volume holding a user's home
+func HomeVolumeName(userID int64) string {
+	return fmt.Sprintf("%s%d", HomeVolumePrefix, userID)
+}
+
+// ensureHomeVolume creates the user's home volume if it doesn't exist yet,
+// limited to size bytes (0 for no limit). A new volume is filled from the
+// image's home directory on first mount. target is the home directory of the
+// container's account. An existing volume keeps the size it was created with.
+func (d *DockerService) ensureHomeVolume(ctx context.Context, userID int64, target string, size int64) (mount.Mount, error) {
+	name := HomeVolumeName(userID)
+	if _, err := d.cli.VolumeInspect(ctx, name); err != nil {
+		if !client.IsErrNotFound(err) {
+			return mount.Mount{}, err
+		}
+		labels := map[string]string{"lsr.user_id": strconv.FormatInt(userID, 10)}
+		if err := d.createVolume(ctx, name, labels, size); err != nil {
+			return mount.Mount{}, fmt.Errorf("failed to create home volume: %w", err)
+		}
+		log.Printf("🏠 Home volume created: %s", name)
+	}
+	return mount.Mount{Type: mount.TypeVolume, Source: name, Target: target}, nil
+}
+
+// HasHome reports whether the user's home volume exists
+func (d *DockerService) HasHome(ctx context.Context, userID int64) (bool, error) {
+	_, err := d.cli.VolumeInspect(ctx, HomeVolumeName(userID))
+	if client.IsErrNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// createVolume creates a local volume limited to size bytes. If the volume
+// driver has no quota support, the limit is dropped for this and later
+// volumes and disk_mb is left to CheckHomeQuota.
+func (d *DockerService) createVolume(ctx context.Context, name string, labels map[string]string, size int64) error {
+	opts := volume.CreateOptions{Name: name, Labels: labels}
+	if size > 0 && !d.noVolumeLimit.Load() {
+		opts.DriverOpts = map[string]string{"size": strconv.FormatInt(size, 10)}
+	}
+	_, err := d.cli.VolumeCreate(ctx, opts)
+	if err == nil || opts.DriverOpts == nil {
+		return err
+	}
+	opts.DriverOpts = nil
+	if _, retryErr := d.cli.VolumeCreate(ctx, opts); retryErr != nil {
+		return err
+	}
+	d.noVolumeLimit.Store(true)
+	log.Printf("⚠️ Volume driver can't limit volume size, home quotas are only checked by the service: %v", err)
+	return nil
+}
+
+// HomeUsage returns the size in bytes of the user's home volume, 0 if it
+// doesn't exist. du walks only that volume: in the user's container while it
+// runs, otherwise in a throwaway container.
+func (d *DockerService) HomeUsage(ctx context.Context, userID int64) (int64, error) {
+	name := d.homeVolumeOf(ctx, userID)
+	if _, err := d.cli.VolumeInspect(ctx, name); err != nil {
+		if client.IsErrNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var out []byte
+	var err error
+	info, inspectErr := d.cli.ContainerInspect(ctx, ContainerName(userID))
+	if target := homeMountTarget(info, name); inspectErr == nil && info.State != nil && info.State.Running && target != "" {
+		out, err = d.execAs(ctx, info.ID, "0:0", []string{"du", "-sk", target})
+	} else {
+		image := info.Image
+		if inspectErr != nil {
+			if image, err = d.anyCatalogImage(ctx); err != nil {
+				return 0, err
+			}
+		}
+		out, err = d.runHelper(ctx, image, []mount.Mount{{Type: mount.TypeVolume, Source: name, Target: "/home-usage", ReadOnly: true}}, []string{"du", "-sk", "/home-usage"})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", name, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("failed to measure %s: empty du output", name)
+	}
+	kb, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", name, err)
+	}
+	return kb << 10, nil
+}
+
+// homeMountTarget returns where a container mounts the volume, "" if it doesn't
+func homeMountTarget(info types.ContainerJSON, volumeName string) string {
+	for _, m := range info.Mounts {
+		if m.Type == mount.TypeVolume && m.Name == volumeName {
+			return m.Destination
+		}
+	}
+	return ""
+}
+
+// anyCatalogImage returns a built catalog image to run helpers in
+func (d *DockerService) anyCatalogImage(ctx context.Context) (string, error) {
+	for _, spec := range d.catalog.Images {
+		if info, _, err := d.cli.ImageInspectWithRaw(ctx, spec.Tag()); err == nil {
+			return info.ID, nil
+		}
+	}
+	return "", errors.New("no catalog image is built")
+}
+
+// runHelper runs cmd to completion in a throwaway root container of the
+// image with no network and the given mounts, and returns its stdout
+func (d *DockerService) runHelper(ctx context.Context, image string, mounts []mount.Mount, cmd []string) ([]byte, error) {
+	resp, err := d.cli.ContainerCreate(ctx,
+		&container.Config{Image: image, User: "0:0", Entrypoint: cmd},
+		&container.HostConfig{NetworkMode: "none", Mounts: mounts},
+		nil, nil, "",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer d.cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+
+	statusCh, errCh := d.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := d.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return nil, err
+	}
+	var code int64
+	select {
+	case err := <-errCh:
+		return nil, err
+	case status := <-statusCh:
+		code = status.StatusCode
+	}
+
+	logs, err := d.cli.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, logs); err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("%s exited with status %d: %s", cmd[0], code, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// WipeHome deletes the user's home volume. The user's container must be removed first.
+func (d *DockerService) WipeHome(ctx context.Context, userID int64) error {
+	name := HomeVolumeName(userID)
+	if err := d.cli.VolumeRemove(ctx, name, false); err != nil && !client.IsErrNotFound(err) {
+		return err
+	}
+	log.Printf("🧹 Home volume wiped: %s", name)
+	return nil
+}
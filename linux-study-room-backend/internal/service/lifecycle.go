@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// Lifecycle actions recorded in containers.lifecycle_action
+const (
+	ActionIdleStop        = "idle_stop"        // No keystrokes for the tier's idle_minutes
+	ActionSessionStop     = "session_stop"     // Ran for the tier's session_minutes
+	ActionRemovalWarning  = "removal_warning"  // Owner warned of the upcoming removal
+	ActionRemovalCanceled = "removal_canceled" // Used again after the warning
+	ActionExpired         = "expired"          // Removed after retention_days unused
+)
+
+// lifecycleInterval is how often the lifecycle policy is applied
+const lifecycleInterval = time.Minute
+
+// DefaultRemovalWarning is how long before removal the owner is warned
+const DefaultRemovalWarning = 24 * time.Hour
+
+// LifecycleNotifier tells users about the upcoming removal of their container
+type LifecycleNotifier interface {
+	// WarnRemoval warns that the container will be removed at removeAt.
+	// A zero removeAt withdraws the warning.
+	WarnRemoval(username, containerID string, removeAt time.Time)
+}
+
+// lifecycle is the idle and expiry state of a CleanupManager
+type lifecycle struct {
+	quotas     *QuotaPolicy
+	notifier   LifecycleNotifier
+	warnBefore time.Duration
+
+	mu        sync.Mutex
+	lastUsed  map[string]time.Time // Container ID -> last keystroke or connection
+	touched   map[string]bool      // Used since the last sweep, not yet saved
+	startedAt map[string]time.Time // Container ID -> first seen running
+	warned    map[string]bool      // Warnings sent by this process
+}
+
+// NewLifecycleManager creates a cleanup manager that stops containers left
+// idle or running too long and removes containers unused for longer than
+// their owner's tier keeps them. Disconnecting doesn't arm the removal timer.
+// Call Start to apply the policy.
+func NewLifecycleManager(runtime ContainerRuntime, db store.Store, quotas *QuotaPolicy, notifier LifecycleNotifier, warnBefore time.Duration) *CleanupManager {
+	cm := NewCleanupManagerWithDelay(runtime, db, 0)
+	cm.lc = &lifecycle{
+		quotas:     quotas,
+		notifier:   notifier,
+		warnBefore: warnBefore,
+		lastUsed:   make(map[string]time.Time),
+		touched:    make(map[string]bool),
+		startedAt:  make(map[string]time.Time),
+		warned:     make(map[string]bool),
+	}
+	return cm
+}
+
+// OnInput records a keystroke sent to the container
+func (cm *CleanupManager) OnInput(containerID string) {
+	cm.markUsed(containerID)
+}
+
+// markUsed records that the container is in use now
+func (cm *CleanupManager) markUsed(containerID string) {
+	if cm.lc == nil {
+		return
+	}
+	cm.lc.mu.Lock()
+	defer cm.lc.mu.Unlock()
+	cm.lc.lastUsed[containerID] = time.Now()
+	cm.lc.touched[containerID] = true
+}
+
+// Start applies the lifecycle policy every lifecycleInterval until ctx is done
+func (cm *CleanupManager) Start(ctx context.Context) {
+	if cm.lc == nil || cm.dockerSvc == nil || cm.db == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(lifecycleInterval)
+		defer ticker.Stop()
+		for {
+			cm.sweep(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sweep saves recent use to the store, then stops and removes the containers
+// that are past their owner's limits
+func (cm *CleanupManager) sweep(ctx context.Context, now time.Time) {
+	lc := cm.lc
+	lc.mu.Lock()
+	touched := lc.touched
+	lc.touched = make(map[string]bool)
+	lc.mu.Unlock()
+	for id := range touched {
+		if err := cm.db.TouchContainer(id); err != nil {
+			log.Printf("⚠️ Container %s: failed to record activity: %v", id[:min(12, len(id))], err)
+		}
+	}
+
+	list, err := cm.dockerSvc.ListUserContainers(ctx)
+	if err != nil {
+		log.Printf("⚠️ Lifecycle sweep can't list containers: %v", err)
+		return
+	}
+	states := make(map[string]string, len(list))
+	for _, c := range list {
+		states[c.ID] = c.State
+	}
+	records, err := cm.db.ListContainerActivity()
+	if err != nil {
+		log.Printf("⚠️ Lifecycle sweep can't read containers: %v", err)
+		return
+	}
+
+	for i := range records {
+		rec := &records[i]
+		state, ok := states[rec.DockerID]
+		if !ok {
+			continue // Gone from Docker; launching again replaces the record
+		}
+		rec.Status = state // Actions record what Docker reports
+		tier := lc.quotas.For(rec.TrustLevel)
+		if rec.LifecycleAction == ActionRemovalWarning && rec.ActionSeconds > rec.IdleSeconds {
+			cm.cancelRemoval(rec)
+		}
+		if state == "running" {
+			cm.checkRunning(ctx, rec, tier, now)
+		} else {
+			lc.mu.Lock()
+			delete(lc.startedAt, rec.DockerID)
+			lc.mu.Unlock()
+			cm.checkExpiry(ctx, rec, tier, now)
+		}
+	}
+}
+
+// checkRunning stops a running container that has been idle for the tier's
+// idle_minutes or running for its session_minutes
+func (cm *CleanupManager) checkRunning(ctx context.Context, rec *store.ContainerActivity, tier *QuotaTier, now time.Time) {
+	lc := cm.lc
+	lc.mu.Lock()
+	started, ok := lc.startedAt[rec.DockerID]
+	if !ok {
+		started = now
+		lc.startedAt[rec.DockerID] = now
+	}
+	used := lc.lastUsed[rec.DockerID]
+	if used.Before(started) {
+		used = started
+	}
+	lc.mu.Unlock()
+
+	switch {
+	case tier.SessionMinutes > 0 && now.Sub(started) >= tier.SessionDuration():
+		log.Printf("⌛ Container %s of %s reached the %d minute session limit", rec.DockerID[:min(12, len(rec.DockerID))], rec.Username, tier.SessionMinutes)
+		cm.stopForPolicy(ctx, rec, ActionSessionStop)
+	case tier.IdleMinutes > 0 && now.Sub(used) >= tier.IdleTimeout():
+		log.Printf("💤 Container %s of %s had no input for %d minutes", rec.DockerID[:min(12, len(rec.DockerID))], rec.Username, tier.IdleMinutes)
+		cm.stopForPolicy(ctx, rec, ActionIdleStop)
+	}
+}
+
+// stopForPolicy ends the container's terminals, stops it and records why
+func (cm *CleanupManager) stopForPolicy(ctx context.Context, rec *store.ContainerActivity, action string) {
+	Hubs.CloseContainer(rec.DockerID)
+	if err := cm.dockerSvc.StopContainer(ctx, rec.DockerID); err != nil {
+		log.Printf("⚠️ Container %s: stop failed: %v", rec.DockerID[:min(12, len(rec.DockerID))], err)
+		return
+	}
+	cm.lc.mu.Lock()
+	delete(cm.lc.startedAt, rec.DockerID)
+	cm.lc.mu.Unlock()
+	cm.recordAction(rec.DockerID, "exited", action)
+}
+
+// checkExpiry warns the owner of a stopped container nearing the tier's
+// retention_days and removes it once both the retention and the warning
+// period are over. The home volume is kept.
+func (cm *CleanupManager) checkExpiry(ctx context.Context, rec *store.ContainerActivity, tier *QuotaTier, now time.Time) {
+	lc := cm.lc
+	retention := tier.Retention()
+	if retention == 0 || cm.GetConnectionCount(rec.DockerID) > 0 {
+		return
+	}
+	idle := time.Duration(rec.IdleSeconds) * time.Second
+	warned := rec.LifecycleAction == ActionRemovalWarning && rec.ActionSeconds <= rec.IdleSeconds
+	if !warned && idle < retention-lc.warnBefore {
+		return
+	}
+
+	// Removal waits for the retention and, once warned, the whole warning period
+	remaining := retention - idle
+	notice := lc.warnBefore
+	if warned {
+		notice -= time.Duration(rec.ActionSeconds) * time.Second
+	}
+	if notice > remaining {
+		remaining = notice
+	}
+	removeAt := now.Add(remaining)
+
+	switch {
+	case !warned:
+		log.Printf("📢 Container %s of %s unused for %s, removal at %s", rec.DockerID[:min(12, len(rec.DockerID))], rec.Username, idle.Round(time.Hour), removeAt.Format("2006-01-02 15:04"))
+		cm.notify(rec, removeAt)
+		cm.recordAction(rec.DockerID, rec.Status, ActionRemovalWarning)
+	case remaining > 0:
+		// Repeat warnings lost with a restart
+		lc.mu.Lock()
+		sent := lc.warned[rec.DockerID]
+		lc.mu.Unlock()
+		if !sent {
+			cm.notify(rec, removeAt)
+		}
+	default:
+		cm.expire(ctx, rec, tier)
+	}
+}
+
+// expire removes a container unused for longer than its tier keeps it
+func (cm *CleanupManager) expire(ctx context.Context, rec *store.ContainerActivity, tier *QuotaTier) {
+	log.Printf("🗑️ Container %s of %s unused for over %d days, removing", rec.DockerID[:min(12, len(rec.DockerID))], rec.Username, tier.RetentionDays)
+	Hubs.CloseContainer(rec.DockerID)
+	if err := cm.dockerSvc.RemoveContainer(ctx, rec.DockerID); err != nil {
+		log.Printf("⚠️ Container %s: remove failed: %v", rec.DockerID[:min(12, len(rec.DockerID))], err)
+		return
+	}
+	cm.recordAction(rec.DockerID, "removed", ActionExpired)
+	Sessions.Unregister(rec.DockerID)
+	cm.notify(rec, time.Time{})
+
+	cm.lc.mu.Lock()
+	delete(cm.lc.lastUsed, rec.DockerID)
+	cm.lc.mu.Unlock()
+}
+
+// cancelRemoval withdraws the warning of a container used since
+func (cm *CleanupManager) cancelRemoval(rec *store.ContainerActivity) {
+	log.Printf("♻️ Container %s of %s is in use again, removal canceled", rec.DockerID[:min(12, len(rec.DockerID))], rec.Username)
+	cm.notify(rec, time.Time{})
+	cm.recordAction(rec.DockerID, rec.Status, ActionRemovalCanceled)
+	rec.LifecycleAction = ActionRemovalCanceled
+}
+
+// notify passes a removal warning, or its withdrawal, to the notifier
+func (cm *CleanupManager) notify(rec *store.ContainerActivity, removeAt time.Time) {
+	cm.lc.mu.Lock()
+	if removeAt.IsZero() {
+		delete(cm.lc.warned, rec.DockerID)
+	} else {
+		cm.lc.warned[rec.DockerID] = true
+	}
+	cm.lc.mu.Unlock()
+	if cm.lc.notifier != nil {
+		cm.lc.notifier.WarnRemoval(rec.Username, rec.DockerID, removeAt)
+	}
+}
+
+// recordAction saves a lifecycle action in the containers table
+func (cm *CleanupManager) recordAction(containerID, status, action string) {
+	if err := cm.db.RecordContainerAction(containerID, status, action); err != nil {
+		log.Printf("⚠️ Container %s: failed to record %s: %v", containerID[:min(12, len(containerID))], action, err)
+	}
+}
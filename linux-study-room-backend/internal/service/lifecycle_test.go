@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linuxstudyroom/backend/internal/store"
+)
+
+// recordingNotifier keeps every removal warning
+type recordingNotifier struct {
+	mu       sync.Mutex
+	warnings []time.Time
+}
+
+func (n *recordingNotifier) WarnRemoval(username, containerID string, removeAt time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.warnings = append(n.warnings, removeAt)
+}
+
+func (n *recordingNotifier) last() (int, time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.warnings) == 0 {
+		return 0, time.Time{}
+	}
+	return len(n.warnings), n.warnings[len(n.warnings)-1]
+}
+
+func TestLifecycleManager_IdleStopWarnAndExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := store.Init(store.DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	// age sets how long ago a column of the container's record was
+	age := func(id, column string, d time.Duration) {
+		t.Helper()
+		at := time.Now().UTC().Add(-d).Format("2006-01-02 15:04:05")
+		if _, err := raw.Exec("UPDATE containers SET "+column+" = ? WHERE docker_id = ?", at, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quotas, err := ParseQuotaPolicy([]byte(`{"tiers": [
+		{"trust_level": 0, "memory_mb": 256, "cpus": 1, "pids_limit": 64, "idle_minutes": 10, "retention_days": 1}
+	]}`), &Catalog{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &store.User{LinuxDoID: "42", Username: "alice"}
+	if err := db.UpsertUser(user); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	rt := NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+	id, _ := rt.CreateContainer(ctx, &ContainerConfig{UserID: user.ID, OSType: "alpine"})
+	if err := db.CreateContainer(&store.Container{UserID: user.ID, DockerID: id, OSType: "alpine", Status: "running"}); err != nil {
+		t.Fatal(err)
+	}
+	notifier := &recordingNotifier{}
+	cm := NewLifecycleManager(rt, db, quotas, notifier, time.Hour)
+	action := func() string {
+		t.Helper()
+		list, err := db.ListContainerActivity()
+		if err != nil || len(list) == 0 {
+			return "removed"
+		}
+		return list[0].LifecycleAction
+	}
+
+	// Keystrokes keep a running container going; no input for idle_minutes stops it
+	now := time.Now()
+	cm.sweep(ctx, now)
+	cm.OnInput(id)
+	cm.sweep(ctx, now.Add(9*time.Minute))
+	if status, _ := rt.GetContainerStatus(ctx, id); status != "running" {
+		t.Fatalf("stopped before the idle timeout: %s", status)
+	}
+	cm.sweep(ctx, now.Add(11*time.Minute))
+	if status, _ := rt.GetContainerStatus(ctx, id); status != "exited" || action() != ActionIdleStop {
+		t.Fatalf("after idle timeout: status %s, action %s", status, action())
+	}
+
+	// Unused for most of retention_days: warned once, removal waits for the notice
+	age(id, "last_active", 23*time.Hour+30*time.Minute)
+	cm.sweep(ctx, time.Now())
+	n, removeAt := notifier.last()
+	if n != 1 || action() != ActionRemovalWarning || time.Until(removeAt) < 55*time.Minute {
+		t.Fatalf("warning: %d sent, removal at %s, action %s", n, removeAt, action())
+	}
+	cm.sweep(ctx, time.Now())
+	if n, _ := notifier.last(); n != 1 {
+		t.Fatalf("warned %d times", n)
+	}
+
+	// Using the container withdraws the warning
+	age(id, "lifecycle_at", time.Minute)
+	cm.OnConnect(id)
+	cm.OnDisconnect(id)
+	if cm.HasPendingCleanup(id) {
+		t.Fatal("disconnect armed the removal timer")
+	}
+	cm.sweep(ctx, time.Now())
+	if n, removeAt := notifier.last(); n != 2 || !removeAt.IsZero() || action() != ActionRemovalCanceled {
+		t.Fatalf("after use: %d warnings, last %s, action %s", n, removeAt, action())
+	}
+
+	// Warned and past both the retention and the notice: removed
+	age(id, "last_active", 30*time.Hour)
+	cm.sweep(ctx, time.Now())
+	age(id, "lifecycle_at", 2*time.Hour)
+	cm.sweep(ctx, time.Now())
+	if _, err := rt.GetContainerStatus(ctx, id); err == nil {
+		t.Fatal("expired container still exists")
+	}
+	if c, err := db.GetContainerByDockerID(id); err != nil || c.Status != "removed" {
+		t.Fatalf("record after expiry = %+v, %v", c, err)
+	}
+	if n, removeAt := notifier.last(); n != 4 || !removeAt.IsZero() {
+		t.Fatalf("after expiry: %d warnings, last %s", n, removeAt)
+	}
+}
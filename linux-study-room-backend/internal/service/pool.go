@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolContainerPrefix names warm pool containers. A claimed container is
+// renamed to its user's lsr-user-* name.
+const PoolContainerPrefix = "lsr-pool-"
+
+// ErrPoolMismatch means a launch needs something a started pool container
+// can't be given, such as a checkpoint or an existing home volume. Launches
+// of users with a home don't try the pool at all; see WarmPool.Launch.
+var ErrPoolMismatch = errors.New("launch can't use a pool container")
+
+// poolCheckInterval is how often the pool is topped up and checked for
+// containers of outdated image builds, besides after every claim
+const poolCheckInterval = 30 * time.Second
+
+// PoolStats reports one image's warm pool
+type PoolStats struct {
+	Image     string `json:"image"`
+	Size      int    `json:"size"`      // pool_size from the catalog
+	Ready     int    `json:"ready"`     // Started containers waiting for a launch
+	Hits      int64  `json:"hits"`      // Launches served from the pool
+	Misses    int64  `json:"misses"`    // First launches that created a container
+	Returning int64  `json:"returning"` // Launches of users with a home already, which the pool can't serve
+}
+
+// WarmPool keeps pre-created, started containers of the catalog images that
+// set pool_size, so launches skip container creation. A container is
+// personalized by the runtime when claimed and the pool refills in the
+// background.
+type WarmPool struct {
+	runtime   ContainerRuntime
+	catalog   *Catalog
+	quota     *QuotaTier // Limits pool containers start with
+	scheduler *Scheduler // Refills only while the host has room; nil for no limit
+	kick      chan struct{}
+
+	mu        sync.Mutex
+	ready     map[string][]string // Image ID -> pool container IDs, oldest first
+	hits      map[string]int64
+	misses    map[string]int64
+	returning map[string]int64
+}
+
+// NewWarmPool creates a pool for the catalog whose containers start with the
+// quota's limits, within the scheduler's capacity. Call Start to fill it.
+func NewWarmPool(runtime ContainerRuntime, catalog *Catalog, quota *QuotaTier, scheduler *Scheduler) *WarmPool {
+	return &WarmPool{
+		runtime:   runtime,
+		catalog:   catalog,
+		quota:     quota,
+		scheduler: scheduler,
+		kick:      make(chan struct{}, 1),
+		ready:     make(map[string][]string),
+		hits:      make(map[string]int64),
+		misses:    make(map[string]int64),
+		returning: make(map[string]int64),
+	}
+}
+
+// Enabled reports whether any image has a pool
+func (p *WarmPool) Enabled() bool {
+	for _, spec := range p.catalog.Images {
+		if spec.PoolSize > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Start takes over pool containers left by a previous run and refills the
+// pool in the background until ctx is done
+func (p *WarmPool) Start(ctx context.Context) {
+	if !p.Enabled() {
+		return
+	}
+	p.adopt(ctx)
+	go p.loop(ctx)
+}
+
+// Launch starts a container for cfg, claiming a pool container when one
+// fits and creating a new one otherwise. pooled reports a pool hit. Only
+// first launches can be served: a started container can't mount the home
+// volume of a returning user.
+func (p *WarmPool) Launch(ctx context.Context, cfg *ContainerConfig) (id string, pooled bool, err error) {
+	if id, ok := p.claim(ctx, cfg); ok {
+		return id, true, nil
+	}
+	id, err = p.runtime.CreateContainer(ctx, cfg)
+	return id, false, err
+}
+
+// claim hands the oldest ready container of the image to cfg's user
+func (p *WarmPool) claim(ctx context.Context, cfg *ContainerConfig) (string, bool) {
+	spec, ok := p.catalog.Get(cfg.OSType)
+	if !ok || spec.PoolSize == 0 {
+		return "", false
+	}
+	hasHome, err := p.runtime.HasHome(ctx, cfg.UserID)
+	if err != nil || hasHome {
+		p.mu.Lock()
+		if err != nil {
+			log.Printf("⚠️ Failed to check the home of user %d, creating a container: %v", cfg.UserID, err)
+			p.misses[spec.ID]++
+		} else {
+			p.returning[spec.ID]++
+		}
+		p.mu.Unlock()
+		return "", false
+	}
+	defer p.refill()
+
+	p.mu.Lock()
+	ids := p.ready[spec.ID]
+	if len(ids) == 0 {
+		p.misses[spec.ID]++
+		p.mu.Unlock()
+		log.Printf("🧊 Warm pool of %s is empty, creating a container for user %d", spec.ID, cfg.UserID)
+		return "", false
+	}
+	id := ids[0]
+	p.ready[spec.ID] = ids[1:]
+	p.mu.Unlock()
+
+	err = p.runtime.ClaimPoolContainer(ctx, id, cfg)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err == nil:
+		p.hits[spec.ID]++
+		log.Printf("🔥 Warm pool container %s claimed by user %d", id[:12], cfg.UserID)
+		return id, true
+	case errors.Is(err, ErrPoolMismatch):
+		// Still good for the next launch
+		p.ready[spec.ID] = append([]string{id}, p.ready[spec.ID]...)
+	default:
+		log.Printf("⚠️ Failed to claim pool container %s: %v", id[:12], err)
+		go p.runtime.RemoveContainer(context.Background(), id)
+	}
+	p.misses[spec.ID]++
+	return "", false
+}
+
+// Stats reports every image that has a pool, in catalog order
+func (p *WarmPool) Stats() []PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := []PoolStats{}
+	for _, spec := range p.catalog.Images {
+		if spec.PoolSize == 0 && p.hits[spec.ID] == 0 && p.misses[spec.ID] == 0 && p.returning[spec.ID] == 0 {
+			continue
+		}
+		stats = append(stats, PoolStats{
+			Image:     spec.ID,
+			Size:      spec.PoolSize,
+			Ready:     len(p.ready[spec.ID]),
+			Hits:      p.hits[spec.ID],
+			Misses:    p.misses[spec.ID],
+			Returning: p.returning[spec.ID],
+		})
+	}
+	return stats
+}
+
+// refill wakes the background loop
+func (p *WarmPool) refill() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+// adopt keeps the running pool containers of images that still have a pool
+// and removes the rest
+func (p *WarmPool) adopt(ctx context.Context) {
+	list, err := p.runtime.ListPoolContainers(ctx)
+	if err != nil {
+		log.Printf("⚠️ Failed to list pool containers: %v", err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range list {
+		if spec, ok := p.catalog.Get(c.Image); ok && c.State == "running" && len(p.ready[spec.ID]) < spec.PoolSize {
+			p.ready[spec.ID] = append(p.ready[spec.ID], c.ID)
+			continue
+		}
+		log.Printf("🧹 Removing leftover pool container %s", c.Name)
+		go p.runtime.RemoveContainer(context.Background(), c.ID)
+	}
+}
+
+// loop tops up the pool whenever kicked and every poolCheckInterval
+func (p *WarmPool) loop(ctx context.Context) {
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+	for {
+		for i := range p.catalog.Images {
+			p.fill(ctx, &p.catalog.Images[i])
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// fill drops the image's pool containers that stopped or run an outdated
+// build, then creates containers until the pool is full or the host has no
+// room
+func (p *WarmPool) fill(ctx context.Context, spec *ImageSpec) {
+	current, err := p.runtime.ImageVersion(ctx, spec)
+	if err != nil {
+		current = "" // Not built through the catalog; keep what is there
+	}
+	quota := p.quota
+	if quota == nil {
+		quota = &defaultQuota
+	}
+	p.mu.Lock()
+	ids := append([]string(nil), p.ready[spec.ID]...)
+	p.mu.Unlock()
+	for i, id := range ids {
+		stale := i >= spec.PoolSize
+		if status, err := p.runtime.GetContainerStatus(ctx, id); err != nil || status != "running" {
+			stale = true
+		} else if current != "" {
+			version, err := p.runtime.ContainerImageVersion(ctx, id)
+			stale = stale || err != nil || version != current
+		}
+		if stale && p.take(spec.ID, id) {
+			log.Printf("🧹 Removing pool container %s of %s", id[:12], spec.ID)
+			p.runtime.RemoveContainer(ctx, id)
+		}
+	}
+
+	for {
+		p.mu.Lock()
+		missing := spec.PoolSize - len(p.ready[spec.ID])
+		p.mu.Unlock()
+		if missing <= 0 || ctx.Err() != nil {
+			return
+		}
+		if p.scheduler != nil && !p.scheduler.HasRoom(ctx, quota.MemoryMB, quota.CPUs) {
+			return
+		}
+		id, err := p.runtime.CreatePoolContainer(ctx, spec.ID, p.quota)
+		if err != nil {
+			log.Printf("⚠️ Failed to create pool container of %s: %v", spec.ID, err)
+			return
+		}
+		p.mu.Lock()
+		p.ready[spec.ID] = append(p.ready[spec.ID], id)
+		p.mu.Unlock()
+		log.Printf("🌡️ Warm pool container %s of %s is ready", id[:12], spec.ID)
+	}
+}
+
+// take removes a container from the ready list, reporting whether it was
+// still there rather than claimed meanwhile
+func (p *WarmPool) take(image, id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := p.ready[image]
+	for i, ready := range ids {
+		if ready == id {
+			p.ready[image] = append(ids[:i:i], ids[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// isPoolContainerName reports whether a container name belongs to the pool
+func isPoolContainerName(name string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(name, "/"), PoolContainerPrefix)
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+)
+
+// labelPool records the name a container had in the warm pool; containers
+// created for a user have none
+const labelPool = "lsr.pool"
+
+// poolEnvFile holds the session environment of a claimed pool container,
+// which can't change the environment it was started with
+const poolEnvFile = "/etc/lsr/env"
+
+// poolHomeVolume names the volume mounted as a pool container's home
+func poolHomeVolume(poolName string) string {
+	return poolName + "-home"
+}
+
+// CreatePoolContainer starts an unassigned root container of a catalog image
+// with the default shell and the quota's limits. It mounts a fresh home
+// volume of its own, since Docker can't add the user's once it is running.
+func (d *DockerService) CreatePoolContainer(ctx context.Context, osType string, quota *QuotaTier) (string, error) {
+	spec, ok := d.catalog.Get(osType)
+	if !ok {
+		return "", fmt.Errorf("unknown image %q", osType)
+	}
+	imageInfo, _, err := d.cli.ImageInspectWithRaw(ctx, spec.Tag())
+	if err != nil {
+		return "", fmt.Errorf("image %s not found - please build it first", spec.Tag())
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := PoolContainerPrefix + spec.ID + "-" + hex.EncodeToString(suffix)
+
+	var mounts []mount.Mount
+	if _, err := writeDisguiseFiles(poolDisguisePath(name), nil); err != nil {
+		log.Printf("⚠️ Warning: Failed to create disguise files: %v", err)
+	} else {
+		mounts = disguiseMounts(poolDisguisePath(name))
+	}
+	if quota == nil {
+		quota = &defaultQuota
+	}
+	if err := d.createVolume(ctx, poolHomeVolume(name), map[string]string{labelPool: name}, quota.DiskBytes()); err != nil {
+		return "", fmt.Errorf("failed to create pool home volume: %w", err)
+	}
+	mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Source: poolHomeVolume(name), Target: ContainerHome})
+
+	shell := spec.ShellFor("")
+	resp, err := d.createContainer(ctx,
+		&container.Config{
+			Image: imageInfo.ID,
+			Cmd:   []string{shell},
+			User:  "0:0",
+			Labels: map[string]string{
+				labelImage:        spec.ID,
+				labelShell:        shell,
+				labelImageVersion: ImageVersionOf(imageInfo.ID),
+				labelMemory:       strconv.FormatInt(quota.MemoryMB, 10),
+				labelCPUs:         strconv.FormatFloat(quota.CPUs, 'f', -1, 64),
+				labelDisk:         strconv.FormatInt(quota.DiskMB, 10),
+				labelPool:         name,
+				labelAccount:      "",
+				labelSudo:         "",
+			},
+			Tty:          true,
+			OpenStdin:    true,
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+			Env:          []string{"USER=root", "TERM=xterm-256color", "COLORTERM=truecolor"},
+		},
+		hostConfig(quota, mounts, []string{"CHOWN", "SETUID", "SETGID"}, []string{"no-new-privileges"}),
+		name,
+	)
+	if err != nil {
+		d.cli.VolumeRemove(ctx, poolHomeVolume(name), true)
+		os.RemoveAll(poolDisguisePath(name))
+		return "", fmt.Errorf("failed to create pool container: %w", err)
+	}
+	if err := d.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		d.RemoveContainer(ctx, resp.ID)
+		return "", fmt.Errorf("failed to start pool container: %w", err)
+	}
+	return resp.ID, nil
+}
+
+// ClaimPoolContainer personalizes a pool container for cfg's user: limits
+// from the quota, the session environment and disguise files, then the
+// user's container name. Only a root container with the image's default
+// shell fits, and only for users without a home volume yet, whose first
+// home becomes the pool container's (see releasePoolContainer). Disk limits
+// can't be changed once created, so where Docker enforces them the pool
+// container must have been created with the quota's disk_mb.
+func (d *DockerService) ClaimPoolContainer(ctx context.Context, containerID string, cfg *ContainerConfig) error {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if info.Config == nil || info.Config.Labels[labelPool] == "" || !isPoolContainerName(info.Name) {
+		return fmt.Errorf("%s is not an unclaimed pool container", info.Name)
+	}
+	labels := info.Config.Labels
+	spec, ok := d.catalog.Get(cfg.OSType)
+	if !ok || labels[labelImage] != spec.ID || cfg.Checkpoint != "" || !cfg.Account.IsRoot() ||
+		spec.ShellFor(cfg.Shell) != labels[labelShell] {
+		return ErrPoolMismatch
+	}
+	quota := cfg.Quota
+	if quota == nil {
+		quota = &defaultQuota
+	}
+	diskLimited := !d.noLayerLimit.Load() || !d.noVolumeLimit.Load()
+	if diskLimited && labels[labelDisk] != strconv.FormatInt(quota.DiskMB, 10) {
+		return ErrPoolMismatch
+	}
+	if hasHome, err := d.HasHome(ctx, cfg.UserID); err != nil {
+		return err
+	} else if hasHome {
+		return ErrPoolMismatch
+	}
+	if _, err := d.cli.ContainerUpdate(ctx, containerID, container.UpdateConfig{Resources: quotaResources(quota)}); err != nil {
+		return fmt.Errorf("failed to apply limits: %w", err)
+	}
+	if err := d.writePoolSessionEnv(ctx, containerID, []string{"USER=" + cfg.Username}); err != nil {
+		return err
+	}
+	if _, err := writeDisguiseFiles(poolDisguisePath(labels[labelPool]), nil); err != nil {
+		log.Printf("⚠️ Warning: Failed to create disguise files: %v", err)
+	}
+
+	// Renaming last leaves a failed claim an ordinary pool container
+	name := ContainerName(cfg.UserID)
+	if old, err := d.cli.ContainerInspect(ctx, name); err == nil {
+		log.Printf("⚠️ Found orphaned container %s, removing it...", name)
+		d.RemoveContainer(ctx, old.ID)
+	}
+	if err := d.cli.ContainerRename(ctx, containerID, name); err != nil {
+		return fmt.Errorf("failed to rename pool container: %w", err)
+	}
+	log.Printf("✅ Pool container %s is now %s (%dMB, %.1f CPU, %d pids)", labels[labelPool], name, quota.MemoryMB, quota.CPUs, quota.PidsLimit)
+	return nil
+}
+
+// ListPoolContainers lists all lsr-pool-* containers, including stopped ones
+func (d *DockerService) ListPoolContainers(ctx context.Context) ([]ContainerInfo, error) {
+	list, err := d.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("name", PoolContainerPrefix)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result []ContainerInfo
+	for _, c := range list {
+		for _, name := range c.Names {
+			if isPoolContainerName(name) {
+				memoryMB, cpus := containerLimits(c.Labels)
+				result = append(result, ContainerInfo{ID: c.ID, Name: strings.TrimPrefix(name, "/"), State: c.State, Image: c.Labels[labelImage], MemoryMB: memoryMB, CPUs: cpus})
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// updatedLimits reads the limits a claimed pool container runs with, falling
+// back to the ones from its labels
+func (d *DockerService) updatedLimits(ctx context.Context, containerID string, memoryMB int64, cpus float64) (int64, float64) {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil || info.HostConfig == nil {
+		return memoryMB, cpus
+	}
+	if info.HostConfig.Memory > 0 {
+		memoryMB = info.HostConfig.Memory >> 20
+	}
+	if info.HostConfig.NanoCPUs > 0 {
+		cpus = float64(info.HostConfig.NanoCPUs) / 1e9
+	}
+	return memoryMB, cpus
+}
+
+// writePoolSessionEnv stores the environment exec sessions of a claimed pool
+// container get
+func (d *DockerService) writePoolSessionEnv(ctx context.Context, containerID string, env []string) error {
+	content := []byte(strings.Join(env, "\n") + "\n")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "lsr/", Mode: 0o755})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "lsr/env", Mode: 0o644, Size: int64(len(content))})
+	tw.Write(content)
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := d.cli.CopyToContainer(ctx, containerID, "/etc", &buf, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to write session environment: %w", err)
+	}
+	return nil
+}
+
+// poolSessionEnv reads the environment written by writePoolSessionEnv
+func (d *DockerService) poolSessionEnv(ctx context.Context, containerID string) []string {
+	rc, _, err := d.cli.CopyFromContainer(ctx, containerID, poolEnvFile)
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	if _, err := tr.Next(); err != nil {
+		return nil
+	}
+	var env []string
+	scanner := bufio.NewScanner(tr)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.Contains(line, "=") {
+			env = append(env, line)
+		}
+	}
+	return env
+}
+
+// homeVolumeOf returns the volume holding a user's home: their own, or that
+// of their claimed pool container until it is removed
+func (d *DockerService) homeVolumeOf(ctx context.Context, userID int64) string {
+	name := HomeVolumeName(userID)
+	if _, err := d.cli.VolumeInspect(ctx, name); err == nil {
+		return name
+	}
+	if info, err := d.cli.ContainerInspect(ctx, ContainerName(userID)); err == nil && info.Config != nil && info.Config.Labels[labelPool] != "" {
+		return poolHomeVolume(info.Config.Labels[labelPool])
+	}
+	return name
+}
+
+// releasePoolContainer cleans up after a removed pool container. The home of
+// a claimed one is copied into its user's home volume first, so the files
+// survive like any other user's; the pool volume is kept if that fails.
+func (d *DockerService) releasePoolContainer(ctx context.Context, info types.ContainerJSON) {
+	poolName := info.Config.Labels[labelPool]
+	os.RemoveAll(poolDisguisePath(poolName))
+
+	var userID int64
+	if _, err := fmt.Sscanf(info.Name, "/"+UserContainerPrefix+"%d", &userID); err == nil && userID > 0 {
+		diskMB, _ := strconv.ParseInt(info.Config.Labels[labelDisk], 10, 64)
+		if err := d.adoptPoolHome(ctx, userID, poolHomeVolume(poolName), info.Image, diskMB<<20); err != nil {
+			log.Printf("⚠️ Failed to move home of %s to user %d, keeping volume %s: %v", poolName, userID, poolHomeVolume(poolName), err)
+			return
+		}
+		log.Printf("🏠 Home of pool container %s moved to %s", poolName, HomeVolumeName(userID))
+	}
+	if err := d.cli.VolumeRemove(ctx, poolHomeVolume(poolName), true); err != nil && !client.IsErrNotFound(err) {
+		log.Printf("⚠️ Failed to remove volume %s: %v", poolHomeVolume(poolName), err)
+	}
+}
+
+// adoptPoolHome copies a pool home volume into the user's home volume, created
+// with size bytes, using a throwaway container of the image
+func (d *DockerService) adoptPoolHome(ctx context.Context, userID int64, poolVolume, image string, size int64) error {
+	home, err := d.ensureHomeVolume(ctx, userID, "/to", size)
+	if err != nil {
+		return err
+	}
+	_, err = d.runHelper(ctx, image, []mount.Mount{
+		{Type: mount.TypeVolume, Source: poolVolume, Target: "/from", ReadOnly: true},
+		home,
+	}, []string{"cp", "-a", "/from/.", "/to/"})
+	return err
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitReady waits until the pool has n ready containers of the image
+func waitReady(t *testing.T, p *WarmPool, image string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, st := range p.Stats() {
+			if st.Image == image && st.Ready == n {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pool of %s never had %d ready: %+v", image, n, p.Stats())
+}
+
+func TestWarmPool_ClaimAndRefill(t *testing.T) {
+	catalog, err := ParseCatalog([]byte(`{"images": [
+		{"id": "alpine", "base": "alpine:3.19", "shell": "sh", "pool_size": 2},
+		{"id": "debian", "base": "debian:bookworm-slim", "shell": "sh"}
+	]}`), "")
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rt := NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+
+	// A container left by a previous run is adopted, not recreated
+	leftover, _ := rt.CreatePoolContainer(ctx, "alpine", nil)
+	p := NewWarmPool(rt, catalog, nil, nil)
+	p.Start(ctx)
+	waitReady(t, p, "alpine", 2)
+	if pool, _ := rt.ListPoolContainers(ctx); len(pool) != 2 {
+		t.Fatalf("pool containers = %+v", pool)
+	}
+
+	id, pooled, err := p.Launch(ctx, &ContainerConfig{UserID: 1, OSType: "alpine", Username: "alice"})
+	if err != nil || !pooled || id != leftover {
+		t.Fatalf("launch = %s %v %v, want the oldest pool container", id, pooled, err)
+	}
+	if _, name, _ := rt.LookupContainer(ctx, id); name != "/"+ContainerName(1) {
+		t.Fatalf("claimed container is named %s", name)
+	}
+	waitReady(t, p, "alpine", 2)
+
+	// Users with a home already, checkpoints and unpooled images create a
+	// container; only the checkpoint counts as a miss
+	rt.HomeDir(2)
+	misses := []*ContainerConfig{
+		{UserID: 2, OSType: "alpine"},
+		{UserID: 3, OSType: "alpine", Checkpoint: "clean"},
+		{UserID: 4, OSType: "debian"},
+	}
+	for _, cfg := range misses {
+		if _, pooled, _ := p.Launch(ctx, cfg); pooled {
+			t.Errorf("launch %+v was served from the pool", cfg)
+		}
+	}
+
+	stats := p.Stats()
+	if len(stats) != 1 || stats[0].Image != "alpine" || stats[0].Size != 2 || stats[0].Hits != 1 || stats[0].Misses != 1 || stats[0].Returning != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if users, _ := rt.ListUserContainers(ctx); len(users) != 3 {
+		t.Fatalf("user containers = %+v", users)
+	}
+}
@@ -0,0 +1,833 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrHubReadOnly is returned when a client without input rights writes to a hub
+var ErrHubReadOnly = errors.New("client may not send input")
+
+// ErrHubClosed is returned when writing to a hub whose shell has exited
+var ErrHubClosed = errors.New("terminal session closed")
+
+// ErrSpectatorsDisabled is returned when the owner does not allow spectators
+var ErrSpectatorsDisabled = errors.New("spectators are disabled for this session")
+
+// HubRole is what a client attached to a PTYHub may do
+type HubRole int
+
+const (
+	// HubOwner is the container owner; the hub lives as long as the owner's connection
+	HubOwner HubRole = iota + 1
+	// HubHelper is an invited helper typing into the owner's shell
+	HubHelper
+	// HubSpectator only watches; it never sends input or affects the PTY size
+	HubSpectator
+)
+
+// HubEventKind tells what a HubEvent carries
+type HubEventKind int
+
+const (
+	// HubEventOutput carries terminal output in Data
+	HubEventOutput HubEventKind = iota + 1
+	// HubEventSpectators tells the owner the spectator setting or count changed
+	HubEventSpectators
+	// HubEventStatus tells every client why the container stopped, in Status
+	HubEventStatus
+)
+
+// HubEvent is delivered to attached clients
+type HubEvent struct {
+	Kind            HubEventKind
+	Data            []byte
+	Seq             uint64 // Output sequence number after Data, for resuming
+	Spectators      int
+	AllowSpectators bool
+	Status          string
+}
+
+// hubClientBuffer is how many events a client may lag behind before it is dropped
+const hubClientBuffer = 256
+
+// hubReplaySize is how much recent output is kept for resuming owners
+const hubReplaySize = 256 * 1024
+
+// hubReadSize is the largest chunk read from the PTY at once
+const hubReadSize = 32 * 1024
+
+// maxCoalesce caps how much queued output Next merges into one event
+const maxCoalesce = 64 * 1024
+
+// Client sizes are clamped to these so nobody can make the screen model
+// allocate an enormous grid
+const (
+	maxTerminalCols = 500
+	maxTerminalRows = 200
+)
+
+// Helper sizes below these are left out of the PTY size
+const (
+	minHelperCols = 40
+	minHelperRows = 10
+)
+
+// resizeTimeout bounds the runtime call resizing an exec TTY
+const resizeTimeout = 5 * time.Second
+
+// PTYHub shares one exec session between every client attached to a terminal.
+// Output is fanned out to all clients and input from owner and helpers is merged
+// into the same stdin. The PTY takes the smallest size reported by the owner
+// and helpers, like tmux, so nobody sees lines wrapped past their screen.
+type PTYHub struct {
+	containerID string
+	terminalID  string
+	runtime     ContainerRuntime
+	stream      ExecStream
+	execID      string
+
+	mu      sync.Mutex
+	clients map[uint64]*HubClient
+	nextID  uint64
+	cols    uint // Current PTY size, 0 until a client reports one
+	rows    uint
+
+	allowSpectators bool
+
+	screen      *Screen // Server-side model of what the PTY shows
+	screenDirty bool    // Changed since the last published snapshot
+	replay      *outputRing
+
+	resumeToken string
+	lingerGen   int         // Bumped to cancel a pending linger timer
+	lingerTimer *time.Timer // Non-nil while waiting for the owner to come back
+	onShutdown  func()
+
+	writeMu   sync.Mutex // Keeps each client's input chunk contiguous
+	resizeMu  sync.Mutex // Orders exec TTY resizes, which run without mu
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// HubClient is one connection attached to a PTYHub
+type HubClient struct {
+	Username string
+	Role     HubRole
+	// Events receives terminal output and notifications; it is closed when
+	// the client is detached, falls too far behind, or the hub closes
+	Events <-chan HubEvent
+
+	id      uint64
+	hub     *PTYHub
+	out     chan HubEvent
+	cols    uint
+	rows    uint
+	pending *HubEvent // Non-output event read ahead by Next
+}
+
+// hubKey identifies one terminal of a container
+type hubKey struct {
+	containerID string
+	terminalID  string
+}
+
+// hubStatusTTL is how long a container's stop reason is kept for terminals
+// that closed before it was known
+const hubStatusTTL = time.Minute
+
+// hubStatus is why a container stopped
+type hubStatus struct {
+	status string
+	at     time.Time
+}
+
+// HubManager tracks the PTY hub of each terminal
+type HubManager struct {
+	mu   sync.Mutex
+	hubs map[hubKey]*PTYHub
+
+	statuses      map[string]hubStatus // Container ID -> latest stop reason
+	statusChanged chan struct{}        // Closed and replaced when a reason is recorded
+}
+
+// Global hub manager instance
+var Hubs = &HubManager{
+	hubs:          make(map[hubKey]*PTYHub),
+	statuses:      make(map[string]hubStatus),
+	statusChanged: make(chan struct{}),
+}
+
+// Open starts a new exec session running shell for a terminal of the
+// container; an empty shell uses the container's default. A hub already open
+// for that terminal is replaced without running its shutdown hook.
+func (m *HubManager) Open(ctx context.Context, runtime ContainerRuntime, containerID, terminalID, shell string) (*PTYHub, error) {
+	if old := m.Get(containerID, terminalID); old != nil {
+		old.close(false)
+	}
+
+	stream, execID, err := runtime.ExecContainer(ctx, containerID, shell)
+	if err != nil {
+		return nil, err
+	}
+	token, err := randomToken()
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	hub := &PTYHub{
+		containerID: containerID,
+		terminalID:  terminalID,
+		runtime:     runtime,
+		stream:      stream,
+		execID:      execID,
+		clients:     make(map[uint64]*HubClient),
+		done:        make(chan struct{}),
+
+		allowSpectators: true,
+		screen:          NewScreen(80, 24),
+		replay:          newOutputRing(hubReplaySize),
+		resumeToken:     token,
+	}
+	key := hubKey{containerID, terminalID}
+	m.mu.Lock()
+	if old := m.hubs[key]; old != nil {
+		// Lost a race with another Open; the newest connection wins
+		defer old.close(false)
+	}
+	m.hubs[key] = hub
+	m.mu.Unlock()
+	go hub.pump()
+
+	log.Printf("📺 PTY hub opened for container %s terminal %s (exec %s)", containerID[:12], terminalID, execID[:12])
+	return hub, nil
+}
+
+// Get returns a terminal's hub, or nil if nobody has opened one
+func (m *HubManager) Get(containerID, terminalID string) *PTYHub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hubs[hubKey{containerID, terminalID}]
+}
+
+// ForContainer returns the hubs of every open terminal in the container
+func (m *HubManager) ForContainer(containerID string) []*PTYHub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*PTYHub
+	for key, hub := range m.hubs {
+		if key.containerID == containerID {
+			result = append(result, hub)
+		}
+	}
+	return result
+}
+
+// Active reports whether an owner or helper is attached to any terminal of
+// the container; spectators don't count
+func (m *HubManager) Active(containerID string) bool {
+	for _, hub := range m.ForContainer(containerID) {
+		if hub.hasUsers() {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseContainer closes every terminal of the container, running their shutdown hooks
+func (m *HubManager) CloseContainer(containerID string) {
+	for _, hub := range m.ForContainer(containerID) {
+		hub.Close()
+	}
+}
+
+// NotifyStatus sends a status to every client of the container's terminals
+// and keeps it for WaitStatus. A container's shells usually end before the
+// reason for its stop is known, closing their terminals first.
+func (m *HubManager) NotifyStatus(containerID, status string) {
+	now := time.Now()
+	m.mu.Lock()
+	for id, st := range m.statuses {
+		if now.Sub(st.at) > hubStatusTTL {
+			delete(m.statuses, id)
+		}
+	}
+	m.statuses[containerID] = hubStatus{status: status, at: now}
+	close(m.statusChanged)
+	m.statusChanged = make(chan struct{})
+	m.mu.Unlock()
+
+	for _, hub := range m.ForContainer(containerID) {
+		hub.broadcast(HubEvent{Kind: HubEventStatus, Status: status})
+	}
+}
+
+// WaitStatus returns the reason the container stopped if NotifyStatus
+// reports one after since, waiting up to timeout for it
+func (m *HubManager) WaitStatus(containerID string, since time.Time, timeout time.Duration) (string, bool) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		m.mu.Lock()
+		st, ok := m.statuses[containerID]
+		changed := m.statusChanged
+		m.mu.Unlock()
+		if ok && !st.at.Before(since) {
+			return st.status, true
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return "", false
+		}
+	}
+}
+
+// DetachHelper disconnects a helper from every terminal of the container so
+// a revoked helper stops seeing its output; an empty username detaches all helpers
+func (m *HubManager) DetachHelper(containerID, username string) {
+	for _, hub := range m.ForContainer(containerID) {
+		hub.detachHelpers(username)
+	}
+}
+
+// SetAllowSpectators applies the owner's spectator setting to every terminal of the container
+func (m *HubManager) SetAllowSpectators(containerID string, allow bool) {
+	for _, hub := range m.ForContainer(containerID) {
+		hub.SetAllowSpectators(allow)
+	}
+}
+
+// remove forgets hub if it is still its terminal's current hub
+func (m *HubManager) remove(hub *PTYHub) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := hubKey{hub.containerID, hub.terminalID}
+	if m.hubs[key] == hub {
+		delete(m.hubs, key)
+	}
+}
+
+// randomToken returns 32 random hex characters
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewTerminalID returns a random ID for a terminal created alongside the main one
+func NewTerminalID() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return token[:8], nil
+}
+
+// TerminalID returns the ID of the terminal this hub serves
+func (h *PTYHub) TerminalID() string {
+	return h.terminalID
+}
+
+// ExecID returns the ID of the shared exec session
+func (h *PTYHub) ExecID() string {
+	return h.execID
+}
+
+// Done is closed once the hub has shut down
+func (h *PTYHub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Size returns the current PTY size, zero until a client has reported one
+func (h *PTYHub) Size() (cols, rows uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cols, h.rows
+}
+
+// Attach adds a client that receives all output from now on.
+// Attaching to a closed hub returns a client whose Events is already closed.
+// Spectators must use AttachSpectator.
+func (h *PTYHub) Attach(username string, role HubRole) *HubClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.attachLocked(username, role)
+	if role == HubOwner {
+		// Tell the owner the current spectator setting straight away
+		h.spectatorsChangedLocked()
+	}
+	return c
+}
+
+// AttachSpectator adds a read-only client if the owner allows spectators
+func (h *PTYHub) AttachSpectator(username string) (*HubClient, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.allowSpectators {
+		return nil, ErrSpectatorsDisabled
+	}
+	c := h.attachLocked(username, HubSpectator)
+	h.spectatorsChangedLocked()
+	return c, nil
+}
+
+// attachLocked registers a new client. Caller holds h.mu.
+func (h *PTYHub) attachLocked(username string, role HubRole) *HubClient {
+	out := make(chan HubEvent, hubClientBuffer)
+	c := &HubClient{Username: username, Role: role, Events: out, hub: h, out: out}
+	select {
+	case <-h.done:
+		close(out)
+		return c
+	default:
+	}
+	h.nextID++
+	c.id = h.nextID
+	h.clients[c.id] = c
+	if role != HubOwner {
+		// Late joiners start from the current screen instead of a blank terminal
+		c.out <- HubEvent{Kind: HubEventOutput, Data: []byte(h.screen.ANSI()), Seq: h.replay.End()}
+	}
+	return c
+}
+
+// SetAllowSpectators turns spectating on or off; turning it off disconnects
+// every current spectator
+func (h *PTYHub) SetAllowSpectators(allow bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.allowSpectators = allow
+	if !allow {
+		for id, c := range h.clients {
+			if c.Role == HubSpectator {
+				close(c.out)
+				delete(h.clients, id)
+			}
+		}
+	}
+	h.spectatorsChangedLocked()
+}
+
+// Spectators returns whether spectators are allowed and how many are watching
+func (h *PTYHub) Spectators() (allow bool, count int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.allowSpectators, h.spectatorCountLocked()
+}
+
+// spectatorCountLocked counts attached spectators. Caller holds h.mu.
+func (h *PTYHub) spectatorCountLocked() int {
+	n := 0
+	for _, c := range h.clients {
+		if c.Role == HubSpectator {
+			n++
+		}
+	}
+	return n
+}
+
+// spectatorsChangedLocked tells the owner and the lobby about the spectator
+// setting and count. Caller holds h.mu.
+func (h *PTYHub) spectatorsChangedLocked() {
+	count := h.spectatorCountLocked()
+	Sessions.UpdateSpectators(h.containerID, h.terminalID, h.allowSpectators, count)
+	h.sendLocked(HubEvent{Kind: HubEventSpectators, Spectators: count, AllowSpectators: h.allowSpectators}, func(c *HubClient) bool {
+		return c.Role == HubOwner
+	})
+}
+
+// hasUsers reports whether an owner or helper is attached
+func (h *PTYHub) hasUsers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.clients {
+		if c.Role != HubSpectator {
+			return true
+		}
+	}
+	return false
+}
+
+// detachHelpers removes the helper clients named username, or every helper
+// if username is empty, closing their Events
+func (h *PTYHub) detachHelpers(username string) {
+	h.mu.Lock()
+	detached := false
+	for id, c := range h.clients {
+		if c.Role == HubHelper && (username == "" || c.Username == username) {
+			delete(h.clients, id)
+			close(c.out)
+			detached = true
+		}
+	}
+	changed := detached && h.resizeLocked()
+	h.mu.Unlock()
+	if changed {
+		h.applySize()
+	}
+}
+
+// OnShutdown sets a hook run once when the shell exits or the owner's grace
+// period runs out. It is not run when a newer session replaces the hub.
+func (h *PTYHub) OnShutdown(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onShutdown = fn
+}
+
+// Close ends the shared exec session, disconnects every client and runs the shutdown hook
+func (h *PTYHub) Close() {
+	h.close(true)
+}
+
+func (h *PTYHub) close(shutdown bool) {
+	h.closeOnce.Do(func() {
+		h.mu.Lock()
+		close(h.done)
+		for id, c := range h.clients {
+			close(c.out)
+			delete(h.clients, id)
+		}
+		h.lingerGen++
+		if h.lingerTimer != nil {
+			h.lingerTimer.Stop()
+			h.lingerTimer = nil
+		}
+		hook := h.onShutdown
+		h.mu.Unlock()
+
+		h.stream.Close()
+		Hubs.remove(h)
+		log.Printf("📺 PTY hub closed for container %s terminal %s", h.containerID[:12], h.terminalID)
+		if shutdown && hook != nil {
+			hook()
+		}
+	})
+}
+
+// ResumeToken returns the secret an owner presents to reattach after a disconnect
+func (h *PTYHub) ResumeToken() string {
+	return h.resumeToken
+}
+
+// Linger keeps the shell running for grace after the owner disconnects and
+// closes the hub if no owner has come back by then. It does nothing while
+// another owner connection is still attached.
+func (h *PTYHub) Linger(grace time.Duration) {
+	h.mu.Lock()
+	for _, c := range h.clients {
+		if c.Role == HubOwner {
+			h.mu.Unlock()
+			return
+		}
+	}
+	if grace <= 0 {
+		h.mu.Unlock()
+		h.Close()
+		return
+	}
+	h.lingerGen++
+	gen := h.lingerGen
+	h.lingerTimer = time.AfterFunc(grace, func() {
+		h.mu.Lock()
+		expired := h.lingerGen == gen
+		h.mu.Unlock()
+		if expired {
+			log.Printf("⌛ Resume grace period expired for container %s", h.containerID[:12])
+			h.Close()
+		}
+	})
+	h.mu.Unlock()
+	log.Printf("⏳ Keeping container %s shell for %s awaiting resume", h.containerID[:12], grace)
+}
+
+// Resume reattaches an owner presenting the resume token. The new client first
+// receives the output after lastSeq, or a repaint of the screen if that output
+// is no longer buffered.
+func (h *PTYHub) Resume(token, username string, lastSeq uint64) (*HubClient, bool) {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.resumeToken)) != 1 {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+		return nil, false
+	default:
+	}
+	h.lingerGen++
+	if h.lingerTimer != nil {
+		h.lingerTimer.Stop()
+		h.lingerTimer = nil
+	}
+
+	c := h.attachLocked(username, HubOwner)
+	if missed, ok := h.replay.Since(lastSeq); ok {
+		if len(missed) > 0 {
+			c.out <- HubEvent{Kind: HubEventOutput, Data: missed, Seq: h.replay.End()}
+		}
+	} else {
+		c.out <- HubEvent{Kind: HubEventOutput, Data: []byte(h.screen.ANSI()), Seq: h.replay.End()}
+	}
+	h.spectatorsChangedLocked()
+	return c, true
+}
+
+// pump copies exec output to every client until the shell exits
+func (h *PTYHub) pump() {
+	defer h.Close()
+	go h.publishSnapshots()
+
+	buf := make([]byte, hubReadSize)
+	var carry []byte // Start of a character split by the previous read
+	for {
+		n, err := h.stream.Read(buf)
+		if n > 0 {
+			data := make([]byte, 0, len(carry)+n)
+			data = append(append(data, carry...), buf[:n]...)
+			// Hold back a trailing partial character so every chunk is valid UTF-8
+			cut := utf8Boundary(data)
+			carry = append(carry[:0], data[cut:]...)
+			h.publish(data[:cut])
+		}
+		if err != nil {
+			h.publish(carry)
+			if err != io.EOF {
+				log.Printf("Container read error: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// publish feeds a chunk of output to the screen, the replay buffer and every client
+func (h *PTYHub) publish(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	// Screen and fan-out change together so a new client's repaint
+	// never misses or repeats a chunk
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.screen.Write(data)
+	h.screenDirty = true
+	h.replay.Write(data)
+	h.sendLocked(HubEvent{Kind: HubEventOutput, Data: data, Seq: h.replay.End()}, nil)
+}
+
+// snapshotInterval is how often a changed screen is rendered into the session snapshot
+const snapshotInterval = 500 * time.Millisecond
+
+// publishSnapshots renders the screen model into Session.Snapshot/RawSnapshot
+// for the lobby until the hub closes
+func (h *PTYHub) publishSnapshots() {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			h.mu.Lock()
+			if !h.screenDirty {
+				h.mu.Unlock()
+				continue
+			}
+			h.screenDirty = false
+			raw, text := h.screen.ANSI(), h.screen.Text()
+			h.mu.Unlock()
+			Sessions.UpdateSnapshot(h.containerID, h.terminalID, raw, text)
+		}
+	}
+}
+
+// Screen returns a repaint sequence and the plain text of the current screen
+func (h *PTYHub) Screen() (ansi, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.screen.ANSI(), h.screen.Text()
+}
+
+// broadcast sends an event to every client
+func (h *PTYHub) broadcast(ev HubEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendLocked(ev, nil)
+}
+
+// sendLocked sends an event to the clients matching filter (all if nil),
+// dropping clients that cannot keep up. Caller holds h.mu.
+func (h *PTYHub) sendLocked(ev HubEvent, filter func(*HubClient) bool) {
+	dropped := false
+	for id, c := range h.clients {
+		if filter != nil && !filter(c) {
+			continue
+		}
+		select {
+		case c.out <- ev:
+		default:
+			log.Printf("⚠️ Dropping slow terminal client %s on %s", c.Username, h.containerID[:12])
+			close(c.out)
+			delete(h.clients, id)
+			dropped = dropped || c.Role == HubSpectator
+		}
+	}
+	if dropped {
+		h.spectatorsChangedLocked()
+	}
+}
+
+// resizeLocked recomputes the PTY size from the attached clients and resizes
+// the screen model. It reports whether the size changed, in which case the
+// caller runs applySize after releasing h.mu. Caller holds h.mu.
+func (h *PTYHub) resizeLocked() bool {
+	var cols, rows uint
+	for _, c := range h.clients {
+		if c.Role == HubSpectator || c.cols == 0 || c.rows == 0 {
+			continue
+		}
+		// A helper can't shrink the owner's terminal to nothing
+		if c.Role == HubHelper && (c.cols < minHelperCols || c.rows < minHelperRows) {
+			continue
+		}
+		if cols == 0 || c.cols < cols {
+			cols = c.cols
+		}
+		if rows == 0 || c.rows < rows {
+			rows = c.rows
+		}
+	}
+	if cols == 0 || (cols == h.cols && rows == h.rows) {
+		return false
+	}
+	h.cols, h.rows = cols, rows
+	h.screen.Resize(int(cols), int(rows))
+	h.screenDirty = true
+	return true
+}
+
+// applySize resizes the exec TTY to the hub's current size. Docker may be
+// slow, so it runs without h.mu; resizeMu keeps concurrent calls in order.
+func (h *PTYHub) applySize() {
+	h.resizeMu.Lock()
+	defer h.resizeMu.Unlock()
+	h.mu.Lock()
+	cols, rows := h.cols, h.rows
+	h.mu.Unlock()
+
+	Sessions.UpdateTerminalSize(h.containerID, h.terminalID, cols, rows)
+	ctx, cancel := context.WithTimeout(context.Background(), resizeTimeout)
+	defer cancel()
+	if err := h.runtime.ResizeExecTTY(ctx, h.execID, cols, rows); err != nil {
+		log.Printf("Resize error: %v", err)
+	}
+}
+
+// Next returns the client's next event, merging output events that are
+// already queued into one so a burst of output goes out as a single message.
+// It returns false once Events is closed. Next is for the client's single
+// reader and must not be mixed with receiving from Events directly.
+func (c *HubClient) Next() (HubEvent, bool) {
+	if ev := c.pending; ev != nil {
+		c.pending = nil
+		return *ev, true
+	}
+	ev, ok := <-c.out
+	if !ok || ev.Kind != HubEventOutput {
+		return ev, ok
+	}
+	for len(ev.Data) < maxCoalesce {
+		select {
+		case more, ok := <-c.out:
+			if !ok {
+				return ev, true
+			}
+			if more.Kind != HubEventOutput {
+				c.pending = &more
+				return ev, true
+			}
+			// Copy so a merged chunk never aliases another client's event
+			merged := make([]byte, 0, len(ev.Data)+len(more.Data))
+			ev.Data = append(append(merged, ev.Data...), more.Data...)
+			ev.Seq = more.Seq
+		default:
+			return ev, true
+		}
+	}
+	return ev, true
+}
+
+// Write sends input to the shared shell
+func (c *HubClient) Write(p []byte) (int, error) {
+	if c.Role != HubOwner && c.Role != HubHelper {
+		return 0, ErrHubReadOnly
+	}
+	select {
+	case <-c.hub.done:
+		return 0, ErrHubClosed
+	default:
+	}
+	c.hub.writeMu.Lock()
+	defer c.hub.writeMu.Unlock()
+	return c.hub.stream.Write(p)
+}
+
+// Resize records the client's terminal size, clamped to maxTerminalCols by
+// maxTerminalRows, and resizes the PTY if the smallest size among all
+// clients changed. A zero dimension is ignored.
+func (c *HubClient) Resize(cols, rows uint) {
+	if cols == 0 || rows == 0 {
+		return
+	}
+	if cols > maxTerminalCols {
+		cols = maxTerminalCols
+	}
+	if rows > maxTerminalRows {
+		rows = maxTerminalRows
+	}
+	h := c.hub
+	h.mu.Lock()
+	if _, ok := h.clients[c.id]; !ok || c.Role == HubSpectator {
+		h.mu.Unlock()
+		return
+	}
+	c.cols, c.rows = cols, rows
+	changed := h.resizeLocked()
+	h.mu.Unlock()
+	if changed {
+		h.applySize()
+	}
+}
+
+// Detach removes the client from the hub and closes its Events
+func (c *HubClient) Detach() {
+	h := c.hub
+	h.mu.Lock()
+	if _, ok := h.clients[c.id]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, c.id)
+	close(c.out)
+	if c.Role == HubSpectator {
+		h.spectatorsChangedLocked()
+		h.mu.Unlock()
+		return
+	}
+	// A smaller client leaving may let the PTY grow again
+	changed := h.resizeLocked()
+	h.mu.Unlock()
+	if changed {
+		h.applySize()
+	}
+}
@@ -0,0 +1,500 @@
+package service
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// readUntil collects a client's output until it contains want
+func readUntil(t *testing.T, c *HubClient, want string) string {
+	t.Helper()
+	var sb strings.Builder
+	timeout := time.After(5 * time.Second)
+	for !strings.Contains(sb.String(), want) {
+		select {
+		case ev, ok := <-c.Events:
+			if !ok {
+				t.Fatalf("%s: output closed before %q (got %q)", c.Username, want, sb.String())
+			}
+			sb.Write(ev.Data)
+		case <-timeout:
+			t.Fatalf("%s: timed out waiting for %q (got %q)", c.Username, want, sb.String())
+		}
+	}
+	return sb.String()
+}
+
+func newTestHub(t *testing.T) *PTYHub {
+	t.Helper()
+	rt := NewFakeRuntime("/bin/sh")
+	id, err := rt.CreateContainer(context.Background(), &ContainerConfig{UserID: 1, OSType: "alpine", Username: "owner"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	hub, err := Hubs.Open(context.Background(), rt, id, MainTerminal, "")
+	if err != nil {
+		t.Fatalf("open hub: %v", err)
+	}
+	t.Cleanup(hub.Close)
+	return hub
+}
+
+func TestPTYHub_SharedInputAndOutput(t *testing.T) {
+	hub := newTestHub(t)
+	owner := hub.Attach("owner", HubOwner)
+	helper := hub.Attach("helper", HubHelper)
+
+	// The helper types into the owner's shell and both see the result
+	if _, err := helper.Write([]byte("echo from-$((1+1))-helper\n")); err != nil {
+		t.Fatalf("helper write: %v", err)
+	}
+	readUntil(t, owner, "from-2-helper")
+	readUntil(t, helper, "from-2-helper")
+
+	if _, err := owner.Write([]byte("echo from-$((2+1))-owner\n")); err != nil {
+		t.Fatalf("owner write: %v", err)
+	}
+	readUntil(t, helper, "from-3-owner")
+
+	// Closing the hub disconnects everyone
+	hub.Close()
+	for _, c := range []*HubClient{owner, helper} {
+		for range c.Events {
+		}
+	}
+	if Hubs.Get(hub.containerID, MainTerminal) != nil {
+		t.Fatal("closed hub still registered")
+	}
+	if _, err := owner.Write([]byte("x")); err != ErrHubClosed {
+		t.Fatalf("expected ErrHubClosed, got %v", err)
+	}
+}
+
+func TestPTYHub_SmallestSizeWins(t *testing.T) {
+	hub := newTestHub(t)
+	owner := hub.Attach("owner", HubOwner)
+	helper := hub.Attach("helper", HubHelper)
+
+	owner.Resize(120, 40)
+	if cols, rows := hub.Size(); cols != 120 || rows != 40 {
+		t.Fatalf("expected 120x40, got %dx%d", cols, rows)
+	}
+
+	helper.Resize(80, 50)
+	if cols, rows := hub.Size(); cols != 80 || rows != 40 {
+		t.Fatalf("expected 80x40, got %dx%d", cols, rows)
+	}
+	owner.Write([]byte("stty size\n"))
+	readUntil(t, owner, "40 80")
+
+	// The PTY grows back once the smaller client leaves
+	helper.Detach()
+	if cols, rows := hub.Size(); cols != 120 || rows != 40 {
+		t.Fatalf("expected 120x40 after detach, got %dx%d", cols, rows)
+	}
+}
+
+// slowResizeRuntime blocks exec TTY resizes until release is closed
+type slowResizeRuntime struct {
+	*FakeRuntime
+	release chan struct{}
+}
+
+func (r *slowResizeRuntime) ResizeExecTTY(ctx context.Context, execID string, cols, rows uint) error {
+	<-r.release
+	return r.FakeRuntime.ResizeExecTTY(ctx, execID, cols, rows)
+}
+
+func TestPTYHub_ResizeOutsideLockAndHelperFloor(t *testing.T) {
+	rt := &slowResizeRuntime{FakeRuntime: NewFakeRuntime("/bin/sh"), release: make(chan struct{})}
+	id, _ := rt.CreateContainer(context.Background(), &ContainerConfig{UserID: 1, OSType: "alpine", Username: "owner"})
+	hub, err := Hubs.Open(context.Background(), rt, id, MainTerminal, "")
+	if err != nil {
+		t.Fatalf("open hub: %v", err)
+	}
+	defer hub.Close()
+	owner := hub.Attach("owner", HubOwner)
+	helper := hub.Attach("helper", HubHelper)
+
+	// A slow runtime resize doesn't hold up the rest of the hub
+	resized := make(chan struct{})
+	go func() {
+		owner.Resize(120, 40)
+		close(resized)
+	}()
+	eventually(t, "new size", func() bool { cols, _ := hub.Size(); return cols == 120 })
+	locked := make(chan struct{})
+	go func() {
+		hub.Screen()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub locked during the runtime resize")
+	}
+	close(rt.release)
+	<-resized
+
+	// A helper can't shrink the owner's PTY below the floor
+	helper.Resize(1, 1)
+	if cols, rows := hub.Size(); cols != 120 || rows != 40 {
+		t.Fatalf("helper shrank the PTY to %dx%d", cols, rows)
+	}
+}
+
+func TestPTYHub_ClampsOversizedResize(t *testing.T) {
+	hub := newTestHub(t)
+	owner := hub.Attach("owner", HubOwner)
+
+	// A huge size would make the screen model allocate gigabytes
+	owner.Resize(65535, 65535)
+	if cols, rows := hub.Size(); cols != maxTerminalCols || rows != maxTerminalRows {
+		t.Fatalf("expected %dx%d, got %dx%d", maxTerminalCols, maxTerminalRows, cols, rows)
+	}
+	owner.Write([]byte("stty size\n"))
+	readUntil(t, owner, "200 500")
+
+	owner.Resize(0, 30)
+	if cols, rows := hub.Size(); cols != maxTerminalCols || rows != maxTerminalRows {
+		t.Fatalf("zero width resized the PTY to %dx%d", cols, rows)
+	}
+}
+
+func TestPTYHub_Spectators(t *testing.T) {
+	hub := newTestHub(t)
+	owner := hub.Attach("owner", HubOwner)
+	owner.Resize(100, 30)
+
+	// The owner hears about the setting when attaching and on every change
+	nextSpectators := func() HubEvent {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-owner.Events:
+				if ev.Kind == HubEventSpectators {
+					return ev
+				}
+			case <-timeout:
+				t.Fatal("timed out waiting for spectator event")
+			}
+		}
+	}
+	if ev := nextSpectators(); !ev.AllowSpectators || ev.Spectators != 0 {
+		t.Fatalf("unexpected initial event %+v", ev)
+	}
+
+	spec, err := hub.AttachSpectator("viewer")
+	if err != nil {
+		t.Fatalf("attach spectator: %v", err)
+	}
+	if ev := nextSpectators(); ev.Spectators != 1 {
+		t.Fatalf("expected 1 spectator, got %+v", ev)
+	}
+
+	// Spectators see output but can neither type nor resize
+	owner.Write([]byte("echo watch-$((3*3))\n"))
+	readUntil(t, spec, "watch-9")
+	if _, err := spec.Write([]byte("echo nope\n")); err != ErrHubReadOnly {
+		t.Fatalf("expected ErrHubReadOnly, got %v", err)
+	}
+	spec.Resize(20, 5)
+	if cols, rows := hub.Size(); cols != 100 || rows != 30 {
+		t.Fatalf("spectator changed the PTY size to %dx%d", cols, rows)
+	}
+
+	// Disabling spectators kicks the current ones and refuses new ones
+	hub.SetAllowSpectators(false)
+	if ev := nextSpectators(); ev.AllowSpectators || ev.Spectators != 0 {
+		t.Fatalf("unexpected event after disabling %+v", ev)
+	}
+	for range spec.Events {
+	}
+	if _, err := hub.AttachSpectator("late"); err != ErrSpectatorsDisabled {
+		t.Fatalf("expected ErrSpectatorsDisabled, got %v", err)
+	}
+}
+
+func TestPTYHub_RevokedHelperStopsReceiving(t *testing.T) {
+	hub := newTestHub(t)
+	id := hub.containerID
+	Sessions.Register(id, &Session{Username: "owner", ContainerID: id})
+	defer Sessions.Unregister(id)
+	Sessions.SetPendingInvite(id, "helper")
+	Sessions.AddHelper(id, "helper")
+	owner := hub.Attach("owner", HubOwner)
+	helper := hub.Attach("helper", HubHelper)
+
+	owner.Write([]byte("echo before-$((1+1))\n"))
+	readUntil(t, helper, "before-2")
+
+	// Revoking detaches the helper; the owner's output no longer reaches them
+	if !Sessions.RemoveHelper(id, "helper") {
+		t.Fatal("helper not removed")
+	}
+	owner.Write([]byte("echo after-$((2+2))\n"))
+	readUntil(t, owner, "after-4")
+	for ev := range helper.Events {
+		if strings.Contains(string(ev.Data), "after-4") {
+			t.Fatal("revoked helper received output")
+		}
+	}
+	select {
+	case <-hub.Done():
+		t.Fatal("revoking a helper closed the hub")
+	default:
+	}
+}
+
+func TestPTYHub_ScreenSnapshot(t *testing.T) {
+	hub := newTestHub(t)
+	Sessions.OpenTerminal(hub.containerID, &Session{Username: "owner", ContainerID: hub.containerID}, &Terminal{ID: MainTerminal})
+	defer Sessions.Unregister(hub.containerID)
+
+	owner := hub.Attach("owner", HubOwner)
+	owner.Resize(40, 10)
+	owner.Write([]byte("printf '\\033[2J\\033[Hscreen-%s\\n' ok\n"))
+	readUntil(t, owner, "screen-ok")
+
+	if _, text := hub.Screen(); !strings.HasPrefix(text, "screen-ok") {
+		t.Fatalf("screen text = %q", text)
+	}
+
+	// A late joiner's first event repaints the current screen
+	helper := hub.Attach("helper", HubHelper)
+	first := <-helper.Events
+	if !strings.Contains(string(first.Data), "screen-ok") {
+		t.Fatalf("first helper event = %q", first.Data)
+	}
+
+	// The lobby snapshot is rendered from the same model
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := Sessions.GetSession(hub.containerID)
+		Sessions.mu.RLock()
+		snapshot := s.Snapshot
+		Sessions.mu.RUnlock()
+		if strings.HasPrefix(snapshot, "screen-ok") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session snapshot not updated: %q", snapshot)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPTYHub_ResumeReplaysMissedOutput(t *testing.T) {
+	hub := newTestHub(t)
+	shutdown := make(chan struct{})
+	hub.OnShutdown(func() { close(shutdown) })
+
+	owner := hub.Attach("owner", HubOwner)
+	owner.Write([]byte("echo before-$((1+1))\n"))
+	readUntil(t, owner, "before-2")
+	lastSeq := hub.replay.End()
+
+	// The owner drops; the shell keeps running and its output is buffered
+	owner.Detach()
+	hub.Linger(time.Minute)
+	watcher := hub.Attach("helper", HubHelper)
+	watcher.Write([]byte("echo missed-$((2+2))\n"))
+	readUntil(t, watcher, "missed-4")
+
+	if _, ok := hub.Resume("wrong-token", "owner", lastSeq); ok {
+		t.Fatal("resumed with a wrong token")
+	}
+	resumed, ok := hub.Resume(hub.ResumeToken(), "owner", lastSeq)
+	if !ok {
+		t.Fatal("resume failed")
+	}
+	first := <-resumed.Events
+	if !strings.Contains(string(first.Data), "missed-4") || strings.Contains(string(first.Data), "before-2") {
+		t.Fatalf("replay should hold only the missed output, got %q", first.Data)
+	}
+	if first.Seq != hub.replay.End() {
+		t.Fatalf("replay seq = %d, want %d", first.Seq, hub.replay.End())
+	}
+
+	// Resuming cancelled the pending shutdown
+	resumed.Write([]byte("echo still-$((3+3))\n"))
+	readUntil(t, resumed, "still-6")
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown hook ran while the owner was attached")
+	default:
+	}
+}
+
+func TestPTYHub_LingerExpires(t *testing.T) {
+	hub := newTestHub(t)
+	shutdown := make(chan struct{})
+	hub.OnShutdown(func() { close(shutdown) })
+
+	owner := hub.Attach("owner", HubOwner)
+	owner.Detach()
+	hub.Linger(50 * time.Millisecond)
+
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub did not shut down after the grace period")
+	}
+	if _, ok := hub.Resume(hub.ResumeToken(), "owner", 0); ok {
+		t.Fatal("resumed a closed hub")
+	}
+}
+
+func TestPTYHub_OpenReplacesLingeringHub(t *testing.T) {
+	rt := NewFakeRuntime("/bin/sh")
+	id, err := rt.CreateContainer(context.Background(), &ContainerConfig{UserID: 1, OSType: "alpine", Username: "owner"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	old, err := Hubs.Open(context.Background(), rt, id, MainTerminal, "")
+	if err != nil {
+		t.Fatalf("open hub: %v", err)
+	}
+	old.OnShutdown(func() { t.Error("replacing a hub must not run its shutdown hook") })
+	old.Linger(time.Minute)
+
+	hub, err := Hubs.Open(context.Background(), rt, id, MainTerminal, "")
+	if err != nil {
+		t.Fatalf("reopen hub: %v", err)
+	}
+	defer hub.Close()
+	if hub == old || Hubs.Get(id, MainTerminal) != hub || hub.ResumeToken() == old.ResumeToken() {
+		t.Fatal("Open should start a fresh hub with a new token")
+	}
+	<-old.Done()
+}
+
+func TestPTYHub_TerminalsInOneContainer(t *testing.T) {
+	rt := NewFakeRuntime("/bin/sh")
+	id, err := rt.CreateContainer(context.Background(), &ContainerConfig{UserID: 1, OSType: "alpine", Username: "owner"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	session := &Session{Username: "owner", ContainerID: id, AllowSpectators: true}
+	if !Sessions.OpenTerminal(id, session, &Terminal{ID: MainTerminal, Name: "main"}) {
+		t.Fatal("first terminal should register the session")
+	}
+	if Sessions.OpenTerminal(id, &Session{}, &Terminal{ID: "logs", Name: "logs"}) {
+		t.Fatal("second terminal should join the existing session")
+	}
+	defer Sessions.Unregister(id)
+
+	var clients []*HubClient
+	for _, tid := range []string{MainTerminal, "logs"} {
+		hub, err := Hubs.Open(context.Background(), rt, id, tid, "")
+		if err != nil {
+			t.Fatalf("open %s: %v", tid, err)
+		}
+		defer hub.Close()
+		clients = append(clients, hub.Attach("owner", HubOwner))
+	}
+	main, logs := clients[0], clients[1]
+
+	// Each terminal is its own shell with its own size
+	main.Resize(100, 30)
+	logs.Resize(60, 20)
+	main.Write([]byte("ONLY=main; echo main-$((1+1))\n"))
+	readUntil(t, main, "main-2")
+	logs.Write([]byte("echo logs-[$ONLY]\n"))
+	readUntil(t, logs, "logs-[]")
+
+	terms := Sessions.GetTerminals(id)
+	if len(terms) != 2 || terms[0].Cols != 100 || terms[1].Cols != 60 || terms[1].Rows != 20 {
+		t.Fatalf("unexpected terminals %+v", terms)
+	}
+	if len(Hubs.ForContainer(id)) != 2 {
+		t.Fatal("expected two hubs in the container")
+	}
+
+	// The session outlives all but its last terminal
+	if Sessions.CloseTerminal(id, "logs") {
+		t.Fatal("closing one of two terminals must keep the session")
+	}
+	if Sessions.HasTerminal(id, "logs") || !Sessions.HasTerminal(id, MainTerminal) {
+		t.Fatal("wrong terminal removed")
+	}
+	if !Sessions.CloseTerminal(id, MainTerminal) || Sessions.GetSession(id) != nil {
+		t.Fatal("closing the last terminal should unregister the session")
+	}
+}
+
+// pipeStream is an ExecStream whose output the test writes directly
+type pipeStream struct {
+	*io.PipeReader
+	io.Writer
+}
+
+func (p pipeStream) Close() error { return p.PipeReader.Close() }
+
+func TestPTYHub_OutputKeepsCharactersWhole(t *testing.T) {
+	r, w := io.Pipe()
+	hub := &PTYHub{
+		containerID: "pipe-container-0000",
+		terminalID:  MainTerminal,
+		stream:      pipeStream{PipeReader: r, Writer: io.Discard},
+		clients:     make(map[uint64]*HubClient),
+		done:        make(chan struct{}),
+		screen:      NewScreen(80, 24),
+		replay:      newOutputRing(hubReplaySize),
+	}
+	client := hub.Attach("owner", HubOwner)
+	go hub.pump()
+
+	// A CJK character split across two reads arrives in one piece
+	zh := []byte("中文")
+	w.Write(zh[:4])
+	w.Write(zh[4:])
+	w.Close()
+
+	var got []byte
+	for {
+		ev, ok := client.Next()
+		if !ok {
+			break
+		}
+		if ev.Kind != HubEventOutput {
+			continue
+		}
+		if !utf8.Valid(ev.Data) {
+			t.Fatalf("event split a character: % x", ev.Data)
+		}
+		got = append(got, ev.Data...)
+	}
+	if string(got) != "中文" {
+		t.Fatalf("output = %q", got)
+	}
+}
+
+func TestHubClient_NextCoalescesOutput(t *testing.T) {
+	out := make(chan HubEvent, 8)
+	c := &HubClient{Events: out, out: out}
+	out <- HubEvent{Kind: HubEventOutput, Data: []byte("a"), Seq: 1}
+	out <- HubEvent{Kind: HubEventOutput, Data: []byte("b"), Seq: 2}
+	out <- HubEvent{Kind: HubEventSpectators, Spectators: 3}
+	out <- HubEvent{Kind: HubEventOutput, Data: []byte("c"), Seq: 3}
+	close(out)
+
+	ev, _ := c.Next()
+	if string(ev.Data) != "ab" || ev.Seq != 2 {
+		t.Fatalf("first = %+v", ev)
+	}
+	if ev, _ = c.Next(); ev.Kind != HubEventSpectators || ev.Spectators != 3 {
+		t.Fatalf("second = %+v", ev)
+	}
+	if ev, _ = c.Next(); string(ev.Data) != "c" {
+		t.Fatalf("third = %+v", ev)
+	}
+	if _, ok := c.Next(); ok {
+		t.Fatal("expected end of events")
+	}
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultQuotaJSON is the quota policy used when QUOTA_POLICY isn't set
+//
+//go:embed quotas.json
+var defaultQuotaJSON []byte
+
+// QuotaTier is what a user of a trust level and above gets
+type QuotaTier struct {
+	TrustLevel     int      `json:"trust_level"`
+	Name           string   `json:"name"`
+	MemoryMB       int64    `json:"memory_mb"`
+	CPUs           float64  `json:"cpus"`
+	PidsLimit      int64    `json:"pids_limit"`
+	DiskMB         int64    `json:"disk_mb"`          // Size of the home volume and of the writable layer; 0 means unlimited
+	Checkpoints    int      `json:"checkpoints"`      // Checkpoints kept at once
+	SessionMinutes int      `json:"session_minutes"`  // Container lifetime per launch; 0 means unlimited
+	IdleMinutes    int      `json:"idle_minutes"`     // Stop after this long without keystrokes; 0 never stops
+	RetentionDays  int      `json:"retention_days"`   // Remove after this long unused; 0 keeps forever
+	Images         []string `json:"images,omitempty"` // Catalog images allowed; empty allows all
+}
+
+// MemoryBytes is the container memory limit in bytes
+func (t *QuotaTier) MemoryBytes() int64 {
+	return t.MemoryMB << 20
+}
+
+// NanoCPUs is the CPU limit in the unit Docker expects
+func (t *QuotaTier) NanoCPUs() int64 {
+	return int64(t.CPUs * 1e9)
+}
+
+// DiskBytes is the disk limit in bytes, 0 if unlimited
+func (t *QuotaTier) DiskBytes() int64 {
+	return t.DiskMB << 20
+}
+
+// SessionDuration is how long a launched container may run, 0 if unlimited
+func (t *QuotaTier) SessionDuration() time.Duration {
+	return time.Duration(t.SessionMinutes) * time.Minute
+}
+
+// IdleTimeout is how long a container may go without keystrokes, 0 if unlimited
+func (t *QuotaTier) IdleTimeout() time.Duration {
+	return time.Duration(t.IdleMinutes) * time.Minute
+}
+
+// Retention is how long an unused container is kept, 0 if forever
+func (t *QuotaTier) Retention() time.Duration {
+	return time.Duration(t.RetentionDays) * 24 * time.Hour
+}
+
+// AllowsImage reports whether the tier may launch a catalog image. The
+// image's own min_trust_level applies on top of this.
+func (t *QuotaTier) AllowsImage(id string) bool {
+	if len(t.Images) == 0 {
+		return true
+	}
+	for _, img := range t.Images {
+		if img == id {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaPolicy maps LinuxDo trust levels to resource limits
+type QuotaPolicy struct {
+	Tiers []QuotaTier `json:"tiers"` // Sorted by trust level
+}
+
+// LoadQuotaPolicy reads a policy file, or the built-in policy when path is
+// empty. Image lists are checked against the catalog.
+func LoadQuotaPolicy(path string, catalog *Catalog) (*QuotaPolicy, error) {
+	data := defaultQuotaJSON
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read quota policy: %w", err)
+		}
+	}
+	return ParseQuotaPolicy(data, catalog)
+}
+
+// ParseQuotaPolicy parses and validates a quota policy
+func ParseQuotaPolicy(data []byte, catalog *Catalog) (*QuotaPolicy, error) {
+	var p QuotaPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse quota policy: %w", err)
+	}
+	sort.SliceStable(p.Tiers, func(i, j int) bool { return p.Tiers[i].TrustLevel < p.Tiers[j].TrustLevel })
+	if len(p.Tiers) == 0 || p.Tiers[0].TrustLevel != 0 {
+		return nil, fmt.Errorf("quota policy needs a tier for trust level 0")
+	}
+
+	for i := range p.Tiers {
+		t := &p.Tiers[i]
+		switch {
+		case t.TrustLevel < 0 || t.TrustLevel > 4:
+			return nil, fmt.Errorf("tier %d: trust_level must be 0-4", i)
+		case i > 0 && p.Tiers[i-1].TrustLevel == t.TrustLevel:
+			return nil, fmt.Errorf("tier %d: duplicate trust_level %d", i, t.TrustLevel)
+		case t.MemoryMB < 16:
+			return nil, fmt.Errorf("trust level %d: memory_mb must be at least 16", t.TrustLevel)
+		case t.CPUs <= 0:
+			return nil, fmt.Errorf("trust level %d: cpus must be positive", t.TrustLevel)
+		case t.PidsLimit <= 0:
+			return nil, fmt.Errorf("trust level %d: pids_limit must be positive", t.TrustLevel)
+		case t.DiskMB < 0 || t.Checkpoints < 0 || t.SessionMinutes < 0:
+			return nil, fmt.Errorf("trust level %d: disk_mb, checkpoints and session_minutes can't be negative", t.TrustLevel)
+		case t.IdleMinutes < 0 || t.RetentionDays < 0:
+			return nil, fmt.Errorf("trust level %d: idle_minutes and retention_days can't be negative", t.TrustLevel)
+		}
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("TL%d", t.TrustLevel)
+		}
+		for _, id := range t.Images {
+			if _, ok := catalog.Get(id); !ok {
+				return nil, fmt.Errorf("trust level %d: unknown image %q", t.TrustLevel, id)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// For returns the tier of a trust level: the highest tier at or below it
+func (p *QuotaPolicy) For(trustLevel int) *QuotaTier {
+	tier := &p.Tiers[0]
+	for i := range p.Tiers {
+		if p.Tiers[i].TrustLevel <= trustLevel {
+			tier = &p.Tiers[i]
+		}
+	}
+	return tier
+}
+
+// CanLaunch reports whether a user of the trust level may launch an image
+func (p *QuotaPolicy) CanLaunch(trustLevel int, spec *ImageSpec) bool {
+	return spec.AllowedFor(trustLevel) && p.For(trustLevel).AllowsImage(spec.ID)
+}
@@ -0,0 +1,70 @@
+package service
+
+import "testing"
+
+func TestQuotaPolicy_Default(t *testing.T) {
+	catalog, err := LoadCatalog("")
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	p, err := LoadQuotaPolicy("", catalog)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if p.For(-1).Checkpoints != 1 || p.For(0).Checkpoints != 1 || p.For(2).Checkpoints != 3 || p.For(9).Checkpoints != 10 {
+		t.Fatal("unexpected checkpoint limits")
+	}
+	tier := p.For(2)
+	if tier.MemoryBytes() != 512<<20 || tier.NanoCPUs() != 1e9 || tier.DiskBytes() != 1024<<20 || tier.SessionDuration().Hours() != 4 {
+		t.Fatalf("tier 2 = %+v", tier)
+	}
+	if p.For(4).SessionDuration() != 0 {
+		t.Fatal("top tier should have unlimited sessions")
+	}
+}
+
+func TestQuotaPolicy_Parse(t *testing.T) {
+	catalog, _ := LoadCatalog("")
+	p, err := ParseQuotaPolicy([]byte(`{"tiers": [
+		{"trust_level": 3, "memory_mb": 1024, "cpus": 2, "pids_limit": 500},
+		{"trust_level": 0, "memory_mb": 128, "cpus": 0.25, "pids_limit": 64, "images": ["alpine"]}
+	]}`), catalog)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	alpine, _ := catalog.Get("alpine")
+	arch, _ := catalog.Get("arch")
+	if p.For(2).TrustLevel != 0 || p.For(2).Name != "TL0" || p.For(3).MemoryMB != 1024 {
+		t.Fatalf("tiers = %+v", p.Tiers)
+	}
+	if !p.CanLaunch(1, alpine) || p.CanLaunch(1, arch) || !p.CanLaunch(3, arch) {
+		t.Fatal("image restrictions not applied")
+	}
+
+	bad := map[string]string{
+		"no tier 0":     `{"tiers": [{"trust_level": 1, "memory_mb": 128, "cpus": 1, "pids_limit": 64}]}`,
+		"duplicate":     `{"tiers": [{"trust_level": 0, "memory_mb": 128, "cpus": 1, "pids_limit": 64}, {"trust_level": 0, "memory_mb": 128, "cpus": 1, "pids_limit": 64}]}`,
+		"tiny memory":   `{"tiers": [{"trust_level": 0, "memory_mb": 1, "cpus": 1, "pids_limit": 64}]}`,
+		"no pids limit": `{"tiers": [{"trust_level": 0, "memory_mb": 128, "cpus": 1}]}`,
+		"unknown image": `{"tiers": [{"trust_level": 0, "memory_mb": 128, "cpus": 1, "pids_limit": 64, "images": ["gentoo"]}]}`,
+		"negative disk": `{"tiers": [{"trust_level": 0, "memory_mb": 128, "cpus": 1, "pids_limit": 64, "disk_mb": -1}]}`,
+		"trust level 5": `{"tiers": [{"trust_level": 0, "memory_mb": 128, "cpus": 1, "pids_limit": 64}, {"trust_level": 5, "memory_mb": 128, "cpus": 1, "pids_limit": 64}]}`,
+		"not json":      `tiers`,
+	}
+	for name, data := range bad {
+		if _, err := ParseQuotaPolicy([]byte(data), catalog); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHostConfig_DiskLimit(t *testing.T) {
+	tier := &QuotaTier{MemoryMB: 256, CPUs: 0.5, PidsLimit: 64, DiskMB: 512}
+	if hc := hostConfig(tier, nil, nil, nil); hc.StorageOpt["size"] != "536870912" {
+		t.Fatalf("storage opts = %v", hc.StorageOpt)
+	}
+	tier.DiskMB = 0
+	if hc := hostConfig(tier, nil, nil, nil); hc.StorageOpt != nil {
+		t.Fatalf("unlimited tier has storage opts %v", hc.StorageOpt)
+	}
+}
@@ -0,0 +1,433 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRecordingNotFound is returned for recordings that don't exist or belong to someone else
+var ErrRecordingNotFound = errors.New("recording not found")
+
+// ErrRecordingQuota is returned when a user already keeps as many recordings as allowed
+var ErrRecordingQuota = errors.New("recording limit reached, delete old recordings first")
+
+// maxRecordingBytes caps a single recording; events past it are dropped
+const maxRecordingBytes = 64 << 20
+
+// Per-user limits checked when a recording starts. A recording also stops
+// growing once the user's total would pass maxUserRecordingBytes.
+const (
+	maxUserRecordings     = 50
+	maxUserRecordingBytes = 512 << 20
+)
+
+// CastHeader is the first line of an asciicast v2 file
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint              `json:"width"`
+	Height    uint              `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// CastEvent is one asciicast v2 event line: [time, code, data]
+type CastEvent struct {
+	Time float64 // Seconds since the recording started
+	Code string  // "o" output, "i" input, "r" resize ("COLSxROWS")
+	Data string
+}
+
+// MarshalJSON encodes the event as the [time, code, data] array asciicast uses
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time, e.Code, e.Data})
+}
+
+// UnmarshalJSON decodes a [time, code, data] array
+func (e *CastEvent) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("asciicast event has %d fields", len(raw))
+	}
+	if err := json.Unmarshal(raw[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw[1], &e.Code); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw[2], &e.Data)
+}
+
+// Recording describes a stored asciicast file
+type Recording struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Width     uint      `json:"width"`
+	Height    uint      `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+	Duration  float64   `json:"duration"` // Seconds, from the last event
+	Size      int64     `json:"size"`
+}
+
+// RecordingStore keeps asciicast v2 recordings on disk, one directory per user
+type RecordingStore struct {
+	dir string
+}
+
+// NewRecordingStore stores recordings under dir
+func NewRecordingStore(dir string) *RecordingStore {
+	return &RecordingStore{dir: dir}
+}
+
+// path returns the file of a recording, rejecting IDs that could escape the user's directory
+func (s *RecordingStore) path(userID int64, id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", ErrRecordingNotFound
+	}
+	return filepath.Join(s.dir, strconv.FormatInt(userID, 10), id+".cast"), nil
+}
+
+// Create starts a new recording for the user. Input is only recorded when
+// recordInput is set, since it may contain passwords typed at prompts.
+func (s *RecordingStore) Create(userID int64, header CastHeader, recordInput bool) (*Recorder, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	id = id[:12]
+	p, err := s.path(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	count, used, err := s.usage(userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxUserRecordings || used >= maxUserRecordingBytes {
+		return nil, ErrRecordingQuota
+	}
+	limit := int64(maxRecordingBytes)
+	if left := maxUserRecordingBytes - used; left < limit {
+		limit = left
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	header.Version = 2
+	header.Timestamp = start.Unix()
+	line, _ := json.Marshal(header)
+	w := bufio.NewWriter(f)
+	w.Write(line)
+	w.WriteByte('\n')
+	// List and Get read the header of recordings still in progress
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(p)
+		return nil, err
+	}
+
+	log.Printf("⏺️ Recording %s started for user %d", id, userID)
+	return &Recorder{
+		ID:      id,
+		f:       f,
+		w:       w,
+		start:   start,
+		input:   recordInput,
+		limit:   limit,
+		written: int64(len(line) + 1),
+	}, nil
+}
+
+// usage counts the user's recordings and their total size
+func (s *RecordingStore) usage(userID int64) (count int, size int64, err error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, strconv.FormatInt(userID, 10)))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".cast") {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			count++
+			size += info.Size()
+		}
+	}
+	return count, size, nil
+}
+
+// List returns the user's recordings, newest first
+func (s *RecordingStore) List(userID int64) ([]Recording, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, strconv.FormatInt(userID, 10)))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Recording{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := []Recording{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".cast")
+		if !ok || e.IsDir() {
+			continue
+		}
+		rec, err := s.Get(userID, id)
+		if err != nil {
+			log.Printf("⚠️ Skipping unreadable recording %s: %v", e.Name(), err)
+			continue
+		}
+		result = append(result, *rec)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+// Get reads a recording's metadata from its header and last event
+func (s *RecordingStore) Get(userID int64, id string) (*Recording, error) {
+	f, err := s.Open(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var header CastHeader
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("bad asciicast header: %w", err)
+	}
+
+	return &Recording{
+		ID:        id,
+		Title:     header.Title,
+		Width:     header.Width,
+		Height:    header.Height,
+		CreatedAt: time.Unix(header.Timestamp, 0),
+		Duration:  lastEventTime(f, info.Size()),
+		Size:      info.Size(),
+	}, nil
+}
+
+// lastEventTime reads the time of the last complete event from the end of the file
+func lastEventTime(f *os.File, size int64) float64 {
+	const tail = 64 << 10
+	offset := max(size-tail, 0)
+	buf := make([]byte, size-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return 0
+	}
+	lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var ev CastEvent
+		if json.Unmarshal(lines[i], &ev) == nil {
+			return ev.Time
+		}
+	}
+	return 0
+}
+
+// Open opens a recording for reading
+func (s *RecordingStore) Open(userID int64, id string) (*os.File, error) {
+	p, err := s.path(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrRecordingNotFound
+	}
+	return f, err
+}
+
+// Delete removes a recording
+func (s *RecordingStore) Delete(userID int64, id string) error {
+	p, err := s.path(userID, id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); errors.Is(err, os.ErrNotExist) {
+		return ErrRecordingNotFound
+	} else if err != nil {
+		return err
+	}
+	log.Printf("🗑️ Recording %s deleted for user %d", id, userID)
+	return nil
+}
+
+// DeleteAll removes every recording of the user
+func (s *RecordingStore) DeleteAll(userID int64) error {
+	return os.RemoveAll(filepath.Join(s.dir, strconv.FormatInt(userID, 10)))
+}
+
+// Recorder appends timestamped terminal events to an asciicast file.
+// It is safe for concurrent use by the output and input goroutines.
+type Recorder struct {
+	ID string
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	start   time.Time
+	input   bool
+	limit   int64 // Size the file may grow to
+	written int64
+	full    bool
+	pending [2][]byte // Incomplete UTF-8 sequence carried over, per stream
+}
+
+// Output records terminal output
+func (r *Recorder) Output(p []byte) {
+	r.write("o", 0, p)
+}
+
+// Input records keystrokes if the recording was started with input enabled
+func (r *Recorder) Input(p []byte) {
+	if r.input {
+		r.write("i", 1, p)
+	}
+}
+
+// Resize records a terminal size change
+func (r *Recorder) Resize(cols, rows uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appendLocked(CastEvent{Code: "r", Data: fmt.Sprintf("%dx%d", cols, rows)})
+}
+
+// write records p, holding back a trailing partial UTF-8 sequence so a
+// multi-byte character split across chunks isn't mangled
+func (r *Recorder) write(code string, stream int, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.pending[stream], p...)
+	cut := utf8Boundary(data)
+	r.pending[stream] = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.appendLocked(CastEvent{Code: code, Data: string(data[:cut])})
+	}
+}
+
+// appendLocked writes one event line. Caller holds r.mu.
+func (r *Recorder) appendLocked(ev CastEvent) {
+	if r.f == nil || r.full {
+		return
+	}
+	ev.Time = float64(time.Since(r.start).Microseconds()) / 1e6
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if r.written+int64(len(line)+1) > r.limit {
+		r.full = true
+		log.Printf("⚠️ Recording %s reached its size limit", r.ID)
+		return
+	}
+	r.w.Write(line)
+	r.w.WriteByte('\n')
+	r.written += int64(len(line) + 1)
+}
+
+// Close flushes and closes the recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f = nil
+	log.Printf("⏹️ Recording %s saved (%d bytes)", r.ID, r.written)
+	return err
+}
+
+// PlayCast streams an asciicast v2 recording to emit in real time scaled by
+// speed. Pauses longer than maxIdle (before scaling) are shortened to maxIdle
+// when maxIdle > 0. The header is passed to onHeader before any event.
+func PlayCast(ctx context.Context, r io.Reader, speed float64, maxIdle time.Duration, onHeader func(CastHeader) error, emit func(CastEvent) error) error {
+	if speed <= 0 {
+		speed = 1
+	}
+	reader := bufio.NewReader(r)
+	line, err := reader.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return err
+	}
+	var header CastHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return fmt.Errorf("bad asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	if err := onHeader(header); err != nil {
+		return err
+	}
+
+	var last float64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var ev CastEvent
+			if jerr := json.Unmarshal(line, &ev); jerr != nil {
+				return fmt.Errorf("bad asciicast event: %w", jerr)
+			}
+			delay := time.Duration((ev.Time - last) * float64(time.Second))
+			if maxIdle > 0 && delay > maxIdle {
+				delay = maxIdle
+			}
+			last = ev.Time
+			if delay > 0 {
+				timer := time.NewTimer(time.Duration(float64(delay) / speed))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+			if err := emit(ev); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingStore_RecordListDelete(t *testing.T) {
+	store := NewRecordingStore(t.TempDir())
+	rec, err := store.Create(7, CastHeader{Width: 80, Height: 24, Title: "lesson"}, false)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// A character split across chunks is written whole
+	data := []byte("héllo\r\n")
+	rec.Output(data[:2])
+	rec.Output(data[2:])
+	rec.Input([]byte("secret\r"))
+	rec.Resize(100, 30)
+	if err := rec.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	f, err := store.Open(7, rec.ID)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 4 || !strings.Contains(lines[0], `"version":2`) {
+		t.Fatalf("unexpected file %q", lines)
+	}
+	if !strings.Contains(lines[1], `"o","h"`) || !strings.Contains(lines[2], `"o","éllo\r\n"`) {
+		t.Fatalf("output events %q", lines[1:3])
+	}
+	if !strings.Contains(lines[3], `"r","100x30"`) {
+		t.Fatalf("input must not be recorded unless enabled: %q", lines[3])
+	}
+
+	list, err := store.List(7)
+	if err != nil || len(list) != 1 || list[0].ID != rec.ID || list[0].Title != "lesson" || list[0].Width != 80 {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	if other, _ := store.List(8); len(other) != 0 {
+		t.Fatal("recordings leaked to another user")
+	}
+	if _, err := store.Open(8, rec.ID); err != ErrRecordingNotFound {
+		t.Fatalf("other user open: %v", err)
+	}
+	if _, err := store.Open(7, "../7/"+rec.ID); err != ErrRecordingNotFound {
+		t.Fatalf("path traversal: %v", err)
+	}
+
+	if err := store.Delete(7, rec.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := store.Delete(7, rec.ID); err != ErrRecordingNotFound {
+		t.Fatalf("second delete: %v", err)
+	}
+}
+
+func TestRecordingStore_InProgressAndQuota(t *testing.T) {
+	store := NewRecordingStore(t.TempDir())
+
+	// A recording in progress is listed before anything else is written
+	rec, err := store.Create(7, CastHeader{Width: 80, Height: 24, Title: "live"}, false)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if list, err := store.List(7); err != nil || len(list) != 1 || list[0].Title != "live" {
+		t.Fatalf("list while recording = %+v, %v", list, err)
+	}
+	rec.Close()
+
+	// Past the per-user count, new recordings are refused until some are deleted
+	for i := 1; i < maxUserRecordings; i++ {
+		r, err := store.Create(7, CastHeader{Width: 80, Height: 24}, false)
+		if err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+		r.Close()
+	}
+	if _, err := store.Create(7, CastHeader{Width: 80, Height: 24}, false); err != ErrRecordingQuota {
+		t.Fatalf("expected ErrRecordingQuota, got %v", err)
+	}
+	if err := store.Delete(7, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.Create(7, CastHeader{Width: 80, Height: 24}, false)
+	if err != nil {
+		t.Fatalf("create after delete: %v", err)
+	}
+	r.Close()
+}
+
+func TestPlayCast_SpeedAndIdleLimit(t *testing.T) {
+	cast := `{"version":2,"width":40,"height":10}
+[0.1,"o","a"]
+[0.3,"i","x"]
+[10.3,"o","b"]
+`
+	var events []CastEvent
+	var header CastHeader
+	start := time.Now()
+	err := PlayCast(context.Background(), strings.NewReader(cast), 2, 200*time.Millisecond,
+		func(h CastHeader) error { header = h; return nil },
+		func(ev CastEvent) error { events = append(events, ev); return nil })
+	if err != nil {
+		t.Fatalf("play: %v", err)
+	}
+	// 0.1s + 0.2s + 0.2s (idle-limited), halved
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("playback took %s", elapsed)
+	}
+	if header.Width != 40 || len(events) != 3 || events[2].Data != "b" || events[1].Code != "i" {
+		t.Fatalf("header %+v events %+v", header, events)
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+// outputRing keeps the most recent terminal output addressed by sequence
+// number: the byte offset since the exec session started
+type outputRing struct {
+	buf []byte
+	end uint64 // Sequence number after the last byte written
+}
+
+func newOutputRing(size int) *outputRing {
+	return &outputRing{buf: make([]byte, size)}
+}
+
+// Write appends output, overwriting the oldest bytes once full
+func (r *outputRing) Write(p []byte) {
+	size := uint64(len(r.buf))
+	if uint64(len(p)) > size {
+		r.end += uint64(len(p)) - size
+		p = p[len(p)-int(size):]
+	}
+	for len(p) > 0 {
+		off := r.end % size
+		n := copy(r.buf[off:], p)
+		p = p[n:]
+		r.end += uint64(n)
+	}
+}
+
+// Start is the oldest sequence number still retained
+func (r *outputRing) Start() uint64 {
+	if size := uint64(len(r.buf)); r.end > size {
+		return r.end - size
+	}
+	return 0
+}
+
+// End is the sequence number after the last byte written
+func (r *outputRing) End() uint64 {
+	return r.end
+}
+
+// Since returns the output after seq, or false if it is no longer retained
+func (r *outputRing) Since(seq uint64) ([]byte, bool) {
+	if seq < r.Start() || seq > r.end {
+		return nil, false
+	}
+	size := uint64(len(r.buf))
+	out := make([]byte, 0, r.end-seq)
+	for seq < r.end {
+		off := seq % size
+		chunk := r.buf[off:]
+		if rem := r.end - seq; uint64(len(chunk)) > rem {
+			chunk = chunk[:rem]
+		}
+		out = append(out, chunk...)
+		seq += uint64(len(chunk))
+	}
+	return out, true
+}
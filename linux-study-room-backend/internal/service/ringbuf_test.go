@@ -0,0 +1,35 @@
+package service
+
+import "testing"
+
+func TestOutputRing(t *testing.T) {
+	r := newOutputRing(8)
+	r.Write([]byte("abc"))
+	if got, ok := r.Since(1); !ok || string(got) != "bc" {
+		t.Fatalf("since 1 = %q %v", got, ok)
+	}
+
+	// Wrap around: only the last 8 bytes are kept
+	r.Write([]byte("defghij"))
+	if r.Start() != 2 || r.End() != 10 {
+		t.Fatalf("start/end = %d/%d", r.Start(), r.End())
+	}
+	if got, ok := r.Since(4); !ok || string(got) != "efghij" {
+		t.Fatalf("since 4 = %q %v", got, ok)
+	}
+	if _, ok := r.Since(1); ok {
+		t.Fatal("seq 1 should have been overwritten")
+	}
+	if got, ok := r.Since(10); !ok || len(got) != 0 {
+		t.Fatalf("since end = %q %v", got, ok)
+	}
+	if _, ok := r.Since(11); ok {
+		t.Fatal("seq past the end must be rejected")
+	}
+
+	// A write larger than the ring keeps its tail
+	r.Write([]byte("0123456789"))
+	if got, ok := r.Since(r.Start()); !ok || string(got) != "23456789" || r.End() != 20 {
+		t.Fatalf("after big write = %q end=%d", got, r.End())
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"io"
+)
+
+// ContainerRuntime is everything the handlers need from a container backend.
+// DockerService is the production implementation; FakeRuntime runs local
+// shells for tests and Docker-less development.
+type ContainerRuntime interface {
+	CreateContainer(ctx context.Context, cfg *ContainerConfig) (string, error)
+	StartContainer(ctx context.Context, containerID string) error
+	StopContainer(ctx context.Context, containerID string) error
+	RemoveContainer(ctx context.Context, containerID string) error
+	GetContainerStatus(ctx context.Context, containerID string) (string, error)
+	LookupContainer(ctx context.Context, containerID string) (id, name string, err error)
+	ListUserContainers(ctx context.Context) ([]ContainerInfo, error)
+	// ContainerEvents streams lifecycle events of lsr-user-* containers. The
+	// error channel receives one error when the stream ends, ctx's included.
+	ContainerEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error)
+
+	// Warm pool containers are started from a catalog image with a quota's
+	// limits before anyone asks for them. ClaimPoolContainer turns one into
+	// cfg's user container, or returns ErrPoolMismatch if cfg needs a freshly
+	// created one.
+	CreatePoolContainer(ctx context.Context, osType string, quota *QuotaTier) (string, error)
+	ClaimPoolContainer(ctx context.Context, containerID string, cfg *ContainerConfig) error
+	ListPoolContainers(ctx context.Context) ([]ContainerInfo, error)
+
+	// ExecContainer starts an interactive shell and returns its stream and exec
+	// ID. An empty shell runs the one the container was created with.
+	ExecContainer(ctx context.Context, containerID, shell string) (ExecStream, string, error)
+	ResizeExecTTY(ctx context.Context, execID string, cols, rows uint) error
+
+	// HasHome reports whether the user's persistent home exists yet
+	HasHome(ctx context.Context, userID int64) (bool, error)
+	// HomeUsage reports the size in bytes of the user's persistent home
+	HomeUsage(ctx context.Context, userID int64) (int64, error)
+	// WipeHome deletes the user's persistent home; remove their container first
+	WipeHome(ctx context.Context, userID int64) error
+
+	// Checkpoints are per-user images of a container's filesystem; restore one
+	// by creating a container with ContainerConfig.Checkpoint set
+	CommitCheckpoint(ctx context.Context, containerID string, userID int64, name, osType string) (*Checkpoint, error)
+	ListCheckpoints(ctx context.Context, userID int64) ([]Checkpoint, error)
+	RemoveCheckpoint(ctx context.Context, userID int64, name string) error
+
+	// Catalog images are tagged by Dockerfile content hash. A version is the
+	// short ID of a built image, so a forced rebuild makes a new version.
+	BuildImages(ctx context.Context, specs []ImageSpec, force bool, progress func(BuildEvent)) []BuildResult
+	ImageVersion(ctx context.Context, spec *ImageSpec) (string, error)
+	ContainerImageVersion(ctx context.Context, containerID string) (string, error)
+
+	// ContainerAccount returns the account exec sessions run as; RootAccount
+	// for containers created without ContainerConfig.Account
+	ContainerAccount(ctx context.Context, containerID string) (*Account, error)
+	// ContainerUsername returns the USER the container was created for: the
+	// LinuxDo username, or its account's name if it has one
+	ContainerUsername(ctx context.Context, containerID string) (string, error)
+
+	// Files inside the container, by absolute path. Archives are tar streams
+	// whose entries are named after the copied file or directory.
+	StatPath(ctx context.Context, containerID, path string) (*FileEntry, error)
+	ListDir(ctx context.Context, containerID, dir string) ([]FileEntry, error)
+	CopyFromContainer(ctx context.Context, containerID, path string) (io.ReadCloser, error)
+	CopyToContainer(ctx context.Context, containerID, dir string, archive io.Reader) error
+}
+
+// ExecStream is an attached exec session: reads return terminal output,
+// writes go to the shell's stdin
+type ExecStream interface {
+	io.ReadWriteCloser
+}
+
+// ContainerInfo summarizes an lsr-user-* or lsr-pool-* container
+type ContainerInfo struct {
+	ID    string
+	Name  string // Without the leading slash
+	State string // "running", "exited", ...
+	Image string // Catalog image ID
+	// Limits the container was created with, for capacity accounting
+	MemoryMB int64
+	CPUs     float64
+}
+
+// Container event actions, named as Docker names them
+const (
+	EventStart   = "start"
+	EventKill    = "kill" // Sent before the die of a stop
+	EventOOM     = "oom"  // Sent before the die of an OOM kill
+	EventDie     = "die"
+	EventDestroy = "destroy"
+)
+
+// ContainerEvent is a state change of an lsr-user-* container
+type ContainerEvent struct {
+	ID       string
+	Name     string // Without the leading slash
+	Action   string // One of the Event* actions
+	ExitCode int    // For EventDie
+}
@@ -0,0 +1,566 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Queue states reported in QueueStatus
+const (
+	QueueNone    = "none"   // Not queued and holding no slot
+	QueueWaiting = "queued" // Waiting for capacity
+	QueueReady   = "ready"  // A slot is reserved; launch before it expires
+)
+
+// Capacity is what the host may run at once; zero fields are unlimited
+type Capacity struct {
+	Containers int     `json:"containers"`
+	MemoryMB   int64   `json:"memory_mb"`
+	CPUs       float64 `json:"cpus"`
+}
+
+// Unlimited reports whether no limit is set, which turns the scheduler off
+func (c Capacity) Unlimited() bool {
+	return c.Containers <= 0 && c.MemoryMB <= 0 && c.CPUs <= 0
+}
+
+// Usage is what running containers, warm pool ones included, and reserved
+// slots take
+type Usage struct {
+	Containers int     `json:"containers"`
+	MemoryMB   int64   `json:"memory_mb"`
+	CPUs       float64 `json:"cpus"`
+}
+
+func (u *Usage) add(memoryMB int64, cpus float64) {
+	u.Containers++
+	u.MemoryMB += memoryMB
+	u.CPUs += cpus
+}
+
+// holds reports whether u stays within c
+func (c Capacity) holds(u Usage) bool {
+	return (c.Containers <= 0 || u.Containers <= c.Containers) &&
+		(c.MemoryMB <= 0 || u.MemoryMB <= c.MemoryMB) &&
+		(c.CPUs <= 0 || u.CPUs <= c.CPUs+1e-9)
+}
+
+// fits reports whether one more container of the given size stays within c
+func (c Capacity) fits(used Usage, memoryMB int64, cpus float64) bool {
+	return (c.Containers <= 0 || used.Containers+1 <= c.Containers) &&
+		(c.MemoryMB <= 0 || used.MemoryMB+memoryMB <= c.MemoryMB) &&
+		(c.CPUs <= 0 || used.CPUs+cpus <= c.CPUs+1e-9)
+}
+
+// SchedulerConfig tunes the launch scheduler
+type SchedulerConfig struct {
+	Capacity Capacity
+	// Priority lets higher trust levels go ahead of lower ones in the queue;
+	// users of the same level are served first come, first served
+	Priority bool
+	// EvictIdle stops the running container nobody has been attached to for
+	// longest, once it has been idle this long, while the queue is blocked.
+	// 0 disables eviction.
+	EvictIdle time.Duration
+	// ClaimTimeout is how long an admitted user has to launch, and how long
+	// a queued user nobody is watching for stays in the queue
+	ClaimTimeout time.Duration
+	// Interval is how often the queue is re-evaluated while it is non-empty
+	Interval time.Duration
+}
+
+// QueueStatus is a user's place in the launch queue
+type QueueStatus struct {
+	State         string `json:"state"`
+	Position      int    `json:"position,omitempty"` // 1 is next
+	Length        int    `json:"length"`
+	EstimatedWait int    `json:"estimated_wait,omitempty"` // Seconds; absent until there is history
+}
+
+type queueEntry struct {
+	userID     int64
+	trustLevel int
+	memoryMB   int64
+	cpus       float64
+	enqueued   time.Time
+	lastSeen   time.Time // Last Acquire by the user, for dropping abandoned entries
+}
+
+// reservation is a slot held for an admitted user until they launch
+type reservation struct {
+	memoryMB int64
+	cpus     float64
+	expires  time.Time
+}
+
+// Scheduler admits container launches against the host's capacity. Usage is
+// read from the runtime's running containers, so stops and removals on any
+// path free capacity without telling the scheduler. Launches that don't fit
+// wait in a queue; the head of the queue is admitted as soon as it fits.
+// Warm pool containers count too but give way to launches: admission looks
+// past them, removes the ones that no longer fit, and the pool only refills
+// while HasRoom.
+type Scheduler struct {
+	runtime ContainerRuntime
+	cfg     SchedulerConfig
+
+	mu         sync.Mutex
+	queue      []*queueEntry
+	reserved   map[int64]reservation               // User ID -> slot held for their launch
+	idleSince  map[string]time.Time                // Running container ID -> first seen with nobody attached
+	watchers   map[int64]map[chan QueueStatus]bool // User ID -> status subscribers
+	lastAdmit  time.Time
+	admitEvery time.Duration // Moving average of the time between queue admissions
+	looping    bool          // Whether the scheduling goroutine runs
+	kick       chan struct{}
+	ctx        context.Context // Scheduling goroutines stop when it is done
+}
+
+// NewScheduler creates a scheduler; a zero Capacity admits everyone at once
+func NewScheduler(runtime ContainerRuntime, cfg SchedulerConfig) *Scheduler {
+	if cfg.ClaimTimeout <= 0 {
+		cfg.ClaimTimeout = 2 * time.Minute
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 2 * time.Second
+	}
+	return &Scheduler{
+		runtime:   runtime,
+		cfg:       cfg,
+		reserved:  make(map[int64]reservation),
+		idleSince: make(map[string]time.Time),
+		watchers:  make(map[int64]map[chan QueueStatus]bool),
+		ctx:       context.Background(),
+		kick:      make(chan struct{}, 1),
+	}
+}
+
+// Start ties the scheduler to ctx: the queue is no longer re-evaluated once
+// ctx is done. Without Start it runs for the life of the process.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+}
+
+// Capacity returns the configured host capacity
+func (s *Scheduler) Capacity() Capacity {
+	return s.cfg.Capacity
+}
+
+// Acquire asks for a slot to launch a container with the quota's size. It
+// returns true when the user may launch now, and must then call Done. Else
+// the user is queued (or stays queued) and the status gives their position.
+func (s *Scheduler) Acquire(ctx context.Context, userID int64, trustLevel int, quota *QuotaTier) (QueueStatus, bool) {
+	if s.cfg.Capacity.Unlimited() {
+		return QueueStatus{State: QueueReady}, true
+	}
+
+	s.mu.Lock()
+	if _, ok := s.reserved[userID]; ok {
+		s.mu.Unlock()
+		return QueueStatus{State: QueueReady}, true
+	}
+	if e := s.findLocked(userID); e != nil {
+		e.lastSeen = time.Now()
+		st := s.statusLocked(userID)
+		s.mu.Unlock()
+		return st, false
+	}
+	s.mu.Unlock()
+
+	used, _, pool, err := s.usage(ctx)
+	if err != nil {
+		// Without a view of the host, don't block launches
+		log.Printf("⚠️ Scheduler can't read running containers, admitting user %d: %v", userID, err)
+		return QueueStatus{State: QueueReady}, true
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findLocked(userID) == nil {
+		total := s.withReservationsLocked(used)
+		if len(s.queue) == 0 && s.cfg.Capacity.fits(total, quota.MemoryMB, quota.CPUs) {
+			s.reserved[userID] = reservation{memoryMB: quota.MemoryMB, cpus: quota.CPUs, expires: now.Add(s.cfg.ClaimTimeout)}
+			total.add(quota.MemoryMB, quota.CPUs)
+			if spare := s.cfg.Capacity.excessPool(total, pool); len(spare) > 0 {
+				go s.removePool(context.Background(), spare)
+			}
+			return QueueStatus{State: QueueReady}, true
+		}
+		s.enqueueLocked(&queueEntry{
+			userID:     userID,
+			trustLevel: trustLevel,
+			memoryMB:   quota.MemoryMB,
+			cpus:       quota.CPUs,
+			enqueued:   now,
+			lastSeen:   now,
+		})
+		log.Printf("🚦 Host full, user %d queued (%d waiting)", userID, len(s.queue))
+		s.startLocked()
+	}
+	return s.statusLocked(userID), false
+}
+
+// Done releases the user's reservation once their launch finished or failed.
+// A started container is counted from the runtime from then on.
+func (s *Scheduler) Done(userID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reserved[userID]; ok {
+		delete(s.reserved, userID)
+		s.kickLocked()
+	}
+}
+
+// Leave takes the user out of the queue and gives up any reserved slot
+func (s *Scheduler) Leave(userID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.queue {
+		if e.userID == userID {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+	delete(s.reserved, userID)
+	s.notifyLocked(userID, s.statusLocked(userID))
+	s.kickLocked()
+}
+
+// Status returns the user's place in the queue
+func (s *Scheduler) Status(userID int64) QueueStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statusLocked(userID)
+}
+
+// Usage returns what running containers and reserved slots take
+func (s *Scheduler) Usage(ctx context.Context) (Usage, error) {
+	used, _, pool, err := s.usage(ctx)
+	if err != nil {
+		return used, err
+	}
+	used = withPool(used, pool)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.withReservationsLocked(used), nil
+}
+
+// Watch subscribes to the user's queue status. The channel first receives
+// the current status and then every change; it keeps only the latest one.
+// A watched user stays queued however long they wait.
+func (s *Scheduler) Watch(userID int64) (<-chan QueueStatus, func()) {
+	ch := make(chan QueueStatus, 1)
+	s.mu.Lock()
+	if s.watchers[userID] == nil {
+		s.watchers[userID] = make(map[chan QueueStatus]bool)
+	}
+	s.watchers[userID][ch] = true
+	ch <- s.statusLocked(userID)
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers[userID], ch)
+		if len(s.watchers[userID]) == 0 {
+			delete(s.watchers, userID)
+		}
+		if e := s.findLocked(userID); e != nil {
+			// The abandon timeout starts when the last watcher leaves
+			e.lastSeen = time.Now()
+		}
+	}
+}
+
+// HasRoom reports whether a container of the given size fits next to the
+// running containers and reserved slots while nobody is queued. The warm
+// pool checks it before starting a container.
+func (s *Scheduler) HasRoom(ctx context.Context, memoryMB int64, cpus float64) bool {
+	if s.cfg.Capacity.Unlimited() {
+		return true
+	}
+	used, _, pool, err := s.usage(ctx)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue) == 0 && s.cfg.Capacity.fits(withPool(s.withReservationsLocked(used), pool), memoryMB, cpus)
+}
+
+// usage sums the limits of the running user containers and returns them,
+// along with the running pool containers
+func (s *Scheduler) usage(ctx context.Context) (Usage, []ContainerInfo, []ContainerInfo, error) {
+	var used Usage
+	containers, err := s.runtime.ListUserContainers(ctx)
+	if err != nil {
+		return used, nil, nil, err
+	}
+	running := containers[:0]
+	for _, c := range containers {
+		if c.State == "running" {
+			used.add(c.MemoryMB, c.CPUs)
+			running = append(running, c)
+		}
+	}
+	list, err := s.runtime.ListPoolContainers(ctx)
+	if err != nil {
+		return used, nil, nil, err
+	}
+	pool := list[:0]
+	for _, c := range list {
+		if c.State == "running" {
+			pool = append(pool, c)
+		}
+	}
+	return used, running, pool, nil
+}
+
+// withPool adds the pool containers' limits to used
+func withPool(used Usage, pool []ContainerInfo) Usage {
+	for _, c := range pool {
+		used.add(c.MemoryMB, c.CPUs)
+	}
+	return used
+}
+
+// excessPool picks the pool containers that don't fit next to used
+func (c Capacity) excessPool(used Usage, pool []ContainerInfo) []string {
+	var excess []string
+	for _, p := range pool {
+		with := used
+		with.add(p.MemoryMB, p.CPUs)
+		if c.holds(with) {
+			used = with
+		} else {
+			excess = append(excess, p.ID)
+		}
+	}
+	return excess
+}
+
+// removePool removes pool containers to make room for launches. The pool
+// drops them from its ready list when it next checks them.
+func (s *Scheduler) removePool(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		log.Printf("🧹 Removing pool container %s to make room for a launch", id[:min(12, len(id))])
+		if err := s.runtime.RemoveContainer(ctx, id); err != nil {
+			log.Printf("⚠️ Failed to remove pool container %s: %v", id[:min(12, len(id))], err)
+		}
+	}
+}
+
+func (s *Scheduler) withReservationsLocked(used Usage) Usage {
+	for _, r := range s.reserved {
+		used.add(r.memoryMB, r.cpus)
+	}
+	return used
+}
+
+func (s *Scheduler) findLocked(userID int64) *queueEntry {
+	for _, e := range s.queue {
+		if e.userID == userID {
+			return e
+		}
+	}
+	return nil
+}
+
+// enqueueLocked appends e, or with priority puts it behind every entry of
+// the same or a higher trust level
+func (s *Scheduler) enqueueLocked(e *queueEntry) {
+	i := len(s.queue)
+	if s.cfg.Priority {
+		for i > 0 && s.queue[i-1].trustLevel < e.trustLevel {
+			i--
+		}
+	}
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = e
+}
+
+func (s *Scheduler) statusLocked(userID int64) QueueStatus {
+	if _, ok := s.reserved[userID]; ok {
+		return QueueStatus{State: QueueReady, Length: len(s.queue)}
+	}
+	for i, e := range s.queue {
+		if e.userID == userID {
+			st := QueueStatus{State: QueueWaiting, Position: i + 1, Length: len(s.queue)}
+			if s.admitEvery > 0 {
+				st.EstimatedWait = int((time.Duration(i+1) * s.admitEvery).Round(time.Second) / time.Second)
+			}
+			return st
+		}
+	}
+	return QueueStatus{State: QueueNone, Length: len(s.queue)}
+}
+
+// notifyLocked replaces whatever status the user's watchers haven't read yet
+func (s *Scheduler) notifyLocked(userID int64, st QueueStatus) {
+	for ch := range s.watchers[userID] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- st
+	}
+}
+
+// startLocked runs the scheduling loop if it isn't running
+func (s *Scheduler) startLocked() {
+	if !s.looping {
+		s.looping = true
+		go s.loop(s.ctx)
+	}
+}
+
+func (s *Scheduler) kickLocked() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// loop re-evaluates the queue until it and the reservations are empty
+func (s *Scheduler) loop(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.looping = false
+			s.mu.Unlock()
+			return
+		case <-ticker.C:
+		case <-s.kick:
+		}
+		if !s.schedule(ctx) {
+			return
+		}
+	}
+}
+
+// schedule expires stale reservations and queue entries, admits the head of
+// the queue while it fits and evicts an idle container if it doesn't. It
+// returns false, stopping the loop, when there is nothing left to do.
+func (s *Scheduler) schedule(ctx context.Context) bool {
+	used, running, pool, err := s.usage(ctx)
+	if err != nil {
+		log.Printf("⚠️ Scheduler can't read running containers: %v", err)
+		return true
+	}
+	active := make(map[string]bool, len(running))
+	for _, c := range running {
+		active[c.ID] = Hubs.Active(c.ID)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for userID, r := range s.reserved {
+		if now.After(r.expires) {
+			log.Printf("⌛ User %d didn't launch in time, slot released", userID)
+			delete(s.reserved, userID)
+			s.notifyLocked(userID, QueueStatus{State: QueueNone})
+		}
+	}
+	kept := s.queue[:0]
+	for _, e := range s.queue {
+		if len(s.watchers[e.userID]) == 0 && now.Sub(e.lastSeen) > s.cfg.ClaimTimeout {
+			log.Printf("🚪 User %d left the launch queue", e.userID)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	s.queue = kept
+
+	// Track how long each running container has had nobody attached
+	for id := range s.idleSince {
+		if _, ok := active[id]; !ok {
+			delete(s.idleSince, id)
+		}
+	}
+	for id, busy := range active {
+		if busy {
+			delete(s.idleSince, id)
+		} else if _, ok := s.idleSince[id]; !ok {
+			s.idleSince[id] = now
+		}
+	}
+
+	total := s.withReservationsLocked(used)
+	for len(s.queue) > 0 {
+		head := s.queue[0]
+		if !s.cfg.Capacity.fits(total, head.memoryMB, head.cpus) {
+			break
+		}
+		s.queue = s.queue[1:]
+		s.reserved[head.userID] = reservation{memoryMB: head.memoryMB, cpus: head.cpus, expires: now.Add(s.cfg.ClaimTimeout)}
+		total.add(head.memoryMB, head.cpus)
+		s.recordAdmitLocked(head, now)
+		log.Printf("🎟️ User %d admitted from the launch queue after %s", head.userID, now.Sub(head.enqueued).Round(time.Second))
+		s.notifyLocked(head.userID, QueueStatus{State: QueueReady, Length: len(s.queue)})
+	}
+
+	spare := s.cfg.Capacity.excessPool(total, pool)
+
+	victim := ""
+	if len(s.queue) > 0 && s.cfg.EvictIdle > 0 {
+		var oldest time.Time
+		for id, since := range s.idleSince {
+			if now.Sub(since) >= s.cfg.EvictIdle && (victim == "" || since.Before(oldest)) {
+				victim, oldest = id, since
+			}
+		}
+		delete(s.idleSince, victim)
+	}
+	for _, e := range s.queue {
+		s.notifyLocked(e.userID, s.statusLocked(e.userID))
+	}
+	more := len(s.queue) > 0 || len(s.reserved) > 0
+	if !more {
+		s.looping = false
+	}
+	s.mu.Unlock()
+
+	s.removePool(ctx, spare)
+	if victim != "" {
+		s.evict(ctx, victim)
+	}
+	return more
+}
+
+// recordAdmitLocked updates the average time between admissions used for
+// wait estimates, counting only time the queue was waiting
+func (s *Scheduler) recordAdmitLocked(e *queueEntry, now time.Time) {
+	start := s.lastAdmit
+	if e.enqueued.After(start) {
+		start = e.enqueued
+	}
+	interval := now.Sub(start)
+	if s.admitEvery == 0 {
+		s.admitEvery = interval
+	} else {
+		s.admitEvery = (3*s.admitEvery + interval) / 4
+	}
+	s.lastAdmit = now
+}
+
+// evict stops an idle container to make room. Its terminals are closed
+// first so their sessions end the same way as after a disconnect.
+func (s *Scheduler) evict(ctx context.Context, containerID string) {
+	log.Printf("🧹 Evicting idle container %s to make room for the queue", containerID[:min(12, len(containerID))])
+	Hubs.CloseContainer(containerID)
+	if err := s.runtime.StopContainer(ctx, containerID); err != nil {
+		log.Printf("⚠️ Failed to evict container %s: %v", containerID[:min(12, len(containerID))], err)
+	}
+	s.mu.Lock()
+	s.kickLocked()
+	s.mu.Unlock()
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var smallQuota = &QuotaTier{MemoryMB: 256, CPUs: 0.5, PidsLimit: 64}
+
+// waitState reads statuses from a watch until one has the wanted state
+func waitState(t *testing.T, updates <-chan QueueStatus, want string) QueueStatus {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case st := <-updates:
+			if st.State == want {
+				return st
+			}
+		case <-deadline:
+			t.Fatalf("no %q status", want)
+		}
+	}
+}
+
+func TestCapacity_Fits(t *testing.T) {
+	c := Capacity{MemoryMB: 1024, CPUs: 1}
+	if !c.fits(Usage{Containers: 10, MemoryMB: 768, CPUs: 0.5}, 256, 0.5) {
+		t.Fatal("exactly full should fit")
+	}
+	if c.fits(Usage{MemoryMB: 800}, 256, 0.5) || c.fits(Usage{CPUs: 0.75}, 256, 0.5) {
+		t.Fatal("over capacity should not fit")
+	}
+	if !(Capacity{}).Unlimited() || (Capacity{Containers: 1}).Unlimited() {
+		t.Fatal("unexpected Unlimited")
+	}
+}
+
+func TestScheduler_Queue(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+	s := NewScheduler(rt, SchedulerConfig{Capacity: Capacity{Containers: 1}, Interval: 10 * time.Millisecond})
+
+	if _, ok := s.Acquire(ctx, 1, 0, smallQuota); !ok {
+		t.Fatal("first launch should be admitted")
+	}
+	id, _ := rt.CreateContainer(ctx, &ContainerConfig{UserID: 1, OSType: "alpine"})
+	s.Done(1)
+
+	if st, ok := s.Acquire(ctx, 2, 0, smallQuota); ok || st.State != QueueWaiting || st.Position != 1 {
+		t.Fatalf("second launch = %+v %v", st, ok)
+	}
+	if st, _ := s.Acquire(ctx, 3, 0, smallQuota); st.Position != 2 || st.Length != 2 {
+		t.Fatalf("third launch = %+v", st)
+	}
+	updates, stop := s.Watch(2)
+	defer stop()
+
+	// Stopping the running container frees the slot for the head of the queue
+	rt.StopContainer(ctx, id)
+	waitState(t, updates, QueueReady)
+	if st := s.Status(3); st.Position != 1 || st.Length != 1 {
+		t.Fatalf("third after admission = %+v", st)
+	}
+	if _, ok := s.Acquire(ctx, 2, 0, smallQuota); !ok {
+		t.Fatal("admitted user should be able to launch")
+	}
+	rt.CreateContainer(ctx, &ContainerConfig{UserID: 2, OSType: "alpine"})
+	s.Done(2)
+
+	s.Leave(3)
+	if st := s.Status(3); st.State != QueueNone || st.Length != 0 {
+		t.Fatalf("after leaving = %+v", st)
+	}
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+	s := NewScheduler(rt, SchedulerConfig{Capacity: Capacity{MemoryMB: 256}, Priority: true, Interval: time.Hour})
+	rt.CreateContainer(ctx, &ContainerConfig{UserID: 1, OSType: "alpine"})
+
+	s.Acquire(ctx, 2, 1, smallQuota)
+	s.Acquire(ctx, 3, 0, smallQuota)
+	s.Acquire(ctx, 4, 3, smallQuota)
+	s.Acquire(ctx, 5, 1, smallQuota)
+	for userID, want := range map[int64]int{4: 1, 2: 2, 5: 3, 3: 4} {
+		if st := s.Status(userID); st.Position != want {
+			t.Errorf("user %d at %d, want %d", userID, st.Position, want)
+		}
+	}
+}
+
+func TestScheduler_EvictsIdle(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+	s := NewScheduler(rt, SchedulerConfig{
+		Capacity:  Capacity{Containers: 1},
+		EvictIdle: 20 * time.Millisecond,
+		Interval:  10 * time.Millisecond,
+	})
+	// Nobody is attached to this container, so it only holds the slot
+	id, _ := rt.CreateContainer(ctx, &ContainerConfig{UserID: 1, OSType: "alpine"})
+
+	if _, ok := s.Acquire(ctx, 2, 0, smallQuota); ok {
+		t.Fatal("host should be full")
+	}
+	updates, stop := s.Watch(2)
+	defer stop()
+	waitState(t, updates, QueueReady)
+	if status, _ := rt.GetContainerStatus(ctx, id); status != "exited" {
+		t.Fatalf("idle container is %s, want it evicted", status)
+	}
+}
+
+func TestScheduler_CountsPool(t *testing.T) {
+	catalog, err := ParseCatalog([]byte(`{"images": [{"id": "alpine", "base": "alpine:3.19", "shell": "sh", "pool_size": 2}]}`), "")
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rt := NewFakeRuntime("/bin/sh")
+	defer rt.Close()
+	s := NewScheduler(rt, SchedulerConfig{Capacity: Capacity{Containers: 2}, Interval: 10 * time.Millisecond})
+	rt.CreateContainer(ctx, &ContainerConfig{UserID: 1, OSType: "alpine"})
+
+	// The pool only fills the room that is left, and takes it up
+	p := NewWarmPool(rt, catalog, nil, s)
+	p.Start(ctx)
+	waitReady(t, p, "alpine", 1)
+	p.refill()
+	time.Sleep(50 * time.Millisecond)
+	if pool, _ := rt.ListPoolContainers(ctx); len(pool) != 1 {
+		t.Fatalf("pool containers = %+v, want 1", pool)
+	}
+	if used, _ := s.Usage(ctx); used.Containers != 2 {
+		t.Fatalf("usage = %+v", used)
+	}
+
+	// A launch takes the pool container's place rather than queueing
+	if _, ok := s.Acquire(ctx, 2, 0, smallQuota); !ok {
+		t.Fatal("launch should be admitted in place of the pool container")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pool, _ := rt.ListPoolContainers(ctx); len(pool) != 0; pool, _ = rt.ListPoolContainers(ctx) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool containers = %+v, want none", pool)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.HasRoom(ctx, smallQuota.MemoryMB, smallQuota.CPUs) {
+		t.Fatal("the reserved slot should leave no room to refill the pool")
+	}
+}
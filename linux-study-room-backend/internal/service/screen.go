@@ -0,0 +1,1027 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Screen is a server-side VT100/xterm screen model. It tracks the cursor,
+// scroll region, main and alternate screens and main-screen scrollback, so
+// snapshots stay correct for full-screen programs like vim and htop.
+// A Screen is not safe for concurrent use.
+type Screen struct {
+	cols, rows int
+
+	main, alt  [][]Cell
+	altActive  bool
+	scrollback [][]Cell
+	maxScroll  int
+
+	x, y     int
+	wrapNext bool // Cursor sits past the last column; the next char wraps
+	attr     CellAttr
+	saved    savedCursor // DECSC / CSI s
+	altSaved savedCursor // Main screen cursor saved by ?1049h
+
+	top, bottom  int // Scroll region, inclusive
+	autowrap     bool
+	originMode   bool
+	cursorHidden bool
+	tabs         []bool
+	lastChar     rune
+
+	// Parser state
+	state    parserState
+	params   []int
+	param    int
+	hasParam bool
+	private  byte
+	inter    byte
+	utf8Buf  []byte
+}
+
+// Cell is one character cell. Ch is 0 for a blank and wideTail for the
+// second half of a double-width character.
+type Cell struct {
+	Ch   rune
+	Attr CellAttr
+}
+
+const wideTail rune = -1
+
+// Color is 0 for the default color, colorPalette|n for 256-color palette
+// entries and colorRGB|0xRRGGBB for truecolor
+type Color uint32
+
+const (
+	colorPalette Color = 1 << 24
+	colorRGB     Color = 1 << 25
+)
+
+// Text attribute flags
+const (
+	AttrBold uint8 = 1 << iota
+	AttrDim
+	AttrItalic
+	AttrUnderline
+	AttrBlink
+	AttrReverse
+	AttrHidden
+	AttrStrike
+)
+
+// sgrFlags maps SGR parameters to the flag they set; 20 more resets it
+var sgrFlags = map[int]uint8{
+	1: AttrBold, 2: AttrDim, 3: AttrItalic, 4: AttrUnderline,
+	5: AttrBlink, 7: AttrReverse, 8: AttrHidden, 9: AttrStrike,
+}
+
+// CellAttr is the graphic rendition of a cell
+type CellAttr struct {
+	Fg, Bg Color
+	Flags  uint8
+}
+
+type savedCursor struct {
+	x, y       int
+	attr       CellAttr
+	originMode bool
+	wrapNext   bool
+}
+
+type parserState int
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateCSI
+	stateOSC     // Operating system command, ends with BEL or ST
+	stateString  // DCS/PM/APC/SOS, ignored until ST
+	stateCharset // One designator byte after ESC ( ) * + etc.
+)
+
+// defaultScrollback is how many lines scroll off the main screen before being dropped
+const defaultScrollback = 1000
+
+// NewScreen creates a blank screen of the given size
+func NewScreen(cols, rows int) *Screen {
+	if cols < 1 {
+		cols = 80
+	}
+	if rows < 1 {
+		rows = 24
+	}
+	s := &Screen{maxScroll: defaultScrollback}
+	s.cols, s.rows = cols, rows
+	s.main = newGrid(cols, rows)
+	s.alt = newGrid(cols, rows)
+	s.reset()
+	return s
+}
+
+func newGrid(cols, rows int) [][]Cell {
+	g := make([][]Cell, rows)
+	for i := range g {
+		g[i] = make([]Cell, cols)
+	}
+	return g
+}
+
+// reset puts the terminal back into its power-on state (RIS), keeping scrollback
+func (s *Screen) reset() {
+	s.main = newGrid(s.cols, s.rows)
+	s.alt = newGrid(s.cols, s.rows)
+	s.altActive = false
+	s.x, s.y = 0, 0
+	s.wrapNext = false
+	s.attr = CellAttr{}
+	s.saved = savedCursor{}
+	s.altSaved = savedCursor{}
+	s.top, s.bottom = 0, s.rows-1
+	s.autowrap = true
+	s.originMode = false
+	s.cursorHidden = false
+	s.resetTabs()
+	s.state = stateGround
+}
+
+func (s *Screen) resetTabs() {
+	s.tabs = make([]bool, s.cols)
+	for i := 8; i < s.cols; i += 8 {
+		s.tabs[i] = true
+	}
+}
+
+// grid returns the active screen
+func (s *Screen) grid() [][]Cell {
+	if s.altActive {
+		return s.alt
+	}
+	return s.main
+}
+
+// Size returns the screen size
+func (s *Screen) Size() (cols, rows int) {
+	return s.cols, s.rows
+}
+
+// Cursor returns the cursor position, 0-based
+func (s *Screen) Cursor() (x, y int) {
+	return s.x, s.y
+}
+
+// AltScreen reports whether the alternate screen is active
+func (s *Screen) AltScreen() bool {
+	return s.altActive
+}
+
+// Write feeds terminal output into the model. It never fails.
+func (s *Screen) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.feed(b)
+	}
+	return len(p), nil
+}
+
+func (s *Screen) feed(b byte) {
+	// Collect UTF-8 sequences in the ground state
+	if s.state == stateGround {
+		if len(s.utf8Buf) > 0 {
+			if b >= 0x80 && b < 0xc0 {
+				s.utf8Buf = append(s.utf8Buf, b)
+				if utf8.FullRune(s.utf8Buf) {
+					r, _ := utf8.DecodeRune(s.utf8Buf)
+					s.utf8Buf = s.utf8Buf[:0]
+					s.put(r)
+				}
+				return
+			}
+			// Truncated sequence
+			s.utf8Buf = s.utf8Buf[:0]
+			s.put(utf8.RuneError)
+		}
+		if b >= 0xc0 {
+			s.utf8Buf = append(s.utf8Buf, b)
+			if utf8.FullRune(s.utf8Buf) { // Invalid lead byte
+				s.utf8Buf = s.utf8Buf[:0]
+				s.put(utf8.RuneError)
+			}
+			return
+		}
+		if b >= 0x80 {
+			s.put(utf8.RuneError)
+			return
+		}
+	}
+
+	switch s.state {
+	case stateOSC, stateString:
+		switch b {
+		case 0x07:
+			s.state = stateGround
+		case 0x1b:
+			s.state = stateEscape // ESC \ terminates
+		case 0x18, 0x1a:
+			s.state = stateGround
+		}
+		return
+	case stateCharset:
+		s.state = stateGround
+		return
+	}
+
+	// C0 controls execute in every remaining state
+	if b < 0x20 || b == 0x7f {
+		s.control(b)
+		return
+	}
+
+	switch s.state {
+	case stateGround:
+		s.put(rune(b))
+	case stateEscape:
+		s.escape(b)
+	case stateCSI:
+		s.csiByte(b)
+	}
+}
+
+func (s *Screen) control(b byte) {
+	switch b {
+	case 0x08: // BS
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapNext = false
+	case 0x09: // HT
+		s.x = s.nextTab(s.x)
+		s.wrapNext = false
+	case 0x0a, 0x0b, 0x0c: // LF, VT, FF
+		s.lineFeed()
+	case 0x0d: // CR
+		s.x = 0
+		s.wrapNext = false
+	case 0x18, 0x1a: // CAN, SUB
+		s.state = stateGround
+	case 0x1b:
+		s.state = stateEscape
+	}
+}
+
+func (s *Screen) escape(b byte) {
+	s.state = stateGround
+	switch b {
+	case '[':
+		s.state = stateCSI
+		s.params = s.params[:0]
+		s.param, s.hasParam = 0, false
+		s.private, s.inter = 0, 0
+	case ']':
+		s.state = stateOSC
+	case 'P', 'X', '^', '_':
+		s.state = stateString
+	case '(', ')', '*', '+', '-', '.', '/', '#', '%':
+		s.state = stateCharset
+	case '7':
+		s.saveCursor(&s.saved)
+	case '8':
+		s.restoreCursor(&s.saved)
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.x = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'H':
+		s.tabs[s.x] = true
+	case 'c':
+		s.reset()
+	}
+}
+
+func (s *Screen) csiByte(b byte) {
+	switch {
+	case b >= '0' && b <= '9':
+		s.param = s.param*10 + int(b-'0')
+		if s.param > 65535 {
+			s.param = 65535
+		}
+		s.hasParam = true
+	case b == ';' || b == ':':
+		s.params = append(s.params, s.param)
+		s.param, s.hasParam = 0, false
+	case b >= '<' && b <= '?':
+		s.private = b
+	case b >= 0x20 && b <= 0x2f:
+		s.inter = b
+	case b >= 0x40 && b <= 0x7e:
+		if s.hasParam || len(s.params) > 0 {
+			s.params = append(s.params, s.param)
+		}
+		s.state = stateGround
+		s.csi(b)
+	default:
+		s.state = stateGround
+	}
+}
+
+// arg returns parameter i, or def when it is missing or zero
+func (s *Screen) arg(i, def int) int {
+	if i < len(s.params) && s.params[i] != 0 {
+		return s.params[i]
+	}
+	return def
+}
+
+func (s *Screen) csi(final byte) {
+	if s.inter != 0 {
+		return // DECSCUSR, DECSTR and friends don't change the screen contents
+	}
+	if s.private == '?' {
+		switch final {
+		case 'h':
+			s.setModes(true)
+		case 'l':
+			s.setModes(false)
+		}
+		return
+	}
+	if s.private != 0 {
+		return
+	}
+
+	n := s.arg(0, 1)
+	switch final {
+	case '@':
+		s.insertChars(n)
+	case 'A':
+		s.moveUp(n)
+	case 'B', 'e':
+		s.moveDown(n)
+	case 'C', 'a':
+		s.moveTo(s.x+n, s.y)
+	case 'D':
+		s.moveTo(s.x-n, s.y)
+	case 'E':
+		s.moveDown(n)
+		s.x = 0
+	case 'F':
+		s.moveUp(n)
+		s.x = 0
+	case 'G', '`':
+		s.moveTo(n-1, s.y)
+	case 'H', 'f':
+		row := s.arg(0, 1) - 1
+		if s.originMode {
+			row += s.top
+		}
+		s.moveTo(s.arg(1, 1)-1, row)
+	case 'd':
+		row := n - 1
+		if s.originMode {
+			row += s.top
+		}
+		s.moveTo(s.x, row)
+	case 'J':
+		s.eraseDisplay(s.arg(0, 0))
+	case 'K':
+		s.eraseLine(s.arg(0, 0))
+	case 'L':
+		s.insertLines(n)
+	case 'M':
+		s.deleteLines(n)
+	case 'P':
+		s.deleteChars(n)
+	case 'S':
+		s.scrollUp(s.top, s.bottom, n, false)
+	case 'T':
+		if len(s.params) <= 1 {
+			s.scrollDown(s.top, s.bottom, n)
+		}
+	case 'X':
+		line := s.grid()[s.y]
+		for i := s.x; i < s.x+n && i < s.cols; i++ {
+			line[i] = s.blank()
+		}
+		s.wrapNext = false
+	case 'b':
+		if s.lastChar != 0 {
+			for i := 0; i < n; i++ {
+				s.put(s.lastChar)
+			}
+		}
+	case 'g':
+		switch s.arg(0, 0) {
+		case 0:
+			s.tabs[s.x] = false
+		case 3:
+			s.tabs = make([]bool, s.cols)
+		}
+	case 'm':
+		s.sgr()
+	case 'r':
+		top, bottom := s.arg(0, 1)-1, s.arg(1, s.rows)-1
+		if bottom >= s.rows {
+			bottom = s.rows - 1
+		}
+		if top < bottom {
+			s.top, s.bottom = top, bottom
+			s.moveTo(0, s.homeRow())
+		}
+	case 's':
+		s.saveCursor(&s.saved)
+	case 'u':
+		s.restoreCursor(&s.saved)
+	}
+}
+
+func (s *Screen) setModes(on bool) {
+	for _, mode := range s.params {
+		switch mode {
+		case 6:
+			s.originMode = on
+			s.moveTo(0, s.homeRow())
+		case 7:
+			s.autowrap = on
+		case 25:
+			s.cursorHidden = !on
+		case 47, 1047:
+			s.switchScreen(on, false)
+		case 1048:
+			if on {
+				s.saveCursor(&s.saved)
+			} else {
+				s.restoreCursor(&s.saved)
+			}
+		case 1049:
+			s.switchScreen(on, true)
+		}
+	}
+}
+
+// switchScreen enters or leaves the alternate screen; saveCursor is the ?1049 behavior
+func (s *Screen) switchScreen(alt, saveCursor bool) {
+	if alt == s.altActive {
+		return
+	}
+	if alt {
+		if saveCursor {
+			s.saveCursor(&s.altSaved)
+		}
+		s.altActive = true
+		s.alt = newGrid(s.cols, s.rows)
+	} else {
+		s.altActive = false
+		if saveCursor {
+			s.restoreCursor(&s.altSaved)
+		}
+	}
+	s.wrapNext = false
+}
+
+func (s *Screen) saveCursor(c *savedCursor) {
+	*c = savedCursor{x: s.x, y: s.y, attr: s.attr, originMode: s.originMode, wrapNext: s.wrapNext}
+}
+
+func (s *Screen) restoreCursor(c *savedCursor) {
+	s.attr, s.originMode = c.attr, c.originMode
+	s.moveTo(c.x, c.y)
+	s.wrapNext = c.wrapNext
+}
+
+func (s *Screen) homeRow() int {
+	if s.originMode {
+		return s.top
+	}
+	return 0
+}
+
+// moveTo places the cursor, clamped to the screen (or the scroll region in origin mode)
+func (s *Screen) moveTo(x, y int) {
+	minY, maxY := 0, s.rows-1
+	if s.originMode {
+		minY, maxY = s.top, s.bottom
+	}
+	s.x = clamp(x, 0, s.cols-1)
+	s.y = clamp(y, minY, maxY)
+	s.wrapNext = false
+}
+
+// moveUp moves the cursor up, stopping at the top margin if it started inside the region
+func (s *Screen) moveUp(n int) {
+	minY := 0
+	if s.y >= s.top {
+		minY = s.top
+	}
+	s.y = clamp(s.y-n, minY, s.rows-1)
+	s.wrapNext = false
+}
+
+// moveDown moves the cursor down, stopping at the bottom margin if it started inside the region
+func (s *Screen) moveDown(n int) {
+	maxY := s.rows - 1
+	if s.y <= s.bottom {
+		maxY = s.bottom
+	}
+	s.y = clamp(s.y+n, 0, maxY)
+	s.wrapNext = false
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func (s *Screen) nextTab(x int) int {
+	for i := x + 1; i < s.cols; i++ {
+		if s.tabs[i] {
+			return i
+		}
+	}
+	return s.cols - 1
+}
+
+// blank is an erased cell: empty, keeping the current background (BCE)
+func (s *Screen) blank() Cell {
+	return Cell{Attr: CellAttr{Bg: s.attr.Bg}}
+}
+
+func (s *Screen) blankLine() []Cell {
+	line := make([]Cell, s.cols)
+	b := s.blank()
+	for i := range line {
+		line[i] = b
+	}
+	return line
+}
+
+func (s *Screen) lineFeed() {
+	s.wrapNext = false
+	if s.y == s.bottom {
+		s.scrollUp(s.top, s.bottom, 1, true)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+func (s *Screen) reverseIndex() {
+	s.wrapNext = false
+	if s.y == s.top {
+		s.scrollDown(s.top, s.bottom, 1)
+	} else if s.y > 0 {
+		s.y--
+	}
+}
+
+// scrollUp scrolls lines top..bottom up by n. Lines leaving the top of the
+// full main screen go to scrollback when save is set.
+func (s *Screen) scrollUp(top, bottom, n int, save bool) {
+	g := s.grid()
+	n = clamp(n, 0, bottom-top+1)
+	if save && top == 0 && bottom == s.rows-1 && !s.altActive {
+		for i := 0; i < n; i++ {
+			s.scrollback = append(s.scrollback, g[i])
+		}
+		if over := len(s.scrollback) - s.maxScroll; over > 0 {
+			s.scrollback = s.scrollback[over:]
+		}
+	}
+	copy(g[top:], g[top+n:bottom+1])
+	for i := bottom - n + 1; i <= bottom; i++ {
+		g[i] = s.blankLine()
+	}
+}
+
+// scrollDown scrolls lines top..bottom down by n, inserting blank lines at top
+func (s *Screen) scrollDown(top, bottom, n int) {
+	g := s.grid()
+	n = clamp(n, 0, bottom-top+1)
+	copy(g[top+n:bottom+1], g[top:bottom+1-n])
+	for i := top; i < top+n; i++ {
+		g[i] = s.blankLine()
+	}
+}
+
+func (s *Screen) insertLines(n int) {
+	if s.y < s.top || s.y > s.bottom {
+		return
+	}
+	s.scrollDown(s.y, s.bottom, n)
+	s.x, s.wrapNext = 0, false
+}
+
+func (s *Screen) deleteLines(n int) {
+	if s.y < s.top || s.y > s.bottom {
+		return
+	}
+	s.scrollUp(s.y, s.bottom, n, false)
+	s.x, s.wrapNext = 0, false
+}
+
+func (s *Screen) insertChars(n int) {
+	line := s.grid()[s.y]
+	n = clamp(n, 0, s.cols-s.x)
+	copy(line[s.x+n:], line[s.x:s.cols-n])
+	for i := s.x; i < s.x+n; i++ {
+		line[i] = s.blank()
+	}
+	s.wrapNext = false
+}
+
+func (s *Screen) deleteChars(n int) {
+	line := s.grid()[s.y]
+	n = clamp(n, 0, s.cols-s.x)
+	copy(line[s.x:], line[s.x+n:])
+	for i := s.cols - n; i < s.cols; i++ {
+		line[i] = s.blank()
+	}
+	s.wrapNext = false
+}
+
+func (s *Screen) eraseLine(mode int) {
+	line := s.grid()[s.y]
+	from, to := s.x, s.cols
+	switch mode {
+	case 1:
+		from, to = 0, s.x+1
+	case 2:
+		from = 0
+	}
+	for i := from; i < to && i < s.cols; i++ {
+		line[i] = s.blank()
+	}
+	s.wrapNext = false
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	g := s.grid()
+	switch mode {
+	case 0:
+		s.eraseLine(0)
+		for i := s.y + 1; i < s.rows; i++ {
+			g[i] = s.blankLine()
+		}
+	case 1:
+		s.eraseLine(1)
+		for i := 0; i < s.y; i++ {
+			g[i] = s.blankLine()
+		}
+	case 2, 3:
+		for i := range g {
+			g[i] = s.blankLine()
+		}
+		if mode == 3 {
+			s.scrollback = nil
+		}
+	}
+	s.wrapNext = false
+}
+
+// put writes a printable character at the cursor
+func (s *Screen) put(r rune) {
+	width := runeWidth(r)
+	if width == 0 {
+		return // Combining marks and other zero-width runes are dropped
+	}
+	s.lastChar = r
+
+	if s.wrapNext && s.autowrap {
+		s.x = 0
+		s.lineFeed()
+	}
+	s.wrapNext = false
+
+	if width == 2 && s.x == s.cols-1 {
+		if !s.autowrap {
+			return
+		}
+		// A wide char doesn't fit in the last column; wrap it whole
+		s.setCell(s.x, s.blank())
+		s.x = 0
+		s.lineFeed()
+	}
+
+	s.setCell(s.x, Cell{Ch: r, Attr: s.attr})
+	if width == 2 {
+		s.setCell(s.x+1, Cell{Ch: wideTail, Attr: s.attr})
+	}
+
+	if s.x+width >= s.cols {
+		s.x = s.cols - 1
+		s.wrapNext = s.autowrap
+	} else {
+		s.x += width
+	}
+}
+
+// setCell writes a cell, clearing the other half of any wide char it overwrites
+func (s *Screen) setCell(x int, c Cell) {
+	line := s.grid()[s.y]
+	if line[x].Ch == wideTail && x > 0 && c.Ch != wideTail {
+		line[x-1] = s.blank()
+	}
+	if x+1 < s.cols && line[x+1].Ch == wideTail && runeWidth(line[x].Ch) == 2 {
+		line[x+1] = s.blank()
+	}
+	line[x] = c
+}
+
+// runeWidth returns how many cells r occupies
+func runeWidth(r rune) int {
+	switch {
+	case r == 0 || r == wideTail:
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0x303e,
+		r >= 0x3041 && r <= 0x33ff,
+		r >= 0x3400 && r <= 0x4dbf,
+		r >= 0x4e00 && r <= 0x9fff,
+		r >= 0xa000 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+// sgr applies Select Graphic Rendition parameters
+func (s *Screen) sgr() {
+	if len(s.params) == 0 {
+		s.attr = CellAttr{}
+		return
+	}
+	for i := 0; i < len(s.params); i++ {
+		p := s.params[i]
+		switch {
+		case p == 0:
+			s.attr = CellAttr{}
+		case p >= 1 && p <= 9:
+			s.attr.Flags |= sgrFlags[p]
+		case p == 22:
+			s.attr.Flags &^= AttrBold | AttrDim
+		case p >= 23 && p <= 29:
+			s.attr.Flags &^= sgrFlags[p-20]
+		case p >= 30 && p <= 37:
+			s.attr.Fg = colorPalette | Color(p-30)
+		case p == 38:
+			s.attr.Fg, i = s.extendedColor(i)
+		case p == 39:
+			s.attr.Fg = 0
+		case p >= 40 && p <= 47:
+			s.attr.Bg = colorPalette | Color(p-40)
+		case p == 48:
+			s.attr.Bg, i = s.extendedColor(i)
+		case p == 49:
+			s.attr.Bg = 0
+		case p >= 90 && p <= 97:
+			s.attr.Fg = colorPalette | Color(p-90+8)
+		case p >= 100 && p <= 107:
+			s.attr.Bg = colorPalette | Color(p-100+8)
+		}
+	}
+}
+
+// extendedColor parses 38/48 ;5;n or ;2;r;g;b starting at params[i] and
+// returns the color and the index of the last parameter consumed
+func (s *Screen) extendedColor(i int) (Color, int) {
+	if i+1 >= len(s.params) {
+		return 0, i
+	}
+	switch s.params[i+1] {
+	case 5:
+		if i+2 < len(s.params) {
+			return colorPalette | Color(s.params[i+2]&0xff), i + 2
+		}
+	case 2:
+		if i+4 < len(s.params) {
+			r, g, b := s.params[i+2]&0xff, s.params[i+3]&0xff, s.params[i+4]&0xff
+			return colorRGB | Color(r<<16|g<<8|b), i + 4
+		}
+	}
+	return 0, len(s.params)
+}
+
+// Resize changes the screen size. Rows removed from a shrinking main screen
+// go to scrollback, taking blank rows below the cursor first.
+func (s *Screen) Resize(cols, rows int) {
+	if cols < 1 || rows < 1 || (cols == s.cols && rows == s.rows) {
+		return
+	}
+	resizeGrid := func(g [][]Cell, cursorY *int, save bool) [][]Cell {
+		// Drop empty rows below the cursor, then rows from the top
+		for len(g) > rows && len(g)-1 > *cursorY && lineEmpty(g[len(g)-1]) {
+			g = g[:len(g)-1]
+		}
+		if over := len(g) - rows; over > 0 {
+			if save {
+				s.scrollback = append(s.scrollback, g[:over]...)
+			}
+			g = g[over:]
+			*cursorY -= over
+		}
+		for len(g) < rows {
+			g = append(g, nil)
+		}
+		for i, line := range g {
+			resized := make([]Cell, cols)
+			copy(resized, line)
+			g[i] = resized
+		}
+		return g
+	}
+
+	mainY, altY := s.y, s.y
+	if s.altActive {
+		mainY = s.altSaved.y
+	}
+	s.main = resizeGrid(s.main, &mainY, true)
+	s.alt = resizeGrid(s.alt, &altY, false)
+	if over := len(s.scrollback) - s.maxScroll; over > 0 {
+		s.scrollback = s.scrollback[over:]
+	}
+	for i, line := range s.scrollback {
+		if len(line) < cols {
+			resized := make([]Cell, cols)
+			copy(resized, line)
+			s.scrollback[i] = resized
+		}
+	}
+
+	s.cols, s.rows = cols, rows
+	if s.altActive {
+		s.y = altY
+		s.altSaved.y = clamp(mainY, 0, rows-1)
+		s.altSaved.x = clamp(s.altSaved.x, 0, cols-1)
+	} else {
+		s.y = mainY
+	}
+	s.x = clamp(s.x, 0, cols-1)
+	s.y = clamp(s.y, 0, rows-1)
+	s.saved.x = clamp(s.saved.x, 0, cols-1)
+	s.saved.y = clamp(s.saved.y, 0, rows-1)
+	s.top, s.bottom = 0, rows-1
+	s.wrapNext = false
+	s.resetTabs()
+}
+
+func lineEmpty(line []Cell) bool {
+	for _, c := range line {
+		if c.Ch != 0 || c.Attr != (CellAttr{}) {
+			return false
+		}
+	}
+	return true
+}
+
+// lineText renders a row as plain text without trailing blanks
+func lineText(line []Cell) string {
+	var sb strings.Builder
+	for _, c := range line {
+		switch c.Ch {
+		case wideTail:
+		case 0:
+			sb.WriteByte(' ')
+		default:
+			sb.WriteRune(c.Ch)
+		}
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// Text renders the visible screen as plain text, one line per row,
+// without trailing blanks or trailing empty rows
+func (s *Screen) Text() string {
+	lines := make([]string, len(s.grid()))
+	for i, line := range s.grid() {
+		lines[i] = lineText(line)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Scrollback returns the lines that scrolled off the main screen, oldest first
+func (s *Screen) Scrollback() []string {
+	lines := make([]string, len(s.scrollback))
+	for i, line := range s.scrollback {
+		lines[i] = lineText(line)
+	}
+	return lines
+}
+
+// ANSI renders a minimal escape sequence that repaints the current state on
+// a terminal of the same size. When the alternate screen is active the main
+// screen is painted first, so the viewer returns to it when the program exits.
+func (s *Screen) ANSI() string {
+	var sb strings.Builder
+	sb.WriteString("\x1b[?1049l\x1b[0m\x1b[r\x1b[H\x1b[2J")
+	if s.altActive {
+		paintGrid(&sb, s.main)
+		sb.WriteString(cup(s.altSaved.x, s.altSaved.y))
+		sb.WriteString("\x1b[?1049h\x1b[H\x1b[2J")
+	}
+	paintGrid(&sb, s.grid())
+
+	if s.top != 0 || s.bottom != s.rows-1 {
+		sb.WriteString("\x1b[" + strconv.Itoa(s.top+1) + ";" + strconv.Itoa(s.bottom+1) + "r")
+	}
+	if !s.autowrap {
+		sb.WriteString("\x1b[?7l")
+	}
+	sb.WriteString(s.attr.sgr())
+	sb.WriteString(cup(s.x, s.y))
+	if s.cursorHidden {
+		sb.WriteString("\x1b[?25l")
+	} else {
+		sb.WriteString("\x1b[?25h")
+	}
+	return sb.String()
+}
+
+// paintGrid writes every row with absolute positioning, trimming default blanks
+func paintGrid(sb *strings.Builder, g [][]Cell) {
+	for y, line := range g {
+		end := len(line)
+		for end > 0 && line[end-1].Ch == 0 && line[end-1].Attr == (CellAttr{}) {
+			end--
+		}
+		if end == 0 {
+			continue
+		}
+		sb.WriteString(cup(0, y))
+		var cur CellAttr
+		for _, c := range line[:end] {
+			if c.Ch == wideTail {
+				continue
+			}
+			if c.Attr != cur {
+				sb.WriteString(c.Attr.sgr())
+				cur = c.Attr
+			}
+			if c.Ch == 0 {
+				sb.WriteByte(' ')
+			} else {
+				sb.WriteRune(c.Ch)
+			}
+		}
+		if cur != (CellAttr{}) {
+			sb.WriteString("\x1b[0m")
+		}
+	}
+}
+
+func cup(x, y int) string {
+	return "\x1b[" + strconv.Itoa(y+1) + ";" + strconv.Itoa(x+1) + "H"
+}
+
+// sgr renders the attribute as a single SGR sequence starting from a reset
+func (a CellAttr) sgr() string {
+	var sb strings.Builder
+	sb.WriteString("\x1b[0")
+	for p := 1; p <= 9; p++ {
+		if f := sgrFlags[p]; f != 0 && a.Flags&f != 0 {
+			sb.WriteString(";" + strconv.Itoa(p))
+		}
+	}
+	if a.Fg != 0 {
+		sb.WriteString(";" + a.Fg.sgr(30, 90, 38))
+	}
+	if a.Bg != 0 {
+		sb.WriteString(";" + a.Bg.sgr(40, 100, 48))
+	}
+	sb.WriteByte('m')
+	return sb.String()
+}
+
+// sgr renders a color with the given base codes (30/90/38 for foreground)
+func (c Color) sgr(base, bright, extended int) string {
+	if c&colorRGB != 0 {
+		v := int(c &^ colorRGB)
+		return strconv.Itoa(extended) + ";2;" + strconv.Itoa(v>>16&0xff) + ";" + strconv.Itoa(v>>8&0xff) + ";" + strconv.Itoa(v&0xff)
+	}
+	n := int(c &^ colorPalette)
+	switch {
+	case n < 8:
+		return strconv.Itoa(base + n)
+	case n < 16:
+		return strconv.Itoa(bright + n - 8)
+	}
+	return strconv.Itoa(extended) + ";5;" + strconv.Itoa(n)
+}
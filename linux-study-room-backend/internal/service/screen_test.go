@@ -0,0 +1,143 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func screenWith(cols, rows int, input string) *Screen {
+	s := NewScreen(cols, rows)
+	s.Write([]byte(input))
+	return s
+}
+
+func TestScreen_TextAndCursor(t *testing.T) {
+	s := screenWith(20, 5, "hello\r\nworld\x1b[1;3Hy\x1b[2;1H\x1b[K12")
+	if got, want := s.Text(), "heylo\n12"; got != want {
+		t.Fatalf("text = %q, want %q", got, want)
+	}
+	if x, y := s.Cursor(); x != 2 || y != 1 {
+		t.Fatalf("cursor = %d,%d, want 2,1", x, y)
+	}
+}
+
+func TestScreen_WrapAndScrollback(t *testing.T) {
+	s := screenWith(5, 3, "abcdefgh\r\n1\r\n2\r\n3")
+	if got, want := s.Text(), "1\n2\n3"; got != want {
+		t.Fatalf("text = %q, want %q", got, want)
+	}
+	if got := strings.Join(s.Scrollback(), "|"); got != "abcde|fgh" {
+		t.Fatalf("scrollback = %q", got)
+	}
+}
+
+func TestScreen_ScrollRegion(t *testing.T) {
+	// Status line at the bottom stays put while the region above scrolls
+	s := screenWith(10, 4, "\x1b[4;1Hstatus\x1b[1;3r\x1b[1;1Ha\r\nb\r\nc\r\nd")
+	if got, want := s.Text(), "b\nc\nd\nstatus"; got != want {
+		t.Fatalf("text = %q, want %q", got, want)
+	}
+	if len(s.Scrollback()) != 0 {
+		t.Fatal("a partial scroll region must not feed scrollback")
+	}
+}
+
+func TestScreen_AlternateScreen(t *testing.T) {
+	s := screenWith(10, 3, "$ vim\r\n\x1b[?1049h\x1b[H\x1b[2J~\r\n~ editing")
+	if !s.AltScreen() || s.Text() != "~\n~ editing" {
+		t.Fatalf("alt screen text = %q", s.Text())
+	}
+
+	// The repaint restores the shell underneath before drawing the editor
+	repaint := s.ANSI()
+	if !strings.Contains(repaint, "$ vim") || strings.Index(repaint, "$ vim") > strings.Index(repaint, "\x1b[?1049h") {
+		t.Fatalf("repaint should paint the main screen before entering alt: %q", repaint)
+	}
+
+	s.Write([]byte("\x1b[?1049l"))
+	if s.AltScreen() || s.Text() != "$ vim" {
+		t.Fatalf("after leaving alt screen text = %q", s.Text())
+	}
+	if x, y := s.Cursor(); x != 0 || y != 1 {
+		t.Fatalf("cursor not restored: %d,%d", x, y)
+	}
+}
+
+func TestScreen_WideCharsAndSplitUTF8(t *testing.T) {
+	s := NewScreen(10, 2)
+	// Split a multi-byte character across writes
+	data := []byte("你好ab")
+	s.Write(data[:2])
+	s.Write(data[2:])
+	if got := s.Text(); got != "你好ab" {
+		t.Fatalf("text = %q", got)
+	}
+	if x, _ := s.Cursor(); x != 6 {
+		t.Fatalf("wide chars should take two cells each, cursor x = %d", x)
+	}
+
+	// A wide char that doesn't fit in the last column wraps whole
+	s = screenWith(5, 2, "abcd你")
+	if got := s.Text(); got != "abcd\n你" {
+		t.Fatalf("text = %q", got)
+	}
+}
+
+func TestScreen_InsertDeleteAndErase(t *testing.T) {
+	s := screenWith(10, 3, "abcdef\x1b[1;3H\x1b[2@\x1b[1;1H\x1b[1P")
+	if got := s.Text(); got != "b  cdef" {
+		t.Fatalf("insert/delete chars: %q", got)
+	}
+	s = screenWith(10, 3, "1\r\n2\r\n3\x1b[2;1H\x1b[L")
+	if got := s.Text(); got != "1\n\n2" {
+		t.Fatalf("insert line: %q", got)
+	}
+	s = screenWith(10, 3, "1\r\n2\r\n3\x1b[1;1H\x1b[M")
+	if got := s.Text(); got != "2\n3" {
+		t.Fatalf("delete line: %q", got)
+	}
+	s = screenWith(10, 3, "1\r\n2\r\n3\x1b[2;1H\x1b[J")
+	if got := s.Text(); got != "1" {
+		t.Fatalf("erase below: %q", got)
+	}
+}
+
+func TestScreen_ANSIRoundTrip(t *testing.T) {
+	input := "\x1b[1;31mred\x1b[0m plain \x1b[38;2;1;2;3mrgb\x1b[0m\r\n\x1b[44m  \x1b[0m\x1b[?25l"
+	s := screenWith(20, 4, input)
+
+	// Replaying the repaint on a fresh screen reproduces the same state
+	replay := screenWith(20, 4, s.ANSI())
+	if replay.Text() != s.Text() {
+		t.Fatalf("text mismatch: %q vs %q", replay.Text(), s.Text())
+	}
+	for y := range s.main {
+		for x := range s.main[y] {
+			if s.main[y][x] != replay.main[y][x] {
+				t.Fatalf("cell %d,%d: %+v vs %+v", x, y, replay.main[y][x], s.main[y][x])
+			}
+		}
+	}
+	sx, sy := s.Cursor()
+	rx, ry := replay.Cursor()
+	if sx != rx || sy != ry || !replay.cursorHidden {
+		t.Fatalf("cursor %d,%d hidden=%v, want %d,%d hidden", rx, ry, replay.cursorHidden, sx, sy)
+	}
+	if s.main[0][0].Attr.Flags&AttrBold == 0 || s.main[0][0].Attr.Fg != colorPalette|1 {
+		t.Fatalf("unexpected attr %+v", s.main[0][0].Attr)
+	}
+}
+
+func TestScreen_ResizeKeepsCursorLine(t *testing.T) {
+	s := screenWith(10, 5, "1\r\n2\r\n3\r\n4\r\n$ ")
+	s.Resize(6, 3)
+	if got := s.Text(); got != "3\n4\n$" {
+		t.Fatalf("text after shrink = %q", got)
+	}
+	if _, y := s.Cursor(); y != 2 {
+		t.Fatalf("cursor row = %d, want 2", y)
+	}
+	if got := strings.Join(s.Scrollback(), "|"); got != "1|2" {
+		t.Fatalf("scrollback = %q", got)
+	}
+}
@@ -2,12 +2,12 @@ package service
 
 import (
 	"log"
-	"regexp"
 	"sync"
+	"time"
 )
 
-// ANSI escape sequence regex - comprehensive pattern for terminal control codes
-var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]|\x1b\][^\x07]*\x07|\x1b[()][AB012]|\x1b[=>]|\x1b\[[\?]?[0-9;]*[hlm]|\r`)
+// MainTerminal is the terminal a connection without a terminal ID attaches to
+const MainTerminal = "main"
 
 // SessionManager manages active user sessions and their terminal snapshots
 type SessionManager struct {
@@ -15,6 +15,18 @@ type SessionManager struct {
 	sessions map[string]*Session
 }
 
+// Terminal is one named exec session inside a container
+type Terminal struct {
+	ID          string
+	Name        string
+	Cols        uint // Current PTY size, 0 until a client reports one
+	Rows        uint
+	Snapshot    string // Plain-text screen grid
+	RawSnapshot string // ANSI repaint of the screen
+	Spectators  int
+	CreatedAt   time.Time
+}
+
 // Session represents an active user session
 type Session struct {
 	Username      string
@@ -22,12 +34,17 @@ type Session struct {
 	ContainerID   string
 	OS            string
 	Avatar        string
-	Snapshot      string   // Cleaned text for fallback display
-	RawSnapshot   string   // Raw terminal output with ANSI codes for xterm.js
+	Snapshot      string   // Plain-text screen grid of the first terminal for fallback display
+	RawSnapshot   string   // ANSI repaint of the first terminal for xterm.js
 	PinCount      int      // Number of users who pinned this session
 	PinnedBy      []string // List of usernames who pinned this session
 	Helpers       []string // List of usernames who can control this session
 	PendingInvite string   // Username of pending invite recipient
+
+	AllowSpectators bool // Owner lets others watch via /ws/terminal/watch
+	Spectators      int  // Number of live spectators across all terminals
+
+	Terminals []*Terminal // Open terminals in creation order
 }
 
 // Global session manager instance
@@ -53,96 +70,143 @@ func (sm *SessionManager) Unregister(containerID string) {
 	delete(sm.sessions, containerID)
 }
 
-// StripANSI removes ANSI escape sequences and control characters from text
-func StripANSI(s string) string {
-	var result []byte
-	i := 0
-	
-	for i < len(s) {
-		c := s[i]
-		
-		// ESC character - start of escape sequence
-		if c == 0x1b {
-			i++
-			if i >= len(s) {
-				break
-			}
-			
-			nextChar := s[i]
-			
-			// CSI sequence: ESC [
-			if nextChar == '[' {
-				i++
-				// Skip until we hit a letter (end of CSI)
-				for i < len(s) {
-					if (s[i] >= 'a' && s[i] <= 'z') || (s[i] >= 'A' && s[i] <= 'Z') {
-						i++
-						break
-					}
-					i++
-				}
-				continue
-			}
-			
-			// OSC sequence: ESC ]
-			if nextChar == ']' {
-				i++
-				// Skip until BEL (0x07) or ST (ESC \)
-				for i < len(s) {
-					if s[i] == 0x07 {
-						i++
-						break
-					}
-					if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
-						i += 2
-						break
-					}
-					i++
-				}
-				continue
-			}
-			
-			// Character set switching: ESC ( X or ESC ) X (3 bytes total)
-			if nextChar == '(' || nextChar == ')' {
-				i += 2  // Skip ESC, (, and the character set designator
-				continue
+// OpenTerminal adds a terminal to the container's session, registering
+// session first if the container has none. A terminal with the same ID is
+// replaced, keeping its name when term.Name is empty. Returns true if the
+// session was newly registered.
+func (sm *SessionManager) OpenTerminal(containerID string, session *Session, term *Terminal) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	s, ok := sm.sessions[containerID]
+	if !ok {
+		s = session
+		sm.sessions[containerID] = s
+		log.Printf("📝 Session registered: %s (%s) - total: %d", s.Username, containerID[:12], len(sm.sessions))
+	}
+	if term.CreatedAt.IsZero() {
+		term.CreatedAt = time.Now()
+	}
+	for i, t := range s.Terminals {
+		if t.ID == term.ID {
+			if term.Name == "" {
+				term.Name = t.Name
 			}
-			
-			// Other escape sequences (single char after ESC)
-			i++
-			continue
+			s.Terminals[i] = term
+			return !ok
 		}
-		
-		// Skip control characters (0-31) except newline (10)
-		if c < 32 && c != '\n' {
-			i++
-			continue
+	}
+	s.Terminals = append(s.Terminals, term)
+	log.Printf("🪟 Terminal %s opened in %s - %d open", term.ID, containerID[:12], len(s.Terminals))
+	return !ok
+}
+
+// CloseTerminal removes a terminal and unregisters the session once its last
+// terminal is gone. Returns true if the session was unregistered.
+func (sm *SessionManager) CloseTerminal(containerID, terminalID string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	s, ok := sm.sessions[containerID]
+	if !ok {
+		return false
+	}
+	for i, t := range s.Terminals {
+		if t.ID == terminalID {
+			s.Terminals = append(s.Terminals[:i], s.Terminals[i+1:]...)
+			break
 		}
-		
-		// Skip DEL and high bytes
-		if c >= 127 {
-			i++
-			continue
+	}
+	if len(s.Terminals) > 0 {
+		return false
+	}
+	delete(sm.sessions, containerID)
+	log.Printf("🗑️ Session unregistered: %s (%s) - remaining: %d", s.Username, containerID[:12], len(sm.sessions))
+	return true
+}
+
+// GetTerminals returns copies of the container's open terminals
+func (sm *SessionManager) GetTerminals(containerID string) []Terminal {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	s, ok := sm.sessions[containerID]
+	if !ok {
+		return nil
+	}
+	result := make([]Terminal, 0, len(s.Terminals))
+	for _, t := range s.Terminals {
+		result = append(result, *t)
+	}
+	return result
+}
+
+// HasTerminal reports whether the container has an open terminal with this ID
+func (sm *SessionManager) HasTerminal(containerID, terminalID string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	s, ok := sm.sessions[containerID]
+	return ok && s.terminalLocked(terminalID) != nil
+}
+
+// terminalLocked finds a terminal by ID. Caller holds Sessions.mu.
+func (s *Session) terminalLocked(terminalID string) *Terminal {
+	for _, t := range s.Terminals {
+		if t.ID == terminalID {
+			return t
 		}
-		
-		// Keep printable ASCII and newline
-		result = append(result, c)
-		i++
 	}
-	
-	return string(result)
+	return nil
 }
 
-// UpdateSnapshot updates the terminal snapshot for a container
-func (sm *SessionManager) UpdateSnapshot(containerID, rawSnapshot, cleanedSnapshot string) {
+// UpdateTerminalSize records a terminal's PTY size
+func (sm *SessionManager) UpdateTerminalSize(containerID, terminalID string, cols, rows uint) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 	if s, ok := sm.sessions[containerID]; ok {
+		if t := s.terminalLocked(terminalID); t != nil {
+			t.Cols, t.Rows = cols, rows
+		}
+	}
+}
+
+// UpdateSnapshot updates a terminal's snapshot; the first terminal's also
+// becomes the session snapshot shown in the lobby
+func (sm *SessionManager) UpdateSnapshot(containerID, terminalID, rawSnapshot, cleanedSnapshot string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	s, ok := sm.sessions[containerID]
+	if !ok {
+		return
+	}
+	t := s.terminalLocked(terminalID)
+	if t == nil {
+		return
+	}
+	t.RawSnapshot = rawSnapshot
+	t.Snapshot = cleanedSnapshot
+	if s.Terminals[0] == t {
 		s.RawSnapshot = rawSnapshot
 		s.Snapshot = cleanedSnapshot
 	}
 }
 
+// UpdateSpectators records the session's spectator setting and a terminal's
+// spectator count
+func (sm *SessionManager) UpdateSpectators(containerID, terminalID string, allow bool, count int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	s, ok := sm.sessions[containerID]
+	if !ok {
+		return
+	}
+	s.AllowSpectators = allow
+	if t := s.terminalLocked(terminalID); t != nil {
+		t.Spectators = count
+	}
+	s.Spectators = 0
+	for _, t := range s.Terminals {
+		s.Spectators += t.Spectators
+	}
+}
+
 // GetAllSessions returns all active sessions
 func (sm *SessionManager) GetAllSessions() []*Session {
 	sm.mu.RLock()
@@ -199,7 +263,7 @@ func (sm *SessionManager) UnpinSession(containerID, username string) bool {
 	return false // Not pinned
 }
 
-// AddHelper adds a helper to a session
+// AddHelper adds a helper to a session. The helper must hold the session's pending invite.
 func (sm *SessionManager) AddHelper(containerID, helperUsername string) bool {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -207,6 +271,9 @@ func (sm *SessionManager) AddHelper(containerID, helperUsername string) bool {
 	if !ok {
 		return false
 	}
+	if s.PendingInvite != helperUsername {
+		return false
+	}
 	// Check if already a helper
 	for _, h := range s.Helpers {
 		if h == helperUsername {
@@ -218,8 +285,18 @@ func (sm *SessionManager) AddHelper(containerID, helperUsername string) bool {
 	return true
 }
 
-// RemoveHelper removes a helper from a session
+// RemoveHelper removes a helper from a session and disconnects them from its terminals
 func (sm *SessionManager) RemoveHelper(containerID, helperUsername string) bool {
+	if !sm.removeHelper(containerID, helperUsername) {
+		return false
+	}
+	// Outside sm.mu: hubs call into Sessions while holding their own lock
+	Hubs.DetachHelper(containerID, helperUsername)
+	return true
+}
+
+// removeHelper drops a helper from the session's list
+func (sm *SessionManager) removeHelper(containerID, helperUsername string) bool {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 	s, ok := sm.sessions[containerID]
@@ -298,11 +375,12 @@ func (sm *SessionManager) GetContainerByHelper(helperUsername string) string {
 	return ""
 }
 
-// ClearAllHelpers removes all helpers from a session
+// ClearAllHelpers removes all helpers from a session and disconnects them from its terminals
 func (sm *SessionManager) ClearAllHelpers(containerID string) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
 	if s, ok := sm.sessions[containerID]; ok {
 		s.Helpers = nil
 	}
+	sm.mu.Unlock()
+	Hubs.DetachHelper(containerID, "")
 }
@@ -0,0 +1,18 @@
+package service
+
+import "unicode/utf8"
+
+// utf8Boundary returns how much of p can be sent without splitting a
+// multi-byte UTF-8 character: everything except a trailing incomplete
+// sequence. Invalid bytes are not held back.
+func utf8Boundary(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(p)
+}
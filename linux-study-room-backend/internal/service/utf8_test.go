@@ -0,0 +1,27 @@
+package service
+
+import "testing"
+
+func TestUTF8Boundary(t *testing.T) {
+	zh := []byte("你") // e4 bd a0
+	cases := []struct {
+		in   []byte
+		want int
+	}{
+		{[]byte("abc"), 3},
+		{nil, 0},
+		{append([]byte("ab"), zh[:1]...), 2},
+		{append([]byte("ab"), zh[:2]...), 2},
+		{append([]byte("ab"), zh...), 5},
+		{[]byte{'a', 0xff}, 2},  // Invalid bytes pass through
+		{[]byte{0xbd, 0xa0}, 2}, // Stray continuation bytes too
+		{[]byte("é")[:1], 0},    // Only a lead byte
+		{[]byte("😀")[:3], 0},    // Four-byte sequence missing its last byte
+		{append(zh, []byte("😀")...), 7},
+	}
+	for _, tc := range cases {
+		if got := utf8Boundary(tc.in); got != tc.want {
+			t.Errorf("utf8Boundary(% x) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// Migration is a numbered, forward-only schema change.
+// Each migration runs in its own transaction together with its
+// schema_migrations bookkeeping row.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+// execSQL returns a migration step that runs a fixed SQL script
+func execSQL(script string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(script)
+		return err
+	}
+}
+
+// sortedMigrations returns the dialect's migrations ordered by version
+func sortedMigrations(d *dialect) []Migration {
+	sorted := make([]Migration, len(d.migrations))
+	copy(sorted, d.migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+// appliedMigrations returns version -> applied_at for recorded migrations
+func appliedMigrations(db *sql.DB, d *dialect) (map[int]string, error) {
+	if _, err := db.Exec(d.migrationsTable); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var appliedAt sql.NullString
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.String
+	}
+	return applied, rows.Err()
+}
+
+// migrationStatus lists every known migration and whether it has been applied
+func migrationStatus(db *sql.DB, d *dialect) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db, d)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, m := range sortedMigrations(d) {
+		at, ok := applied[m.Version]
+		status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: at})
+	}
+	return status, nil
+}
+
+// pendingMigrations returns migrations that have not been applied yet, in
+// order. Use it for a dry run of migrate.
+func pendingMigrations(db *sql.DB, d *dialect) ([]Migration, error) {
+	status, err := migrationStatus(db, d)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range status {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// migrate applies all pending migrations in version order and returns the
+// ones it applied. It stops at the first failure, leaving that migration
+// rolled back and later ones unapplied.
+func migrate(db *sql.DB, d *dialect) ([]Migration, error) {
+	pending, err := pendingMigrations(db, d)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range pending {
+		if err := applyMigration(db, d, m); err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// applyMigration runs one migration and records it in a single transaction
+func applyMigration(db *sql.DB, d *dialect, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(d.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate_AppliesOnceInOrder(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	d := sqliteDialect
+
+	pending, err := pendingMigrations(db, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(d.migrations) {
+		t.Fatalf("expected %d pending migrations, got %d", len(d.migrations), len(pending))
+	}
+	for i := 1; i < len(pending); i++ {
+		if pending[i].Version <= pending[i-1].Version {
+			t.Fatalf("migrations out of order: %d after %d", pending[i].Version, pending[i-1].Version)
+		}
+	}
+
+	applied, err := migrate(db, d)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if len(applied) != len(d.migrations) {
+		t.Fatalf("applied %d of %d migrations", len(applied), len(d.migrations))
+	}
+
+	applied, err = migrate(db, d)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("second Migrate applied %d, err %v", len(applied), err)
+	}
+
+	status, err := migrationStatus(db, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied {
+			t.Errorf("migration %d not applied", s.Version)
+		}
+	}
+}
+
+func TestMigrate_RollsBackFailedMigration(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "rollback.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	d := *sqliteDialect
+	d.migrations = []Migration{
+		{Version: 1, Name: "create_a", Up: execSQL("CREATE TABLE a (id INTEGER)")},
+		{Version: 2, Name: "broken", Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE b (id INTEGER)"); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		}},
+		{Version: 3, Name: "create_c", Up: execSQL("CREATE TABLE c (id INTEGER)")},
+	}
+
+	applied, err := migrate(db, &d)
+	if err == nil {
+		t.Fatal("expected failure")
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Fatalf("expected only migration 1 applied, got %+v", applied)
+	}
+
+	for table, want := range map[string]int{"a": 1, "b": 0, "c": 0} {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+		if n != want {
+			t.Errorf("table %s: exists=%d, want %d", table, n, want)
+		}
+	}
+
+	pending, _ := pendingMigrations(db, &d)
+	if len(pending) != 2 {
+		t.Errorf("expected 2 pending after failure, got %d", len(pending))
+	}
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresDialect is the PostgreSQL flavour of the schema and queries
+var postgresDialect = &dialect{
+	name:              DriverPostgres,
+	numberedParams:    true,
+	onlineSecondsExpr: "CAST(EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - last_connect)) AS BIGINT)",
+	secondsSinceExpr:  "CAST(EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - %s)) AS BIGINT)",
+	migrationsTable: `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+	// Versions mirror the SQLite migrations one-to-one
+	migrations: []Migration{
+		{Version: 1, Name: "initial_schema", Up: execSQL(postgresSchemaV1)},
+		{Version: 2, Name: "legacy_container_owners", Up: execSQL(`
+			ALTER TABLE containers ADD COLUMN IF NOT EXISTS legacy_user_id BIGINT;
+			UPDATE containers SET legacy_user_id = user_id, user_id = 0 WHERE legacy_user_id IS NULL AND user_id <> 0;
+		`)},
+		{Version: 3, Name: "container_image_version", Up: execSQL(
+			"ALTER TABLE containers ADD COLUMN IF NOT EXISTS image_version TEXT",
+		)},
+		{Version: 4, Name: "user_shell", Up: execSQL(
+			"ALTER TABLE users ADD COLUMN IF NOT EXISTS shell TEXT",
+		)},
+		{Version: 5, Name: "container_lifecycle", Up: execSQL(`
+			ALTER TABLE containers ADD COLUMN IF NOT EXISTS lifecycle_action TEXT;
+			ALTER TABLE containers ADD COLUMN IF NOT EXISTS lifecycle_at TIMESTAMPTZ;
+		`)},
+	},
+}
+
+// NewPostgresStore connects to PostgreSQL without applying migrations
+func NewPostgresStore(dsn string) (Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, d: postgresDialect}, nil
+}
+
+// postgresSchemaV1 matches sqliteSchemaV1. containers.user_id has no foreign
+// key because legacy rows use 0 until claimed.
+const postgresSchemaV1 = `
+CREATE TABLE IF NOT EXISTS users (
+	id BIGSERIAL PRIMARY KEY,
+	linuxdo_id TEXT UNIQUE,
+	username TEXT NOT NULL,
+	avatar TEXT,
+	trust_level INTEGER DEFAULT 0,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	last_seen TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS containers (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	docker_id TEXT,
+	os_type TEXT NOT NULL,
+	status TEXT DEFAULT 'stopped',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	last_active TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS chat_messages (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT REFERENCES users(id),
+	username TEXT NOT NULL,
+	avatar TEXT,
+	content TEXT NOT NULL,
+	content_type TEXT DEFAULT 'text',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_online_time (
+	id BIGSERIAL PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL,
+	avatar TEXT,
+	total_seconds BIGINT DEFAULT 0,
+	last_connect TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+`
@@ -0,0 +1,403 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect captures what differs between the SQL backends
+type dialect struct {
+	name string
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numberedParams bool
+	// expression for whole seconds elapsed since user_online_time.last_connect
+	onlineSecondsExpr string
+	// expression for whole seconds elapsed since a timestamp, %s is the column
+	secondsSinceExpr string
+	// DDL for the schema_migrations bookkeeping table
+	migrationsTable string
+	migrations      []Migration
+}
+
+// rebind rewrites ? placeholders for dialects that use numbered parameters
+func (d *dialect) rebind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// sqlStore implements Store on database/sql. Queries are written with ?
+// placeholders in the portable subset shared by SQLite and PostgreSQL.
+type sqlStore struct {
+	db *sql.DB
+	d  *dialect
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.d.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.d.rebind(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.d.rebind(query), args...)
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Close closes the underlying database
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies pending schema migrations
+func (s *sqlStore) Migrate() ([]Migration, error) {
+	return migrate(s.db, s.d)
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	return migrationStatus(s.db, s.d)
+}
+
+// PendingMigrations returns migrations that have not been applied yet
+func (s *sqlStore) PendingMigrations() ([]Migration, error) {
+	return pendingMigrations(s.db, s.d)
+}
+
+// CreateUser inserts a new user
+func (s *sqlStore) CreateUser(user *User) error {
+	return s.queryRow(
+		"INSERT INTO users (linuxdo_id, username, avatar, trust_level) VALUES (?, ?, ?, ?) RETURNING id",
+		user.LinuxDoID, user.Username, user.Avatar, user.TrustLevel,
+	).Scan(&user.ID)
+}
+
+// GetUserByLinuxDoID finds user by LinuxDo ID
+func (s *sqlStore) GetUserByLinuxDoID(linuxdoID string) (*User, error) {
+	user := &User{}
+	var avatar sql.NullString
+	err := s.queryRow(
+		"SELECT id, linuxdo_id, username, avatar, trust_level, COALESCE(shell, '') FROM users WHERE linuxdo_id = ?",
+		linuxdoID,
+	).Scan(&user.ID, &user.LinuxDoID, &user.Username, &avatar, &user.TrustLevel, &user.Shell)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	user.Avatar = avatar.String
+	return user, nil
+}
+
+// GetUserShell returns the user's preferred login shell, empty if unset
+func (s *sqlStore) GetUserShell(userID int64) (string, error) {
+	var shell sql.NullString
+	if err := s.queryRow("SELECT shell FROM users WHERE id = ?", userID).Scan(&shell); err != nil {
+		return "", notFound(err)
+	}
+	return shell.String, nil
+}
+
+// SetUserShell stores the user's preferred login shell; empty clears it
+func (s *sqlStore) SetUserShell(userID int64, shell string) error {
+	result, err := s.exec("UPDATE users SET shell = ? WHERE id = ?", sql.NullString{String: shell, Valid: shell != ""}, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpsertUser inserts or refreshes a user keyed by LinuxDo ID and sets user.ID
+func (s *sqlStore) UpsertUser(user *User) error {
+	return s.queryRow(`
+		INSERT INTO users (linuxdo_id, username, avatar, trust_level, last_seen)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(linuxdo_id) DO UPDATE SET
+			username = excluded.username,
+			avatar = excluded.avatar,
+			trust_level = excluded.trust_level,
+			last_seen = CURRENT_TIMESTAMP
+		RETURNING id
+	`, user.LinuxDoID, user.Username, user.Avatar, user.TrustLevel).Scan(&user.ID)
+}
+
+// ListLegacyContainers returns the unowned containers recorded under a username hash
+func (s *sqlStore) ListLegacyContainers(legacyUserID int64) ([]Container, error) {
+	rows, err := s.query(
+		"SELECT id, user_id, COALESCE(docker_id, ''), os_type, COALESCE(status, ''), COALESCE(image_version, '') FROM containers WHERE user_id = 0 AND legacy_user_id = ? ORDER BY id",
+		legacyUserID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var containers []Container
+	for rows.Next() {
+		var c Container
+		if err := rows.Scan(&c.ID, &c.UserID, &c.DockerID, &c.OSType, &c.Status, &c.ImageVersion); err != nil {
+			return nil, err
+		}
+		containers = append(containers, c)
+	}
+	return containers, rows.Err()
+}
+
+// ClaimLegacyContainer assigns an unowned legacy container to a real user.
+// It reports false if the row was already claimed.
+func (s *sqlStore) ClaimLegacyContainer(id, userID int64) (bool, error) {
+	result, err := s.exec(
+		"UPDATE containers SET user_id = ?, legacy_user_id = NULL WHERE id = ? AND user_id = 0",
+		userID, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteUser removes a user and their container records. Chat messages are
+// kept but no longer point at the user.
+func (s *sqlStore) DeleteUser(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.d.rebind("DELETE FROM containers WHERE user_id = ?"), id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.d.rebind("UPDATE chat_messages SET user_id = NULL WHERE user_id = ?"), id); err != nil {
+		return err
+	}
+	result, err := tx.Exec(s.d.rebind("DELETE FROM users WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// GetContainerByUserID finds container by user ID
+func (s *sqlStore) GetContainerByUserID(userID int64) (*Container, error) {
+	container := &Container{}
+	err := s.queryRow(
+		"SELECT id, user_id, docker_id, os_type, status, COALESCE(image_version, '') FROM containers WHERE user_id = ? ORDER BY id DESC LIMIT 1",
+		userID,
+	).Scan(&container.ID, &container.UserID, &container.DockerID, &container.OSType, &container.Status, &container.ImageVersion)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return container, nil
+}
+
+// GetContainerByDockerID finds container by Docker ID
+func (s *sqlStore) GetContainerByDockerID(dockerID string) (*Container, error) {
+	container := &Container{}
+	err := s.queryRow(
+		"SELECT id, user_id, docker_id, os_type, status, COALESCE(image_version, '') FROM containers WHERE docker_id = ? ORDER BY id DESC LIMIT 1",
+		dockerID,
+	).Scan(&container.ID, &container.UserID, &container.DockerID, &container.OSType, &container.Status, &container.ImageVersion)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return container, nil
+}
+
+// CreateContainer inserts a new container record
+func (s *sqlStore) CreateContainer(container *Container) error {
+	return s.queryRow(
+		"INSERT INTO containers (user_id, docker_id, os_type, status, image_version) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		container.UserID, container.DockerID, container.OSType, container.Status, container.ImageVersion,
+	).Scan(&container.ID)
+}
+
+// UpdateContainerStatus updates container status
+func (s *sqlStore) UpdateContainerStatus(id int64, status, dockerID string) error {
+	_, err := s.exec(
+		"UPDATE containers SET status = ?, docker_id = ?, last_active = CURRENT_TIMESTAMP WHERE id = ?",
+		status, dockerID, id,
+	)
+	return err
+}
+
+// UpdateContainerImage records the image a container record now runs
+func (s *sqlStore) UpdateContainerImage(id int64, osType, imageVersion string) error {
+	_, err := s.exec(
+		"UPDATE containers SET os_type = ?, image_version = ? WHERE id = ?",
+		osType, imageVersion, id,
+	)
+	return err
+}
+
+// UpdateContainerStatusByDockerID records the status Docker reports for a
+// container, found by docker_id. It leaves last_active alone, so a status
+// change never counts as use.
+func (s *sqlStore) UpdateContainerStatusByDockerID(dockerID, status string) error {
+	_, err := s.exec("UPDATE containers SET status = ? WHERE docker_id = ?", status, dockerID)
+	return err
+}
+
+// TouchContainer marks a container as used now without changing its status
+func (s *sqlStore) TouchContainer(dockerID string) error {
+	_, err := s.exec("UPDATE containers SET last_active = CURRENT_TIMESTAMP WHERE docker_id = ?", dockerID)
+	return err
+}
+
+// RecordContainerAction sets a container's status and records the lifecycle
+// action that changed it. last_active is left alone so actions don't count as use.
+func (s *sqlStore) RecordContainerAction(dockerID, status, action string) error {
+	_, err := s.exec(
+		"UPDATE containers SET status = ?, lifecycle_action = ?, lifecycle_at = CURRENT_TIMESTAMP WHERE docker_id = ?",
+		status, action, dockerID,
+	)
+	return err
+}
+
+// ListContainerActivity returns the containers of real users that haven't
+// been removed, with how long ago they were last used
+func (s *sqlStore) ListContainerActivity() ([]ContainerActivity, error) {
+	rows, err := s.query(`
+		SELECT c.id, c.user_id, c.docker_id, c.os_type, c.status, COALESCE(c.image_version, ''),
+			u.username, COALESCE(u.trust_level, 0), COALESCE(c.lifecycle_action, ''),
+			` + fmt.Sprintf(s.d.secondsSinceExpr, "COALESCE(c.last_active, c.created_at)") + `,
+			COALESCE(` + fmt.Sprintf(s.d.secondsSinceExpr, "c.lifecycle_at") + `, -1)
+		FROM containers c JOIN users u ON u.id = c.user_id
+		WHERE COALESCE(c.docker_id, '') <> '' AND COALESCE(c.status, '') <> 'removed'
+		ORDER BY c.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ContainerActivity
+	for rows.Next() {
+		var a ContainerActivity
+		if err := rows.Scan(&a.ID, &a.UserID, &a.DockerID, &a.OSType, &a.Status, &a.ImageVersion,
+			&a.Username, &a.TrustLevel, &a.LifecycleAction, &a.IdleSeconds, &a.ActionSeconds); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// SaveChatMessage saves a chat message to the database
+func (s *sqlStore) SaveChatMessage(username, avatar, content, contentType string) error {
+	_, err := s.exec(
+		"INSERT INTO chat_messages (username, avatar, content, content_type) VALUES (?, ?, ?, ?)",
+		username, avatar, content, contentType,
+	)
+	return err
+}
+
+// GetRecentMessages retrieves the most recent messages
+func (s *sqlStore) GetRecentMessages(limit int) ([]ChatMessage, error) {
+	rows, err := s.query(
+		"SELECT id, username, avatar, content, content_type, created_at FROM chat_messages ORDER BY id DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ChatMessage
+	for rows.Next() {
+		var msg ChatMessage
+		var avatar sql.NullString
+		if err := rows.Scan(&msg.ID, &msg.Username, &avatar, &msg.Content, &msg.ContentType, &msg.CreatedAt); err != nil {
+			continue
+		}
+		if avatar.Valid {
+			msg.Avatar = avatar.String
+		}
+		messages = append(messages, msg)
+	}
+
+	// Reverse to get chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// RecordConnect records when a user connects
+func (s *sqlStore) RecordConnect(username, avatar string) error {
+	_, err := s.exec(`
+		INSERT INTO user_online_time (username, avatar, last_connect, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(username) DO UPDATE SET
+			avatar = excluded.avatar,
+			last_connect = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+	`, username, avatar)
+	return err
+}
+
+// RecordDisconnect calculates and adds online time when user disconnects
+func (s *sqlStore) RecordDisconnect(username string) error {
+	_, err := s.exec(`
+		UPDATE user_online_time
+		SET total_seconds = total_seconds + `+s.d.onlineSecondsExpr+`,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE username = ? AND last_connect IS NOT NULL
+	`, username)
+	return err
+}
+
+// GetOnlineLeaderboard returns top users by online time
+func (s *sqlStore) GetOnlineLeaderboard(limit int) ([]UserOnlineTime, error) {
+	rows, err := s.query(
+		"SELECT id, username, avatar, total_seconds FROM user_online_time ORDER BY total_seconds DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leaderboard []UserOnlineTime
+	for rows.Next() {
+		var user UserOnlineTime
+		var avatar sql.NullString
+		if err := rows.Scan(&user.ID, &user.Username, &avatar, &user.TotalSeconds); err != nil {
+			continue
+		}
+		if avatar.Valid {
+			user.Avatar = avatar.String
+		}
+		leaderboard = append(leaderboard, user)
+	}
+
+	return leaderboard, nil
+}
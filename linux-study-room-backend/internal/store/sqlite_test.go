@@ -0,0 +1,83 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteLegacyContainerMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	// Simulate a database written before real user IDs existed
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(`CREATE TABLE containers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		docker_id TEXT,
+		os_type TEXT NOT NULL,
+		status TEXT DEFAULT 'stopped',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_active DATETIME
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	aliceHash := LegacyUserID("alice")
+	if _, err := legacy.Exec("INSERT INTO containers (user_id, docker_id, os_type) VALUES (?, 'docker-alice', 'alpine'), (?, 'docker-bob', 'debian')",
+		aliceHash, LegacyUserID("bob")); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	s, err := Init(DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	defer s.Close()
+
+	// Hash IDs must no longer look like real user IDs
+	if _, err := s.GetContainerByUserID(aliceHash); err == nil {
+		t.Fatal("legacy container still owned by hash ID")
+	}
+
+	user := &User{LinuxDoID: "7", Username: "alice"}
+	if err := s.UpsertUser(user); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.ListLegacyContainers(aliceHash)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("ListLegacyContainers = %+v, %v", list, err)
+	}
+	if ok, err := s.ClaimLegacyContainer(list[0].ID, user.ID); err != nil || !ok {
+		t.Fatalf("ClaimLegacyContainer = %v, %v", ok, err)
+	}
+	c, err := s.GetContainerByUserID(user.ID)
+	if err != nil || c.DockerID != "docker-alice" {
+		t.Fatalf("claimed container = %+v, %v", c, err)
+	}
+
+	// Opening again must not touch migrated rows
+	s2, err := Init(DriverSQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if c, err := s2.GetContainerByUserID(user.ID); err != nil || c.DockerID != "docker-alice" {
+		t.Fatalf("re-init changed ownership: %+v, %v", c, err)
+	}
+}
+
+func TestSQLiteStore_WaitsForLocks(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var timeout int
+	if err := s.(*sqlStore).queryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil || timeout != 5000 {
+		t.Fatalf("busy_timeout = %d, %v", timeout, err)
+	}
+}
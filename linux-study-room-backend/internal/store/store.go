@@ -0,0 +1,154 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrNotFound is returned when a lookup matches no row
+var ErrNotFound = errors.New("not found")
+
+// Store is the persistence layer used by handlers and services.
+// SQLite and PostgreSQL implementations are provided; pick one with Open.
+type Store interface {
+	// Users
+	CreateUser(user *User) error
+	UpsertUser(user *User) error
+	GetUserByLinuxDoID(linuxdoID string) (*User, error)
+	ListLegacyContainers(legacyUserID int64) ([]Container, error)
+	ClaimLegacyContainer(id, userID int64) (bool, error)
+	DeleteUser(id int64) error
+	GetUserShell(userID int64) (string, error)
+	SetUserShell(userID int64, shell string) error
+
+	// Containers
+	GetContainerByUserID(userID int64) (*Container, error)
+	GetContainerByDockerID(dockerID string) (*Container, error)
+	CreateContainer(container *Container) error
+	UpdateContainerStatus(id int64, status, dockerID string) error
+	UpdateContainerImage(id int64, osType, imageVersion string) error
+	UpdateContainerStatusByDockerID(dockerID, status string) error
+	TouchContainer(dockerID string) error
+	RecordContainerAction(dockerID, status, action string) error
+	ListContainerActivity() ([]ContainerActivity, error)
+
+	// Chat
+	SaveChatMessage(username, avatar, content, contentType string) error
+	GetRecentMessages(limit int) ([]ChatMessage, error)
+
+	// Online time
+	RecordConnect(username, avatar string) error
+	RecordDisconnect(username string) error
+	GetOnlineLeaderboard(limit int) ([]UserOnlineTime, error)
+
+	// Schema migrations
+	Migrate() ([]Migration, error)
+	MigrationStatus() ([]MigrationStatus, error)
+	PendingMigrations() ([]Migration, error)
+
+	Close() error
+}
+
+// Supported DB_DRIVER values
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Open connects to the database selected by driver without migrating it.
+// For sqlite the DSN is a file path; for postgres it is a libpq connection string or URL.
+func Open(driver, dsn string) (Store, error) {
+	switch driver {
+	case DriverSQLite, "":
+		return NewSQLiteStore(dsn)
+	case DriverPostgres, "postgresql", "pgx":
+		return NewPostgresStore(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q (want %q or %q)", driver, DriverSQLite, DriverPostgres)
+	}
+}
+
+// Init opens the database and applies pending schema migrations
+func Init(driver, dsn string) (Store, error) {
+	s, err := Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.Migrate()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	for _, m := range applied {
+		log.Printf("📦 Applied migration %04d_%s", m.Version, m.Name)
+	}
+
+	log.Printf("✅ Database initialized (%s)", driver)
+	return s, nil
+}
+
+// User represents a user in the database
+type User struct {
+	ID         int64
+	LinuxDoID  string
+	Username   string
+	Avatar     string
+	TrustLevel int
+	Shell      string // Preferred login shell, empty for the image default
+}
+
+// Container represents a user's container
+type Container struct {
+	ID       int64
+	UserID   int64
+	DockerID string
+	OSType   string
+	Status   string
+	// ImageVersion is the catalog image version the container was created from
+	ImageVersion string
+}
+
+// ContainerActivity is a container with its owner and how long ago it was
+// last used, for the lifecycle policy
+type ContainerActivity struct {
+	Container
+	Username   string
+	TrustLevel int
+	// LifecycleAction is the last action recorded by RecordContainerAction
+	LifecycleAction string
+	IdleSeconds     int64 // Since last_active, or created_at if never used
+	ActionSeconds   int64 // Since LifecycleAction, -1 if none was recorded
+}
+
+// ChatMessage represents a chat message
+type ChatMessage struct {
+	ID          int64
+	Username    string
+	Avatar      string
+	Content     string
+	ContentType string
+	CreatedAt   string
+}
+
+// UserOnlineTime represents a user's online time record
+type UserOnlineTime struct {
+	ID           int64
+	Username     string
+	Avatar       string
+	TotalSeconds int64
+}
+
+// LegacyUserID returns the username hash that containers.user_id held before
+// real user records existed. Only used to migrate old rows.
+func LegacyUserID(username string) int64 {
+	var userID int64
+	for _, b := range username {
+		userID = userID*31 + int64(b)
+	}
+	if userID < 0 {
+		userID = -userID
+	}
+	return userID % 1000000
+}
@@ -2,6 +2,20 @@
 const API_BASE = 'https://64b1f537d0ed56f536980d2b789fc1c5fb663308-18080.dstack-pha-prod7.phala.network';
 const WS_BASE = 'wss://64b1f537d0ed56f536980d2b789fc1c5fb663308-18080.dstack-pha-prod7.phala.network';
 
+// JWT issued by the backend OAuth callback (stored by App.vue)
+function getToken(): string {
+    return localStorage.getItem('lsr_token') || '';
+}
+
+function authHeaders(extra: Record<string, string> = {}): Record<string, string> {
+    return { ...extra, 'Authorization': `Bearer ${getToken()}` };
+}
+
+// Browsers can't set headers on WebSocket requests, so the token rides in the subprotocol list
+function authSocket(url: string): WebSocket {
+    return new WebSocket(url, ['bearer', getToken()]);
+}
+
 // Auth API
 export const authApi = {
     // Get login URL
@@ -10,55 +24,428 @@ export const authApi = {
     },
 
     // Get current user info from token
-    async me(token: string) {
+    async me(token: string): Promise<CurrentUser> {
         const res = await fetch(`${API_BASE}/api/auth/me`, {
             headers: { 'Authorization': `Bearer ${token}` }
         });
         if (!res.ok) throw new Error('Unauthorized');
         return res.json();
+    },
+
+    // Login shell preference; applies to terminals opened afterwards
+    async getShell(): Promise<ShellPreference> {
+        const res = await fetch(`${API_BASE}/api/auth/me/shell`, {
+            headers: authHeaders()
+        });
+        return res.json();
+    },
+
+    async setShell(shell: string): Promise<ShellPreference> {
+        const res = await fetch(`${API_BASE}/api/auth/me/shell`, {
+            method: 'PUT',
+            headers: authHeaders({ 'Content-Type': 'application/json' }),
+            body: JSON.stringify({ shell })
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to set shell');
+        return data;
     }
 };
 
+export interface QueueStatus {
+    state: 'none' | 'queued' | 'ready';
+    position?: number;       // 1 is next
+    length: number;
+    estimated_wait?: number; // Seconds
+}
+
+export interface CurrentUser {
+    id: number;             // LinuxDo user ID
+    user_id: number;
+    username: string;
+    name: string;
+    avatar: string;
+    trust_level: number;
+    admin: boolean;
+    quota: QuotaTier;
+}
+
+// Resource limits of a trust level tier
+export interface QuotaTier {
+    trust_level: number;
+    name: string;
+    memory_mb: number;
+    cpus: number;
+    pids_limit: number;
+    disk_mb: number;         // 0 means unlimited
+    checkpoints: number;
+    session_minutes: number; // 0 means unlimited
+    images?: string[];       // Absent means every image
+}
+
+export interface ShellPreference {
+    shell: string;          // Empty means the image default
+    shells: string[];       // Every selectable shell
+    image?: string;         // Image of the current container
+    installed?: string[];   // Shells installed in that image
+    effective?: string;     // Shell new terminals will run
+}
+
 // Container API
 export const containerApi = {
-    async check(username: string) {
+    // Identity comes from the token; username is kept for call-site compatibility
+    async check(_username?: string) {
         const res = await fetch(`${API_BASE}/api/container/check`, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ username })
+            headers: authHeaders()
         });
         return res.json();
     },
 
-    async launch(osType: 'alpine' | 'debian', username?: string) {
+    async launch(osType: string, _username?: string) {
         const res = await fetch(`${API_BASE}/api/container/launch`, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ os_type: osType, username: username || '' })
+            headers: authHeaders({ 'Content-Type': 'application/json' }),
+            body: JSON.stringify({ os_type: osType })
+        });
+        return res.json();
+    },
+
+    // Launch, waiting in the queue while the host is full. onQueue sees each
+    // position update; the returned promise resolves with the launch result.
+    async launchWhenReady(osType: string, onQueue?: (status: QueueStatus) => void) {
+        const result = await this.launch(osType);
+        if (!result.queued) return result;
+        onQueue?.(result.queue);
+        await new Promise<void>((resolve, reject) => {
+            const ws = authSocket(`${WS_BASE}/ws/queue`);
+            ws.onmessage = (event) => {
+                const status: QueueStatus = JSON.parse(event.data);
+                onQueue?.(status);
+                if (status.state === 'ready') {
+                    ws.close();
+                    resolve();
+                }
+            };
+            ws.onerror = () => reject(new Error('Lost connection to the launch queue'));
+        });
+        return this.launch(osType);
+    },
+
+    async leaveQueue() {
+        const res = await fetch(`${API_BASE}/api/container/queue`, {
+            method: 'DELETE',
+            headers: authHeaders()
         });
         return res.json();
     },
 
     async restart(containerId: string) {
         const res = await fetch(`${API_BASE}/api/container/${containerId}/restart`, {
-            method: 'POST'
+            method: 'POST',
+            headers: authHeaders()
         });
         return res.json();
     },
 
     async reset(containerId: string) {
         const res = await fetch(`${API_BASE}/api/container/${containerId}/reset`, {
-            method: 'POST'
+            method: 'POST',
+            headers: authHeaders()
+        });
+        return res.json();
+    },
+
+    // Persistent home volume: kept across reset and OS switches
+    async homeUsage(): Promise<HomeUsage> {
+        const res = await fetch(`${API_BASE}/api/container/home`, {
+            headers: authHeaders()
         });
         return res.json();
     },
 
+    async wipeHome() {
+        const res = await fetch(`${API_BASE}/api/container/home`, {
+            method: 'DELETE',
+            headers: authHeaders()
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to wipe home');
+        return data;
+    },
+
     async status(containerId: string) {
-        const res = await fetch(`${API_BASE}/api/container/${containerId}/status`);
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/status`, {
+            headers: authHeaders()
+        });
+        return res.json();
+    },
+
+    // Terminal tabs: each is its own shell inside the container
+    async listTerminals(containerId: string): Promise<{ terminals: TerminalTab[] }> {
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/terminals`, {
+            headers: authHeaders()
+        });
+        return res.json();
+    },
+
+    async createTerminal(containerId: string, name = ''): Promise<TerminalTab> {
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/terminals`, {
+            method: 'POST',
+            headers: authHeaders({ 'Content-Type': 'application/json' }),
+            body: JSON.stringify({ name })
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to open terminal');
+        return data;
+    },
+
+    async closeTerminal(containerId: string, terminalId: string) {
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/terminals/${terminalId}`, {
+            method: 'DELETE',
+            headers: authHeaders()
+        });
+        return res.json();
+    }
+};
+
+// Files in the container's home directory; paths are relative to it
+export const fileApi = {
+    async list(containerId: string, path = ''): Promise<{ path: string; entries: FileEntry[] }> {
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/files?path=${encodeURIComponent(path)}`, {
+            headers: authHeaders()
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to list files');
+        return data;
+    },
+
+    async download(containerId: string, path: string, format: '' | 'raw' | 'tar' | 'zip' = ''): Promise<Blob> {
+        const query = `path=${encodeURIComponent(path)}${format ? `&format=${format}` : ''}`;
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/files/download?${query}`, {
+            headers: authHeaders()
+        });
+        if (!res.ok) throw new Error((await res.json()).error || 'Download failed');
+        return res.blob();
+    },
+
+    async upload(containerId: string, path: string, files: File[]) {
+        const form = new FormData();
+        files.forEach(f => form.append('file', f));
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/files?path=${encodeURIComponent(path)}`, {
+            method: 'POST',
+            headers: authHeaders(),
+            body: form
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Upload failed');
+        return data as { path: string; uploaded: string[] };
+    }
+};
+
+export interface FileEntry {
+    name: string;
+    type: 'file' | 'dir' | 'symlink' | 'other';
+    size: number;
+    mode: string;
+    mod_time: string;
+}
+
+// Checkpoints: saved copies of the container filesystem
+export const checkpointApi = {
+    async list(): Promise<{ checkpoints: Checkpoint[]; limit: number }> {
+        const res = await fetch(`${API_BASE}/api/checkpoints`, {
+            headers: authHeaders()
+        });
+        return res.json();
+    },
+
+    async create(containerId: string, name = ''): Promise<Checkpoint> {
+        const res = await fetch(`${API_BASE}/api/container/${containerId}/checkpoints`, {
+            method: 'POST',
+            headers: authHeaders({ 'Content-Type': 'application/json' }),
+            body: JSON.stringify({ name })
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to save checkpoint');
+        return data;
+    },
+
+    async restore(name: string): Promise<{ container_id: string; os_type: string; checkpoint: string }> {
+        const res = await fetch(`${API_BASE}/api/checkpoints/${encodeURIComponent(name)}/restore`, {
+            method: 'POST',
+            headers: authHeaders()
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to restore checkpoint');
+        return data;
+    },
+
+    async remove(name: string) {
+        const res = await fetch(`${API_BASE}/api/checkpoints/${encodeURIComponent(name)}`, {
+            method: 'DELETE',
+            headers: authHeaders()
+        });
+        return res.json();
+    }
+};
+
+// Image catalog: which systems can be launched
+export const imageApi = {
+    async list(): Promise<{ images: ImageInfo[] }> {
+        const res = await fetch(`${API_BASE}/api/images`, {
+            headers: authHeaders()
+        });
+        return res.json();
+    },
+
+    // Admin only (ADMIN_USERS): rebuild images, reporting each NDJSON progress event
+    async rebuild(onEvent: (ev: BuildEvent) => void, images: string[] = [], force = true) {
+        const res = await fetch(`${API_BASE}/api/admin/images/rebuild`, {
+            method: 'POST',
+            headers: authHeaders({ 'Content-Type': 'application/json' }),
+            body: JSON.stringify({ images, force })
+        });
+        if (!res.ok || !res.body) {
+            const data = await res.json().catch(() => ({}));
+            throw new Error(data.error || 'Failed to rebuild images');
+        }
+        const reader = res.body.getReader();
+        const decoder = new TextDecoder();
+        let buffered = '';
+        for (;;) {
+            const { done, value } = await reader.read();
+            if (done) break;
+            buffered += decoder.decode(value, { stream: true });
+            const lines = buffered.split('\n');
+            buffered = lines.pop() || '';
+            for (const line of lines) {
+                if (line.trim()) onEvent(JSON.parse(line));
+            }
+        }
+    },
+
+    async builds(): Promise<{ building: boolean; builds: BuildResult[] }> {
+        const res = await fetch(`${API_BASE}/api/admin/images/builds`, {
+            headers: authHeaders()
+        });
+        return res.json();
+    }
+};
+
+export interface BuildEvent {
+    image?: string;
+    status: 'started' | 'running' | 'cached' | 'built' | 'failed' | 'done';
+    stream?: string;
+    version?: string;
+    error?: string;
+    results?: { image: string; status: string; version?: string; error?: string }[];
+}
+
+export interface BuildResult {
+    image: string;
+    tag: string;
+    status: string;
+    version?: string;
+    error?: string;
+    log: string;
+    started_at: string;
+    finished_at: string;
+}
+
+export interface ImageInfo {
+    id: string;
+    name: string;
+    description?: string;
+    shell: string;
+    shells: string[];
+    min_trust_level: number;
+    allowed: boolean;
+    version?: string;
+}
+
+export interface Checkpoint {
+    name: string;
+    os_type: string;
+    size: number;
+    created_at: string;
+    image_version?: string;
+}
+
+export interface HomeUsage {
+    volume: string;
+    path: string;
+    size: number;
+    quota: number;
+    over_quota: boolean;
+}
+
+export interface TerminalTab {
+    id: string;
+    name: string;
+    cols: number;
+    rows: number;
+    spectators: number;
+    created_at: string;
+    resume_token?: string;
+}
+
+// Terminal recordings (asciicast v2, playable with asciinema)
+export interface Recording {
+    id: string;
+    title: string;
+    width: number;
+    height: number;
+    created_at: string;
+    duration: number;
+    size: number;
+}
+
+export const recordingApi = {
+    async list(): Promise<{ recordings: Recording[] }> {
+        const res = await fetch(`${API_BASE}/api/recordings`, {
+            headers: authHeaders()
+        });
+        return res.json();
+    },
+
+    async download(id: string): Promise<Blob> {
+        const res = await fetch(`${API_BASE}/api/recordings/${id}`, {
+            headers: authHeaders()
+        });
+        if (!res.ok) throw new Error('Recording not found');
+        return res.blob();
+    },
+
+    async remove(id: string) {
+        const res = await fetch(`${API_BASE}/api/recordings/${id}`, {
+            method: 'DELETE',
+            headers: authHeaders()
+        });
         return res.json();
     }
 };
 
+// Playback WebSocket: streams a recording in the terminal message format
+export function createPlaybackSocket(id: string, speed: number, handlers: {
+    onOutput: (data: string) => void;
+    onResize?: (cols: number, rows: number) => void;
+    onEnd?: () => void;
+}) {
+    const ws = authSocket(`${WS_BASE}/ws/recordings/${id}/play?speed=${speed}`);
+    ws.onmessage = (event) => {
+        const msg = JSON.parse(event.data);
+        if (msg.type === 'output') {
+            handlers.onOutput(msg.data);
+        } else if (msg.type === 'resize') {
+            handlers.onResize?.(msg.cols, msg.rows);
+        } else if (msg.type === 'status' && msg.data === 'ended') {
+            handlers.onEnd?.();
+        }
+    };
+    return { close: () => ws.close() };
+}
+
 // Leaderboard API
 export const leaderboardApi = {
     async getLeaderboard() {
@@ -67,17 +454,90 @@ export const leaderboardApi = {
     }
 };
 
+// Resume state for a container's terminal, kept per browser tab so a dropped
+// connection can reattach to the same shell and replay what it missed
+interface TerminalResume {
+    token: string;
+    seq: number;
+}
+
+function loadResume(containerId: string, terminal: string): TerminalResume | null {
+    try {
+        return JSON.parse(sessionStorage.getItem(`lsr-resume-${containerId}-${terminal}`) || 'null');
+    } catch {
+        return null;
+    }
+}
+
+function saveResume(containerId: string, terminal: string, resume: TerminalResume) {
+    sessionStorage.setItem(`lsr-resume-${containerId}-${terminal}`, JSON.stringify(resume));
+}
+
+// Binary terminal protocol (v2): one opcode byte, then the payload
+const TERMINAL_PROTOCOL_V2 = 'lsr.terminal.v2';
+const OP_OUTPUT = 0x01; // 8-byte seq + UTF-8 output
+const OP_INPUT = 0x02;
+const OP_RESIZE = 0x03; // uint16 cols, uint16 rows
+const OP_STATUS = 0x04; // JSON message
+const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder();
+
+function frame(op: number, payload: Uint8Array): Uint8Array {
+    const out = new Uint8Array(payload.length + 1);
+    out[0] = op;
+    out.set(payload, 1);
+    return out;
+}
+
 // Terminal WebSocket
-export function createTerminalSocket(containerId: string, username: string, os: string, handlers: {
+// username/name/avatar are resolved server-side from the token
+export function createTerminalSocket(containerId: string, _username: string, os: string, handlers: {
     onOpen?: () => void;
     onOutput: (data: string) => void;
     onStatus: (status: string) => void;
+    onSpectators?: (allow: boolean, count: number) => void;
+    onSession?: () => void;
+    onRecord?: (recordingId: string) => void;
     onError: (error: Event) => void;
     onClose?: () => void;
-}, name?: string, avatar?: string) {
-    const wsUrl = `${WS_BASE}/ws/terminal?container_id=${containerId}&username=${encodeURIComponent(username)}&os=${encodeURIComponent(os)}&name=${encodeURIComponent(name || username)}&avatar=${encodeURIComponent(avatar || '')}`;
-    const ws = new WebSocket(wsUrl);
+}, _name?: string, _avatar?: string, opts: { terminal?: string; resumeToken?: string } = {}) {
+    const terminal = opts.terminal || 'main';
+    let wsUrl = `${WS_BASE}/ws/terminal?container_id=${containerId}&os=${encodeURIComponent(os)}&terminal=${encodeURIComponent(terminal)}`;
+    // Fall back to the token from the terminal list when this tab never attached
+    const stored = loadResume(containerId, terminal);
+    const resume = stored && (!opts.resumeToken || stored.token === opts.resumeToken)
+        ? stored
+        : opts.resumeToken ? { token: opts.resumeToken, seq: 0 } : null;
+    if (resume) {
+        wsUrl += `&resume_token=${encodeURIComponent(resume.token)}&last_seq=${resume.seq}`;
+    }
+    // Prefer the binary v2 protocol; old servers fall back to JSON
+    const ws = new WebSocket(wsUrl, [TERMINAL_PROTOCOL_V2, 'bearer', getToken()]);
+    ws.binaryType = 'arraybuffer';
     let isOpen = false;
+    let current: TerminalResume | null = null;
+    const binary = () => ws.protocol === TERMINAL_PROTOCOL_V2;
+
+    const handleMessage = (msg: { type: string; data?: string; seq?: number; count?: number }) => {
+        if (msg.type === 'output') {
+            handlers.onOutput(msg.data || '');
+            if (current && msg.seq) {
+                current.seq = msg.seq;
+                saveResume(containerId, terminal, current);
+            }
+        } else if (msg.type === 'session') {
+            // A different token means the server started a fresh shell
+            current = resume && resume.token === msg.data ? resume : { token: msg.data || '', seq: 0 };
+            saveResume(containerId, terminal, current);
+            handlers.onSession?.();
+        } else if (msg.type === 'record') {
+            handlers.onRecord?.(msg.data || '');
+        } else if (msg.type === 'status') {
+            handlers.onStatus(msg.data || '');
+        } else if (msg.type === 'spectators') {
+            handlers.onSpectators?.(msg.data === 'on', msg.count || 0);
+        }
+    };
 
     ws.onopen = () => {
         isOpen = true;
@@ -85,13 +545,18 @@ export function createTerminalSocket(containerId: string, username: string, os:
     };
 
     ws.onmessage = (event) => {
-        try {
-            const msg = JSON.parse(event.data);
-            if (msg.type === 'output') {
-                handlers.onOutput(msg.data);
-            } else if (msg.type === 'status') {
-                handlers.onStatus(msg.data);
+        if (event.data instanceof ArrayBuffer) {
+            const bytes = new Uint8Array(event.data);
+            if (bytes[0] === OP_OUTPUT) {
+                const seq = Number(new DataView(event.data).getBigUint64(1));
+                handleMessage({ type: 'output', data: textDecoder.decode(bytes.subarray(9)), seq });
+            } else if (bytes[0] === OP_STATUS) {
+                handleMessage(JSON.parse(textDecoder.decode(bytes.subarray(1))));
             }
+            return;
+        }
+        try {
+            handleMessage(JSON.parse(event.data));
         } catch {
             // Raw data, treat as output
             handlers.onOutput(event.data);
@@ -104,22 +569,76 @@ export function createTerminalSocket(containerId: string, username: string, os:
         handlers.onClose?.();
     };
 
+    // Control messages are JSON in v1 and JSON behind OP_STATUS in v2
+    const sendControl = (msg: object) => {
+        if (!isOpen || ws.readyState !== WebSocket.OPEN) return;
+        if (binary()) {
+            ws.send(frame(OP_STATUS, textEncoder.encode(JSON.stringify(msg))));
+        } else {
+            ws.send(JSON.stringify(msg));
+        }
+    };
+
     return {
         send: (data: string) => {
-            if (isOpen && ws.readyState === WebSocket.OPEN) {
+            if (!isOpen || ws.readyState !== WebSocket.OPEN) return;
+            if (binary()) {
+                ws.send(frame(OP_INPUT, textEncoder.encode(data)));
+            } else {
                 ws.send(JSON.stringify({ type: 'input', data }));
             }
         },
         resize: (cols: number, rows: number) => {
-            if (isOpen && ws.readyState === WebSocket.OPEN) {
+            if (!isOpen || ws.readyState !== WebSocket.OPEN) return;
+            if (binary()) {
+                const payload = new Uint8Array(4);
+                new DataView(payload.buffer).setUint16(0, cols);
+                new DataView(payload.buffer).setUint16(2, rows);
+                ws.send(frame(OP_RESIZE, payload));
+            } else {
                 ws.send(JSON.stringify({ type: 'resize', cols, rows }));
             }
         },
+        // Allow or forbid read-only spectators
+        setSpectators: (allow: boolean) => {
+            sendControl({ type: 'spectators', data: allow ? 'on' : 'off' });
+        },
+        // Start ("on", or "input" to include keystrokes) or stop ("off") recording
+        setRecording: (mode: 'on' | 'input' | 'off') => {
+            sendControl({ type: 'record', data: mode });
+        },
         close: () => ws.close(),
         isConnected: () => isOpen && ws.readyState === WebSocket.OPEN
     };
 }
 
+// Spectator WebSocket (read-only live view of someone's terminal)
+export function createSpectatorSocket(containerId: string, handlers: {
+    onOutput: (data: string) => void;
+    onStatus?: (status: string) => void;
+    onClose?: () => void;
+}) {
+    const ws = authSocket(`${WS_BASE}/ws/terminal/watch?container_id=${containerId}`);
+
+    ws.onmessage = (event) => {
+        try {
+            const msg = JSON.parse(event.data);
+            if (msg.type === 'output') {
+                handlers.onOutput(msg.data);
+            } else if (msg.type === 'status') {
+                handlers.onStatus?.(msg.data);
+            }
+        } catch {
+            handlers.onOutput(event.data);
+        }
+    };
+    ws.onclose = () => handlers.onClose?.();
+
+    return {
+        close: () => ws.close()
+    };
+}
+
 // Lobby WebSocket
 export function createLobbySocket(username: string, os: string, handlers: {
     onOpen?: () => void;
@@ -140,9 +659,9 @@ export function createLobbySocket(username: string, os: string, handlers: {
     onHelperLeft?: (helper: string) => void;
     onOwnerCancel?: (owner: string, content: string) => void;  // New: owner cancelled invite/helpers
     onError: (error: Event) => void;
-}, name?: string, avatar?: string) {
-    const wsUrl = `${WS_BASE}/ws/lobby?username=${encodeURIComponent(username)}&os=${os}&name=${encodeURIComponent(name || username)}&avatar=${encodeURIComponent(avatar || '')}`;
-    const ws = new WebSocket(wsUrl);
+}, _name?: string, _avatar?: string) {
+    const wsUrl = `${WS_BASE}/ws/lobby?os=${os}`;
+    const ws = authSocket(wsUrl);
     let isOpen = false;
 
     ws.onopen = () => {
@@ -274,14 +793,14 @@ export async function healthCheck() {
 }
 
 // Helper Terminal WebSocket (for helpers to control someone else's terminal)
-export function createHelperTerminalSocket(containerId: string, helperUsername: string, handlers: {
+export function createHelperTerminalSocket(containerId: string, _helperUsername: string, handlers: {
     onOpen?: () => void;
     onOutput: (data: string) => void;
     onStatus: (status: string) => void;
     onError: (error: Event) => void;
     onClose?: () => void;
 }) {
-    const ws = new WebSocket(`${WS_BASE}/ws/terminal/helper?container_id=${containerId}&username=${encodeURIComponent(helperUsername)}`);
+    const ws = authSocket(`${WS_BASE}/ws/terminal/helper?container_id=${containerId}`);
     let isOpen = false;
 
     ws.onopen = () => {